@@ -31,10 +31,30 @@ func main() {
 		}); err != nil {
 			log.Fatal(err)
 		}
+	case "versions":
+		if err := cli.RunVersions(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+	case "restore":
+		if err := cli.RunRestore(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+	case "prune":
+		if err := cli.RunPrune(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+	case "decrypt":
+		if err := cli.RunDecrypt(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+	case "decompress":
+		if err := cli.RunDecompress(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
 	case "help", "--help", "-h":
 		printUsage()
 	default:
-		fmt.Println("expected 'scan' or 'sync' subcommands")
+		fmt.Println("expected 'scan', 'sync', 'versions', 'restore', 'prune', 'decrypt' or 'decompress' subcommands")
 		os.Exit(1)
 	}
 }
@@ -42,7 +62,12 @@ func main() {
 func printUsage() {
 	fmt.Printf("Usage: %s <command> [options]\n", os.Args[0])
 	fmt.Println("Commands:")
-	fmt.Println("  scan   Plan migration tasks")
-	fmt.Println("  sync   Execute migration tasks")
+	fmt.Println("  scan        Plan migration tasks")
+	fmt.Println("  sync        Execute migration tasks")
+	fmt.Println("  versions    List or restore revisions kept by sync --versions-dir")
+	fmt.Println("  restore     Restore a run from a sync --repository backup repository")
+	fmt.Println("  prune       Remove old runs and unreferenced chunks from a repository")
+	fmt.Println("  decrypt     Restore the plaintext of an encrypted sync destination")
+	fmt.Println("  decompress  Restore the files of a compressed sync destination")
 	fmt.Printf("Use '%s <command> --help' for command-specific options.\n", os.Args[0])
 }
@@ -0,0 +1,678 @@
+package backend
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Archive formats, chosen by the file name suffix.
+const (
+	ArchiveTar   = "tar"
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+)
+
+// ArchiveFormat returns the archive format named by the suffix of path, or ""
+// when path does not name an archive: .tar, .tar.gz, .tgz or .zip.
+func ArchiveFormat(path string) string {
+	name := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(name, ".tar"):
+		return ArchiveTar
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return ArchiveTarGz
+	case strings.HasSuffix(name, ".zip"):
+		return ArchiveZip
+	}
+	return ""
+}
+
+// Archive presents a tar, gzip-compressed tar or zip file as a tree rooted
+// at the path /. Existing entries are read in place; a .tar.gz is first
+// decompressed into a temporary file so entries can be read in any order.
+// Files written through Create are spooled to a temporary directory, and the
+// archive on disk is left alone until Commit writes its replacement.
+//
+// Only regular files and directories are represented. Hard links in tar
+// archives read as copies of their target; symbolic links, devices and other
+// special entries are skipped.
+type Archive struct {
+	path   string
+	format string
+
+	mu      sync.Mutex
+	entries map[string]*archiveEntry
+	data    io.ReaderAt
+	closers []io.Closer
+	spool   string
+	changed bool
+	closed  bool
+}
+
+type archiveEntry struct {
+	dir     bool
+	mode    fs.FileMode
+	modTime time.Time
+	size    int64
+	// The content lives at offset in the tar data, in zf, or in the
+	// spooled file.
+	offset  int64
+	zf      *zip.File
+	spooled string
+	// touched is set when the entry's metadata changed since it was read.
+	touched bool
+}
+
+var _ Backend = (*Archive)(nil)
+
+// OpenArchive opens the archive at path in the format named by its suffix.
+// A missing file opens as an empty archive that Commit creates.
+func OpenArchive(path string) (*Archive, error) {
+	format := ArchiveFormat(path)
+	if format == "" {
+		return nil, fmt.Errorf("%s: not a .tar, .tar.gz, .tgz or .zip file", path)
+	}
+	a := &Archive{
+		path:    path,
+		format:  format,
+		entries: map[string]*archiveEntry{"": {dir: true, mode: fs.ModeDir | 0o755, modTime: time.Now()}},
+	}
+	var err error
+	switch format {
+	case ArchiveZip:
+		err = a.readZip()
+	default:
+		err = a.readTar()
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		a.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return a, nil
+}
+
+func (a *Archive) readZip() error {
+	zr, err := zip.OpenReader(a.path)
+	if err != nil {
+		return err
+	}
+	a.closers = append(a.closers, zr)
+	for _, f := range zr.File {
+		key := archiveKey(f.Name)
+		if key == "" {
+			continue
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			a.addDir(key, mode.Perm(), f.Modified)
+		case mode.IsRegular():
+			a.addParents(key)
+			a.entries[key] = &archiveEntry{mode: mode.Perm(), modTime: f.Modified, size: int64(f.UncompressedSize64), zf: f}
+		}
+	}
+	return nil
+}
+
+func (a *Archive) readTar() error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	a.closers = append(a.closers, f)
+	var data interface {
+		io.ReadSeeker
+		io.ReaderAt
+	} = f
+	if a.format == ArchiveTarGz {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		tmp, err := os.CreateTemp("", "syncopa-archive-*.tar")
+		if err != nil {
+			return err
+		}
+		// Closers run in reverse, so the file is closed before it is removed.
+		a.closers = append(a.closers, removeOnClose(tmp.Name()), tmp)
+		if _, err := io.Copy(tmp, zr); err != nil {
+			return err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		data = tmp
+	}
+	a.data = data
+
+	pos := &positionReader{r: data}
+	tr := tar.NewReader(pos)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		key := archiveKey(hdr.Name)
+		if key == "" {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			a.addDir(key, hdr.FileInfo().Mode().Perm(), hdr.ModTime)
+		case tar.TypeGNUSparse:
+			return fmt.Errorf("%s: sparse entries are not supported", hdr.Name)
+		case tar.TypeReg:
+			if hasSparseRecords(hdr) {
+				return fmt.Errorf("%s: sparse entries are not supported", hdr.Name)
+			}
+			a.addParents(key)
+			a.entries[key] = &archiveEntry{mode: hdr.FileInfo().Mode().Perm(), modTime: hdr.ModTime, size: hdr.Size, offset: pos.pos}
+		case tar.TypeLink:
+			target, ok := a.entries[archiveKey(hdr.Linkname)]
+			if !ok || target.dir {
+				return fmt.Errorf("%s: hard link to missing file %s", hdr.Name, hdr.Linkname)
+			}
+			a.addParents(key)
+			linked := *target
+			linked.mode, linked.modTime = hdr.FileInfo().Mode().Perm(), hdr.ModTime
+			a.entries[key] = &linked
+		}
+	}
+}
+
+func hasSparseRecords(hdr *tar.Header) bool {
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// positionReader tracks the offset of the next byte read from r, so the
+// offset of each tar entry's content is known after its header is read.
+type positionReader struct {
+	r   io.ReadSeeker
+	pos int64
+}
+
+func (p *positionReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.pos += int64(n)
+	return n, err
+}
+
+func (p *positionReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := p.r.Seek(offset, whence)
+	if err == nil {
+		p.pos = pos
+	}
+	return pos, err
+}
+
+type removeOnClose string
+
+func (r removeOnClose) Close() error {
+	return os.Remove(string(r))
+}
+
+// archiveKey maps an entry name or backend path onto the key of the entry,
+// relative to the root and slash separated. Names cannot escape the root.
+func archiveKey(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+}
+
+// addDir records the directory key and its parents. a.mu must be held or
+// the archive not yet shared.
+func (a *Archive) addDir(key string, perm fs.FileMode, modTime time.Time) {
+	a.addParents(key)
+	if e, ok := a.entries[key]; ok && e.dir {
+		e.mode, e.modTime = fs.ModeDir|perm, modTime
+		return
+	}
+	a.entries[key] = &archiveEntry{dir: true, mode: fs.ModeDir | perm, modTime: modTime}
+}
+
+// addParents records the missing parent directories of key.
+func (a *Archive) addParents(key string) {
+	for dir := path.Dir(key); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if e, ok := a.entries[dir]; ok && e.dir {
+			return
+		}
+		a.entries[dir] = &archiveEntry{dir: true, mode: fs.ModeDir | 0o755, modTime: time.Now()}
+	}
+}
+
+func (a *Archive) info(key string, e *archiveEntry) fs.FileInfo {
+	name := path.Base(key)
+	if key == "" {
+		name = "/"
+	}
+	return memInfo{name: name, size: e.size, mode: e.mode, modTime: e.modTime}
+}
+
+func (a *Archive) Stat(p string) (fs.FileInfo, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := archiveKey(p)
+	e, ok := a.entries[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: p, Err: fs.ErrNotExist}
+	}
+	return a.info(key, e), nil
+}
+
+func (a *Archive) List(dir string) ([]fs.DirEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := archiveKey(dir)
+	e, ok := a.entries[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: fs.ErrNotExist}
+	}
+	if !e.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: errors.New("not a directory")}
+	}
+	var entries []fs.DirEntry
+	for k, child := range a.entries {
+		if k != "" && archiveParent(k) == key {
+			entries = append(entries, fs.FileInfoToDirEntry(a.info(k, child)))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func archiveParent(key string) string {
+	if i := strings.LastIndexByte(key, '/'); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+func (a *Archive) Open(p string) (fs.File, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := archiveKey(p)
+	e, ok := a.entries[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	if e.dir {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: errors.New("is a directory")}
+	}
+	r, err := a.content(e)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: err}
+	}
+	return &archiveFile{ReadCloser: r, info: a.info(key, e)}, nil
+}
+
+// content opens the data of the file entry e.
+func (a *Archive) content(e *archiveEntry) (io.ReadCloser, error) {
+	switch {
+	case e.spooled != "":
+		return os.Open(e.spooled)
+	case e.zf != nil:
+		return e.zf.Open()
+	default:
+		return io.NopCloser(io.NewSectionReader(a.data, e.offset, e.size)), nil
+	}
+}
+
+type archiveFile struct {
+	io.ReadCloser
+	info fs.FileInfo
+}
+
+func (f *archiveFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// Create spools the new content of p, which is added to the tree when the
+// writer is closed. The parent directory must exist.
+func (a *Archive) Create(p string) (Writer, error) {
+	key := archiveKey(p)
+	if key == "" {
+		return nil, &fs.PathError{Op: "create", Path: p, Err: errors.New("is a directory")}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return nil, &fs.PathError{Op: "create", Path: p, Err: fs.ErrClosed}
+	}
+	if e, ok := a.entries[key]; ok && e.dir {
+		return nil, &fs.PathError{Op: "create", Path: p, Err: errors.New("is a directory")}
+	}
+	if parent, ok := a.entries[archiveParent(key)]; !ok || !parent.dir {
+		return nil, &fs.PathError{Op: "create", Path: p, Err: fs.ErrNotExist}
+	}
+	if a.spool == "" {
+		dir, err := os.MkdirTemp("", "syncopa-archive-spool-*")
+		if err != nil {
+			return nil, err
+		}
+		a.spool = dir
+	}
+	f, err := os.CreateTemp(a.spool, "entry-*")
+	if err != nil {
+		return nil, err
+	}
+	return &archiveWriter{File: f, a: a, key: key}, nil
+}
+
+// archiveWriter adds its spooled file to the archive when it is closed.
+type archiveWriter struct {
+	*os.File
+	a   *Archive
+	key string
+}
+
+func (w *archiveWriter) Close() error {
+	info, err := w.File.Stat()
+	if closeErr := w.File.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	a := w.a
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	mode := fs.FileMode(0o644)
+	if e, ok := a.entries[w.key]; ok {
+		if e.dir {
+			os.Remove(w.Name())
+			return &fs.PathError{Op: "close", Path: w.key, Err: errors.New("is a directory")}
+		}
+		mode = e.mode
+		a.discard(e)
+	}
+	a.addParents(w.key)
+	a.entries[w.key] = &archiveEntry{mode: mode, modTime: time.Now(), size: info.Size(), spooled: w.Name()}
+	a.changed = true
+	return nil
+}
+
+// Abort removes the spooled file; the archive keeps its previous entry.
+func (w *archiveWriter) Abort() error {
+	w.File.Close()
+	return os.Remove(w.Name())
+}
+
+// discard removes the spooled content of an entry that is replaced or
+// removed.
+func (a *Archive) discard(e *archiveEntry) {
+	if e.spooled != "" {
+		os.Remove(e.spooled)
+	}
+}
+
+func (a *Archive) MkdirAll(dir string, perm fs.FileMode) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := archiveKey(dir)
+	for k := key; k != ""; k = archiveParent(k) {
+		if e, ok := a.entries[k]; ok && !e.dir {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: errors.New("not a directory")}
+		}
+	}
+	if _, ok := a.entries[key]; !ok {
+		a.addDir(key, perm.Perm(), time.Now())
+		a.changed = true
+	}
+	return nil
+}
+
+func (a *Archive) Remove(p string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := archiveKey(p)
+	if key == "" {
+		return &fs.PathError{Op: "remove", Path: p, Err: fs.ErrPermission}
+	}
+	for k, e := range a.entries {
+		if k == key || strings.HasPrefix(k, key+"/") {
+			a.discard(e)
+			delete(a.entries, k)
+			a.changed = true
+		}
+	}
+	return nil
+}
+
+func (a *Archive) Rename(oldpath, newpath string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	from, to := archiveKey(oldpath), archiveKey(newpath)
+	e, ok := a.entries[from]
+	if !ok || from == "" {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrNotExist}
+	}
+	if target, ok := a.entries[to]; ok && target.dir && (!e.dir || a.hasChildren(to)) {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrExist}
+	}
+	if e.dir && (to == from || strings.HasPrefix(to, from+"/")) {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrInvalid}
+	}
+	if target, ok := a.entries[to]; ok && !target.dir {
+		a.discard(target)
+	}
+	moved := make(map[string]*archiveEntry)
+	for k, child := range a.entries {
+		if k == from || strings.HasPrefix(k, from+"/") {
+			child.touched = true
+			moved[to+strings.TrimPrefix(k, from)] = child
+			delete(a.entries, k)
+		}
+	}
+	for k, child := range moved {
+		a.entries[k] = child
+	}
+	a.addParents(to)
+	a.changed = true
+	return nil
+}
+
+func (a *Archive) hasChildren(key string) bool {
+	for k := range a.entries {
+		if strings.HasPrefix(k, key+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Archive) SetMetadata(p string, perm fs.FileMode, mtime time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e, ok := a.entries[archiveKey(p)]
+	if !ok {
+		return &fs.PathError{Op: "chmod", Path: p, Err: fs.ErrNotExist}
+	}
+	e.mode = e.mode&^fs.ModePerm | perm.Perm()
+	if !mtime.IsZero() {
+		e.modTime = mtime
+	}
+	e.touched = true
+	a.changed = true
+	return nil
+}
+
+// Changed reports whether the tree differs from the archive on disk.
+func (a *Archive) Changed() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.changed
+}
+
+// Commit writes the current tree as a new archive and replaces the file
+// with it. It does nothing when the tree is unchanged. The Archive can only
+// be closed afterwards.
+func (a *Archive) Commit() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return fs.ErrClosed
+	}
+	if !a.changed {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), "."+filepath.Base(a.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if err := a.write(tmp); err != nil {
+		return fmt.Errorf("%s: %w", a.path, err)
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// The old archive must be closed before it can be replaced on every
+	// platform.
+	a.release()
+	if err := os.Rename(tmp.Name(), a.path); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// write encodes every entry into w in path order, so directories precede
+// their contents.
+func (a *Archive) write(w io.Writer) error {
+	keys := make([]string, 0, len(a.entries))
+	for key := range a.entries {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if a.format == ArchiveZip {
+		return a.writeZip(w, keys)
+	}
+	var zw *gzip.Writer
+	if a.format == ArchiveTarGz {
+		zw = gzip.NewWriter(w)
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	for _, key := range keys {
+		e := a.entries[key]
+		hdr := &tar.Header{Name: key, Mode: int64(e.mode.Perm()), ModTime: e.modTime, Typeflag: tar.TypeReg, Size: e.size}
+		if e.dir {
+			hdr.Name, hdr.Typeflag, hdr.Size = key+"/", tar.TypeDir, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !e.dir {
+			if err := a.copyContent(tw, key, e); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if zw != nil {
+		return zw.Close()
+	}
+	return nil
+}
+
+func (a *Archive) writeZip(w io.Writer, keys []string) error {
+	zw := zip.NewWriter(w)
+	for _, key := range keys {
+		e := a.entries[key]
+		if e.zf != nil && !e.touched && e.zf.Name == key {
+			// Unchanged entries keep their compressed data.
+			if err := zw.Copy(e.zf); err != nil {
+				return err
+			}
+			continue
+		}
+		hdr := &zip.FileHeader{Name: key, Method: zip.Deflate, Modified: e.modTime}
+		hdr.SetMode(e.mode)
+		if e.dir {
+			hdr.Name, hdr.Method = key+"/", zip.Store
+		}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if !e.dir {
+			if err := a.copyContent(fw, key, e); err != nil {
+				return err
+			}
+		}
+	}
+	return zw.Close()
+}
+
+func (a *Archive) copyContent(w io.Writer, key string, e *archiveEntry) error {
+	r, err := a.content(e)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return err
+	}
+	if n != e.size {
+		return fmt.Errorf("%s: read %d of %d bytes", key, n, e.size)
+	}
+	return nil
+}
+
+// release closes the files the archive reads from. a.mu must be held.
+func (a *Archive) release() {
+	for i := len(a.closers) - 1; i >= 0; i-- {
+		a.closers[i].Close()
+	}
+	a.closers = nil
+	a.closed = true
+}
+
+// Close releases the archive and discards spooled files that were not
+// committed.
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.release()
+	if a.spool != "" {
+		return os.RemoveAll(a.spool)
+	}
+	return nil
+}
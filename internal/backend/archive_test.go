@@ -0,0 +1,220 @@
+package backend
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestArchiveCommitRoundTrip(t *testing.T) {
+	mtime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	root := string(filepath.Separator)
+	for _, name := range []string{"tree.tar", "tree.tar.gz", "tree.tgz", "tree.zip"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			a, err := OpenArchive(path)
+			if err != nil {
+				t.Fatalf("OpenArchive returned error: %v", err)
+			}
+			if err := a.MkdirAll(filepath.Join(root, "dir"), 0o755); err != nil {
+				t.Fatalf("MkdirAll returned error: %v", err)
+			}
+			for rel, data := range map[string]string{"a.txt": "alpha", filepath.Join("dir", "b.txt"): "beta", filepath.Join("dir", "c.txt"): "gamma"} {
+				writeArchiveFile(t, a, filepath.Join(root, rel), data)
+				if err := a.SetMetadata(filepath.Join(root, rel), 0o640, mtime); err != nil {
+					t.Fatalf("SetMetadata returned error: %v", err)
+				}
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Fatalf("expected nothing on disk before Commit, got %v", err)
+			}
+			if err := a.Commit(); err != nil {
+				t.Fatalf("Commit returned error: %v", err)
+			}
+			a.Close()
+
+			a, err = OpenArchive(path)
+			if err != nil {
+				t.Fatalf("OpenArchive returned error: %v", err)
+			}
+			info, err := a.Stat(filepath.Join(root, "dir", "b.txt"))
+			if err != nil || info.Size() != 4 || info.Mode().Perm() != 0o640 || !info.ModTime().Equal(mtime) {
+				t.Fatalf("unexpected info after reopening: %v, %v", info, err)
+			}
+			writeArchiveFile(t, a, filepath.Join(root, "a.txt"), "ALPHA!")
+			if err := a.Remove(filepath.Join(root, "dir", "c.txt")); err != nil {
+				t.Fatalf("Remove returned error: %v", err)
+			}
+			if err := a.Commit(); err != nil {
+				t.Fatalf("Commit returned error: %v", err)
+			}
+			a.Close()
+
+			a, err = OpenArchive(path)
+			if err != nil {
+				t.Fatalf("OpenArchive returned error: %v", err)
+			}
+			defer a.Close()
+			got := readArchiveTree(t, a)
+			want := map[string]string{"a.txt": "ALPHA!", "dir/": "", "dir/b.txt": "beta"}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("unexpected tree: got %v want %v", got, want)
+			}
+			if info, err := a.Stat(filepath.Join(root, "dir", "b.txt")); err != nil || !info.ModTime().Equal(mtime) {
+				t.Fatalf("expected the unchanged entry to keep its metadata: %v, %v", info, err)
+			}
+		})
+	}
+}
+
+func TestArchiveCommitWithoutChangesKeepsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.zip")
+	a, err := OpenArchive(path)
+	if err != nil {
+		t.Fatalf("OpenArchive returned error: %v", err)
+	}
+	writeArchiveFile(t, a, filepath.Join(string(filepath.Separator), "a.txt"), "alpha")
+	if err := a.Commit(); err != nil {
+		t.Fatalf("Commit returned error: %v", err)
+	}
+	a.Close()
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("expected the archive to exist: %v", err)
+	}
+
+	a, err = OpenArchive(path)
+	if err != nil {
+		t.Fatalf("OpenArchive returned error: %v", err)
+	}
+	if err := a.Commit(); err != nil || a.Changed() {
+		t.Fatalf("Commit returned %v, changed %v", err, a.Changed())
+	}
+	a.Close()
+	after, err := os.Stat(path)
+	if err != nil || !os.SameFile(before, after) {
+		t.Fatalf("expected an unchanged archive to be left alone: %v", err)
+	}
+}
+
+func TestArchiveReadsForeignTar(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	mtime := time.Date(2023, 3, 4, 5, 6, 7, 0, time.UTC)
+	for _, hdr := range []*tar.Header{
+		{Name: "./project/readme.md", Typeflag: tar.TypeReg, Mode: 0o600, Size: 5, ModTime: mtime},
+		{Name: "project/copy.md", Typeflag: tar.TypeLink, Linkname: "./project/readme.md", Mode: 0o644, ModTime: mtime},
+		{Name: "project/latest", Typeflag: tar.TypeSymlink, Linkname: "readme.md", ModTime: mtime},
+		{Name: "../escape.txt", Typeflag: tar.TypeReg, Mode: 0o644, Size: 3, ModTime: mtime},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("WriteHeader returned error: %v", err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			io.WriteString(tw, "hello"[:hdr.Size])
+		}
+	}
+	tw.Close()
+	path := filepath.Join(t.TempDir(), "foreign.tar")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+
+	a, err := OpenArchive(path)
+	if err != nil {
+		t.Fatalf("OpenArchive returned error: %v", err)
+	}
+	defer a.Close()
+	got := readArchiveTree(t, a)
+	want := map[string]string{"escape.txt": "hel", "project/": "", "project/readme.md": "hello", "project/copy.md": "hello"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected tree: got %v want %v", got, want)
+	}
+	if info, err := a.Stat(filepath.Join(string(filepath.Separator), "project", "readme.md")); err != nil || info.Mode().Perm() != 0o600 || !info.ModTime().Equal(mtime) {
+		t.Fatalf("unexpected info: %v, %v", info, err)
+	}
+}
+
+func TestArchiveWritesStandardZip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.zip")
+	a, err := OpenArchive(path)
+	if err != nil {
+		t.Fatalf("OpenArchive returned error: %v", err)
+	}
+	if err := a.MkdirAll(filepath.Join(string(filepath.Separator), "docs"), 0o755); err != nil {
+		t.Fatalf("MkdirAll returned error: %v", err)
+	}
+	writeArchiveFile(t, a, filepath.Join(string(filepath.Separator), "docs", "a.txt"), "alpha")
+	if err := a.Commit(); err != nil {
+		t.Fatalf("Commit returned error: %v", err)
+	}
+	a.Close()
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("zip.OpenReader returned error: %v", err)
+	}
+	defer zr.Close()
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"docs/", "docs/a.txt"}) {
+		t.Fatalf("unexpected zip entries: %v", names)
+	}
+}
+
+func writeArchiveFile(t *testing.T, a *Archive, path, data string) {
+	t.Helper()
+	w, err := a.Create(path)
+	if err != nil {
+		t.Fatalf("Create returned error: %v", err)
+	}
+	if _, err := io.WriteString(w, data); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+}
+
+// readArchiveTree returns the content of every file and a "" for every
+// directory, keyed by slash-separated path with directories ending in '/'.
+func readArchiveTree(t *testing.T, a *Archive) map[string]string {
+	t.Helper()
+	root := string(filepath.Separator)
+	tree := map[string]string{}
+	err := Walk(a, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			tree[rel+"/"] = ""
+			return nil
+		}
+		f, err := a.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		data, err := io.ReadAll(f)
+		tree[rel] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Walk returned error: %v", err)
+	}
+	return tree
+}
@@ -0,0 +1,141 @@
+// Package backend abstracts the storage that scans read and syncs write.
+// Paths are filesystem-style paths in the host's separator convention; each
+// backend decides what they refer to. Local is the host filesystem, Memory
+// an in-memory tree for tests, S3 a bucket on an S3-compatible object
+// store, and Archive the entries of a tar or zip file.
+package backend
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// Backend is a hierarchical store of files and directories.
+type Backend interface {
+	// Stat returns information about path, following symlinks.
+	Stat(path string) (fs.FileInfo, error)
+	// List returns the entries of the directory dir sorted by name.
+	List(dir string) ([]fs.DirEntry, error)
+	// Open opens path for reading.
+	Open(path string) (fs.File, error)
+	// Create creates or truncates the file path for writing. The parent
+	// directory must exist.
+	Create(path string) (Writer, error)
+	// MkdirAll creates dir and any missing parents.
+	MkdirAll(dir string, perm fs.FileMode) error
+	// Remove removes path and everything below it. A missing path is not
+	// an error.
+	Remove(path string) error
+	// Rename moves oldpath to newpath, replacing a file at newpath.
+	Rename(oldpath, newpath string) error
+	// SetMetadata sets the permission bits and modification time of path.
+	// A zero mtime leaves the modification time unchanged.
+	SetMetadata(path string, perm fs.FileMode, mtime time.Time) error
+}
+
+// Writer is a file opened for writing by Backend.Create. Close publishes
+// what was written; Abort gives up on it.
+type Writer interface {
+	io.Writer
+	// Sync flushes written data to stable storage.
+	Sync() error
+	Close() error
+	// Abort releases the writer without publishing its content, for
+	// example after the data being copied could not be read completely.
+	// A file that was already truncated or created by Create is removed.
+	Abort() error
+}
+
+// CreateMode creates path like b.Create and gives the file the permission
+// bits perm. Backends that store them with the content, such as S3, record
+// them in the same write; on the others Close applies them with SetMetadata.
+func CreateMode(b Backend, path string, perm fs.FileMode) (Writer, error) {
+	if c, ok := b.(interface {
+		CreateMode(path string, perm fs.FileMode) (Writer, error)
+	}); ok {
+		return c.CreateMode(path, perm)
+	}
+	w, err := b.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &modeWriter{Writer: w, b: b, path: path, perm: perm}, nil
+}
+
+// modeWriter sets the permission bits of its file once it is closed.
+type modeWriter struct {
+	Writer
+	b    Backend
+	path string
+	perm fs.FileMode
+}
+
+func (w *modeWriter) Close() error {
+	if err := w.Writer.Close(); err != nil {
+		return err
+	}
+	return w.b.SetMetadata(w.path, w.perm, time.Time{})
+}
+
+// OrLocal returns b, or Local when b is nil.
+func OrLocal(b Backend) Backend {
+	if b == nil {
+		return Local{}
+	}
+	return b
+}
+
+// IsLocal reports whether b refers to the host filesystem.
+func IsLocal(b Backend) bool {
+	_, ok := OrLocal(b).(Local)
+	return ok
+}
+
+// Walk walks the tree rooted at root like filepath.WalkDir, visiting entries
+// in lexical order.
+func Walk(b Backend, root string, fn fs.WalkDirFunc) error {
+	if _, ok := b.(Local); ok {
+		return filepath.WalkDir(root, fn)
+	}
+	info, err := b.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDir(b, root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if errors.Is(err, filepath.SkipDir) || errors.Is(err, filepath.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+func walkDir(b Backend, path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if errors.Is(err, filepath.SkipDir) && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+	entries, err := b.List(path)
+	if err != nil {
+		// Give fn a chance to handle the unreadable directory.
+		if err = fn(path, d, err); err != nil {
+			if errors.Is(err, filepath.SkipDir) && d.IsDir() {
+				err = nil
+			}
+			return err
+		}
+	}
+	for _, entry := range entries {
+		if err := walkDir(b, filepath.Join(path, entry.Name()), entry, fn); err != nil {
+			if errors.Is(err, filepath.SkipDir) {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
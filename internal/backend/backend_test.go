@@ -0,0 +1,143 @@
+package backend
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBackends(t *testing.T) {
+	for name, newBackend := range map[string]func(t *testing.T) (Backend, string){
+		"local": func(t *testing.T) (Backend, string) { return Local{}, t.TempDir() },
+		"memory": func(t *testing.T) (Backend, string) {
+			return NewMemory(), filepath.Join(string(filepath.Separator), "mem")
+		},
+		"archive": func(t *testing.T) (Backend, string) {
+			a, err := OpenArchive(filepath.Join(t.TempDir(), "tree.tar"))
+			if err != nil {
+				t.Fatalf("OpenArchive returned error: %v", err)
+			}
+			t.Cleanup(func() { a.Close() })
+			return a, string(filepath.Separator)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			b, root := newBackend(t)
+			testBackend(t, b, root)
+		})
+	}
+}
+
+func testBackend(t *testing.T, b Backend, root string) {
+	mtime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	if _, err := b.Stat(filepath.Join(root, "missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist for a missing path, got %v", err)
+	}
+	if err := b.MkdirAll(filepath.Join(root, "dir", "sub"), 0o755); err != nil {
+		t.Fatalf("MkdirAll returned error: %v", err)
+	}
+	if _, err := b.Create(filepath.Join(root, "nodir", "a.txt")); err == nil {
+		t.Fatalf("expected Create to require the parent directory")
+	}
+	write := func(rel, data string) {
+		t.Helper()
+		w, err := b.Create(filepath.Join(root, rel))
+		if err != nil {
+			t.Fatalf("Create returned error: %v", err)
+		}
+		if _, err := io.WriteString(w, data); err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+		if err := w.Sync(); err != nil {
+			t.Fatalf("Sync returned error: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close returned error: %v", err)
+		}
+	}
+	write("b.txt", "beta")
+	write(filepath.Join("dir", "a.txt"), "alpha")
+	write(filepath.Join("dir", "sub", "c.txt"), "gamma")
+
+	if err := b.SetMetadata(filepath.Join(root, "b.txt"), 0o600, mtime); err != nil {
+		t.Fatalf("SetMetadata returned error: %v", err)
+	}
+	info, err := b.Stat(filepath.Join(root, "b.txt"))
+	if err != nil {
+		t.Fatalf("Stat returned error: %v", err)
+	}
+	if info.Size() != 4 || info.Mode().Perm() != 0o600 || !info.ModTime().Equal(mtime) || info.IsDir() {
+		t.Fatalf("unexpected info: %d %s %s", info.Size(), info.Mode(), info.ModTime())
+	}
+
+	f, err := b.Open(filepath.Join(root, "dir", "a.txt"))
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "alpha" {
+		t.Fatalf("read %q, %v", data, err)
+	}
+
+	var walked []string
+	err = Walk(b, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			rel += "/"
+		}
+		walked = append(walked, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk returned error: %v", err)
+	}
+	want := []string{"./", "b.txt", "dir/", "dir/a.txt", "dir/sub/", "dir/sub/c.txt"}
+	if !reflect.DeepEqual(walked, want) {
+		t.Fatalf("unexpected walk: got %v want %v", walked, want)
+	}
+
+	if err := b.Rename(filepath.Join(root, "dir"), filepath.Join(root, "moved")); err != nil {
+		t.Fatalf("Rename returned error: %v", err)
+	}
+	if _, err := b.Stat(filepath.Join(root, "moved", "sub", "c.txt")); err != nil {
+		t.Fatalf("expected the directory contents to move: %v", err)
+	}
+	if err := b.Rename(filepath.Join(root, "b.txt"), filepath.Join(root, "moved", "a.txt")); err != nil {
+		t.Fatalf("Rename over a file returned error: %v", err)
+	}
+	entries, err := b.List(filepath.Join(root, "moved"))
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"a.txt", "sub"}) {
+		t.Fatalf("unexpected listing: %v", names)
+	}
+	if info, err := b.Stat(filepath.Join(root, "moved", "a.txt")); err != nil || info.Size() != 4 {
+		t.Fatalf("expected the renamed file to replace the old one: %v, %v", info, err)
+	}
+
+	if err := b.Remove(filepath.Join(root, "moved")); err != nil {
+		t.Fatalf("Remove returned error: %v", err)
+	}
+	if err := b.Remove(filepath.Join(root, "moved")); err != nil {
+		t.Fatalf("Remove of a missing path returned error: %v", err)
+	}
+	if entries, err := b.List(root); err != nil || len(entries) != 0 {
+		t.Fatalf("expected an empty root, got %v, %v", entries, err)
+	}
+}
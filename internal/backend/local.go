@@ -0,0 +1,66 @@
+package backend
+
+import (
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Local is the host filesystem.
+type Local struct{}
+
+var _ Backend = Local{}
+
+func (Local) Stat(path string) (fs.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (Local) List(dir string) ([]fs.DirEntry, error) {
+	return os.ReadDir(dir)
+}
+
+func (Local) Open(path string) (fs.File, error) {
+	return os.Open(path)
+}
+
+func (Local) Create(path string) (Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return localWriter{f}, nil
+}
+
+// localWriter is a file on the host filesystem, which sees every write as
+// it happens.
+type localWriter struct {
+	*os.File
+}
+
+// Abort closes the file and removes it.
+func (w localWriter) Abort() error {
+	w.File.Close()
+	return os.Remove(w.Name())
+}
+
+func (Local) MkdirAll(dir string, perm fs.FileMode) error {
+	return os.MkdirAll(dir, perm)
+}
+
+func (Local) Remove(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (Local) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (Local) SetMetadata(path string, perm fs.FileMode, mtime time.Time) error {
+	if err := os.Chmod(path, perm); err != nil {
+		return err
+	}
+	if mtime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(path, time.Now(), mtime)
+}
@@ -0,0 +1,311 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is an in-memory tree in which only the root directory exists
+// initially. The zero value is ready to use. Memory is safe for concurrent
+// use; a file written through Create gets its content when it is closed.
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]*memEntry
+}
+
+type memEntry struct {
+	dir     bool
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+var _ Backend = (*Memory)(nil)
+
+// NewMemory returns an empty in-memory tree.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) key(path string) string {
+	return filepath.Clean(path)
+}
+
+// lookup returns the entry at key, creating the root on first use. m.mu
+// must be held.
+func (m *Memory) lookup(key string) (*memEntry, bool) {
+	if m.entries == nil {
+		m.entries = make(map[string]*memEntry)
+	}
+	if e, ok := m.entries[key]; ok {
+		return e, true
+	}
+	if filepath.Dir(key) == key {
+		root := &memEntry{dir: true, mode: fs.ModeDir | 0o755, modTime: time.Now()}
+		m.entries[key] = root
+		return root, true
+	}
+	return nil, false
+}
+
+func (m *Memory) Stat(path string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := m.key(path)
+	e, ok := m.lookup(key)
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+	}
+	return memInfo{name: filepath.Base(key), size: int64(len(e.data)), mode: e.mode, modTime: e.modTime}, nil
+}
+
+func (m *Memory) List(dir string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := m.key(dir)
+	e, ok := m.lookup(key)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: fs.ErrNotExist}
+	}
+	if !e.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: errors.New("not a directory")}
+	}
+	var entries []fs.DirEntry
+	for path, child := range m.entries {
+		if path != key && filepath.Dir(path) == key {
+			info := memInfo{name: filepath.Base(path), size: int64(len(child.data)), mode: child.mode, modTime: child.modTime}
+			entries = append(entries, fs.FileInfoToDirEntry(info))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *Memory) Open(path string) (fs.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := m.key(path)
+	e, ok := m.lookup(key)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+	info := memInfo{name: filepath.Base(key), size: int64(len(e.data)), mode: e.mode, modTime: e.modTime}
+	return &memFile{Reader: bytes.NewReader(e.data), info: info}, nil
+}
+
+func (m *Memory) Create(path string) (Writer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := m.key(path)
+	if parent, ok := m.lookup(filepath.Dir(key)); !ok || !parent.dir {
+		return nil, &fs.PathError{Op: "create", Path: path, Err: fs.ErrNotExist}
+	}
+	mode := fs.FileMode(0o644)
+	if e, ok := m.lookup(key); ok {
+		if e.dir {
+			return nil, &fs.PathError{Op: "create", Path: path, Err: errors.New("is a directory")}
+		}
+		mode = e.mode
+	}
+	m.entries[key] = &memEntry{mode: mode, modTime: time.Now()}
+	return &memWriter{m: m, key: key}, nil
+}
+
+func (m *Memory) MkdirAll(dir string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := m.key(dir)
+	var missing []string
+	for {
+		e, ok := m.lookup(key)
+		if ok {
+			if !e.dir {
+				return &fs.PathError{Op: "mkdir", Path: dir, Err: errors.New("not a directory")}
+			}
+			break
+		}
+		missing = append(missing, key)
+		key = filepath.Dir(key)
+	}
+	for _, path := range missing {
+		m.entries[path] = &memEntry{dir: true, mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+	}
+	return nil
+}
+
+func (m *Memory) Remove(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := m.key(path)
+	if filepath.Dir(key) == key {
+		return &fs.PathError{Op: "remove", Path: path, Err: fs.ErrPermission}
+	}
+	for p := range m.entries {
+		if p == key || within(key, p) {
+			delete(m.entries, p)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	from, to := m.key(oldpath), m.key(newpath)
+	e, ok := m.lookup(from)
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrNotExist}
+	}
+	if parent, ok := m.lookup(filepath.Dir(to)); !ok || !parent.dir {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrNotExist}
+	}
+	if target, ok := m.lookup(to); ok && target.dir && (!e.dir || m.hasChildren(to)) {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrExist}
+	}
+	if e.dir && within(from, to) {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrInvalid}
+	}
+	moved := make(map[string]*memEntry)
+	for p, child := range m.entries {
+		if p == from || within(from, p) {
+			moved[to+strings.TrimPrefix(p, from)] = child
+			delete(m.entries, p)
+		}
+	}
+	for p, child := range moved {
+		m.entries[p] = child
+	}
+	return nil
+}
+
+func (m *Memory) SetMetadata(path string, perm fs.FileMode, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.lookup(m.key(path))
+	if !ok {
+		return &fs.PathError{Op: "chmod", Path: path, Err: fs.ErrNotExist}
+	}
+	e.mode = e.mode&^fs.ModePerm | perm.Perm()
+	if !mtime.IsZero() {
+		e.modTime = mtime
+	}
+	return nil
+}
+
+// hasChildren reports whether the directory key holds entries. m.mu must be
+// held.
+func (m *Memory) hasChildren(key string) bool {
+	for p := range m.entries {
+		if p != key && filepath.Dir(p) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// within reports whether path lies strictly below dir.
+func within(dir, path string) bool {
+	if strings.HasSuffix(dir, string(filepath.Separator)) {
+		return path != dir && strings.HasPrefix(path, dir)
+	}
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return i.mode }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memInfo) Sys() any           { return nil }
+
+type memFile struct {
+	*bytes.Reader
+	info memInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+// memWriter buffers a file's content until Close publishes it.
+type memWriter struct {
+	m      *Memory
+	key    string
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fs.ErrClosed
+	}
+	return w.buf.Write(p)
+}
+
+func (w *memWriter) Sync() error {
+	if w.closed {
+		return fs.ErrClosed
+	}
+	return nil
+}
+
+func (w *memWriter) Close() error {
+	if w.closed {
+		return fs.ErrClosed
+	}
+	w.closed = true
+	w.m.mu.Lock()
+	defer w.m.mu.Unlock()
+	// The file may have been removed or renamed while it was open.
+	if e, ok := w.m.entries[w.key]; ok && !e.dir {
+		e.data = append([]byte(nil), w.buf.Bytes()...)
+		e.modTime = time.Now()
+	}
+	return nil
+}
+
+// Abort drops the file created by Create.
+func (w *memWriter) Abort() error {
+	if w.closed {
+		return fs.ErrClosed
+	}
+	w.closed = true
+	w.m.mu.Lock()
+	defer w.m.mu.Unlock()
+	if e, ok := w.m.entries[w.key]; ok && !e.dir {
+		delete(w.m.entries, w.key)
+	}
+	return nil
+}
+
+// WriteFile stores data at path with the given permissions and
+// modification time, creating missing parent directories. It seeds trees
+// for tests.
+func (m *Memory) WriteFile(path string, data []byte, perm fs.FileMode, mtime time.Time) error {
+	if err := m.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	w, err := m.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return m.SetMetadata(path, perm, mtime)
+}
@@ -0,0 +1,784 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPartSize is the multipart upload part size used when
+	// S3Config.PartSize is not set.
+	DefaultPartSize = 16 << 20
+	// MinPartSize is the smallest part size object stores accept for every
+	// part but the last.
+	MinPartSize = 5 << 20
+	// maxCopySize is the largest object a single copy request can copy.
+	maxCopySize = 5 << 30
+	// partsPerStep is how many parts are uploaded before the part size
+	// doubles, so uploads of unknown size stay below the 10,000 part limit.
+	partsPerStep = 1000
+)
+
+// ErrUnavailable marks failures the service reported as temporary, such as
+// throttling or an internal error. The request may succeed when retried.
+var ErrUnavailable = errors.New("service temporarily unavailable")
+
+// S3Config describes a bucket on an S3-compatible object store.
+type S3Config struct {
+	// Endpoint is the base URL of the service, for example
+	// https://s3.eu-west-1.amazonaws.com or http://localhost:9000. Buckets
+	// are addressed path-style below it.
+	Endpoint string
+	// Region is the signing region. Empty uses us-east-1.
+	Region string
+	Bucket string
+	// AccessKeyID and SecretAccessKey sign every request with AWS Signature
+	// Version 4. Requests are sent unsigned when AccessKeyID is empty.
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken accompanies temporary credentials.
+	SessionToken string
+	// PartSize is the size of the parts large files are uploaded in; files
+	// up to PartSize are uploaded with a single request. A value <= 0 uses
+	// DefaultPartSize.
+	PartSize int64
+	// Client sends the requests. Nil uses http.DefaultClient.
+	Client *http.Client
+}
+
+// S3 stores files as objects in a bucket of an S3-compatible object store.
+// The path /a/b names the key a/b; directories are the key prefixes ending
+// in '/', listed with a delimiter instead of walked. MkdirAll is a no-op and
+// a directory exists as long as an object lies below it.
+//
+// Objects cannot be changed in place, so the modification time of a file is
+// the time it was last written, as listings report it; SetMetadata cannot
+// change it. Files also report the ETag of the object, which identifies its
+// content; see ETag. Permission bits are kept in the x-amz-meta-mode header,
+// set by SetMetadata or, without a second write, by CreateMode.
+type S3 struct {
+	cfg      S3Config
+	endpoint string
+	client   *http.Client
+	signer   *signer
+}
+
+var _ Backend = (*S3)(nil)
+
+// NewS3 returns the backend for the bucket described by cfg.
+func NewS3(cfg S3Config) (*S3, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid object store endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" || strings.Contains(cfg.Bucket, "/") {
+		return nil, fmt.Errorf("invalid bucket name %q", cfg.Bucket)
+	}
+	if cfg.PartSize <= 0 {
+		cfg.PartSize = DefaultPartSize
+	}
+	if cfg.PartSize < MinPartSize {
+		return nil, fmt.Errorf("part size %d is below the minimum of %d bytes", cfg.PartSize, MinPartSize)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	s := &S3{cfg: cfg, endpoint: strings.TrimSuffix(u.String(), "/"), client: cfg.Client}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+	if cfg.AccessKeyID != "" {
+		s.signer = &signer{
+			accessKey:    cfg.AccessKeyID,
+			secretKey:    cfg.SecretAccessKey,
+			sessionToken: cfg.SessionToken,
+			region:       cfg.Region,
+			service:      "s3",
+		}
+	}
+	return s, nil
+}
+
+// ParseS3URL splits an s3://bucket/prefix location into the bucket and the
+// backend path of the prefix. ok is false when location is not an s3 URL.
+func ParseS3URL(location string) (bucket, path string, ok bool, err error) {
+	rest, found := strings.CutPrefix(location, "s3://")
+	if !found {
+		return "", "", false, nil
+	}
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", true, fmt.Errorf("%s: missing bucket name", location)
+	}
+	return bucket, filepath.Join(string(filepath.Separator), filepath.FromSlash(prefix)), true, nil
+}
+
+// ETag returns the entity tag an object store reported for info, or "" when
+// info does not describe an object. Equal ETags of single-part uploads mean
+// equal content; multipart ETags depend on the part size and contain '-'.
+func ETag(info fs.FileInfo) string {
+	if t, ok := info.(interface{ ETag() string }); ok {
+		return t.ETag()
+	}
+	return ""
+}
+
+// key maps a backend path onto an object key.
+func (s *S3) key(path string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "/")
+}
+
+func (s *S3) Stat(path string) (fs.FileInfo, error) {
+	key := s.key(path)
+	if key == "" || key == "." {
+		return s3Info{name: "/", mode: fs.ModeDir | 0o755}, nil
+	}
+	resp, err := s.do(http.MethodHead, key, nil, nil, nil)
+	if err == nil {
+		resp.Body.Close()
+		return objectInfo(key, resp.Header), nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, &fs.PathError{Op: "stat", Path: path, Err: err}
+	}
+	page, err := s.list(key+"/", "/", "", 1)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: path, Err: err}
+	}
+	if len(page.Contents) == 0 && len(page.CommonPrefixes) == 0 {
+		return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+	}
+	return s3Info{name: baseName(key), mode: fs.ModeDir | 0o755}, nil
+}
+
+func (s *S3) List(dir string) ([]fs.DirEntry, error) {
+	prefix := s.key(dir)
+	if prefix != "" && prefix != "." {
+		prefix += "/"
+	} else {
+		prefix = ""
+	}
+	var entries []fs.DirEntry
+	token := ""
+	for {
+		page, err := s.list(prefix, "/", token, 0)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: dir, Err: err}
+		}
+		for _, obj := range page.Contents {
+			if obj.Key == prefix {
+				// A directory marker written by another tool.
+				continue
+			}
+			entries = append(entries, fs.FileInfoToDirEntry(s3Info{
+				name:    strings.TrimPrefix(obj.Key, prefix),
+				size:    obj.Size,
+				mode:    0o644,
+				modTime: obj.LastModified,
+				etag:    obj.ETag,
+			}))
+		}
+		for _, p := range page.CommonPrefixes {
+			entries = append(entries, fs.FileInfoToDirEntry(s3Info{
+				name: strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/"),
+				mode: fs.ModeDir | 0o755,
+			}))
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+	if len(entries) == 0 && prefix != "" {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (s *S3) Open(path string) (fs.File, error) {
+	key := s.key(path)
+	// Ask for the stored bytes; a transparently decoded body would not
+	// match the object's size.
+	resp, err := s.do(http.MethodGet, key, nil, http.Header{"Accept-Encoding": {"identity"}}, nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: err}
+	}
+	return &s3File{body: resp.Body, info: objectInfo(key, resp.Header)}, nil
+}
+
+func (s *S3) Create(path string) (Writer, error) {
+	key := s.key(path)
+	if key == "" || key == "." {
+		return nil, &fs.PathError{Op: "create", Path: path, Err: fs.ErrInvalid}
+	}
+	return &s3Writer{s: s, key: key, path: path}, nil
+}
+
+// CreateMode is Create for a file that records perm in its metadata when
+// it is uploaded.
+func (s *S3) CreateMode(path string, perm fs.FileMode) (Writer, error) {
+	w, err := s.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w.(*s3Writer).header = http.Header{"X-Amz-Meta-Mode": {modeMeta(perm)}}
+	return w, nil
+}
+
+// modeMeta formats permission bits for the x-amz-meta-mode header.
+func modeMeta(perm fs.FileMode) string {
+	return strconv.FormatUint(uint64(perm.Perm()), 8)
+}
+
+// MkdirAll does nothing: directories exist implicitly below their objects.
+func (s *S3) MkdirAll(dir string, perm fs.FileMode) error {
+	return nil
+}
+
+func (s *S3) Remove(path string) error {
+	key := s.key(path)
+	if key == "" || key == "." {
+		return &fs.PathError{Op: "remove", Path: path, Err: fs.ErrPermission}
+	}
+	keys, err := s.keysBelow(key + "/")
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: path, Err: err}
+	}
+	for _, k := range append(keys, key) {
+		resp, err := s.do(http.MethodDelete, k, nil, nil, nil)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return &fs.PathError{Op: "remove", Path: path, Err: err}
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+	return nil
+}
+
+func (s *S3) Rename(oldpath, newpath string) error {
+	from, to := s.key(oldpath), s.key(newpath)
+	info, err := s.Stat(oldpath)
+	if err != nil {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: errors.Unwrap(err)}
+	}
+	moves := map[string]string{from: to}
+	var sizes map[string]int64
+	if info.IsDir() {
+		if strings.HasPrefix(to+"/", from+"/") {
+			return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrInvalid}
+		}
+		moves = map[string]string{}
+		sizes = map[string]int64{}
+		keys, err := s.keysBelow(from + "/")
+		if err != nil {
+			return &fs.PathError{Op: "rename", Path: oldpath, Err: err}
+		}
+		for _, k := range keys {
+			moves[k] = to + strings.TrimPrefix(k, from)
+			sizes[k] = -1
+		}
+	} else {
+		sizes = map[string]int64{from: info.Size()}
+	}
+	for src, dst := range moves {
+		if err := s.copyObject(src, dst, sizes[src], nil); err != nil {
+			return &fs.PathError{Op: "rename", Path: oldpath, Err: err}
+		}
+		resp, err := s.do(http.MethodDelete, src, nil, nil, nil)
+		if err != nil {
+			return &fs.PathError{Op: "rename", Path: oldpath, Err: err}
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// SetMetadata records perm in the object's metadata. Objects cannot be
+// updated in place, so this copies the object onto itself unless the
+// metadata already matches. The modification time is always the time the
+// object was written, so mtime is ignored.
+func (s *S3) SetMetadata(path string, perm fs.FileMode, mtime time.Time) error {
+	key := s.key(path)
+	resp, err := s.do(http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return &fs.PathError{Op: "chmod", Path: path, Err: err}
+	}
+	resp.Body.Close()
+	meta := http.Header{}
+	for name, values := range resp.Header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
+			meta[name] = values
+		}
+	}
+	meta.Set("X-Amz-Meta-Mode", modeMeta(perm))
+	changed := false
+	for name := range meta {
+		if resp.Header.Get(name) != meta.Get(name) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	meta.Set("X-Amz-Metadata-Directive", "REPLACE")
+	if err := s.copyObject(key, key, objectInfo(key, resp.Header).Size(), meta); err != nil {
+		return &fs.PathError{Op: "chmod", Path: path, Err: err}
+	}
+	return nil
+}
+
+// keysBelow returns every key starting with prefix.
+func (s *S3) keysBelow(prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		page, err := s.list(prefix, "", token, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			keys = append(keys, obj.Key)
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// copyObject copies src to dst within the bucket. header adds request
+// headers such as replacement metadata. Objects larger than a single copy
+// request allows are copied in parts; size < 0 looks the size up first.
+func (s *S3) copyObject(src, dst string, size int64, header http.Header) error {
+	if size < 0 {
+		info, err := s.Stat("/" + src)
+		if err != nil {
+			return err
+		}
+		size = info.Size()
+	}
+	source := "/" + s.cfg.Bucket + "/" + uriEscape(src, false)
+	if size <= maxCopySize {
+		h := http.Header{"X-Amz-Copy-Source": {source}}
+		for name, values := range header {
+			h[name] = values
+		}
+		resp, err := s.do(http.MethodPut, dst, nil, h, nil)
+		if err != nil {
+			return err
+		}
+		// A copy can fail after the service has answered 200.
+		return readResult(resp, nil)
+	}
+	meta := http.Header{}
+	for name, values := range header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
+			meta[name] = values
+		}
+	}
+	up, err := s.startUpload(dst, meta)
+	if err != nil {
+		return err
+	}
+	partSize := int64(maxCopySize)
+	for offset := int64(0); offset < size; offset += partSize {
+		end := min(offset+partSize, size) - 1
+		h := http.Header{
+			"X-Amz-Copy-Source":       {source},
+			"X-Amz-Copy-Source-Range": {fmt.Sprintf("bytes=%d-%d", offset, end)},
+		}
+		var result struct {
+			ETag string `xml:"ETag"`
+		}
+		resp, err := s.do(http.MethodPut, dst, up.partQuery(len(up.parts)+1), h, nil)
+		if err == nil {
+			err = readResult(resp, &result)
+		}
+		if err != nil {
+			up.abort()
+			return err
+		}
+		up.parts = append(up.parts, completedPart{PartNumber: len(up.parts) + 1, ETag: result.ETag})
+	}
+	return up.complete()
+}
+
+// listPage is one page of a ListObjectsV2 response.
+type listPage struct {
+	Contents []struct {
+		Key          string
+		LastModified time.Time
+		ETag         string
+		Size         int64
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// list returns one page of the keys starting with prefix. With a delimiter
+// keys below the next delimiter are folded into CommonPrefixes.
+func (s *S3) list(prefix, delimiter, token string, maxKeys int) (*listPage, error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	if maxKeys > 0 {
+		query.Set("max-keys", strconv.Itoa(maxKeys))
+	}
+	resp, err := s.do(http.MethodGet, "", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	page := &listPage{}
+	if err := readResult(resp, page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// do sends a signed request for key, or for the bucket when key is empty,
+// and returns the response when its status is 2xx. Other statuses are
+// returned as *S3Error.
+func (s *S3) do(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	uri := "/" + uriEscape(s.cfg.Bucket, true)
+	if key != "" {
+		uri += "/" + uriEscape(key, false)
+	}
+	target := s.endpoint + uri
+	if len(query) > 0 {
+		target += "?" + canonicalQuery(query)
+	}
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		req.Body = http.NoBody
+	}
+	req.ContentLength = int64(len(body))
+	for name, values := range header {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	if s.signer != nil {
+		payloadHash := emptySHA256
+		if len(body) > 0 {
+			sum := sha256.Sum256(body)
+			payloadHash = hex.EncodeToString(sum[:])
+		}
+		s.signer.sign(req, uri, payloadHash, time.Now())
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	e := &S3Error{Method: method, Key: key, StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	_ = xml.Unmarshal(data, e)
+	return nil, e
+}
+
+// readResult decodes the XML body of resp into v, which may be nil, and
+// returns an error document sent with a 2xx status as *S3Error.
+func readResult(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		e := &S3Error{Method: resp.Request.Method, StatusCode: resp.StatusCode}
+		_ = xml.Unmarshal(data, e)
+		return e
+	}
+	if v == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, v)
+}
+
+// S3Error is an error response from the object store. It wraps
+// fs.ErrNotExist, fs.ErrPermission or ErrUnavailable where they apply.
+type S3Error struct {
+	Method     string `xml:"-"`
+	Key        string `xml:"-"`
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	msg := fmt.Sprintf("%s %s: %d", e.Method, e.Key, e.StatusCode)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+func (e *S3Error) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusNotFound:
+		return fs.ErrNotExist
+	case e.StatusCode == http.StatusForbidden:
+		return fs.ErrPermission
+	case e.StatusCode == http.StatusTooManyRequests, e.StatusCode >= 500, e.Code == "SlowDown":
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// objectInfo builds the file information of key from a HEAD or GET
+// response.
+func objectInfo(key string, h http.Header) s3Info {
+	info := s3Info{name: baseName(key), mode: 0o644, etag: h.Get("ETag")}
+	info.size, _ = strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	info.modTime, _ = http.ParseTime(h.Get("Last-Modified"))
+	if mode, err := strconv.ParseUint(h.Get("X-Amz-Meta-Mode"), 8, 32); err == nil {
+		info.mode = fs.FileMode(mode).Perm()
+	}
+	return info
+}
+
+func baseName(key string) string {
+	return key[strings.LastIndex(key, "/")+1:]
+}
+
+type s3Info struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	etag    string
+}
+
+func (i s3Info) Name() string       { return i.name }
+func (i s3Info) Size() int64        { return i.size }
+func (i s3Info) Mode() fs.FileMode  { return i.mode }
+func (i s3Info) ModTime() time.Time { return i.modTime }
+func (i s3Info) IsDir() bool        { return i.mode.IsDir() }
+func (i s3Info) Sys() any           { return nil }
+func (i s3Info) ETag() string       { return i.etag }
+
+type s3File struct {
+	body io.ReadCloser
+	info s3Info
+}
+
+func (f *s3File) Read(p []byte) (int, error) { return f.body.Read(p) }
+func (f *s3File) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *s3File) Close() error               { return f.body.Close() }
+
+// s3Writer buffers a file and uploads it with a single request when it
+// fits in one part, or as a multipart upload otherwise. Nothing is visible
+// in the bucket until Close succeeds.
+type s3Writer struct {
+	s    *S3
+	key  string
+	path string
+	// header holds the metadata sent with the upload.
+	header http.Header
+	buf    []byte
+	upload *multipartUpload
+	err    error
+	closed bool
+}
+
+func (w *s3Writer) partSize() int {
+	parts := 0
+	if w.upload != nil {
+		parts = len(w.upload.parts)
+	}
+	return int(w.s.cfg.PartSize << (parts / partsPerStep))
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fs.ErrClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), w.partSize()-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		// Only upload a full part once more data follows, so a file of
+		// exactly one part still goes out in a single request.
+		if len(w.buf) == w.partSize() && len(p) > 0 {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush uploads the buffer as the next part.
+func (w *s3Writer) flush() error {
+	if w.upload == nil {
+		up, err := w.s.startUpload(w.key, w.header)
+		if err != nil {
+			w.err = &fs.PathError{Op: "write", Path: w.path, Err: err}
+			return w.err
+		}
+		w.upload = up
+	}
+	if err := w.upload.put(w.buf); err != nil {
+		w.upload.abort()
+		w.err = &fs.PathError{Op: "write", Path: w.path, Err: err}
+		return w.err
+	}
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Sync does nothing; data is stored durably once Close returns.
+func (w *s3Writer) Sync() error {
+	if w.closed {
+		return fs.ErrClosed
+	}
+	return w.err
+}
+
+func (w *s3Writer) Close() error {
+	if w.closed {
+		return fs.ErrClosed
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+	if w.upload == nil {
+		resp, err := w.s.do(http.MethodPut, w.key, nil, w.header, w.buf)
+		if err != nil {
+			return &fs.PathError{Op: "close", Path: w.path, Err: err}
+		}
+		resp.Body.Close()
+		return nil
+	}
+	if len(w.buf) > 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	if err := w.upload.complete(); err != nil {
+		return &fs.PathError{Op: "close", Path: w.path, Err: err}
+	}
+	return nil
+}
+
+// Abort drops the buffered data and any multipart upload in progress, so
+// nothing reaches the bucket.
+func (w *s3Writer) Abort() error {
+	if w.closed {
+		return fs.ErrClosed
+	}
+	w.closed = true
+	w.buf = nil
+	if w.upload != nil && w.err == nil {
+		w.upload.abort()
+	}
+	return nil
+}
+
+// multipartUpload is an upload in progress.
+type multipartUpload struct {
+	s     *S3
+	key   string
+	id    string
+	parts []completedPart
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+func (s *S3) startUpload(key string, header http.Header) (*multipartUpload, error) {
+	resp, err := s.do(http.MethodPost, key, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := readResult(resp, &result); err != nil {
+		return nil, err
+	}
+	if result.UploadID == "" {
+		return nil, fmt.Errorf("%s: no upload id in response", key)
+	}
+	return &multipartUpload{s: s, key: key, id: result.UploadID}, nil
+}
+
+func (u *multipartUpload) partQuery(number int) url.Values {
+	return url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {u.id}}
+}
+
+// put uploads data as the next part.
+func (u *multipartUpload) put(data []byte) error {
+	number := len(u.parts) + 1
+	resp, err := u.s.do(http.MethodPut, u.key, u.partQuery(number), nil, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	u.parts = append(u.parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	return nil
+}
+
+func (u *multipartUpload) complete() error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: u.parts})
+	if err != nil {
+		return err
+	}
+	resp, err := u.s.do(http.MethodPost, u.key, url.Values{"uploadId": {u.id}}, nil, body)
+	if err == nil {
+		err = readResult(resp, nil)
+	}
+	if err != nil {
+		u.abort()
+	}
+	return err
+}
+
+// abort discards the parts uploaded so far. Failures are ignored; the
+// bucket's lifecycle rules collect abandoned uploads.
+func (u *multipartUpload) abort() {
+	resp, err := u.s.do(http.MethodDelete, u.key, url.Values{"uploadId": {u.id}}, nil, nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
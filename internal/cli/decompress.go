@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/syncopasoft/syncopa-core/internal/worker"
+)
+
+// RunDecompress executes the decompress command, which restores the files of
+// a destination written by sync --compress.
+func RunDecompress(args []string) error {
+	decompressCmd := flag.NewFlagSet("decompress", flag.ExitOnError)
+	src := decompressCmd.String("src", "", "compressed destination written by sync --compress")
+	dst := decompressCmd.String("dst", "", "directory to restore the files into")
+	help := decompressCmd.Bool("help", false, "show help for decompress")
+	decompressCmd.Usage = func() {
+		out := decompressCmd.Output()
+		fmt.Fprintf(out, "Usage: %s decompress --src <dir> --dst <dir>\n", os.Args[0])
+		fmt.Fprintln(out, "\nDescription:")
+		fmt.Fprintln(out, "  Decompress every file of a compressed destination, verifying sizes and")
+		fmt.Fprintln(out, "  checksums, and restore original names, modes and modification times.")
+		fmt.Fprintln(out, "")
+		decompressCmd.PrintDefaults()
+	}
+	if err := decompressCmd.Parse(args); err != nil {
+		return err
+	}
+	if *help {
+		decompressCmd.Usage()
+		return nil
+	}
+	if *src == "" || *dst == "" {
+		return fmt.Errorf("src and dst required")
+	}
+	inside, err := pathWithin(*src, *dst)
+	if err != nil {
+		return err
+	}
+	if inside {
+		return fmt.Errorf("%s must not be inside %s", *dst, *src)
+	}
+	restored, err := worker.DecompressTree(*src, *dst)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("decompressed %d files into %s\n", restored, *dst)
+	return nil
+}
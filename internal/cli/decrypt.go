@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/syncopasoft/syncopa-core/internal/worker"
+)
+
+// RunDecrypt executes the decrypt command, which restores the plaintext of a
+// destination written by sync with an encryption key.
+func RunDecrypt(args []string) error {
+	decryptCmd := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	src := decryptCmd.String("src", "", "encrypted destination written by sync --encryption-key-file or --encryption-key-env")
+	dst := decryptCmd.String("dst", "", "directory to restore the plaintext into")
+	keyFile := decryptCmd.String("encryption-key-file", "", "file holding the 256-bit key (raw, hex or base64)")
+	keyEnv := decryptCmd.String("encryption-key-env", "", "environment variable holding the 256-bit key")
+	help := decryptCmd.Bool("help", false, "show help for decrypt")
+	decryptCmd.Usage = func() {
+		out := decryptCmd.Output()
+		fmt.Fprintf(out, "Usage: %s decrypt --src <dir> --dst <dir> (--encryption-key-file <path> | --encryption-key-env <name>)\n", os.Args[0])
+		fmt.Fprintln(out, "\nDescription:")
+		fmt.Fprintln(out, "  Decrypt every file of an encrypted destination, verifying its integrity, and")
+		fmt.Fprintln(out, "  restore names, modes and modification times.")
+		fmt.Fprintln(out, "")
+		decryptCmd.PrintDefaults()
+	}
+	if err := decryptCmd.Parse(args); err != nil {
+		return err
+	}
+	if *help {
+		decryptCmd.Usage()
+		return nil
+	}
+	if *src == "" || *dst == "" {
+		return fmt.Errorf("src and dst required")
+	}
+	if *keyFile != "" && *keyEnv != "" {
+		return fmt.Errorf("--encryption-key-file and --encryption-key-env are mutually exclusive")
+	}
+	inside, err := pathWithin(*src, *dst)
+	if err != nil {
+		return err
+	}
+	if inside {
+		return fmt.Errorf("%s must not be inside %s", *dst, *src)
+	}
+	key, err := worker.LoadEncryptionKey(*keyFile, *keyEnv)
+	if err != nil {
+		return err
+	}
+	enc, err := worker.OpenEncryptor(*src, key)
+	if err != nil {
+		return err
+	}
+	restored, err := enc.DecryptTree(*dst)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("decrypted %d files into %s\n", restored, *dst)
+	return nil
+}
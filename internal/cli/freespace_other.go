@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package cli
+
+import "errors"
+
+func freeSpace(string) (int64, error) {
+	return 0, errors.New("reading free space is not supported on this platform")
+}
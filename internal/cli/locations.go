@@ -0,0 +1,159 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/syncopasoft/syncopa-core/internal/backend"
+	"github.com/syncopasoft/syncopa-core/internal/scanner"
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+// locations resolves the --src and --dst arguments of scan and sync onto a
+// path and the backend that holds it: a local directory, an s3://bucket/prefix
+// location or a .tar, .tar.gz or .zip archive.
+type locations struct {
+	stores   *objectStoreFlags
+	archives map[string]*backend.Archive
+}
+
+func newLocations(stores *objectStoreFlags) *locations {
+	return &locations{stores: stores, archives: make(map[string]*backend.Archive)}
+}
+
+// resolve maps location onto a path and its backend. A source archive must
+// exist; a destination archive is created when the run is committed. An
+// archive is presented as a tree rooted at the separator, so its entries are
+// always synced as the contents of the archive. Locations naming the same
+// archive share a backend.
+func (l *locations) resolve(location string, source bool) (string, backend.Backend, error) {
+	path, b, err := l.stores.resolve(location)
+	if err != nil || b != nil || backend.ArchiveFormat(location) == "" {
+		return path, b, err
+	}
+	abs, err := filepath.Abs(location)
+	if err != nil {
+		return "", nil, err
+	}
+	root := string(filepath.Separator)
+	if a, ok := l.archives[abs]; ok {
+		return root, a, nil
+	}
+	if source {
+		if _, err := os.Stat(abs); err != nil {
+			return "", nil, fmt.Errorf("source archive: %w", err)
+		}
+	}
+	a, err := backend.OpenArchive(abs)
+	if err != nil {
+		return "", nil, err
+	}
+	l.archives[abs] = a
+	return root, a, nil
+}
+
+// hasArchives reports whether any location resolved to an archive.
+func (l *locations) hasArchives() bool {
+	return len(l.archives) > 0
+}
+
+// commit writes every archive changed by the run.
+func (l *locations) commit() error {
+	for path, a := range l.archives {
+		if err := a.Commit(); err != nil {
+			return fmt.Errorf("failed to write archive %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// close releases the archives and their spooled content. Archives that were
+// not committed are left unchanged on disk.
+func (l *locations) close() {
+	for _, a := range l.archives {
+		a.Close()
+	}
+}
+
+// resolveDestinations resolves every --dst argument. A run with several
+// destinations replicates to local directories only, and none of them may
+// contain another.
+func resolveDestinations(l *locations, dsts []string) ([]string, backend.Backend, error) {
+	paths := make([]string, len(dsts))
+	var b backend.Backend
+	for i, location := range dsts {
+		path, locBackend, err := l.resolve(location, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(dsts) > 1 && locBackend != nil {
+			return nil, nil, fmt.Errorf("%s: multiple --dst must all be local directories", location)
+		}
+		paths[i], b = path, locBackend
+	}
+	for i := range paths {
+		for j := i + 1; j < len(paths); j++ {
+			inside, err := pathWithin(paths[i], paths[j])
+			if err != nil {
+				return nil, nil, err
+			}
+			contains, err := pathWithin(paths[j], paths[i])
+			if err != nil {
+				return nil, nil, err
+			}
+			if inside || contains {
+				return nil, nil, fmt.Errorf("--dst %s and --dst %s overlap", dsts[i], dsts[j])
+			}
+		}
+	}
+	return paths, b, nil
+}
+
+// resolveSources resolves every --src argument. A single source is used as
+// given. Several sources are merged into the destination: each must be a
+// local directory and may name the destination directory it is placed in as
+// PATH=DIR. Without one, a source is placed in a directory named after it
+// unless it ends in a separator, in which case its contents are merged into
+// the destination root.
+func resolveSources(l *locations, srcs []string) ([]scanner.Source, backend.Backend, error) {
+	if len(srcs) == 1 {
+		path, b, err := l.resolve(srcs[0], true)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []scanner.Source{{Root: path}}, b, nil
+	}
+	sources := make([]scanner.Source, len(srcs))
+	for i, location := range srcs {
+		prefix, mapped := "", false
+		if eq := strings.LastIndex(location, "="); eq > 0 {
+			location, prefix, mapped = location[:eq], location[eq+1:], true
+		}
+		path, b, err := l.resolve(location, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		if b != nil {
+			return nil, nil, fmt.Errorf("%s: multiple --src must all be local directories", location)
+		}
+		if !mapped && !HasTrailingSeparator(path) {
+			if base := filepath.Base(filepath.Clean(path)); base != string(filepath.Separator) && base != "." {
+				prefix = base
+			}
+		}
+		sources[i] = scanner.Source{Root: path, Prefix: prefix}
+	}
+	return sources, nil, nil
+}
+
+// scanSources plans the run for the resolved sources. A single source keeps
+// the trailing separator rule of Scan; several sources are merged.
+func scanSources(sources []scanner.Source, dsts []string, mode scanner.Mode, opts scanner.Options, tasks chan<- task.Task) error {
+	if len(sources) == 1 {
+		src := sources[0].Root
+		return scanner.ScanFanout(src, dsts, !HasTrailingSeparator(src), mode, opts, tasks)
+	}
+	return scanner.ScanMerge(sources, dsts, mode, opts, tasks)
+}
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/syncopasoft/syncopa-core/internal/backend"
+)
+
+// objectStoreFlags holds the flags shared by scan and sync that configure
+// s3://bucket/prefix locations. Credentials come from the standard
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN variables.
+type objectStoreFlags struct {
+	endpoint *string
+	region   *string
+	partSize *int64
+	buckets  map[string]*backend.S3
+}
+
+func addObjectStoreFlags(fs *flag.FlagSet) *objectStoreFlags {
+	s := &objectStoreFlags{}
+	s.endpoint = fs.String("s3-endpoint", "", "endpoint URL for s3:// locations (defaults to $AWS_ENDPOINT_URL, then https://s3.<region>.amazonaws.com)")
+	s.region = fs.String("s3-region", "", "signing region for s3:// locations (defaults to $AWS_REGION, then us-east-1)")
+	s.partSize = fs.Int64("s3-part-size", backend.DefaultPartSize, "upload files to s3:// locations in parts of this many bytes when they are larger (minimum 5 MiB)")
+	return s
+}
+
+// resolve maps a --src or --dst argument onto a path and the backend that
+// holds it. Local paths are returned unchanged with a nil backend. Locations
+// in the same bucket share a backend so they can be synced in both
+// directions.
+func (s *objectStoreFlags) resolve(location string) (string, backend.Backend, error) {
+	bucket, path, ok, err := backend.ParseS3URL(location)
+	if !ok || err != nil {
+		return location, nil, err
+	}
+	if strings.HasSuffix(location, "/") && !HasTrailingSeparator(path) {
+		// Keep the trailing separator that selects the contents of the
+		// prefix rather than the prefix itself.
+		path += string(filepath.Separator)
+	}
+	if b, ok := s.buckets[bucket]; ok {
+		return path, b, nil
+	}
+	region := firstNonEmpty(*s.region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+	endpoint := firstNonEmpty(*s.endpoint, os.Getenv("AWS_ENDPOINT_URL"), "https://s3."+region+".amazonaws.com")
+	b, err := backend.NewS3(backend.S3Config{
+		Endpoint:        endpoint,
+		Region:          region,
+		Bucket:          bucket,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		PartSize:        *s.partSize,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", location, err)
+	}
+	if s.buckets == nil {
+		s.buckets = make(map[string]*backend.S3)
+	}
+	s.buckets[bucket] = b
+	return path, b, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
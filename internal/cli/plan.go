@@ -0,0 +1,263 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/syncopasoft/syncopa-core/internal/backend"
+	"github.com/syncopasoft/syncopa-core/internal/plan"
+	"github.com/syncopasoft/syncopa-core/internal/scanner"
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+// Ways sync --plan-in handles planned files whose source changed since the
+// plan was made.
+const (
+	planStaleRefuse     = "refuse"
+	planStaleSkip       = "skip"
+	planStaleRevalidate = "revalidate"
+)
+
+// absoluteLocations returns locations with local paths made absolute, so a
+// plan can be executed from any directory. Trailing separators and the
+// =DIR mappings of merged sources are kept.
+func absoluteLocations(locations []string, mapped bool) ([]string, error) {
+	out := make([]string, len(locations))
+	for i, location := range locations {
+		suffix := ""
+		if mapped {
+			if eq := strings.LastIndex(location, "="); eq > 0 {
+				location, suffix = location[:eq], location[eq:]
+			}
+		}
+		abs, err := absLocation(location)
+		if err != nil {
+			return nil, err
+		}
+		if HasTrailingSeparator(location) && !HasTrailingSeparator(abs) {
+			abs += string(os.PathSeparator)
+		}
+		out[i] = abs + suffix
+	}
+	return out, nil
+}
+
+// planFile is a plan being written next to its final location. It replaces
+// the final file only once the scan succeeds.
+type planFile struct {
+	*plan.Writer
+	file *os.File
+	path string
+}
+
+func createPlanFile(path string, h plan.Header) (*planFile, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	return &planFile{Writer: plan.NewWriter(f, h), file: f, path: path}, nil
+}
+
+// commit completes the plan and moves it into place.
+func (p *planFile) commit() error {
+	if err := p.Writer.Close(); err != nil {
+		p.discard()
+		return err
+	}
+	if err := p.file.Close(); err != nil {
+		os.Remove(p.file.Name())
+		return err
+	}
+	if err := os.Rename(p.file.Name(), p.path); err != nil {
+		os.Remove(p.file.Name())
+		return err
+	}
+	return nil
+}
+
+// discard removes the partial plan.
+func (p *planFile) discard() {
+	p.file.Close()
+	os.Remove(p.file.Name())
+}
+
+// planTrees are the resolved locations a plan is executed against.
+type planTrees struct {
+	src, dst backend.Backend
+	sources  []scanner.Source
+	dsts     []string
+}
+
+// deleteSources returns the source paths that would provide the destination
+// path dst, so a planned delete can be checked against the sources.
+func (p planTrees) deleteSources(dst string) []string {
+	for _, root := range p.dsts {
+		rel, err := filepath.Rel(root, dst)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		var paths []string
+		for _, source := range p.sources {
+			prefix := source.Prefix
+			if len(p.sources) == 1 && !HasTrailingSeparator(source.Root) {
+				// A single source without a trailing separator is
+				// placed in a directory named after it.
+				prefix = filepath.Base(filepath.Clean(source.Root))
+			}
+			below, err := filepath.Rel(prefix, rel)
+			if err != nil || below == ".." || strings.HasPrefix(below, ".."+string(filepath.Separator)) {
+				continue
+			}
+			paths = append(paths, filepath.Join(source.Root, below))
+		}
+		return paths
+	}
+	return nil
+}
+
+// staleDelete reports why the planned delete t must no longer run, or ""
+// when it still may.
+func (p planTrees) staleDelete(t task.Task) (string, error) {
+	return plan.StaleDelete(p.src, p.dst, t, p.deleteSources(t.Dst))
+}
+
+// checkPlan refuses the plan at path when a source it reads changed since
+// the plan was made or a planned delete became stale, naming the first
+// changed path. When dstChanged is set a destination tree changed, and a
+// plan that deletes anything is refused as well.
+func checkPlan(path string, trees planTrees, dstChanged bool) error {
+	r, err := plan.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	stale, first := 0, ""
+	deletes, firstDelete := 0, ""
+	for {
+		t, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if t.Action == task.ActionDelete {
+			reason, err := trees.staleDelete(t)
+			if err != nil {
+				return err
+			}
+			if reason != "" {
+				return fmt.Errorf("planned delete of %s is stale: %s; scan again or use --plan-stale %s or %s", t.Dst, reason, planStaleSkip, planStaleRevalidate)
+			}
+			if deletes == 0 {
+				firstDelete = t.Dst
+			}
+			deletes++
+			continue
+		}
+		paths, err := plan.StaleSources(trees.src, t)
+		if err != nil {
+			return err
+		}
+		if len(paths) > 0 && first == "" {
+			first = paths[0]
+		}
+		stale += len(paths)
+	}
+	if stale > 0 {
+		return fmt.Errorf("%d planned source files changed since the plan was made, starting with %s; scan again or use --plan-stale %s or %s", stale, first, planStaleSkip, planStaleRevalidate)
+	}
+	if dstChanged && deletes > 0 {
+		return fmt.Errorf("the destination changed since the plan was made, which deletes %d entries starting with %s; scan again or use --plan-stale %s or %s", deletes, firstDelete, planStaleSkip, planStaleRevalidate)
+	}
+	return nil
+}
+
+// feedPlan sends the tasks of the plan at path to tasks. When changed is
+// set the trees differ from the plan's fingerprints, and each task is first
+// checked according to the stale policy. Stale deletes are dropped by both
+// skip and revalidate.
+func feedPlan(path, stale string, changed bool, trees planTrees, tasks chan<- task.Task) error {
+	r, err := plan.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for {
+		t, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !changed || stale == planStaleRefuse {
+			tasks <- t
+			continue
+		}
+		if t.Action == task.ActionDelete {
+			reason, err := trees.staleDelete(t)
+			if err != nil {
+				return err
+			}
+			if reason != "" {
+				fmt.Printf("skipping delete of %s: %s\n", t.Dst, reason)
+				continue
+			}
+			tasks <- t
+			continue
+		}
+		paths, err := plan.StaleSources(trees.src, t)
+		if err != nil {
+			return err
+		}
+		if len(paths) == 0 {
+			tasks <- t
+			continue
+		}
+		if stale == planStaleSkip {
+			for _, p := range paths {
+				fmt.Printf("skipping %s: changed since the plan was made\n", p)
+			}
+			if rest, ok := plan.Without(t, paths); ok {
+				tasks <- rest
+			}
+			continue
+		}
+		replaced, err := plan.Revalidate(trees.src, trees.dst, t)
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			fmt.Printf("revalidated %s: changed since the plan was made\n", p)
+		}
+		for _, rt := range replaced {
+			tasks <- rt
+		}
+	}
+}
+
+// planLocations returns the locations of a plan for flag. Locations given
+// on the command line must match them.
+func planLocations(flag string, given, planned []string, mapped bool) ([]string, error) {
+	if len(given) == 0 {
+		return append([]string(nil), planned...), nil
+	}
+	abs, err := absoluteLocations(given, mapped)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Equal(abs, planned) {
+		return nil, fmt.Errorf("%s does not match the plan, which was made for %s", flag, strings.Join(planned, ", "))
+	}
+	return given, nil
+}
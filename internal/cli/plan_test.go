@@ -0,0 +1,188 @@
+package cli
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// planFixture is a mirror plan of src into dst that copies a.txt and b.txt
+// and deletes old.txt.
+type planFixture struct {
+	src, dst, plan string
+}
+
+func newPlanFixture(t *testing.T) planFixture {
+	t.Helper()
+	// Syncs record their throughput under the user cache directory.
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("HOME", cache)
+	t.Setenv("LocalAppData", cache)
+
+	f := planFixture{src: t.TempDir(), dst: t.TempDir(), plan: filepath.Join(t.TempDir(), "site.plan.jsonl")}
+	writePlanFile(t, filepath.Join(f.src, "a.txt"), "alpha")
+	writePlanFile(t, filepath.Join(f.src, "b.txt"), "bravo")
+	writePlanFile(t, filepath.Join(f.dst, "old.txt"), "old")
+	args := []string{"--src", f.src + string(filepath.Separator), "--dst", f.dst, "--mode", "mirror", "--plan-out", f.plan}
+	if err := RunScan(args, ScanConfig{}); err != nil {
+		t.Fatalf("scan --plan-out failed: %v", err)
+	}
+	return f
+}
+
+func (f planFixture) sync(args ...string) error {
+	return RunSync(append([]string{"--plan-in", f.plan}, args...), SyncConfig{})
+}
+
+func writePlanFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func assertPlanFile(t *testing.T, path, want string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if want == "" {
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected %s to be absent, got %q, %v", path, data, err)
+		}
+		return
+	}
+	if err != nil || string(data) != want {
+		t.Fatalf("expected %s to hold %q, got %q, %v", path, want, data, err)
+	}
+}
+
+func TestSyncPlanInExecutesUnchangedPlan(t *testing.T) {
+	f := newPlanFixture(t)
+	if err := f.sync(); err != nil {
+		t.Fatalf("sync --plan-in failed: %v", err)
+	}
+	assertPlanFile(t, filepath.Join(f.dst, "a.txt"), "alpha")
+	assertPlanFile(t, filepath.Join(f.dst, "b.txt"), "bravo")
+	assertPlanFile(t, filepath.Join(f.dst, "old.txt"), "")
+}
+
+func TestSyncPlanInIgnoresNewSourceFiles(t *testing.T) {
+	f := newPlanFixture(t)
+	// Files added after planning are left for the next scan.
+	writePlanFile(t, filepath.Join(f.src, "new.txt"), "new")
+
+	if err := f.sync(); err != nil {
+		t.Fatalf("sync --plan-in failed: %v", err)
+	}
+	assertPlanFile(t, filepath.Join(f.dst, "a.txt"), "alpha")
+	assertPlanFile(t, filepath.Join(f.dst, "old.txt"), "")
+	assertPlanFile(t, filepath.Join(f.dst, "new.txt"), "")
+}
+
+func TestSyncPlanInHandlesChangedSources(t *testing.T) {
+	cases := []struct {
+		stale   string
+		wantErr string
+		wantA   string
+	}{
+		{stale: planStaleRefuse, wantErr: "planned source files changed since the plan was made"},
+		{stale: planStaleSkip, wantA: ""},
+		{stale: planStaleRevalidate, wantA: "alpha, rewritten"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.stale, func(t *testing.T) {
+			f := newPlanFixture(t)
+			writePlanFile(t, filepath.Join(f.src, "a.txt"), "alpha, rewritten")
+
+			err := f.sync("--plan-stale", tc.stale)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected %q, got %v", tc.wantErr, err)
+				}
+				// A refused plan writes nothing.
+				assertPlanFile(t, filepath.Join(f.dst, "b.txt"), "")
+				assertPlanFile(t, filepath.Join(f.dst, "old.txt"), "old")
+				return
+			}
+			if err != nil {
+				t.Fatalf("sync --plan-in failed: %v", err)
+			}
+			assertPlanFile(t, filepath.Join(f.dst, "a.txt"), tc.wantA)
+			assertPlanFile(t, filepath.Join(f.dst, "b.txt"), "bravo")
+		})
+	}
+}
+
+func TestSyncPlanInHandlesStaleDeletes(t *testing.T) {
+	for _, stale := range []string{planStaleRefuse, planStaleSkip} {
+		t.Run(stale, func(t *testing.T) {
+			f := newPlanFixture(t)
+			// The source provides the planned delete again.
+			writePlanFile(t, filepath.Join(f.src, "old.txt"), "old")
+
+			err := f.sync("--plan-stale", stale)
+			if stale == planStaleRefuse {
+				if err == nil || !strings.Contains(err.Error(), "planned delete of "+filepath.Join(f.dst, "old.txt")+" is stale") {
+					t.Fatalf("expected the stale delete to refuse the plan, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("sync --plan-in failed: %v", err)
+			} else {
+				assertPlanFile(t, filepath.Join(f.dst, "a.txt"), "alpha")
+			}
+			assertPlanFile(t, filepath.Join(f.dst, "old.txt"), "old")
+		})
+	}
+}
+
+func TestSyncPlanInRefusesDeletesWhenDestinationChanged(t *testing.T) {
+	f := newPlanFixture(t)
+	writePlanFile(t, filepath.Join(f.dst, "other.txt"), "other")
+
+	err := f.sync()
+	if err == nil || !strings.Contains(err.Error(), "the destination changed since the plan was made") {
+		t.Fatalf("expected the changed destination to refuse the plan, got %v", err)
+	}
+	assertPlanFile(t, filepath.Join(f.dst, "old.txt"), "old")
+}
+
+func TestSyncPlanInRejectsMismatchedLocationsAndMode(t *testing.T) {
+	f := newPlanFixture(t)
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--dst", t.TempDir()}, "--dst does not match the plan"},
+		{[]string{"--src", t.TempDir() + string(filepath.Separator)}, "--src does not match the plan"},
+		{[]string{"--mode", "update"}, "--mode update does not match the plan, which was made with --mode mirror"},
+	}
+	for _, tc := range cases {
+		if err := f.sync(tc.args...); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%v: expected %q, got %v", tc.args, tc.want, err)
+		}
+	}
+	// The matching locations and mode are accepted.
+	if err := f.sync("--src", f.src+string(filepath.Separator), "--dst", f.dst, "--mode", "mirror"); err != nil {
+		t.Fatalf("sync with matching flags failed: %v", err)
+	}
+}
+
+func TestSyncPlanInRejectsNewerVersion(t *testing.T) {
+	f := newPlanFixture(t)
+	data, err := os.ReadFile(f.plan)
+	if err != nil {
+		t.Fatalf("failed to read plan: %v", err)
+	}
+	newer := strings.Replace(string(data), `"v":1,`, `"v":99,`, 1)
+	if newer == string(data) {
+		t.Fatalf("plan header has no version: %s", data)
+	}
+	writePlanFile(t, f.plan, newer)
+
+	if err := f.sync(); err == nil || !strings.Contains(err.Error(), "unsupported version 99") {
+		t.Fatalf("expected a newer plan to be refused, got %v", err)
+	}
+	assertPlanFile(t, filepath.Join(f.dst, "old.txt"), "old")
+}
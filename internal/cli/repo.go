@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/syncopasoft/syncopa-core/internal/repo"
+)
+
+// RunRestore executes the restore command, which materialises a run stored
+// in a repository written by sync --repository.
+func RunRestore(args []string) error {
+	restoreCmd := flag.NewFlagSet("restore", flag.ExitOnError)
+	repoDir := restoreCmd.String("repo", "", "repository directory written by sync --repository")
+	runFlag := restoreCmd.String("run", "latest", "run to restore, by stamp, or latest")
+	path := restoreCmd.String("path", "", "only restore this file or directory, relative to the repository root")
+	dst := restoreCmd.String("dst", "", "directory to restore into")
+	list := restoreCmd.Bool("list", false, "list the runs stored in the repository instead of restoring")
+	help := restoreCmd.Bool("help", false, "show help for restore")
+	restoreCmd.Usage = func() {
+		out := restoreCmd.Output()
+		fmt.Fprintf(out, "Usage: %s restore --repo <dir> --dst <path> [--run <stamp>] [--path <rel>]\n", os.Args[0])
+		fmt.Fprintf(out, "       %s restore --repo <dir> --list\n", os.Args[0])
+		fmt.Fprintln(out, "\nDescription:")
+		fmt.Fprintln(out, "  Rebuild the files recorded by a repository run, verifying every chunk.")
+		fmt.Fprintln(out, "")
+		restoreCmd.PrintDefaults()
+	}
+	if err := restoreCmd.Parse(args); err != nil {
+		return err
+	}
+	if *help {
+		restoreCmd.Usage()
+		return nil
+	}
+	if *repoDir == "" {
+		return fmt.Errorf("repo required")
+	}
+	r, err := repo.Open(*repoDir)
+	if err != nil {
+		return err
+	}
+
+	if *list {
+		runs, err := r.Runs()
+		if err != nil {
+			return err
+		}
+		for _, name := range runs {
+			m, err := r.Manifest(name)
+			if err != nil {
+				return err
+			}
+			var size int64
+			for _, entry := range m.Files {
+				size += entry.Size
+			}
+			fmt.Printf("%s\t%d files\t%d bytes\n", name, len(m.Files), size)
+		}
+		return nil
+	}
+
+	if *dst == "" {
+		return fmt.Errorf("dst required")
+	}
+	restored, err := r.Restore(*runFlag, *path, *dst)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %d files into %s\n", restored, *dst)
+	return nil
+}
+
+// RunPrune executes the prune command, which drops old runs from a
+// repository and deletes the chunks no remaining run references.
+func RunPrune(args []string) error {
+	pruneCmd := flag.NewFlagSet("prune", flag.ExitOnError)
+	repoDir := pruneCmd.String("repo", "", "repository directory written by sync --repository")
+	keepRuns := pruneCmd.Int("keep-runs", 0, "keep only the newest N runs (0 keeps every run and only collects unreferenced chunks)")
+	help := pruneCmd.Bool("help", false, "show help for prune")
+	pruneCmd.Usage = func() {
+		out := pruneCmd.Output()
+		fmt.Fprintf(out, "Usage: %s prune --repo <dir> [--keep-runs N]\n", os.Args[0])
+		fmt.Fprintln(out, "\nDescription:")
+		fmt.Fprintln(out, "  Remove old runs and garbage-collect chunks that no remaining run references.")
+		fmt.Fprintln(out, "  Do not prune while a sync is writing to the repository.")
+		fmt.Fprintln(out, "")
+		pruneCmd.PrintDefaults()
+	}
+	if err := pruneCmd.Parse(args); err != nil {
+		return err
+	}
+	if *help {
+		pruneCmd.Usage()
+		return nil
+	}
+	if *repoDir == "" {
+		return fmt.Errorf("repo required")
+	}
+	r, err := repo.Open(*repoDir)
+	if err != nil {
+		return err
+	}
+	res, err := r.Prune(*keepRuns)
+	for _, name := range res.Runs {
+		fmt.Printf("removed run %s\n", name)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("removed %d unreferenced chunks (%d bytes)\n", res.Chunks, res.Bytes)
+	return nil
+}
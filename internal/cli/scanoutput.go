@@ -0,0 +1,369 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/syncopasoft/syncopa-core/internal/scanner"
+	"github.com/syncopasoft/syncopa-core/internal/task"
+	"github.com/syncopasoft/syncopa-core/internal/worker"
+)
+
+// Formats accepted by scan --output.
+const (
+	scanOutputText     = "text"
+	scanOutputJSON     = "json"
+	scanOutputJSONL    = "jsonl"
+	scanOutputItemized = "itemized"
+)
+
+// scanOutput prints the tasks planned by scan.
+type scanOutput interface {
+	// collision reports a path provided by several merged sources.
+	collision(c scanner.Collision)
+	// task prints a planned task.
+	task(t task.Task)
+	// finish completes the output once the scan succeeded.
+	finish() error
+}
+
+// scanOutputOptions describes the scan whose plan is printed.
+type scanOutputOptions struct {
+	verbose    bool
+	mode       string
+	precedence string
+	// reverse reports whether a copy to dst goes back to the source, which
+	// happens for bidirectional scans.
+	reverse func(dst string) bool
+}
+
+func newScanOutput(format string, w io.Writer, opts scanOutputOptions) (scanOutput, error) {
+	switch strings.ToLower(format) {
+	case scanOutputText:
+		return &textScanOutput{w: w, opts: opts}, nil
+	case scanOutputJSON:
+		return &jsonScanOutput{w: w, recorder: newScanRecorder()}, nil
+	case scanOutputJSONL:
+		return &jsonlScanOutput{enc: json.NewEncoder(w), recorder: newScanRecorder()}, nil
+	case scanOutputItemized:
+		return &itemizedScanOutput{w: w, errw: os.Stderr, opts: opts, recorder: newScanRecorder()}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
+
+// textScanOutput prints one line per task for people to read.
+type textScanOutput struct {
+	w    io.Writer
+	opts scanOutputOptions
+}
+
+func (o *textScanOutput) collision(c scanner.Collision) {
+	if o.opts.verbose {
+		fmt.Fprintf(o.w, "[collision:%s] %s <- %s (skipped %s)\n", o.opts.precedence, c.Path, c.Used, strings.Join(c.Skipped, ", "))
+	} else {
+		fmt.Fprintf(o.w, "collision %s <- %s\n", c.Path, c.Used)
+	}
+}
+
+func (o *textScanOutput) task(t task.Task) {
+	mode := o.opts.mode
+	switch t.Action {
+	case task.ActionCopy:
+		// A copy fanned out to several destinations is listed once per
+		// destination.
+		for i, dst := range t.Destinations() {
+			if o.opts.verbose {
+				fmt.Fprintf(o.w, "[copy:%s] %s -> %s%s\n", mode, t.Src, dst, explain(t.ExplanationFor(i)))
+			} else {
+				fmt.Fprintf(o.w, "%s -> %s\n", t.Src, dst)
+			}
+		}
+	case task.ActionCopyBatch:
+		count := 0
+		var totalBytes int64
+		firstDsts := []string{t.Dst}
+		if t.Batch != nil {
+			count = len(t.Batch.Entries)
+			if count > 0 {
+				first := t.Batch.Entries[0]
+				firstDsts = append([]string{first.Destination}, first.Fanout...)
+			}
+			for _, entry := range t.Batch.Entries {
+				totalBytes += entry.Size
+			}
+		}
+		for i, firstDst := range firstDsts {
+			if o.opts.verbose {
+				fmt.Fprintf(o.w, "[copy-batch:%s] %d files (%d bytes) -> %s\n", mode, count, totalBytes, firstDst)
+				if t.Batch == nil {
+					continue
+				}
+				for _, entry := range t.Batch.Entries {
+					dst := entry.Destination
+					if i > 0 {
+						dst = entry.Fanout[i-1]
+					}
+					fmt.Fprintf(o.w, "  %s -> %s%s\n", entry.Source, dst, explain(entry.ExplanationFor(i)))
+				}
+			} else {
+				fmt.Fprintf(o.w, "batch %d files -> %s\n", count, firstDst)
+			}
+		}
+	case task.ActionLink:
+		if o.opts.verbose {
+			fmt.Fprintf(o.w, "[link:%s] %s -> %s%s\n", mode, t.Src, t.Dst, explain(t.ExplanationFor(0)))
+		} else {
+			fmt.Fprintf(o.w, "link %s -> %s\n", t.Src, t.Dst)
+		}
+	case task.ActionDelete:
+		if o.opts.verbose {
+			fmt.Fprintf(o.w, "[delete:%s] %s%s\n", mode, t.Dst, explain(t.ExplanationFor(0)))
+		} else {
+			fmt.Fprintf(o.w, "delete %s\n", t.Dst)
+		}
+	}
+}
+
+func (o *textScanOutput) finish() error { return nil }
+
+// explain formats why a task was planned for verbose output.
+func explain(e *task.Explanation) string {
+	if e == nil {
+		return ""
+	}
+	return " (" + e.String() + ")"
+}
+
+// scanFile is one file planned by a scan for one destination.
+type scanFile struct {
+	Type   string      `json:"type"`
+	Action string      `json:"action"`
+	Src    string      `json:"src,omitempty"`
+	Dst    string      `json:"dst"`
+	Size   int64       `json:"size,omitempty"`
+	Reason task.Reason `json:"reason,omitempty"`
+	// SrcMeta and DstMeta are the source and destination metadata the
+	// scanner compared to pick the action.
+	SrcMeta *task.Metadata `json:"src_meta,omitempty"`
+	DstMeta *task.Metadata `json:"dst_meta,omitempty"`
+	// Task numbers the planned tasks from 1 in the order they were
+	// planned.
+	Task int `json:"task"`
+	// Batch numbers the batch tasks from 1 and is zero for files copied
+	// on their own.
+	Batch int `json:"batch,omitempty"`
+}
+
+func newScanFile(action, src, dst string, size int64, e *task.Explanation, id, batch int) scanFile {
+	f := scanFile{Type: "file", Action: action, Src: src, Dst: dst, Size: size, Task: id, Batch: batch}
+	if e != nil {
+		f.Reason, f.SrcMeta, f.DstMeta = e.Reason, e.Src, e.Dst
+	}
+	return f
+}
+
+// scanCollision is a path provided by several merged sources.
+type scanCollision struct {
+	Type    string   `json:"type"`
+	Path    string   `json:"path"`
+	Used    string   `json:"used"`
+	Skipped []string `json:"skipped"`
+}
+
+// scanSummary totals the files planned by a scan.
+type scanSummary struct {
+	Type    string                       `json:"type"`
+	Tasks   int                          `json:"tasks"`
+	Batches int                          `json:"batches"`
+	Actions map[string]*scanActionTotals `json:"actions"`
+}
+
+// scanActionTotals counts the files and bytes of one action. Files copied
+// to several destinations count once per destination.
+type scanActionTotals struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// scanRecorder turns tasks into per-file records and keeps the summary.
+type scanRecorder struct {
+	summary scanSummary
+}
+
+func newScanRecorder() scanRecorder {
+	return scanRecorder{summary: scanSummary{Type: "summary", Actions: make(map[string]*scanActionTotals)}}
+}
+
+// files returns one record per file and destination of t.
+func (r *scanRecorder) files(t task.Task) []scanFile {
+	r.summary.Tasks++
+	id := r.summary.Tasks
+	var files []scanFile
+	switch t.Action {
+	case task.ActionCopy:
+		for i, dst := range t.Destinations() {
+			files = append(files, newScanFile("copy", t.Src, dst, t.Size, t.ExplanationFor(i), id, 0))
+		}
+	case task.ActionCopyBatch:
+		r.summary.Batches++
+		if t.Batch == nil {
+			break
+		}
+		for _, entry := range t.Batch.Entries {
+			for i, dst := range append([]string{entry.Destination}, entry.Fanout...) {
+				files = append(files, newScanFile("copy", entry.Source, dst, entry.Size, entry.ExplanationFor(i), id, r.summary.Batches))
+			}
+		}
+	case task.ActionLink:
+		files = append(files, newScanFile("link", t.Src, t.Dst, t.Size, t.ExplanationFor(0), id, 0))
+	case task.ActionDelete:
+		files = append(files, newScanFile("delete", "", t.Dst, 0, t.ExplanationFor(0), id, 0))
+	}
+	for i := range files {
+		totals := r.summary.Actions[files[i].Action]
+		if totals == nil {
+			totals = &scanActionTotals{}
+			r.summary.Actions[files[i].Action] = totals
+		}
+		totals.Files++
+		totals.Bytes += files[i].Size
+	}
+	return files
+}
+
+func newScanCollision(c scanner.Collision) scanCollision {
+	return scanCollision{Type: "collision", Path: c.Path, Used: c.Used, Skipped: c.Skipped}
+}
+
+// jsonlScanOutput prints one JSON object per line: a record per file and
+// destination, a record per collision, and a final summary.
+type jsonlScanOutput struct {
+	enc      *json.Encoder
+	recorder scanRecorder
+	err      error
+}
+
+func (o *jsonlScanOutput) encode(v any) {
+	if o.err == nil {
+		o.err = o.enc.Encode(v)
+	}
+}
+
+func (o *jsonlScanOutput) collision(c scanner.Collision) {
+	o.encode(newScanCollision(c))
+}
+
+func (o *jsonlScanOutput) task(t task.Task) {
+	for _, f := range o.recorder.files(t) {
+		o.encode(f)
+	}
+}
+
+func (o *jsonlScanOutput) finish() error {
+	o.encode(o.recorder.summary)
+	return o.err
+}
+
+// jsonScanOutput prints a single JSON document once the scan completes.
+type jsonScanOutput struct {
+	w          io.Writer
+	recorder   scanRecorder
+	files      []scanFile
+	collisions []scanCollision
+}
+
+func (o *jsonScanOutput) collision(c scanner.Collision) {
+	o.collisions = append(o.collisions, newScanCollision(c))
+}
+
+func (o *jsonScanOutput) task(t task.Task) {
+	o.files = append(o.files, o.recorder.files(t)...)
+}
+
+func (o *jsonScanOutput) finish() error {
+	doc := struct {
+		Files      []scanFile      `json:"files"`
+		Collisions []scanCollision `json:"collisions"`
+		Summary    scanSummary     `json:"summary"`
+	}{Files: o.files, Collisions: o.collisions, Summary: o.recorder.summary}
+	if doc.Files == nil {
+		doc.Files = []scanFile{}
+	}
+	if doc.Collisions == nil {
+		doc.Collisions = []scanCollision{}
+	}
+	enc := json.NewEncoder(o.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// itemizedScanOutput prints one line per file and destination with an
+// rsync-like change code: the direction or kind of update, the file type,
+// and one column per attribute that triggered the copy.
+type itemizedScanOutput struct {
+	w io.Writer
+	// errw receives collisions and totals so that w holds only change
+	// codes.
+	errw     io.Writer
+	opts     scanOutputOptions
+	recorder scanRecorder
+}
+
+// itemizedAttributes maps reasons to the attribute columns of a change
+// code.
+var itemizedAttributes = map[task.Reason]string{
+	task.ReasonNew:  "+++++++++",
+	task.ReasonSize: ".s.......",
+	task.ReasonTime: "..t......",
+	task.ReasonETag: "c........",
+}
+
+func (o *itemizedScanOutput) collision(c scanner.Collision) {
+	fmt.Fprintf(o.errw, "collision %s <- %s\n", c.Path, c.Used)
+}
+
+func (o *itemizedScanOutput) task(t task.Task) {
+	for _, f := range o.recorder.files(t) {
+		switch f.Action {
+		case "delete":
+			fmt.Fprintf(o.w, "*deleting   %s\n", f.Dst)
+		case "link":
+			fmt.Fprintf(o.w, "hf%s %s => %s\n", itemizedAttributes[task.ReasonNew], f.Dst, f.Src)
+		default:
+			direction := ">"
+			if o.opts.reverse != nil && o.opts.reverse(f.Dst) {
+				direction = "<"
+			}
+			attrs, ok := itemizedAttributes[f.Reason]
+			if !ok {
+				attrs = "........."
+			}
+			fmt.Fprintf(o.w, "%sf%s %s\n", direction, attrs, f.Dst)
+		}
+	}
+}
+
+// finish prints the totals to errw, like collisions.
+func (o *itemizedScanOutput) finish() error {
+	summary := o.recorder.summary
+	line := fmt.Sprintf("total: %d tasks (%d batches)", summary.Tasks, summary.Batches)
+	names := make([]string, 0, len(summary.Actions))
+	for name := range summary.Actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		totals := summary.Actions[name]
+		if name == "delete" {
+			line += fmt.Sprintf("; %s: %d files", name, totals.Files)
+			continue
+		}
+		line += fmt.Sprintf("; %s: %d files, %s", name, totals.Files, worker.FormatBytes(totals.Bytes))
+	}
+	_, err := fmt.Fprintln(o.errw, line)
+	return err
+}
@@ -0,0 +1,220 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/scanner"
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+var scanOutputTime = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// scanOutputTasks plans one task of every kind and one copy back to the
+// source.
+func scanOutputTasks() []task.Task {
+	src := &task.Metadata{Size: 3, ModTime: scanOutputTime, Mode: 0o644}
+	dst := &task.Metadata{Size: 2, ModTime: scanOutputTime, Mode: 0o644}
+	return []task.Task{
+		{Action: task.ActionCopy, Src: "/src/a.txt", Dst: "/dst/a.txt", Size: 3, Explanations: []task.Explanation{{Reason: task.ReasonNew, Src: src}}},
+		{Action: task.ActionCopy, Src: "/src/b.txt", Dst: "/dst/b.txt", Size: 3, Explanations: []task.Explanation{{Reason: task.ReasonSize, Src: src, Dst: dst}}},
+		{Action: task.ActionCopyBatch, Src: "/src/c.txt", Dst: "/dst/c.txt", Batch: &task.CopyBatchPayload{Entries: []task.CopyBatchEntry{
+			{Source: "/src/c.txt", Destination: "/dst/c.txt", Size: 4, Explanations: []task.Explanation{{Reason: task.ReasonTime}}},
+			{Source: "/src/d.txt", Destination: "/dst/d.txt", Size: 5, Explanations: []task.Explanation{{Reason: task.ReasonETag}}},
+		}}},
+		{Action: task.ActionLink, Src: "/ref/e.txt", Dst: "/dst/e.txt", Explanations: []task.Explanation{{Reason: task.ReasonNew}}},
+		{Action: task.ActionDelete, Dst: "/dst/old.txt", Explanations: []task.Explanation{{Reason: task.ReasonExtra, Dst: dst}}},
+		{Action: task.ActionCopy, Src: "/dst/back.txt", Dst: "/src/back.txt", Size: 6, Explanations: []task.Explanation{{Reason: task.ReasonNew}}},
+	}
+}
+
+var scanOutputCollision = scanner.Collision{Path: "x.txt", Used: "/a/x.txt", Skipped: []string{"/b/x.txt"}}
+
+func runScanOutput(t *testing.T, format string, opts scanOutputOptions, stderr *bytes.Buffer, tasks []task.Task) string {
+	t.Helper()
+	var out bytes.Buffer
+	output, err := newScanOutput(format, &out, opts)
+	if err != nil {
+		t.Fatalf("newScanOutput(%q) returned error: %v", format, err)
+	}
+	if itemized, ok := output.(*itemizedScanOutput); ok {
+		itemized.errw = stderr
+	}
+	output.collision(scanOutputCollision)
+	for _, tk := range tasks {
+		output.task(tk)
+	}
+	if err := output.finish(); err != nil {
+		t.Fatalf("finish returned error: %v", err)
+	}
+	return out.String()
+}
+
+func TestScanOutputFormats(t *testing.T) {
+	reverse := func(dst string) bool { return strings.HasPrefix(dst, "/src/") }
+	cases := []struct {
+		format     string
+		opts       scanOutputOptions
+		want       []string
+		wantStderr []string
+	}{
+		{
+			format: scanOutputText,
+			want: []string{
+				"collision x.txt <- /a/x.txt",
+				"/src/a.txt -> /dst/a.txt",
+				"/src/b.txt -> /dst/b.txt",
+				"batch 2 files -> /dst/c.txt",
+				"link /ref/e.txt -> /dst/e.txt",
+				"delete /dst/old.txt",
+				"/dst/back.txt -> /src/back.txt",
+			},
+		},
+		{
+			format: scanOutputText,
+			opts:   scanOutputOptions{verbose: true, mode: "sync", precedence: "first"},
+			want: []string{
+				"[collision:first] x.txt <- /a/x.txt (skipped /b/x.txt)",
+				"[copy:sync] /src/a.txt -> /dst/a.txt (new: src 3 bytes, 2024-06-01T12:00:00Z, -rw-r--r--; dst missing)",
+				"[copy:sync] /src/b.txt -> /dst/b.txt (size: src 3 bytes, 2024-06-01T12:00:00Z, -rw-r--r--; dst 2 bytes, 2024-06-01T12:00:00Z, -rw-r--r--)",
+				"[copy-batch:sync] 2 files (9 bytes) -> /dst/c.txt",
+				"  /src/c.txt -> /dst/c.txt (time: dst missing)",
+				"  /src/d.txt -> /dst/d.txt (etag: dst missing)",
+				"[link:sync] /ref/e.txt -> /dst/e.txt (new: dst missing)",
+				"[delete:sync] /dst/old.txt (extra: dst 2 bytes, 2024-06-01T12:00:00Z, -rw-r--r--)",
+				"[copy:sync] /dst/back.txt -> /src/back.txt (new: dst missing)",
+			},
+		},
+		{
+			format: scanOutputItemized,
+			opts:   scanOutputOptions{reverse: reverse},
+			want: []string{
+				">f+++++++++ /dst/a.txt",
+				">f.s....... /dst/b.txt",
+				">f..t...... /dst/c.txt",
+				">fc........ /dst/d.txt",
+				"hf+++++++++ /dst/e.txt => /ref/e.txt",
+				"*deleting   /dst/old.txt",
+				"<f+++++++++ /src/back.txt",
+			},
+			wantStderr: []string{
+				"collision x.txt <- /a/x.txt",
+				"total: 6 tasks (1 batches); copy: 5 files, 21 B; delete: 1 files; link: 1 files, 0 B",
+			},
+		},
+		{
+			format: scanOutputJSONL,
+			want: []string{
+				`{"type":"collision","path":"x.txt","used":"/a/x.txt","skipped":["/b/x.txt"]}`,
+				`{"type":"file","action":"copy","src":"/src/a.txt","dst":"/dst/a.txt","size":3,"reason":"new","src_meta":{"size":3,"mtime":"2024-06-01T12:00:00Z","mode":420},"task":1}`,
+				`{"type":"file","action":"copy","src":"/src/b.txt","dst":"/dst/b.txt","size":3,"reason":"size","src_meta":{"size":3,"mtime":"2024-06-01T12:00:00Z","mode":420},"dst_meta":{"size":2,"mtime":"2024-06-01T12:00:00Z","mode":420},"task":2}`,
+				`{"type":"file","action":"copy","src":"/src/c.txt","dst":"/dst/c.txt","size":4,"reason":"time","task":3,"batch":1}`,
+				`{"type":"file","action":"copy","src":"/src/d.txt","dst":"/dst/d.txt","size":5,"reason":"etag","task":3,"batch":1}`,
+				`{"type":"file","action":"link","src":"/ref/e.txt","dst":"/dst/e.txt","reason":"new","task":4}`,
+				`{"type":"file","action":"delete","dst":"/dst/old.txt","reason":"extra","dst_meta":{"size":2,"mtime":"2024-06-01T12:00:00Z","mode":420},"task":5}`,
+				`{"type":"file","action":"copy","src":"/dst/back.txt","dst":"/src/back.txt","size":6,"reason":"new","task":6}`,
+				`{"type":"summary","tasks":6,"batches":1,"actions":{"copy":{"files":5,"bytes":21},"delete":{"files":1,"bytes":0},"link":{"files":1,"bytes":0}}}`,
+			},
+		},
+	}
+	for _, tc := range cases {
+		var stderr bytes.Buffer
+		got := runScanOutput(t, tc.format, tc.opts, &stderr, scanOutputTasks())
+		if want := strings.Join(tc.want, "\n") + "\n"; got != want {
+			t.Fatalf("%s (verbose %v): unexpected output:\n%s\nwant:\n%s", tc.format, tc.opts.verbose, got, want)
+		}
+		wantStderr := ""
+		if len(tc.wantStderr) > 0 {
+			wantStderr = strings.Join(tc.wantStderr, "\n") + "\n"
+		}
+		if stderr.String() != wantStderr {
+			t.Fatalf("%s: unexpected stderr:\n%s\nwant:\n%s", tc.format, stderr.String(), wantStderr)
+		}
+	}
+}
+
+func TestScanOutputJSONDocument(t *testing.T) {
+	tasks := scanOutputTasks()
+	got := runScanOutput(t, scanOutputJSON, scanOutputOptions{}, nil, []task.Task{tasks[0], tasks[4]})
+	want := `{
+  "files": [
+    {
+      "type": "file",
+      "action": "copy",
+      "src": "/src/a.txt",
+      "dst": "/dst/a.txt",
+      "size": 3,
+      "reason": "new",
+      "src_meta": {
+        "size": 3,
+        "mtime": "2024-06-01T12:00:00Z",
+        "mode": 420
+      },
+      "task": 1
+    },
+    {
+      "type": "file",
+      "action": "delete",
+      "dst": "/dst/old.txt",
+      "reason": "extra",
+      "dst_meta": {
+        "size": 2,
+        "mtime": "2024-06-01T12:00:00Z",
+        "mode": 420
+      },
+      "task": 2
+    }
+  ],
+  "collisions": [
+    {
+      "type": "collision",
+      "path": "x.txt",
+      "used": "/a/x.txt",
+      "skipped": [
+        "/b/x.txt"
+      ]
+    }
+  ],
+  "summary": {
+    "type": "summary",
+    "tasks": 2,
+    "batches": 0,
+    "actions": {
+      "copy": {
+        "files": 1,
+        "bytes": 3
+      },
+      "delete": {
+        "files": 1,
+        "bytes": 0
+      }
+    }
+  }
+}
+`
+	if got != want {
+		t.Fatalf("unexpected JSON document:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestScanOutputEmptyJSONDocument(t *testing.T) {
+	var out bytes.Buffer
+	output, err := newScanOutput(scanOutputJSON, &out, scanOutputOptions{})
+	if err != nil {
+		t.Fatalf("newScanOutput returned error: %v", err)
+	}
+	if err := output.finish(); err != nil {
+		t.Fatalf("finish returned error: %v", err)
+	}
+	if !strings.Contains(out.String(), `"files": []`) || !strings.Contains(out.String(), `"collisions": []`) {
+		t.Fatalf("expected empty lists rather than null, got:\n%s", out.String())
+	}
+}
+
+func TestNewScanOutputRejectsUnknownFormat(t *testing.T) {
+	if _, err := newScanOutput("yaml", &bytes.Buffer{}, scanOutputOptions{}); err == nil {
+		t.Fatal("expected an unknown format to be rejected")
+	}
+}
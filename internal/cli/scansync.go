@@ -1,13 +1,19 @@
 package cli
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/syncopasoft/syncopa-core/internal/backend"
+	"github.com/syncopasoft/syncopa-core/internal/plan"
+	"github.com/syncopasoft/syncopa-core/internal/repo"
 	"github.com/syncopasoft/syncopa-core/internal/scanner"
 	"github.com/syncopasoft/syncopa-core/internal/task"
 	"github.com/syncopasoft/syncopa-core/internal/worker"
@@ -39,9 +45,19 @@ type SyncConfig struct {
 // RunScan executes the scan command using the provided arguments and configuration.
 func RunScan(args []string, cfg ScanConfig) error {
 	scanCmd := flag.NewFlagSet("scan", flag.ExitOnError)
-	src := scanCmd.String("src", "", "source directory")
-	dst := scanCmd.String("dst", "", "destination directory")
+	var srcs stringList
+	scanCmd.Var(&srcs, "src", "source directory, s3://bucket/prefix, or .tar, .tar.gz or .zip archive; repeat as PATH[=DIR] to merge several local directories")
+	var dsts stringList
+	scanCmd.Var(&dsts, "dst", "destination directory, s3://bucket/prefix, or .tar, .tar.gz or .zip archive; repeat to plan for several local directories")
 	modeFlag := scanCmd.String("mode", "update", "planning mode: update (one-way copy), mirror (one-way copy + deletes), sync (bidirectional)")
+	precedenceFlag := scanCmd.String("precedence", "first", "source used for a path that several --src provide: first, newest, or error")
+	linkDest := scanCmd.String("link-dest", "", "reference tree laid out like the destination; unchanged files are hard-linked from it instead of copied")
+	safeguards := addDeleteSafeguardFlags(scanCmd)
+	stores := addObjectStoreFlags(scanCmd)
+	planOut := scanCmd.String("plan-out", "", "also save the plan to this file as JSON lines for sync --plan-in")
+	outputFlag := scanCmd.String("output", scanOutputText, "output format: text, json, jsonl, or itemized")
+	summary := scanCmd.Bool("summary", false, "print the impact of the plan by action and top-level directory instead of the planned tasks")
+	throughput := scanCmd.Int64("throughput", 0, "bytes per second used by --summary to estimate the duration (0 uses the throughput of earlier syncs to --dst)")
 	verbose := scanCmd.Bool("verbose", false, "enable verbose output")
 	batchThreshold := scanCmd.Int64("batch-threshold", 0, "maximum file size in bytes eligible for batching (0 disables)")
 	batchMaxFiles := scanCmd.Int("batch-max-files", 0, "maximum files per batch task (0 for unlimited)")
@@ -70,82 +86,168 @@ func RunScan(args []string, cfg ScanConfig) error {
 		scanCmd.Usage()
 		return nil
 	}
-	if *src == "" || *dst == "" {
+	if len(srcs) == 0 || len(dsts) == 0 {
 		return fmt.Errorf("src and dst required")
 	}
-	includeDir := !HasTrailingSeparator(*src)
+	if *planOut != "" {
+		// A plan names absolute locations so it can be executed from any
+		// directory.
+		var err error
+		if srcs, err = absoluteLocations(srcs, len(srcs) > 1); err != nil {
+			return err
+		}
+		if dsts, err = absoluteLocations(dsts, false); err != nil {
+			return err
+		}
+	}
+	locs := newLocations(stores)
+	defer locs.close()
+	sources, srcBackend, err := resolveSources(locs, srcs)
+	if err != nil {
+		return err
+	}
+	dstPaths, dstBackend, err := resolveDestinations(locs, dsts)
+	if err != nil {
+		return err
+	}
+	if (srcBackend != nil || dstBackend != nil) && *linkDest != "" {
+		return fmt.Errorf("--link-dest cannot be used with s3:// or archive locations")
+	}
 	mode, err := scanner.ParseMode(*modeFlag)
 	if err != nil {
 		return err
 	}
+	precedence, err := scanner.ParsePrecedence(*precedenceFlag)
+	if err != nil {
+		return err
+	}
 	opts := scanner.Options{
-		BatchThreshold:   *batchThreshold,
-		BatchMaxFiles:    *batchMaxFiles,
-		BatchMaxBytes:    *batchMaxBytes,
-		AutoTuneBatching: cfg.AutoBatch.Default,
+		BatchThreshold:     *batchThreshold,
+		BatchMaxFiles:      *batchMaxFiles,
+		BatchMaxBytes:      *batchMaxBytes,
+		AutoTuneBatching:   cfg.AutoBatch.Default,
+		LinkDest:           *linkDest,
+		Precedence:         precedence,
+		SourceBackend:      srcBackend,
+		DestinationBackend: dstBackend,
 	}
 	if cfg.AutoBatch.Forced != nil {
 		opts.AutoTuneBatching = *cfg.AutoBatch.Forced
 	} else if autoBatchFlag != nil {
 		opts.AutoTuneBatching = *autoBatchFlag
 	}
+	if err := safeguards.apply(&opts); err != nil {
+		return err
+	}
+	var output scanOutput
+	if *summary {
+		output, err = newScanSummary(*outputFlag, *throughput, dsts, dstPaths, sources, mode, srcBackend == nil && dstBackend == nil)
+	} else {
+		output, err = newScanOutput(*outputFlag, os.Stdout, scanOutputOptions{
+			verbose:    *verbose,
+			mode:       *modeFlag,
+			precedence: *precedenceFlag,
+			reverse: func(dst string) bool {
+				if mode != scanner.ModeSync {
+					return false
+				}
+				inside, err := pathWithin(sources[0].Root, dst)
+				return err == nil && inside
+			},
+		})
+	}
+	if err != nil {
+		return err
+	}
+	opts.OnCollision = output.collision
+	var out *planFile
+	if *planOut != "" {
+		out, err = createPlanFile(*planOut, plan.Header{
+			Created:      time.Now().UTC(),
+			Mode:         strings.ToLower(*modeFlag),
+			Sources:      srcs,
+			Destinations: dsts,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create plan: %w", err)
+		}
+		// Trees are fingerprinted before the first task is emitted.
+		opts.OnFingerprint = func(fp scanner.TreeFingerprint) {
+			out.AddTree(plan.TreeFromFingerprint(fp))
+		}
+	}
 
 	tasks := make(chan task.Task)
 	scanErr := make(chan error, 1)
 	go func() {
 		defer close(tasks)
-		scanErr <- scanner.Scan(*src, *dst, includeDir, mode, opts, tasks)
+		scanErr <- scanSources(sources, dstPaths, mode, opts, tasks)
 	}()
 
+	var planErr error
 	for t := range tasks {
-		switch t.Action {
-		case task.ActionCopy:
-			if *verbose {
-				fmt.Printf("[copy:%s] %s -> %s\n", *modeFlag, t.Src, t.Dst)
-			} else {
-				fmt.Printf("%s -> %s\n", t.Src, t.Dst)
-			}
-		case task.ActionCopyBatch:
-			count := 0
-			var totalBytes int64
-			firstDst := t.Dst
-			if t.Batch != nil {
-				count = len(t.Batch.Entries)
-				if count > 0 && firstDst == "" {
-					firstDst = t.Batch.Entries[0].Destination
-				}
-				for _, entry := range t.Batch.Entries {
-					totalBytes += entry.Size
-				}
-			}
-			if *verbose {
-				fmt.Printf("[copy-batch:%s] %d files (%d bytes) -> %s\n", *modeFlag, count, totalBytes, firstDst)
-			} else {
-				fmt.Printf("batch %d files -> %s\n", count, firstDst)
-			}
-		case task.ActionDelete:
-			if *verbose {
-				fmt.Printf("[delete:%s] %s\n", *modeFlag, t.Dst)
-			} else {
-				fmt.Printf("delete %s\n", t.Dst)
-			}
+		if out != nil && planErr == nil {
+			planErr = out.Add(t)
 		}
+		output.task(t)
 	}
 
 	if err := <-scanErr; err != nil {
+		if out != nil {
+			out.discard()
+		}
 		return err
 	}
-	return nil
+	if out != nil {
+		if planErr != nil {
+			out.discard()
+			return fmt.Errorf("failed to write plan: %w", planErr)
+		}
+		if err := out.commit(); err != nil {
+			return fmt.Errorf("failed to write plan: %w", err)
+		}
+	}
+	return output.finish()
 }
 
 // RunSync executes the sync command using the provided arguments and configuration.
 func RunSync(args []string, cfg SyncConfig) error {
 	syncCmd := flag.NewFlagSet("sync", flag.ExitOnError)
-	src := syncCmd.String("src", "", "source directory")
-	dst := syncCmd.String("dst", "", "destination directory")
+	var srcs stringList
+	syncCmd.Var(&srcs, "src", "source directory, s3://bucket/prefix, or .tar, .tar.gz or .zip archive; repeat as PATH[=DIR] to merge several local directories")
+	var dsts stringList
+	syncCmd.Var(&dsts, "dst", "destination directory, s3://bucket/prefix, or .tar, .tar.gz or .zip archive; repeat to replicate to several local directories")
 	workers := syncCmd.Int("workers", 4, "number of workers")
 	bandwidth := syncCmd.Int64("bandwidth", 0, "maximum bandwidth in bytes per second when copying (0 for unlimited)")
+	durabilityFlag := syncCmd.String("durability", string(worker.DurabilityFile), "flush written data: none, file (fsync each file), file+dir (also fsync parent directories), or syncfs (flush the destination filesystems once at the end)")
+	hashFlag := syncCmd.String("hash", string(worker.HashSHA256), "digest recorded for copied files: sha256, sha512, md5, crc32c, or none")
+	maxErrors := syncCmd.Int("max-errors", 0, "abort the run after this many failed tasks (0 keeps going)")
+	defaultRetry := worker.DefaultRetryPolicy()
+	retries := syncCmd.Int("retries", 0, "retry transient failures (EIO, ESTALE, ETIMEDOUT, ENOSPC, ...) up to this many times")
+	retryBackoff := syncCmd.Duration("retry-backoff", defaultRetry.InitialBackoff, "initial delay before retrying a failed task; doubles with jitter on every attempt")
+	retryMaxBackoff := syncCmd.Duration("retry-max-backoff", defaultRetry.MaxBackoff, "maximum delay between retries")
+	retryActions := syncCmd.String("retry-actions", "", "per-action retry counts overriding --retries, e.g. copy=5,delete=0")
+	journalPath := syncCmd.String("journal", "", "append completed tasks to this checkpoint journal (defaults to the user cache dir when --resume is set)")
+	resume := syncCmd.Bool("resume", false, "skip work recorded in the checkpoint journal by an interrupted run")
+	backupDir := syncCmd.String("backup-dir", "", "move destination files into a timestamped tree in this directory before overwriting them")
+	trashDir := syncCmd.String("trash-dir", "", "move deleted destination entries into a timestamped tree in this directory instead of removing them")
+	backupRetention := syncCmd.Duration("backup-retention", 0, "purge backup and trash trees older than this at the start of the run (0 keeps everything)")
+	versionsDir := syncCmd.String("versions-dir", "", "keep overwritten and deleted destination files as revisions in this directory")
+	keepVersions := syncCmd.Int("keep-versions", 0, "number of revisions kept per file in --versions-dir (0 keeps every revision)")
+	versionMaxAge := syncCmd.Duration("version-max-age", 0, "remove revisions older than this from --versions-dir; the newest revision of a file is always kept (0 disables)")
 	modeFlag := syncCmd.String("mode", "update", "sync mode: update (one-way copy), mirror (one-way copy + deletes), sync (bidirectional)")
+	precedenceFlag := syncCmd.String("precedence", "first", "source used for a path that several --src provide: first, newest, or error")
+	linkDest := syncCmd.String("link-dest", "", "reference tree laid out like the destination; unchanged files are hard-linked from it instead of copied")
+	repository := syncCmd.Bool("repository", false, "treat --dst as a deduplicating backup repository, created if missing; each run records a restorable manifest")
+	keyFile := syncCmd.String("encryption-key-file", "", "encrypt everything written to --dst with the 256-bit key in this file (raw, hex or base64)")
+	keyEnv := syncCmd.String("encryption-key-env", "", "encrypt everything written to --dst with the 256-bit key in this environment variable")
+	encryptNames := syncCmd.Bool("encrypt-names", false, "also encrypt file and directory names at an encrypted destination")
+	compress := syncCmd.String("compress", "none", "store destination files compressed: none, gzip (.gz) or flate (.flate)")
+	snapshot := syncCmd.Bool("snapshot", false, "write into a new timestamped snapshot directory below --dst, hard-linking unchanged files from the previous snapshot")
+	planIn := syncCmd.String("plan-in", "", "execute the plan saved by scan --plan-out instead of scanning")
+	planStale := syncCmd.String("plan-stale", planStaleRefuse, "planned files whose source changed since --plan-in was made: refuse the plan, skip them, or revalidate them against the destination")
+	safeguards := addDeleteSafeguardFlags(syncCmd)
+	stores := addObjectStoreFlags(syncCmd)
 	verbose := syncCmd.Bool("verbose", false, "enable verbose output")
 	batchThreshold := syncCmd.Int64("batch-threshold", 0, "maximum file size in bytes eligible for batching (0 disables)")
 	batchMaxFiles := syncCmd.Int("batch-max-files", 0, "maximum files per batch task (0 for unlimited)")
@@ -179,38 +281,352 @@ func RunSync(args []string, cfg SyncConfig) error {
 		syncCmd.Usage()
 		return nil
 	}
-	if *src == "" || *dst == "" {
+	var planHeader plan.Header
+	if *planIn != "" {
+		switch *planStale {
+		case planStaleRefuse, planStaleSkip, planStaleRevalidate:
+		default:
+			return fmt.Errorf("unknown --plan-stale %q", *planStale)
+		}
+		r, err := plan.Open(*planIn)
+		if err != nil {
+			return err
+		}
+		planHeader = r.Header
+		r.Close()
+		// The plan names its locations and mode; any given on the command
+		// line must agree with them.
+		if srcs, err = planLocations("--src", srcs, planHeader.Sources, len(srcs) > 1); err != nil {
+			return err
+		}
+		if dsts, err = planLocations("--dst", dsts, planHeader.Destinations, false); err != nil {
+			return err
+		}
+		syncCmd.Visit(func(f *flag.Flag) {
+			if f.Name == "mode" && !strings.EqualFold(*modeFlag, planHeader.Mode) {
+				err = fmt.Errorf("--mode %s does not match the plan, which was made with --mode %s", *modeFlag, planHeader.Mode)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		*modeFlag = planHeader.Mode
+	}
+	if len(srcs) == 0 || len(dsts) == 0 {
 		return fmt.Errorf("src and dst required")
 	}
-	includeDir := !HasTrailingSeparator(*src)
+	srcLocations, dstLocations := append([]string(nil), srcs...), append([]string(nil), dsts...)
+	var dstBackend backend.Backend
+	locs := newLocations(stores)
+	defer locs.close()
+	sources, srcBackend, err := resolveSources(locs, srcLocations)
+	if err != nil {
+		return err
+	}
+	if dsts, dstBackend, err = resolveDestinations(locs, dstLocations); err != nil {
+		return err
+	}
+	// The first destination is the one repositories, snapshots, encryption
+	// and compression apply to; they are refused for fan-out runs below.
+	dst := &dsts[0]
 	mode, err := scanner.ParseMode(*modeFlag)
 	if err != nil {
 		return err
 	}
+	precedence, err := scanner.ParsePrecedence(*precedenceFlag)
+	if err != nil {
+		return err
+	}
 	opts := scanner.Options{
-		BatchThreshold:   *batchThreshold,
-		BatchMaxFiles:    *batchMaxFiles,
-		BatchMaxBytes:    *batchMaxBytes,
-		AutoTuneBatching: cfg.AutoBatch.Default,
+		BatchThreshold:     *batchThreshold,
+		BatchMaxFiles:      *batchMaxFiles,
+		BatchMaxBytes:      *batchMaxBytes,
+		AutoTuneBatching:   cfg.AutoBatch.Default,
+		LinkDest:           *linkDest,
+		Precedence:         precedence,
+		SourceBackend:      srcBackend,
+		DestinationBackend: dstBackend,
 	}
 	if cfg.AutoBatch.Forced != nil {
 		opts.AutoTuneBatching = *cfg.AutoBatch.Forced
 	} else if autoBatchFlag != nil {
 		opts.AutoTuneBatching = *autoBatchFlag
 	}
+	if err := safeguards.apply(&opts); err != nil {
+		return err
+	}
+	opts.OnCollision = func(c scanner.Collision) {
+		fmt.Printf("collision %s: using %s, skipping %s\n", c.Path, c.Used, strings.Join(c.Skipped, ", "))
+	}
+	if len(sources) > 1 && mode == scanner.ModeSync {
+		return fmt.Errorf("--mode sync cannot be used with multiple --src")
+	}
+	if len(dsts) > 1 {
+		switch {
+		case mode == scanner.ModeSync:
+			return fmt.Errorf("--mode sync cannot be used with multiple --dst")
+		case *repository || *snapshot || opts.LinkDest != "":
+			return fmt.Errorf("multiple --dst cannot be combined with --repository, --snapshot or --link-dest")
+		case *keyFile != "" || *keyEnv != "" || *compress != "none":
+			return fmt.Errorf("multiple --dst cannot be combined with an encrypted or compressed destination")
+		case *backupDir != "" || *trashDir != "" || *versionsDir != "":
+			return fmt.Errorf("multiple --dst cannot be combined with --backup-dir, --trash-dir or --versions-dir")
+		}
+	}
+	if *planIn != "" {
+		// The plan fixes the destination paths and already holds any links.
+		switch {
+		case *repository || *snapshot || opts.LinkDest != "":
+			return fmt.Errorf("--plan-in cannot be combined with --repository, --snapshot or --link-dest")
+		case *keyFile != "" || *keyEnv != "" || *compress != "none":
+			return fmt.Errorf("--plan-in cannot be combined with an encrypted or compressed destination")
+		}
+	}
+	if srcBackend != nil || dstBackend != nil {
+		switch {
+		case *repository || *snapshot || opts.LinkDest != "":
+			return fmt.Errorf("s3:// and archive locations cannot be combined with --repository, --snapshot or --link-dest")
+		case *keyFile != "" || *keyEnv != "" || *compress != "none":
+			return fmt.Errorf("s3:// and archive locations cannot be combined with an encrypted or compressed destination")
+		case *backupDir != "" || *trashDir != "" || *versionsDir != "":
+			return fmt.Errorf("s3:// and archive locations cannot be combined with --backup-dir, --trash-dir or --versions-dir")
+		case locs.hasArchives() && mode == scanner.ModeSync:
+			return fmt.Errorf("archive locations cannot be used with --mode sync")
+		case locs.hasArchives() && (*journalPath != "" || *resume):
+			// Archives are only written once the run succeeds, so a
+			// journal would record work that never reached the disk.
+			return fmt.Errorf("archive locations cannot be combined with --journal or --resume")
+		}
+	}
+	now := time.Now()
+	if *snapshot {
+		if mode == scanner.ModeSync {
+			return fmt.Errorf("--snapshot cannot be used with --mode sync")
+		}
+		dir, previous, err := worker.NextSnapshot(*dst, now)
+		if err != nil {
+			return err
+		}
+		if opts.LinkDest == "" {
+			opts.LinkDest = previous
+		}
+		if *verbose {
+			fmt.Printf("snapshot %s\n", dir)
+		}
+		*dst = dir
+	}
+	retryOverrides, err := worker.ParseRetryOverrides(*retryActions)
+	if err != nil {
+		return err
+	}
+	hashAlgorithm, err := worker.ParseHashAlgorithm(*hashFlag)
+	if err != nil {
+		return err
+	}
+	durability, err := worker.ParseDurability(*durabilityFlag)
+	if err != nil {
+		return err
+	}
+
+	pool := worker.New(*workers, *verbose, *bandwidth)
+	pool.MaxErrors = *maxErrors
+	pool.HashAlgorithm = hashAlgorithm
+	pool.Durability = durability
+	pool.SourceBackend = srcBackend
+	pool.DestinationBackend = dstBackend
+	pool.DestinationRoots = append([]string(nil), dsts...)
+	pool.Destinations = dsts
+	if mode == scanner.ModeSync {
+		// Bidirectional runs also write back into the source tree.
+		pool.DestinationRoots = append(pool.DestinationRoots, sources[0].Root)
+	}
+	retryPolicy := func(retries int) worker.RetryPolicy {
+		policy := defaultRetry
+		policy.MaxAttempts = retries + 1
+		policy.InitialBackoff = *retryBackoff
+		policy.MaxBackoff = *retryMaxBackoff
+		return policy
+	}
+	pool.Retry = retryPolicy(*retries)
+	if len(retryOverrides) > 0 {
+		pool.ActionRetry = make(map[task.Action]worker.RetryPolicy, len(retryOverrides))
+		for action, n := range retryOverrides {
+			pool.ActionRetry[action] = retryPolicy(n)
+		}
+	}
+
+	if *versionsDir != "" && (*backupDir != "" || *trashDir != "") {
+		return fmt.Errorf("--versions-dir cannot be combined with --backup-dir or --trash-dir")
+	}
+	for _, dir := range []string{*backupDir, *trashDir, *versionsDir} {
+		if dir == "" {
+			continue
+		}
+		// A tree inside a synced directory would be scanned, copied, or
+		// deleted as an extra by the run it protects.
+		for _, root := range pool.DestinationRoots {
+			inside, err := pathWithin(root, dir)
+			if err != nil {
+				return err
+			}
+			if inside {
+				return fmt.Errorf("%s must not be inside %s", dir, root)
+			}
+		}
+	}
+	for _, dir := range []string{*backupDir, *trashDir} {
+		if dir == "" {
+			continue
+		}
+		removed, err := worker.PurgeBackups(dir, *backupRetention, now)
+		if err != nil {
+			return fmt.Errorf("failed to purge %s: %w", dir, err)
+		}
+		if *verbose {
+			for _, path := range removed {
+				fmt.Printf("purged %s\n", path)
+			}
+		}
+	}
+	if *backupDir != "" {
+		pool.Backup = worker.NewBackupTree(*backupDir, pool.DestinationRoots, now)
+	}
+	if *trashDir != "" {
+		pool.Trash = worker.NewBackupTree(*trashDir, pool.DestinationRoots, now)
+	}
+	if *versionsDir != "" {
+		store := worker.NewVersionStore(*versionsDir, pool.DestinationRoots, now)
+		store.KeepVersions = *keepVersions
+		store.MaxAge = *versionMaxAge
+		removed, err := store.Prune()
+		if err != nil {
+			return fmt.Errorf("failed to prune %s: %w", *versionsDir, err)
+		}
+		if *verbose {
+			for _, path := range removed {
+				fmt.Printf("pruned %s\n", path)
+			}
+		}
+		pool.Versions = store
+	}
+	var run *repo.Run
+	if *repository {
+		switch {
+		case mode == scanner.ModeSync:
+			return fmt.Errorf("--repository cannot be used with --mode sync")
+		case *snapshot || opts.LinkDest != "":
+			return fmt.Errorf("--repository cannot be combined with --snapshot or --link-dest")
+		case *backupDir != "" || *trashDir != "" || *versionsDir != "":
+			return fmt.Errorf("--repository keeps every run; it cannot be combined with --backup-dir, --trash-dir or --versions-dir")
+		case *journalPath != "" || *resume:
+			return fmt.Errorf("--repository cannot be combined with --journal or --resume")
+		}
+		r, err := repo.OpenOrInit(*dst)
+		if err != nil {
+			return err
+		}
+		r.Sync = durability != worker.DurabilityNone
+		if run, err = r.NewRun(now); err != nil {
+			return err
+		}
+		opts.Destination = run
+		pool.Repository = run
+	}
+	if *keyFile != "" && *keyEnv != "" {
+		return fmt.Errorf("--encryption-key-file and --encryption-key-env are mutually exclusive")
+	}
+	if *encryptNames && *keyFile == "" && *keyEnv == "" {
+		return fmt.Errorf("--encrypt-names requires --encryption-key-file or --encryption-key-env")
+	}
+	if *keyFile != "" || *keyEnv != "" {
+		switch {
+		case mode == scanner.ModeSync:
+			return fmt.Errorf("encrypted destinations cannot be used with --mode sync")
+		case *repository || *snapshot || opts.LinkDest != "":
+			return fmt.Errorf("encrypted destinations cannot be combined with --repository, --snapshot or --link-dest")
+		case *backupDir != "" || *trashDir != "" || *versionsDir != "":
+			return fmt.Errorf("encrypted destinations cannot be combined with --backup-dir, --trash-dir or --versions-dir")
+		}
+		key, err := worker.LoadEncryptionKey(*keyFile, *keyEnv)
+		if err != nil {
+			return err
+		}
+		enc, err := worker.NewEncryptor(*dst, key, *encryptNames)
+		if err != nil {
+			return err
+		}
+		if err := enc.Prepare(); err != nil {
+			return err
+		}
+		opts.Destination = enc
+		pool.Encryption = enc
+	}
+	if *compress != "none" {
+		switch {
+		case mode == scanner.ModeSync:
+			return fmt.Errorf("--compress cannot be used with --mode sync")
+		case *keyFile != "" || *keyEnv != "":
+			return fmt.Errorf("--compress cannot be combined with an encrypted destination")
+		case *repository || *snapshot || opts.LinkDest != "":
+			return fmt.Errorf("--compress cannot be combined with --repository, --snapshot or --link-dest")
+		case *backupDir != "" || *trashDir != "" || *versionsDir != "":
+			return fmt.Errorf("--compress cannot be combined with --backup-dir, --trash-dir or --versions-dir")
+		}
+		comp, err := worker.NewCompressor(*dst, *compress)
+		if err != nil {
+			return err
+		}
+		opts.Destination = comp
+		pool.Compression = comp
+	}
+
+	if *journalPath == "" && *resume {
+		*journalPath, err = defaultJournalPath(srcLocations, dstLocations)
+		if err != nil {
+			return err
+		}
+	}
+	if *journalPath != "" {
+		journal, err := worker.OpenJournal(*journalPath, *resume)
+		if err != nil {
+			return fmt.Errorf("failed to open journal: %w", err)
+		}
+		defer journal.Close()
+		pool.Journal = journal
+	}
+
+	changed := false
+	planned := planTrees{src: srcBackend, dst: dstBackend, sources: sources, dsts: dsts}
+	if *planIn != "" {
+		trees, err := plan.ChangedTrees(planHeader, srcBackend, dstBackend)
+		if err != nil {
+			return fmt.Errorf("failed to check the plan: %w", err)
+		}
+		dstChanged := false
+		for _, tree := range trees {
+			fmt.Printf("%s changed since the plan was made\n", tree.Root)
+			dstChanged = dstChanged || !tree.Source
+		}
+		changed = len(trees) > 0
+		if changed && *planStale == planStaleRefuse {
+			if err := checkPlan(*planIn, planned, dstChanged); err != nil {
+				return err
+			}
+		}
+	}
 
 	tasks := make(chan task.Task)
 	scanErr := make(chan error, 1)
 	go func() {
 		defer close(tasks)
-		scanErr <- scanner.Scan(*src, *dst, includeDir, mode, opts, tasks)
+		if *planIn != "" {
+			scanErr <- feedPlan(*planIn, *planStale, changed, planned, tasks)
+			return
+		}
+		scanErr <- scanSources(sources, dsts, mode, opts, tasks)
 	}()
 
-	pool := worker.New(*workers, *verbose, *bandwidth)
-	report, err := pool.Run(tasks)
-	if err != nil {
-		return err
-	}
+	report, runErr := pool.Run(tasks)
 
 	var pdfPath, csvPath string
 	if reportPDF != nil {
@@ -219,6 +635,8 @@ func RunSync(args []string, cfg SyncConfig) error {
 	if reportCSV != nil {
 		csvPath = *reportCSV
 	}
+	// Reports are written even when tasks failed so the failures can be
+	// inspected.
 	if err := handleSyncReportOutput(report, cfg, pdfPath, csvPath); err != nil {
 		return err
 	}
@@ -226,9 +644,134 @@ func RunSync(args []string, cfg SyncConfig) error {
 	if err := <-scanErr; err != nil {
 		return err
 	}
+	if runErr != nil {
+		return runErr
+	}
+	// Like repository runs, archives are only rewritten after a complete
+	// run, so a failed run leaves the previous archive in place.
+	if err := locs.commit(); err != nil {
+		return err
+	}
+	if run != nil {
+		// Only complete runs are recorded; chunks stored by a failed run
+		// are collected by prune.
+		if err := run.Commit(); err != nil {
+			return fmt.Errorf("failed to record repository run: %w", err)
+		}
+		fmt.Printf("Recorded run %s in %s (%d bytes of new data)\n", run.Stamp(), *dst, run.StoredBytes())
+	}
+	if !*resume && report.TotalBytes() > 0 && report.Duration() > 0 {
+		// The history only feeds scan --summary estimates, so failing to
+		// record it does not fail the run.
+		if path, err := throughputHistoryPath(dstLocations); err == nil {
+			_ = recordThroughput(path, report.TotalBytes(), report.Duration())
+		}
+	}
+	// The run finished cleanly, so there is nothing left to resume.
+	if err := pool.Journal.Remove(); err != nil {
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+	return nil
+}
+
+// deleteSafeguards holds the mirror-mode safety flags shared by scan and sync.
+type deleteSafeguards struct {
+	force     *bool
+	maxDelete *string
+	protect   stringList
+}
+
+func addDeleteSafeguardFlags(fs *flag.FlagSet) *deleteSafeguards {
+	s := &deleteSafeguards{}
+	s.force = fs.Bool("force", false, "mirror even when the source root is missing or empty")
+	s.maxDelete = fs.String("max-delete", "", "refuse to mirror when more destination entries would be deleted, as a count (500) or a percentage (10%)")
+	fs.Var(&s.protect, "protect", "destination path pattern that is never deleted; may be repeated")
+	return s
+}
+
+func (s *deleteSafeguards) apply(opts *scanner.Options) error {
+	maxDelete, maxDeletePercent, err := scanner.ParseDeleteLimit(*s.maxDelete)
+	if err != nil {
+		return err
+	}
+	if err := scanner.ValidateProtectPatterns(s.protect); err != nil {
+		return err
+	}
+	opts.AllowEmptySource = *s.force
+	opts.MaxDelete = maxDelete
+	opts.MaxDeletePercent = maxDeletePercent
+	opts.Protect = s.protect
 	return nil
 }
 
+// stringList is a flag.Value collecting every occurrence of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// defaultJournalPath returns a per sources/destinations journal location
+// inside the user's cache directory.
+func defaultJournalPath(srcs, dsts []string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine journal location, use --journal: %w", err)
+	}
+	key, err := absLocation(srcs[0])
+	if err != nil {
+		return "", err
+	}
+	for _, src := range srcs[1:] {
+		absSrc, err := absLocation(src)
+		if err != nil {
+			return "", err
+		}
+		key += "\x01" + absSrc
+	}
+	for _, dst := range dsts {
+		absDst, err := absLocation(dst)
+		if err != nil {
+			return "", err
+		}
+		key += "\x00" + absDst
+	}
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:8]) + ".jsonl"
+	return filepath.Join(cacheDir, "syncopa-core", "journals", name), nil
+}
+
+// absLocation returns the absolute form of a local path. Object store URLs
+// are already absolute.
+func absLocation(location string) (string, error) {
+	if strings.HasPrefix(location, "s3://") {
+		return location, nil
+	}
+	return filepath.Abs(location)
+}
+
+// pathWithin reports whether path is root itself or lies below it.
+func pathWithin(root, path string) (bool, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return false, err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(absRoot, absPath)
+	if err != nil {
+		return false, nil
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))), nil
+}
+
 func writeReportFile(path string, writer func(io.Writer) error) error {
 	if path == "" {
 		return nil
@@ -0,0 +1,398 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/scanner"
+	"github.com/syncopasoft/syncopa-core/internal/task"
+	"github.com/syncopasoft/syncopa-core/internal/worker"
+)
+
+// throughputHistoryRuns is the number of recent sync runs kept to estimate
+// the throughput to a set of destinations.
+const throughputHistoryRuns = 10
+
+// Sources of the throughput a scan summary estimates the duration with.
+const (
+	throughputConfigured = "configured"
+	throughputHistory    = "history"
+)
+
+// scanImpact is the report printed by scan --summary.
+type scanImpact struct {
+	Type        string                       `json:"type"`
+	Tasks       int                          `json:"tasks"`
+	Batches     int                          `json:"batches"`
+	Collisions  int                          `json:"collisions"`
+	Actions     map[string]*scanActionTotals `json:"actions"`
+	Directories []scanDirectoryImpact        `json:"directories"`
+	// Sizes is the distribution of the sizes of the copied files.
+	Sizes scanner.SizeDistribution `json:"sizes"`
+	// Throughput is in bytes per second and is zero when unknown.
+	Throughput       float64           `json:"throughput,omitempty"`
+	ThroughputSource string            `json:"throughput_source,omitempty"`
+	EstimatedSeconds float64           `json:"estimated_seconds,omitempty"`
+	Space            []scanSpaceImpact `json:"space"`
+}
+
+// scanDirectoryImpact totals the files planned below a top-level directory
+// of the destinations. "." holds the files at the top level.
+type scanDirectoryImpact struct {
+	Path    string                       `json:"path"`
+	Actions map[string]*scanActionTotals `json:"actions"`
+}
+
+// scanSpaceImpact compares the bytes copied into a tree with the space left
+// on its filesystem. Required ignores the space freed by overwrites and
+// deletes, so it is an upper bound.
+type scanSpaceImpact struct {
+	Root     string `json:"root"`
+	Required int64  `json:"required"`
+	// Free is nil when the free space could not be read.
+	Free  *int64 `json:"free,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Sufficient reports whether the tree has room for the planned copies.
+func (s scanSpaceImpact) Sufficient() bool {
+	return s.Free == nil || *s.Free >= s.Required
+}
+
+// impactScanOutput collects the plan and prints its scanImpact instead of
+// the planned tasks.
+type impactScanOutput struct {
+	w        io.Writer
+	json     bool
+	recorder scanRecorder
+	// roots are the trees copies are written to; local is set when they
+	// are local directories whose free space can be read.
+	roots      []string
+	local      bool
+	throughput float64
+	source     string
+	collisions int
+	dirs       map[string]map[string]*scanActionTotals
+	required   map[string]int64
+	sizes      []int64
+}
+
+func newImpactScanOutput(w io.Writer, asJSON bool, roots []string, local bool, throughput float64, source string) *impactScanOutput {
+	return &impactScanOutput{
+		w:          w,
+		json:       asJSON,
+		recorder:   newScanRecorder(),
+		roots:      roots,
+		local:      local,
+		throughput: throughput,
+		source:     source,
+		dirs:       make(map[string]map[string]*scanActionTotals),
+		required:   make(map[string]int64),
+	}
+}
+
+// newScanSummary returns the output of scan --summary for the --output
+// format. Copies are written to the destinations and, for bidirectional
+// scans, to the source as well.
+func newScanSummary(format string, throughput int64, dstLocations, dsts []string, sources []scanner.Source, mode scanner.Mode, local bool) (scanOutput, error) {
+	var asJSON bool
+	switch strings.ToLower(format) {
+	case scanOutputText:
+	case scanOutputJSON, scanOutputJSONL:
+		asJSON = true
+	default:
+		return nil, fmt.Errorf("--summary cannot be combined with --output %s", format)
+	}
+	if throughput < 0 {
+		return nil, fmt.Errorf("--throughput cannot be negative")
+	}
+	rate, source := float64(throughput), throughputConfigured
+	if throughput == 0 {
+		rate, source = estimatedThroughput(dstLocations, os.Stderr), throughputHistory
+	}
+	roots := append([]string(nil), dsts...)
+	if mode == scanner.ModeSync {
+		roots = append(roots, sources[0].Root)
+	}
+	return newImpactScanOutput(os.Stdout, asJSON, roots, local, rate, source), nil
+}
+
+func (o *impactScanOutput) collision(scanner.Collision) {
+	o.collisions++
+}
+
+func (o *impactScanOutput) task(t task.Task) {
+	for _, f := range o.recorder.files(t) {
+		root, dir := o.topLevel(f.Dst)
+		actions := o.dirs[dir]
+		if actions == nil {
+			actions = make(map[string]*scanActionTotals)
+			o.dirs[dir] = actions
+		}
+		totals := actions[f.Action]
+		if totals == nil {
+			totals = &scanActionTotals{}
+			actions[f.Action] = totals
+		}
+		totals.Files++
+		totals.Bytes += f.Size
+		if f.Action == "copy" {
+			o.sizes = append(o.sizes, f.Size)
+			o.required[root] += f.Size
+		}
+	}
+}
+
+// topLevel returns the root holding path and the top-level directory of
+// path below it.
+func (o *impactScanOutput) topLevel(path string) (string, string) {
+	for _, root := range o.roots {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if i := strings.IndexRune(rel, filepath.Separator); i >= 0 {
+			return root, rel[:i]
+		}
+		return root, "."
+	}
+	return "", "."
+}
+
+func (o *impactScanOutput) finish() error {
+	impact := o.impact()
+	if o.json {
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(impact)
+	}
+	writeScanImpact(o.w, impact)
+	return nil
+}
+
+func (o *impactScanOutput) impact() scanImpact {
+	summary := o.recorder.summary
+	impact := scanImpact{
+		Type:        "impact",
+		Tasks:       summary.Tasks,
+		Batches:     summary.Batches,
+		Collisions:  o.collisions,
+		Actions:     summary.Actions,
+		Directories: []scanDirectoryImpact{},
+		Sizes:       scanner.NewSizeDistribution(o.sizes),
+		Space:       []scanSpaceImpact{},
+	}
+	for dir, actions := range o.dirs {
+		impact.Directories = append(impact.Directories, scanDirectoryImpact{Path: dir, Actions: actions})
+	}
+	sort.Slice(impact.Directories, func(i, j int) bool { return impact.Directories[i].Path < impact.Directories[j].Path })
+	if o.throughput > 0 {
+		impact.Throughput = o.throughput
+		impact.ThroughputSource = o.source
+		impact.EstimatedSeconds = float64(impact.Sizes.Bytes) / o.throughput
+	}
+	for _, root := range o.roots {
+		space := scanSpaceImpact{Root: root, Required: o.required[root]}
+		if !o.local {
+			space.Error = "free space is only known for local directories"
+		} else if free, err := freeSpace(existingParent(root)); err != nil {
+			space.Error = err.Error()
+		} else {
+			space.Free = &free
+		}
+		impact.Space = append(impact.Space, space)
+	}
+	return impact
+}
+
+// existingParent returns path or its closest existing parent, which is on
+// the filesystem a missing destination would be created on.
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// writeScanImpact prints impact for people to read.
+func writeScanImpact(w io.Writer, impact scanImpact) {
+	fmt.Fprintln(w, "Scan Summary")
+	fmt.Fprintln(w, strings.Repeat("=", len("Scan Summary")))
+	fmt.Fprintf(w, "Tasks: %d (%d batches)\n", impact.Tasks, impact.Batches)
+	if impact.Collisions > 0 {
+		fmt.Fprintf(w, "Collisions: %d\n", impact.Collisions)
+	}
+	fmt.Fprintln(w, "\nBy action:")
+	writeActionTotals(w, "  ", impact.Actions)
+	if len(impact.Directories) > 0 {
+		fmt.Fprintln(w, "\nBy top-level directory:")
+		for _, dir := range impact.Directories {
+			fmt.Fprintf(w, "  %s\n", dir.Path)
+			writeActionTotals(w, "    ", dir.Actions)
+		}
+	}
+	if sizes := impact.Sizes; sizes.Files > 0 {
+		fmt.Fprintln(w, "\nCopied file sizes:")
+		fmt.Fprintf(w, "  min %s, median %s, p90 %s, p99 %s, max %s\n",
+			worker.FormatBytes(sizes.Min), worker.FormatBytes(sizes.P50), worker.FormatBytes(sizes.P90), worker.FormatBytes(sizes.P99), worker.FormatBytes(sizes.Max))
+	}
+	fmt.Fprintln(w)
+	if impact.Throughput > 0 {
+		estimate := time.Duration(impact.EstimatedSeconds * float64(time.Second)).Round(time.Second)
+		fmt.Fprintf(w, "Estimated duration: %s at %s/s (%s)\n", estimate, worker.FormatBytes(int64(impact.Throughput)), impact.ThroughputSource)
+	} else {
+		fmt.Fprintln(w, "Estimated duration: unknown; pass --throughput or sync to these destinations once")
+	}
+	if len(impact.Space) > 0 {
+		fmt.Fprintln(w, "\nSpace:")
+	}
+	for _, space := range impact.Space {
+		switch {
+		case space.Free == nil:
+			fmt.Fprintf(w, "  %s: %s required, free space unknown (%s)\n", space.Root, worker.FormatBytes(space.Required), space.Error)
+		case space.Sufficient():
+			fmt.Fprintf(w, "  %s: %s required, %s free\n", space.Root, worker.FormatBytes(space.Required), worker.FormatBytes(*space.Free))
+		default:
+			fmt.Fprintf(w, "  %s: %s required, %s free (insufficient)\n", space.Root, worker.FormatBytes(space.Required), worker.FormatBytes(*space.Free))
+		}
+	}
+}
+
+func writeActionTotals(w io.Writer, indent string, actions map[string]*scanActionTotals) {
+	if len(actions) == 0 {
+		fmt.Fprintf(w, "%snothing to do\n", indent)
+		return
+	}
+	names := make([]string, 0, len(actions))
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		totals := actions[name]
+		if name == "delete" {
+			fmt.Fprintf(w, "%s%s: %d files\n", indent, name, totals.Files)
+			continue
+		}
+		fmt.Fprintf(w, "%s%s: %d files, %s\n", indent, name, totals.Files, worker.FormatBytes(totals.Bytes))
+	}
+}
+
+// throughputRecord is one sync run in the throughput history.
+type throughputRecord struct {
+	Finished time.Time `json:"finished"`
+	Bytes    int64     `json:"bytes"`
+	Seconds  float64   `json:"seconds"`
+}
+
+// throughputHistoryPath returns the location of the throughput history of
+// runs to dsts in the user cache dir.
+func throughputHistoryPath(dsts []string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	var key string
+	for _, dst := range dsts {
+		absDst, err := absLocation(dst)
+		if err != nil {
+			return "", err
+		}
+		key += "\x00" + absDst
+	}
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:8]) + ".json"
+	return filepath.Join(cacheDir, "syncopa-core", "throughput", name), nil
+}
+
+func readThroughputHistory(path string) ([]throughputRecord, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var runs []throughputRecord
+	if err := json.Unmarshal(data, &runs); err != nil {
+		return nil, fmt.Errorf("throughput history %s: %w", path, err)
+	}
+	return runs, nil
+}
+
+// estimatedThroughput returns the throughput of recent runs to dsts. The
+// history only feeds estimates, so when it cannot be read a warning is
+// written to warn and the throughput is unknown.
+func estimatedThroughput(dsts []string, warn io.Writer) float64 {
+	var rate float64
+	path, err := throughputHistoryPath(dsts)
+	if err == nil {
+		rate, err = historicalThroughput(path)
+	}
+	if err != nil {
+		fmt.Fprintf(warn, "warning: throughput unknown: %v\n", err)
+		return 0
+	}
+	return rate
+}
+
+// historicalThroughput returns the bytes per second of the runs recorded at
+// path, or zero when there are none.
+func historicalThroughput(path string) (float64, error) {
+	runs, err := readThroughputHistory(path)
+	if err != nil {
+		return 0, err
+	}
+	var bytes int64
+	var seconds float64
+	for _, run := range runs {
+		bytes += run.Bytes
+		seconds += run.Seconds
+	}
+	if seconds <= 0 {
+		return 0, nil
+	}
+	return float64(bytes) / seconds, nil
+}
+
+// recordThroughput adds a run to the history at path, keeping the most
+// recent throughputHistoryRuns runs. A history that cannot be parsed is
+// started afresh.
+func recordThroughput(path string, bytes int64, d time.Duration) error {
+	runs, err := readThroughputHistory(path)
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		runs, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+	runs = append(runs, throughputRecord{Finished: time.Now().UTC(), Bytes: bytes, Seconds: d.Seconds()})
+	if len(runs) > throughputHistoryRuns {
+		runs = runs[len(runs)-throughputHistoryRuns:]
+	}
+	data, err := json.Marshal(runs)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
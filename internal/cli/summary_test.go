@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/scanner"
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+func TestImpactTopLevel(t *testing.T) {
+	dst := filepath.FromSlash("/data/dst")
+	src := filepath.FromSlash("/data/src")
+	o := newImpactScanOutput(&bytes.Buffer{}, false, []string{dst, src}, false, 0, throughputHistory)
+	cases := []struct {
+		path, root, dir string
+	}{
+		{filepath.Join(dst, "a.txt"), dst, "."},
+		{filepath.Join(dst, "photos", "2024", "b.jpg"), dst, "photos"},
+		{filepath.Join(src, "docs", "c.txt"), src, "docs"},
+		{filepath.FromSlash("/data/dst-other/d.txt"), "", "."},
+		{filepath.FromSlash("/elsewhere/e.txt"), "", "."},
+	}
+	for _, tc := range cases {
+		root, dir := o.topLevel(tc.path)
+		if root != tc.root || dir != tc.dir {
+			t.Fatalf("topLevel(%s) = %q, %q; want %q, %q", tc.path, root, dir, tc.root, tc.dir)
+		}
+	}
+}
+
+func TestImpactTotalsPerDirectoryAndRoot(t *testing.T) {
+	dst := filepath.FromSlash("/data/dst")
+	src := filepath.FromSlash("/data/src")
+	o := newImpactScanOutput(&bytes.Buffer{}, true, []string{dst, src}, false, 100, throughputConfigured)
+	o.collision(scanOutputCollision)
+	o.task(task.Task{Action: task.ActionCopy, Src: "a", Dst: filepath.Join(dst, "a.txt"), Size: 300})
+	o.task(task.Task{Action: task.ActionCopyBatch, Batch: &task.CopyBatchPayload{Entries: []task.CopyBatchEntry{
+		{Source: "b", Destination: filepath.Join(dst, "dir", "b.txt"), Size: 100},
+		{Source: "c", Destination: filepath.Join(src, "dir", "c.txt"), Size: 100},
+	}}})
+	o.task(task.Task{Action: task.ActionDelete, Dst: filepath.Join(dst, "dir", "old.txt")})
+
+	impact := o.impact()
+	if impact.Tasks != 3 || impact.Batches != 1 || impact.Collisions != 1 {
+		t.Fatalf("unexpected counts: %d tasks, %d batches, %d collisions", impact.Tasks, impact.Batches, impact.Collisions)
+	}
+	if len(impact.Directories) != 2 || impact.Directories[0].Path != "." || impact.Directories[1].Path != "dir" {
+		t.Fatalf("unexpected directories: %+v", impact.Directories)
+	}
+	if got := impact.Directories[1].Actions; got["copy"].Files != 2 || got["copy"].Bytes != 200 || got["delete"].Files != 1 {
+		t.Fatalf("unexpected totals below dir: copy %+v, delete %+v", got["copy"], got["delete"])
+	}
+	if len(impact.Space) != 2 || impact.Space[0].Required != 400 || impact.Space[1].Required != 100 {
+		t.Fatalf("unexpected space required per root: %+v", impact.Space)
+	}
+	for _, space := range impact.Space {
+		if space.Free != nil || space.Error == "" {
+			t.Fatalf("expected free space to be unknown for non-local roots, got %+v", space)
+		}
+	}
+	if impact.Throughput != 100 || impact.ThroughputSource != throughputConfigured || impact.EstimatedSeconds != 5 {
+		t.Fatalf("expected 500 bytes at 100 B/s to take 5s, got %g B/s (%s), %gs", impact.Throughput, impact.ThroughputSource, impact.EstimatedSeconds)
+	}
+}
+
+func TestWriteScanImpactReportsInsufficientSpace(t *testing.T) {
+	free, plenty := int64(10), int64(1<<30)
+	impact := scanImpact{
+		Actions: map[string]*scanActionTotals{"copy": {Files: 1, Bytes: 100}},
+		Space: []scanSpaceImpact{
+			{Root: "/small", Required: 100, Free: &free},
+			{Root: "/large", Required: 100, Free: &plenty},
+			{Root: "/remote", Required: 100, Error: "free space is only known for local directories"},
+		},
+	}
+	var out bytes.Buffer
+	writeScanImpact(&out, impact)
+	for _, want := range []string{
+		"/small: 100 B required, 10 B free (insufficient)",
+		"/large: 100 B required, 1.00 GiB free\n",
+		"/remote: 100 B required, free space unknown (free space is only known for local directories)",
+		"Estimated duration: unknown",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in:\n%s", want, out.String())
+		}
+	}
+	if impact.Space[0].Sufficient() || !impact.Space[1].Sufficient() || !impact.Space[2].Sufficient() {
+		t.Fatal("only the known shortage should be insufficient")
+	}
+}
+
+func TestExistingParent(t *testing.T) {
+	dir := t.TempDir()
+	if got := existingParent(filepath.Join(dir, "missing", "deeper")); got != dir {
+		t.Fatalf("expected %s, got %s", dir, got)
+	}
+	if got := existingParent(dir); got != dir {
+		t.Fatalf("expected an existing path to be kept, got %s", got)
+	}
+}
+
+func TestThroughputHistoryKeepsRecentRuns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "throughput", "history.json")
+	if rate, err := historicalThroughput(path); err != nil || rate != 0 {
+		t.Fatalf("expected no throughput without history, got %g, %v", rate, err)
+	}
+	for i := 1; i <= throughputHistoryRuns+2; i++ {
+		if err := recordThroughput(path, int64(i)*1000, time.Second); err != nil {
+			t.Fatalf("recordThroughput failed: %v", err)
+		}
+	}
+	runs, err := readThroughputHistory(path)
+	if err != nil {
+		t.Fatalf("readThroughputHistory failed: %v", err)
+	}
+	if len(runs) != throughputHistoryRuns || runs[0].Bytes != 3000 || runs[len(runs)-1].Bytes != 12000 {
+		t.Fatalf("expected the last %d runs, got %d from %d to %d bytes", throughputHistoryRuns, len(runs), runs[0].Bytes, runs[len(runs)-1].Bytes)
+	}
+	// Runs 3 to 12 copied 75000 bytes in 10 seconds.
+	if rate, err := historicalThroughput(path); err != nil || rate != 7500 {
+		t.Fatalf("expected 7500 B/s, got %g, %v", rate, err)
+	}
+}
+
+func TestCorruptThroughputHistoryIsUnknown(t *testing.T) {
+	cache := t.TempDir()
+	// os.UserCacheDir reads one of these depending on the platform.
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("HOME", cache)
+	t.Setenv("LocalAppData", cache)
+	dsts := []string{filepath.Join(t.TempDir(), "dst")}
+	path, err := throughputHistoryPath(dsts)
+	if err != nil {
+		t.Fatalf("throughputHistoryPath failed: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("failed to create cache dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+		t.Fatalf("failed to write history: %v", err)
+	}
+
+	var warn bytes.Buffer
+	if rate := estimatedThroughput(dsts, &warn); rate != 0 {
+		t.Fatalf("expected an unknown throughput, got %g", rate)
+	}
+	if !strings.HasPrefix(warn.String(), "warning: throughput unknown: ") {
+		t.Fatalf("expected a warning, got %q", warn.String())
+	}
+	output, err := newScanSummary(scanOutputText, 0, dsts, dsts, nil, scanner.ModeUpdate, true)
+	if err != nil || output == nil {
+		t.Fatalf("expected the summary to proceed without a throughput, got %v", err)
+	}
+
+	// The next recorded run replaces the corrupt history.
+	if err := recordThroughput(path, 2000, time.Second); err != nil {
+		t.Fatalf("recordThroughput failed: %v", err)
+	}
+	if rate := estimatedThroughput(dsts, &warn); rate != 2000 {
+		t.Fatalf("expected 2000 B/s after recording a run, got %g", rate)
+	}
+}
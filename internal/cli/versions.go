@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/worker"
+)
+
+// RunVersions executes the versions command, which inspects and restores the
+// revisions kept by sync --versions-dir.
+func RunVersions(args []string) error {
+	if len(args) == 0 {
+		printVersionsUsage()
+		return fmt.Errorf("expected 'list' or 'restore' subcommands")
+	}
+	switch args[0] {
+	case "list":
+		return runVersionsList(args[1:])
+	case "restore":
+		return runVersionsRestore(args[1:])
+	case "help", "--help", "-h":
+		printVersionsUsage()
+		return nil
+	default:
+		printVersionsUsage()
+		return fmt.Errorf("unknown versions subcommand %q", args[0])
+	}
+}
+
+func printVersionsUsage() {
+	fmt.Printf("Usage: %s versions <list|restore> [options]\n", os.Args[0])
+	fmt.Println("Subcommands:")
+	fmt.Println("  list     Show the revisions kept in a versions directory")
+	fmt.Println("  restore  Copy a revision back into a destination tree")
+	fmt.Printf("Use '%s versions <subcommand> --help' for subcommand-specific options.\n", os.Args[0])
+}
+
+func runVersionsList(args []string) error {
+	listCmd := flag.NewFlagSet("versions list", flag.ExitOnError)
+	store := listCmd.String("store", "", "versions directory used with sync --versions-dir")
+	path := listCmd.String("path", "", "only list revisions of this path, relative to the destination root")
+	help := listCmd.Bool("help", false, "show help for versions list")
+	listCmd.Usage = func() {
+		out := listCmd.Output()
+		fmt.Fprintf(out, "Usage: %s versions list --store <dir> [--path <rel>]\n", os.Args[0])
+		fmt.Fprintln(out, "\nDescription:")
+		fmt.Fprintln(out, "  List stored revisions, newest first for each path.")
+		fmt.Fprintln(out, "")
+		listCmd.PrintDefaults()
+	}
+	if err := listCmd.Parse(args); err != nil {
+		return err
+	}
+	if *help {
+		listCmd.Usage()
+		return nil
+	}
+	if *store == "" {
+		return fmt.Errorf("store required")
+	}
+
+	versions, err := worker.NewVersionStore(*store, nil, time.Now()).List(*path)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		fmt.Printf("%s\t%s\t%d\t%s\n", filepath.ToSlash(v.Path), v.Stamp, v.Size, v.ModTime.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+func runVersionsRestore(args []string) error {
+	restoreCmd := flag.NewFlagSet("versions restore", flag.ExitOnError)
+	store := restoreCmd.String("store", "", "versions directory used with sync --versions-dir")
+	path := restoreCmd.String("path", "", "path to restore, relative to the destination root")
+	dst := restoreCmd.String("dst", "", "destination root to restore into")
+	at := restoreCmd.String("at", "", "restore the newest revision saved at or before this time (RFC 3339 or a run stamp); defaults to the newest revision")
+	help := restoreCmd.Bool("help", false, "show help for versions restore")
+	restoreCmd.Usage = func() {
+		out := restoreCmd.Output()
+		fmt.Fprintf(out, "Usage: %s versions restore --store <dir> --path <rel> --dst <path> [--at <time>]\n", os.Args[0])
+		fmt.Fprintln(out, "\nDescription:")
+		fmt.Fprintln(out, "  Copy a stored revision back to <dst>/<rel>. The file currently at that")
+		fmt.Fprintln(out, "  location is kept as a new revision first.")
+		fmt.Fprintln(out, "")
+		restoreCmd.PrintDefaults()
+	}
+	if err := restoreCmd.Parse(args); err != nil {
+		return err
+	}
+	if *help {
+		restoreCmd.Usage()
+		return nil
+	}
+	if *store == "" || *path == "" || *dst == "" {
+		return fmt.Errorf("store, path and dst required")
+	}
+	var when time.Time
+	if *at != "" {
+		var err error
+		if when, err = worker.ParseVersionTime(*at); err != nil {
+			return err
+		}
+	}
+	inside, err := pathWithin(*dst, *store)
+	if err != nil {
+		return err
+	}
+	if inside {
+		return fmt.Errorf("%s must not be inside %s", *store, *dst)
+	}
+
+	versions := worker.NewVersionStore(*store, []string{*dst}, time.Now())
+	version, err := versions.Find(*path, when)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(*dst, version.Path)
+	if err := versions.Restore(version, target); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", version.Path, err)
+	}
+	fmt.Printf("restored %s from %s\n", target, version.Stamp)
+	return nil
+}
@@ -13,37 +13,76 @@ const (
 	actionCopy      = "copy"
 	actionDelete    = "delete"
 	actionCopyBatch = "copy_batch"
+	actionLink      = "link"
 )
 
 // TaskMessage represents the payload exchanged between the server and agents
 // for an individual unit of work.
 type TaskMessage struct {
-	ID     string                 `json:"id"`
-	Action string                 `json:"action"`
-	Src    string                 `json:"src"`
-	Dst    string                 `json:"dst"`
-	Batch  *task.CopyBatchPayload `json:"batch,omitempty"`
+	ID     string   `json:"id"`
+	Action string   `json:"action"`
+	Src    string   `json:"src"`
+	Dst    string   `json:"dst"`
+	Fanout []string `json:"fanout,omitempty"`
+	// Size and ModTime describe the source as the scanner saw it.
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"mtime,omitempty"`
+	// Explanations say why the scanner planned the task for each
+	// destination.
+	Explanations []task.Explanation     `json:"explanations,omitempty"`
+	Batch        *task.CopyBatchPayload `json:"batch,omitempty"`
 }
 
 // TaskResultMessage communicates the outcome of a task processed by an agent.
 type TaskResultMessage struct {
-	AgentID string             `json:"agent_id"`
-	TaskID  string             `json:"task_id"`
-	Success bool               `json:"success"`
-	Error   string             `json:"error,omitempty"`
-	Report  *TaskReportMessage `json:"report,omitempty"`
+	AgentID string              `json:"agent_id"`
+	TaskID  string              `json:"task_id"`
+	Success bool                `json:"success"`
+	Error   string              `json:"error,omitempty"`
+	Report  *TaskReportMessage  `json:"report,omitempty"`
+	Failure *TaskFailureMessage `json:"failure,omitempty"`
 }
 
-// TaskReportMessage is a JSON-friendly form of worker.TaskReport.
+// TaskFailureMessage is a JSON-friendly form of worker.TaskFailure.
+type TaskFailureMessage struct {
+	Action       string                `json:"action"`
+	Source       string                `json:"source"`
+	Destination  string                `json:"destination"`
+	Error        string                `json:"error"`
+	Class        string                `json:"class"`
+	Errno        int                   `json:"errno,omitempty"`
+	Attempts     int                   `json:"attempts"`
+	FailedAt     time.Time             `json:"failed_at"`
+	BatchEntries []task.CopyBatchEntry `json:"batch_entries,omitempty"`
+}
+
+// TaskReportMessage is a JSON-friendly form of worker.TaskReport. For batches
+// Hash covers the archive as a whole while BatchEntries carries the hash of
+// every file written.
 type TaskReportMessage struct {
 	Action        string                `json:"action"`
 	Source        string                `json:"source"`
 	Destination   string                `json:"destination"`
 	Bytes         int64                 `json:"bytes"`
 	Hash          string                `json:"hash"`
+	HashAlgorithm string                `json:"hash_algorithm,omitempty"`
 	StartedAt     time.Time             `json:"started_at"`
 	DurationMilli int64                 `json:"duration_ms"`
+	SyncMilli     int64                 `json:"sync_ms,omitempty"`
+	BackupPath    string                `json:"backup_path,omitempty"`
 	BatchEntries  []task.CopyBatchEntry `json:"batch_entries,omitempty"`
+	Explanation   *task.Explanation     `json:"explanation,omitempty"`
+	Attempts      int                   `json:"attempts,omitempty"`
+	Retries       []RetryMessage        `json:"retries,omitempty"`
+}
+
+// RetryMessage is a JSON-friendly form of worker.RetryAttempt.
+type RetryMessage struct {
+	Attempt      int    `json:"attempt"`
+	Error        string `json:"error"`
+	Class        string `json:"class"`
+	Errno        int    `json:"errno,omitempty"`
+	BackoffMilli int64  `json:"backoff_ms"`
 }
 
 // TaskToMessage converts a task and identifier to a transferable message.
@@ -52,7 +91,7 @@ func TaskToMessage(id string, t task.Task) (TaskMessage, error) {
 	if err != nil {
 		return TaskMessage{}, err
 	}
-	return TaskMessage{ID: id, Action: action, Src: t.Src, Dst: t.Dst, Batch: t.Batch}, nil
+	return TaskMessage{ID: id, Action: action, Src: t.Src, Dst: t.Dst, Fanout: t.Fanout, Size: t.Size, ModTime: t.ModTime, Explanations: t.Explanations, Batch: t.Batch}, nil
 }
 
 // ToTask converts a TaskMessage back into the internal task representation.
@@ -61,7 +100,7 @@ func (m TaskMessage) ToTask() (task.Task, error) {
 	if err != nil {
 		return task.Task{}, err
 	}
-	return task.Task{Action: action, Src: m.Src, Dst: m.Dst, Batch: m.Batch}, nil
+	return task.Task{Action: action, Src: m.Src, Dst: m.Dst, Fanout: m.Fanout, Size: m.Size, ModTime: m.ModTime, Explanations: m.Explanations, Batch: m.Batch}, nil
 }
 
 // ReportToMessage converts a worker.TaskReport into a TaskReportMessage.
@@ -78,9 +117,15 @@ func ReportToMessage(tr worker.TaskReport) TaskReportMessage {
 		Destination:   tr.Destination,
 		Bytes:         tr.Bytes,
 		Hash:          tr.Hash,
+		HashAlgorithm: string(tr.HashAlgorithm),
 		StartedAt:     tr.StartedAt,
 		DurationMilli: tr.Duration.Milliseconds(),
+		SyncMilli:     tr.SyncDuration.Milliseconds(),
+		BackupPath:    tr.BackupPath,
 		BatchEntries:  append([]task.CopyBatchEntry(nil), tr.BatchEntries...),
+		Explanation:   tr.Explanation,
+		Attempts:      tr.Attempts,
+		Retries:       retriesToMessages(tr.Retries),
 	}
 }
 
@@ -91,13 +136,91 @@ func (m TaskReportMessage) ToTaskReport() (worker.TaskReport, error) {
 		return worker.TaskReport{}, err
 	}
 	return worker.TaskReport{
+		Action:        action,
+		Source:        m.Source,
+		Destination:   m.Destination,
+		Bytes:         m.Bytes,
+		Hash:          m.Hash,
+		HashAlgorithm: worker.HashAlgorithm(m.HashAlgorithm),
+		StartedAt:     m.StartedAt,
+		Duration:      time.Duration(m.DurationMilli) * time.Millisecond,
+		SyncDuration:  time.Duration(m.SyncMilli) * time.Millisecond,
+		BackupPath:    m.BackupPath,
+		BatchEntries:  append([]task.CopyBatchEntry(nil), m.BatchEntries...),
+		Explanation:   m.Explanation,
+		Attempts:      m.Attempts,
+		Retries:       retriesFromMessages(m.Retries),
+	}, nil
+}
+
+func retriesToMessages(retries []worker.RetryAttempt) []RetryMessage {
+	if len(retries) == 0 {
+		return nil
+	}
+	out := make([]RetryMessage, len(retries))
+	for i, r := range retries {
+		out[i] = RetryMessage{
+			Attempt:      r.Attempt,
+			Error:        r.Error,
+			Class:        string(r.Class),
+			Errno:        r.Errno,
+			BackoffMilli: r.Backoff.Milliseconds(),
+		}
+	}
+	return out
+}
+
+func retriesFromMessages(retries []RetryMessage) []worker.RetryAttempt {
+	if len(retries) == 0 {
+		return nil
+	}
+	out := make([]worker.RetryAttempt, len(retries))
+	for i, r := range retries {
+		out[i] = worker.RetryAttempt{
+			Attempt: r.Attempt,
+			Error:   r.Error,
+			Class:   worker.ErrorClass(r.Class),
+			Errno:   r.Errno,
+			Backoff: time.Duration(r.BackoffMilli) * time.Millisecond,
+		}
+	}
+	return out
+}
+
+// FailureToMessage converts a worker.TaskFailure into a TaskFailureMessage.
+func FailureToMessage(f worker.TaskFailure) TaskFailureMessage {
+	action, err := actionToString(f.Action)
+	if err != nil {
+		action = fmt.Sprintf("unknown:%d", f.Action)
+	}
+	return TaskFailureMessage{
+		Action:       action,
+		Source:       f.Source,
+		Destination:  f.Destination,
+		Error:        f.Error,
+		Class:        string(f.Class),
+		Errno:        f.Errno,
+		Attempts:     f.Attempts,
+		FailedAt:     f.FailedAt,
+		BatchEntries: append([]task.CopyBatchEntry(nil), f.BatchEntries...),
+	}
+}
+
+// ToTaskFailure converts a TaskFailureMessage into a worker.TaskFailure.
+func (m TaskFailureMessage) ToTaskFailure() (worker.TaskFailure, error) {
+	action, err := actionFromString(m.Action)
+	if err != nil {
+		return worker.TaskFailure{}, err
+	}
+	return worker.TaskFailure{
 		Action:       action,
 		Source:       m.Source,
 		Destination:  m.Destination,
-		Bytes:        m.Bytes,
-		Hash:         m.Hash,
-		StartedAt:    m.StartedAt,
-		Duration:     time.Duration(m.DurationMilli) * time.Millisecond,
+		Error:        m.Error,
+		Class:        worker.ErrorClass(m.Class),
+		Errno:        m.Errno,
+		Attempts:     m.Attempts,
+		FailedAt:     m.FailedAt,
 		BatchEntries: append([]task.CopyBatchEntry(nil), m.BatchEntries...),
 	}, nil
 }
@@ -110,6 +233,8 @@ func actionToString(a task.Action) (string, error) {
 		return actionDelete, nil
 	case task.ActionCopyBatch:
 		return actionCopyBatch, nil
+	case task.ActionLink:
+		return actionLink, nil
 	default:
 		return "", fmt.Errorf("unsupported action %d", a)
 	}
@@ -123,6 +248,8 @@ func actionFromString(s string) (task.Action, error) {
 		return task.ActionDelete, nil
 	case actionCopyBatch:
 		return task.ActionCopyBatch, nil
+	case actionLink:
+		return task.ActionLink, nil
 	default:
 		return task.ActionCopy, fmt.Errorf("unknown action %q", s)
 	}
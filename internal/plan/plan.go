@@ -0,0 +1,531 @@
+// Package plan reads and writes plan files: the tasks of a scan saved as
+// JSON lines so they can be reviewed and executed later by a sync.
+//
+// A plan starts with a header record naming the locations, the mode and a
+// fingerprint of every scanned tree, continues with one record per task and
+// ends with a record holding the totals, which tells a complete plan from a
+// truncated one. Every record carries a "type" field.
+package plan
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/backend"
+	"github.com/syncopasoft/syncopa-core/internal/scanner"
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+// Version is the plan format written by this package. Readers refuse
+// plans with a newer version.
+const Version = 1
+
+// Header describes how and when a plan was made.
+type Header struct {
+	Version int       `json:"v"`
+	Created time.Time `json:"created"`
+	Mode    string    `json:"mode"`
+	// Sources and Destinations are the --src and --dst locations of the
+	// scan.
+	Sources      []string `json:"sources"`
+	Destinations []string `json:"destinations"`
+	Trees        []Tree   `json:"trees"`
+}
+
+// Tree is the fingerprint of a tree when the plan was made.
+type Tree struct {
+	Root   string `json:"root"`
+	Source bool   `json:"source,omitempty"`
+	Files  int    `json:"files"`
+	Bytes  int64  `json:"bytes"`
+	Digest string `json:"digest"`
+}
+
+// TreeFromFingerprint converts a scanner fingerprint into a Tree.
+func TreeFromFingerprint(fp scanner.TreeFingerprint) Tree {
+	return Tree{Root: fp.Root, Source: fp.Source, Files: fp.Files, Bytes: fp.Bytes, Digest: fp.Digest}
+}
+
+// Entry is a planned task. Size and ModTime describe the source as it was
+// scanned, or for deletes the destination entry being removed; batches list
+// their files in Entries.
+type Entry struct {
+	Action  string       `json:"action"`
+	Src     string       `json:"src,omitempty"`
+	Dst     string       `json:"dst,omitempty"`
+	Fanout  []string     `json:"fanout,omitempty"`
+	Size    int64        `json:"size,omitempty"`
+	ModTime *time.Time   `json:"mtime,omitempty"`
+	Entries []BatchEntry `json:"entries,omitempty"`
+}
+
+// BatchEntry is one file of a planned batch.
+type BatchEntry struct {
+	Src     string    `json:"src"`
+	Dst     string    `json:"dst"`
+	Fanout  []string  `json:"fanout,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// Totals closes a plan. Files and Bytes count the files copied and the
+// data written, once per destination.
+type Totals struct {
+	Tasks int   `json:"tasks"`
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+const (
+	typeHeader = "plan"
+	typeTask   = "task"
+	typeEnd    = "end"
+)
+
+type headerRecord struct {
+	Type string `json:"type"`
+	Header
+}
+
+type taskRecord struct {
+	Type string `json:"type"`
+	Entry
+}
+
+type endRecord struct {
+	Type string `json:"type"`
+	Totals
+}
+
+// EntryFromTask converts a task into its plan entry.
+func EntryFromTask(t task.Task) Entry {
+	e := Entry{Action: t.Action.String(), Src: t.Src, Dst: t.Dst, Fanout: t.Fanout, Size: t.Size}
+	if !t.ModTime.IsZero() {
+		mtime := t.ModTime
+		e.ModTime = &mtime
+	}
+	if t.Action == task.ActionDelete {
+		if dst := t.ExplanationFor(0); dst != nil && dst.Dst != nil {
+			mtime := dst.Dst.ModTime
+			e.Size, e.ModTime = dst.Dst.Size, &mtime
+		}
+	}
+	if t.Action == task.ActionCopyBatch && t.Batch != nil {
+		// Batches are described by their entries alone.
+		e.Src, e.Dst = "", ""
+		for _, entry := range t.Batch.Entries {
+			e.Entries = append(e.Entries, BatchEntry{Src: entry.Source, Dst: entry.Destination, Fanout: entry.Fanout, Size: entry.Size, ModTime: entry.ModTime})
+		}
+	}
+	return e
+}
+
+// Task converts e back into a task. Batches are lazy and read every file
+// from its source.
+func (e Entry) Task() (task.Task, error) {
+	action, err := task.ParseAction(e.Action)
+	if err != nil {
+		return task.Task{}, err
+	}
+	t := task.Task{Action: action, Src: e.Src, Dst: e.Dst, Fanout: e.Fanout, Size: e.Size}
+	if e.ModTime != nil {
+		t.ModTime = *e.ModTime
+	}
+	if action == task.ActionDelete && e.ModTime != nil {
+		// The destination entry is kept with the reason for the delete.
+		t.Explanations = []task.Explanation{{Reason: task.ReasonExtra, Dst: &task.Metadata{Size: e.Size, ModTime: *e.ModTime}}}
+		t.Size, t.ModTime = 0, time.Time{}
+	}
+	if action != task.ActionCopyBatch {
+		if e.Dst == "" {
+			return task.Task{}, fmt.Errorf("%s task without a destination", e.Action)
+		}
+		return t, nil
+	}
+	if len(e.Entries) == 0 {
+		return task.Task{}, errors.New("batch without entries")
+	}
+	payload := &task.CopyBatchPayload{Entries: make([]task.CopyBatchEntry, len(e.Entries))}
+	for i, entry := range e.Entries {
+		payload.Entries[i] = task.CopyBatchEntry{Source: entry.Src, Destination: entry.Dst, Fanout: entry.Fanout, Size: entry.Size, ModTime: entry.ModTime}
+	}
+	t.Src, t.Dst, t.Batch = payload.Entries[0].Source, payload.Entries[0].Destination, payload
+	return t, nil
+}
+
+// Writer writes a plan. The header is written with the first task or on
+// Close, so trees can be added while the scan runs.
+type Writer struct {
+	w       *bufio.Writer
+	enc     *json.Encoder
+	header  Header
+	started bool
+	totals  Totals
+}
+
+// NewWriter returns a Writer that writes the plan described by h to w.
+func NewWriter(w io.Writer, h Header) *Writer {
+	h.Version = Version
+	bw := bufio.NewWriter(w)
+	return &Writer{w: bw, enc: json.NewEncoder(bw), header: h}
+}
+
+// AddTree records the fingerprint of a scanned tree in the header.
+func (w *Writer) AddTree(t Tree) error {
+	if w.started {
+		return errors.New("plan header already written")
+	}
+	w.header.Trees = append(w.header.Trees, t)
+	return nil
+}
+
+// Add appends t to the plan.
+func (w *Writer) Add(t task.Task) error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	e := EntryFromTask(t)
+	w.totals.Tasks++
+	switch {
+	case len(e.Entries) > 0:
+		for _, entry := range e.Entries {
+			w.totals.Files++
+			w.totals.Bytes += entry.Size * int64(1+len(entry.Fanout))
+		}
+	case t.Action == task.ActionCopy:
+		w.totals.Files++
+		w.totals.Bytes += e.Size * int64(1+len(e.Fanout))
+	}
+	return w.enc.Encode(taskRecord{Type: typeTask, Entry: e})
+}
+
+// Close writes the totals and flushes the plan. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	if err := w.enc.Encode(endRecord{Type: typeEnd, Totals: w.totals}); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+func (w *Writer) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	return w.enc.Encode(headerRecord{Type: typeHeader, Header: w.header})
+}
+
+// Reader reads the tasks of a plan in order.
+type Reader struct {
+	Header Header
+
+	path    string
+	file    *os.File
+	scanner *bufio.Scanner
+	line    int
+	tasks   int
+	done    bool
+}
+
+// Open opens the plan at path and reads its header.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{path: path, file: f, scanner: bufio.NewScanner(f)}
+	r.scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	if !r.scanner.Scan() {
+		f.Close()
+		if err := r.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("plan %s is empty", path)
+	}
+	r.line++
+	var rec headerRecord
+	if err := json.Unmarshal(r.scanner.Bytes(), &rec); err != nil || rec.Type != typeHeader {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a plan file", path)
+	}
+	if rec.Version < 1 || rec.Version > Version {
+		f.Close()
+		return nil, fmt.Errorf("plan %s: unsupported version %d", path, rec.Version)
+	}
+	r.Header = rec.Header
+	return r, nil
+}
+
+// Next returns the next task of the plan. It returns io.EOF after the last
+// task, and an error when the plan ends without its totals or they do not
+// match the tasks read.
+func (r *Reader) Next() (task.Task, error) {
+	if r.done {
+		return task.Task{}, io.EOF
+	}
+	for r.scanner.Scan() {
+		r.line++
+		var kind struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(r.scanner.Bytes(), &kind); err != nil {
+			return task.Task{}, fmt.Errorf("plan %s line %d: %w", r.path, r.line, err)
+		}
+		switch kind.Type {
+		case typeTask:
+			var rec taskRecord
+			if err := json.Unmarshal(r.scanner.Bytes(), &rec); err != nil {
+				return task.Task{}, fmt.Errorf("plan %s line %d: %w", r.path, r.line, err)
+			}
+			t, err := rec.Entry.Task()
+			if err != nil {
+				return task.Task{}, fmt.Errorf("plan %s line %d: %w", r.path, r.line, err)
+			}
+			r.tasks++
+			return t, nil
+		case typeEnd:
+			var rec endRecord
+			if err := json.Unmarshal(r.scanner.Bytes(), &rec); err != nil {
+				return task.Task{}, fmt.Errorf("plan %s line %d: %w", r.path, r.line, err)
+			}
+			if rec.Tasks != r.tasks {
+				return task.Task{}, fmt.Errorf("plan %s lists %d tasks but holds %d", r.path, rec.Tasks, r.tasks)
+			}
+			r.done = true
+			return task.Task{}, io.EOF
+		default:
+			// Records added by later minor revisions are skipped.
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return task.Task{}, err
+	}
+	return task.Task{}, fmt.Errorf("plan %s is truncated after %d tasks", r.path, r.tasks)
+}
+
+// Close closes the plan file.
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
+
+// ChangedTrees returns the trees of the header whose fingerprint no longer
+// matches, reading source trees from src and the others from dst.
+func ChangedTrees(h Header, src, dst backend.Backend) ([]Tree, error) {
+	var changed []Tree
+	for _, tree := range h.Trees {
+		b := dst
+		if tree.Source {
+			b = src
+		}
+		fp, err := scanner.Fingerprint(b, tree.Root)
+		if err != nil {
+			return nil, err
+		}
+		if fp.Digest != tree.Digest {
+			changed = append(changed, tree)
+		}
+	}
+	return changed, nil
+}
+
+// StaleSources returns the sources read by t that changed since the plan
+// was made. Deletions read nothing; StaleDelete checks them.
+func StaleSources(b backend.Backend, t task.Task) ([]string, error) {
+	b = backend.OrLocal(b)
+	var stale []string
+	check := func(path string, size int64, mtime time.Time) error {
+		changed, _, err := sourceChanged(b, path, size, mtime)
+		if err == nil && changed {
+			stale = append(stale, path)
+		}
+		return err
+	}
+	switch t.Action {
+	case task.ActionCopy, task.ActionLink:
+		if err := check(t.Src, t.Size, t.ModTime); err != nil {
+			return nil, err
+		}
+	case task.ActionCopyBatch:
+		if t.Batch == nil {
+			return nil, nil
+		}
+		for _, entry := range t.Batch.Entries {
+			if err := check(entry.Source, entry.Size, entry.ModTime); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return stale, nil
+}
+
+// StaleDelete reports why the planned delete t must no longer run, or ""
+// when it still may. sources are the paths that would provide its
+// destination path: a delete is stale once any of them exists, or when the
+// destination entry is no longer the one that was planned for removal.
+func StaleDelete(src, dst backend.Backend, t task.Task, sources []string) (string, error) {
+	if t.Action != task.ActionDelete {
+		return "", nil
+	}
+	src, dst = backend.OrLocal(src), backend.OrLocal(dst)
+	for _, path := range sources {
+		_, err := src.Stat(path)
+		if err == nil {
+			return fmt.Sprintf("%s appeared since the plan was made", path), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+	planned := t.ExplanationFor(0)
+	if planned == nil || planned.Dst == nil {
+		return "", nil
+	}
+	info, err := dst.Stat(t.Dst)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !info.ModTime().Equal(planned.Dst.ModTime) || (!info.IsDir() && info.Size() != planned.Dst.Size) {
+		return "it changed since the plan was made", nil
+	}
+	return "", nil
+}
+
+// sourceChanged compares path with the size and time it was planned with.
+// A missing source counts as changed and returns a nil info.
+func sourceChanged(b backend.Backend, path string, size int64, mtime time.Time) (bool, fs.FileInfo, error) {
+	info, err := b.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	return info.Size() != size || !info.ModTime().Equal(mtime), info, nil
+}
+
+// Revalidate returns the tasks that bring the destinations of t up to date
+// with the sources as they are now. Unchanged work is kept as planned. A
+// changed file is copied, with its current size and time, to the
+// destinations the scanner would copy it to today and dropped when its
+// source is gone. Changed batch files are split into copies of their own.
+// A link whose reference file changed cannot be revalidated and returns an
+// error.
+func Revalidate(src, dst backend.Backend, t task.Task) ([]task.Task, error) {
+	src, dst = backend.OrLocal(src), backend.OrLocal(dst)
+	switch t.Action {
+	case task.ActionLink:
+		changed, _, err := sourceChanged(src, t.Src, t.Size, t.ModTime)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			return nil, fmt.Errorf("reference file %s changed since planning; scan again", t.Src)
+		}
+		return []task.Task{t}, nil
+	case task.ActionCopy:
+		changed, info, err := sourceChanged(src, t.Src, t.Size, t.ModTime)
+		if err != nil || !changed {
+			return []task.Task{t}, err
+		}
+		return recopy(dst, t.Src, info, t.Destinations())
+	case task.ActionCopyBatch:
+		if t.Batch == nil {
+			return []task.Task{t}, nil
+		}
+		var kept []task.CopyBatchEntry
+		var out []task.Task
+		for _, entry := range t.Batch.Entries {
+			changed, info, err := sourceChanged(src, entry.Source, entry.Size, entry.ModTime)
+			if err != nil {
+				return nil, err
+			}
+			if !changed {
+				kept = append(kept, entry)
+				continue
+			}
+			copies, err := recopy(dst, entry.Source, info, append([]string{entry.Destination}, entry.Fanout...))
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, copies...)
+		}
+		if len(kept) == len(t.Batch.Entries) {
+			return []task.Task{t}, nil
+		}
+		if len(kept) > 0 {
+			payload := &task.CopyBatchPayload{Entries: kept}
+			batch := task.Task{Action: task.ActionCopyBatch, Src: kept[0].Source, Dst: kept[0].Destination, Batch: payload}
+			out = append([]task.Task{batch}, out...)
+		}
+		return out, nil
+	}
+	return []task.Task{t}, nil
+}
+
+// recopy plans a copy of the current source file described by info to the
+// destinations that still need it.
+func recopy(dst backend.Backend, src string, info fs.FileInfo, dsts []string) ([]task.Task, error) {
+	if info == nil {
+		return nil, nil
+	}
+	var need []string
+	for _, path := range dsts {
+		dstInfo, err := dst.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			dstInfo, err = nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if scanner.NeedsCopy(info, dstInfo) {
+			need = append(need, path)
+		}
+	}
+	if len(need) == 0 {
+		return nil, nil
+	}
+	t := task.Task{Action: task.ActionCopy, Src: src, Dst: need[0], Size: info.Size(), ModTime: info.ModTime()}
+	if len(need) > 1 {
+		t.Fanout = need[1:]
+	}
+	return []task.Task{t}, nil
+}
+
+// Without returns t without the files read from the given sources. Only
+// batches keep their remaining files; other tasks read a single source and
+// are dropped entirely. The boolean reports whether anything is left.
+func Without(t task.Task, sources []string) (task.Task, bool) {
+	if len(sources) == 0 {
+		return t, true
+	}
+	if t.Action != task.ActionCopyBatch || t.Batch == nil {
+		return task.Task{}, false
+	}
+	drop := make(map[string]bool, len(sources))
+	for _, src := range sources {
+		drop[src] = true
+	}
+	var kept []task.CopyBatchEntry
+	for _, entry := range t.Batch.Entries {
+		if !drop[entry.Source] {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) == 0 {
+		return task.Task{}, false
+	}
+	return task.Task{Action: task.ActionCopyBatch, Src: kept[0].Source, Dst: kept[0].Destination, Batch: &task.CopyBatchPayload{Entries: kept}}, true
+}
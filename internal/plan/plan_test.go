@@ -0,0 +1,226 @@
+package plan
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/backend"
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+func writePlan(t *testing.T, tasks ...task.Task) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Header{Mode: "mirror", Sources: []string{"/src/"}, Destinations: []string{"/a", "/b"}})
+	if err := w.AddTree(Tree{Root: "/src", Source: true, Files: 2, Digest: "d1"}); err != nil {
+		t.Fatalf("AddTree returned error: %v", err)
+	}
+	for _, tk := range tasks {
+		if err := w.Add(tk); err != nil {
+			t.Fatalf("Add returned error: %v", err)
+		}
+	}
+	if err := w.AddTree(Tree{Root: "/late"}); err == nil {
+		t.Fatalf("expected trees to be refused once tasks were written")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "plan.jsonl")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("WriteFile returned error: %v", err)
+	}
+	return path
+}
+
+func readPlan(t *testing.T, path string) (Header, []task.Task, error) {
+	t.Helper()
+	r, err := Open(path)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	defer r.Close()
+	var tasks []task.Task
+	for {
+		tk, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return r.Header, tasks, nil
+		}
+		if err != nil {
+			return r.Header, tasks, err
+		}
+		tasks = append(tasks, tk)
+	}
+}
+
+func TestPlanRoundTrip(t *testing.T) {
+	mtime := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	planned := []task.Task{
+		{Action: task.ActionCopy, Src: "/src/big", Dst: "/a/big", Fanout: []string{"/b/big"}, Size: 100, ModTime: mtime},
+		{Action: task.ActionCopyBatch, Src: "/src/x", Dst: "/a/x", Batch: &task.CopyBatchPayload{Entries: []task.CopyBatchEntry{
+			{Source: "/src/x", Destination: "/a/x", Size: 1, ModTime: mtime},
+			{Source: "/src/y", Destination: "/a/y", Size: 2, ModTime: mtime},
+		}}},
+		{Action: task.ActionLink, Src: "/ref/z", Dst: "/a/z", Size: 3, ModTime: mtime},
+		{Action: task.ActionDelete, Dst: "/b/old"},
+		{Action: task.ActionDelete, Dst: "/b/extra", Explanations: []task.Explanation{{Reason: task.ReasonExtra, Dst: &task.Metadata{Size: 5, ModTime: mtime}}}},
+	}
+	path := writePlan(t, planned...)
+	header, got, err := readPlan(t, path)
+	if err != nil {
+		t.Fatalf("reading the plan failed: %v", err)
+	}
+	if header.Version != Version || header.Mode != "mirror" || len(header.Trees) != 1 || header.Trees[0].Digest != "d1" {
+		t.Fatalf("unexpected header: %+v", header)
+	}
+	if !reflect.DeepEqual(got, planned) {
+		t.Fatalf("unexpected tasks:\ngot  %+v\nwant %+v", got, planned)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile returned error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if last := lines[len(lines)-1]; last != `{"type":"end","tasks":5,"files":3,"bytes":203}` {
+		t.Fatalf("unexpected totals record: %s", last)
+	}
+}
+
+func TestPlanRejectsDamagedFiles(t *testing.T) {
+	path := writePlan(t, task.Task{Action: task.ActionDelete, Dst: "/a/x"}, task.Task{Action: task.ActionDelete, Dst: "/a/y"})
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile returned error: %v", err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+
+	cases := map[string]string{
+		"truncated":   strings.Join(lines[:3], ""),
+		"newer":       strings.Replace(string(data), `"v":1`, `"v":2`, 1),
+		"not a plan":  "{}\n",
+		"task count":  strings.Join(append(append([]string{}, lines[:2]...), lines[3:]...), ""),
+		"bad payload": strings.Join(lines[:1], "") + `{"type":"task","action":"copy"}` + "\n" + lines[3],
+	}
+	for name, content := range cases {
+		t.Run(name, func(t *testing.T) {
+			damaged := filepath.Join(t.TempDir(), "plan.jsonl")
+			if err := os.WriteFile(damaged, []byte(content), 0o644); err != nil {
+				t.Fatalf("WriteFile returned error: %v", err)
+			}
+			if _, _, err := readPlan(t, damaged); err == nil {
+				t.Fatalf("expected the plan to be rejected")
+			}
+		})
+	}
+}
+
+func TestRevalidateChangedSources(t *testing.T) {
+	mem := backend.NewMemory()
+	sep := string(filepath.Separator)
+	path := func(parts ...string) string { return filepath.Join(append([]string{sep}, parts...)...) }
+	planned := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	later := planned.Add(time.Hour)
+	for _, f := range []struct {
+		path  string
+		data  string
+		mtime time.Time
+	}{
+		{path("src", "same"), "same", planned},
+		{path("src", "edited"), "edited!", later},
+		{path("a", "edited"), "old", planned},
+		{path("b", "edited"), "edited!", later},
+	} {
+		if err := mem.WriteFile(f.path, []byte(f.data), 0o644, f.mtime); err != nil {
+			t.Fatalf("WriteFile returned error: %v", err)
+		}
+	}
+	batch := task.Task{Action: task.ActionCopyBatch, Batch: &task.CopyBatchPayload{Entries: []task.CopyBatchEntry{
+		{Source: path("src", "same"), Destination: path("a", "same"), Fanout: []string{path("b", "same")}, Size: 4, ModTime: planned},
+		{Source: path("src", "edited"), Destination: path("a", "edited"), Fanout: []string{path("b", "edited")}, Size: 3, ModTime: planned},
+		{Source: path("src", "gone"), Destination: path("a", "gone"), Fanout: []string{path("b", "gone")}, Size: 1, ModTime: planned},
+	}}}
+
+	stale, err := StaleSources(mem, batch)
+	if err != nil {
+		t.Fatalf("StaleSources returned error: %v", err)
+	}
+	if want := []string{path("src", "edited"), path("src", "gone")}; !reflect.DeepEqual(stale, want) {
+		t.Fatalf("unexpected stale sources: got %v want %v", stale, want)
+	}
+
+	rest, ok := Without(batch, stale)
+	if !ok || len(rest.Batch.Entries) != 1 || rest.Batch.Entries[0].Source != path("src", "same") {
+		t.Fatalf("expected only the unchanged file to remain, got %+v", rest)
+	}
+
+	got, err := Revalidate(mem, mem, batch)
+	if err != nil {
+		t.Fatalf("Revalidate returned error: %v", err)
+	}
+	// The unchanged file stays batched, the edited file is copied only to
+	// the destination that does not hold it yet, and the missing source is
+	// dropped.
+	if len(got) != 2 || got[0].Action != task.ActionCopyBatch || len(got[0].Batch.Entries) != 1 {
+		t.Fatalf("unexpected revalidated tasks: %+v", got)
+	}
+	want := task.Task{Action: task.ActionCopy, Src: path("src", "edited"), Dst: path("a", "edited"), Size: 7, ModTime: later}
+	if !reflect.DeepEqual(got[1], want) {
+		t.Fatalf("unexpected copy: got %+v want %+v", got[1], want)
+	}
+
+	link := task.Task{Action: task.ActionLink, Src: path("src", "edited"), Dst: path("a", "linked"), Size: 3, ModTime: planned}
+	if _, err := Revalidate(mem, mem, link); err == nil {
+		t.Fatalf("expected a link to a changed reference file to be refused")
+	}
+}
+
+func TestStaleDelete(t *testing.T) {
+	mem := backend.NewMemory()
+	sep := string(filepath.Separator)
+	path := func(parts ...string) string { return filepath.Join(append([]string{sep}, parts...)...) }
+	planned := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	for _, f := range []struct {
+		path  string
+		mtime time.Time
+	}{
+		{path("src", "back"), planned},
+		{path("dst", "back"), planned},
+		{path("dst", "extra"), planned},
+		{path("dst", "edited"), planned.Add(time.Hour)},
+	} {
+		if err := mem.WriteFile(f.path, []byte("data"), 0o644, f.mtime); err != nil {
+			t.Fatalf("WriteFile returned error: %v", err)
+		}
+	}
+	del := func(name string) task.Task {
+		return task.Task{Action: task.ActionDelete, Dst: path("dst", name), Explanations: []task.Explanation{{Reason: task.ReasonExtra, Dst: &task.Metadata{Size: 4, ModTime: planned}}}}
+	}
+
+	for _, tc := range []struct {
+		name  string
+		stale bool
+	}{
+		{"extra", false},
+		// The source provides the path again.
+		{"back", true},
+		// The destination file was rewritten after planning.
+		{"edited", true},
+		// Deleting what is already gone is harmless.
+		{"gone", false},
+	} {
+		reason, err := StaleDelete(mem, mem, del(tc.name), []string{path("src", tc.name)})
+		if err != nil {
+			t.Fatalf("%s: StaleDelete returned error: %v", tc.name, err)
+		}
+		if (reason != "") != tc.stale {
+			t.Fatalf("%s: got reason %q, want stale %v", tc.name, reason, tc.stale)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ChunkParams bound the size of content-defined chunks. Avg must be a power
+// of two; cut points are found where the rolling hash has log2(Avg) low zero
+// bits, so chunks average roughly Avg bytes once past Min.
+type ChunkParams struct {
+	Min int `json:"min"`
+	Avg int `json:"avg"`
+	Max int `json:"max"`
+}
+
+// DefaultChunkParams are used for new repositories.
+var DefaultChunkParams = ChunkParams{Min: 256 << 10, Avg: 1 << 20, Max: 4 << 20}
+
+// Validate reports whether the parameters describe a usable chunker.
+func (p ChunkParams) Validate() error {
+	if p.Min <= 0 || p.Avg <= 0 || p.Max <= 0 {
+		return errors.New("chunk sizes must be positive")
+	}
+	if p.Avg&(p.Avg-1) != 0 {
+		return fmt.Errorf("average chunk size %d is not a power of two", p.Avg)
+	}
+	if p.Min > p.Avg || p.Avg > p.Max {
+		return fmt.Errorf("chunk sizes must satisfy min <= avg <= max, got %d/%d/%d", p.Min, p.Avg, p.Max)
+	}
+	return nil
+}
+
+// gear holds the per-byte constants of the rolling hash. They are derived
+// from a fixed seed so every build cuts identical content identically.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x5eed5eed5eed5eed)
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// chunker splits a stream at content-defined boundaries using a gear
+// rolling hash, so an insertion only changes the chunks around it.
+type chunker struct {
+	r      io.Reader
+	params ChunkParams
+	mask   uint64
+	buf    []byte
+	start  int
+	end    int
+	eof    bool
+}
+
+func newChunker(r io.Reader, params ChunkParams) *chunker {
+	return &chunker{
+		r:      r,
+		params: params,
+		mask:   uint64(params.Avg - 1),
+		buf:    make([]byte, 2*params.Max),
+	}
+}
+
+// Next returns the next chunk, or io.EOF once the stream is exhausted. The
+// returned slice is only valid until the following call.
+func (c *chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	data := c.buf[c.start:c.end]
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+	n := c.cut(data)
+	c.start += n
+	return data[:n], nil
+}
+
+// fill makes at least Max bytes available unless the stream ends first.
+func (c *chunker) fill() error {
+	if c.end-c.start >= c.params.Max || c.eof {
+		return nil
+	}
+	copy(c.buf, c.buf[c.start:c.end])
+	c.end -= c.start
+	c.start = 0
+	for c.end < len(c.buf) && !c.eof {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if errors.Is(err, io.EOF) {
+			c.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *chunker) cut(data []byte) int {
+	if len(data) <= c.params.Min {
+		return len(data)
+	}
+	limit := len(data)
+	if limit > c.params.Max {
+		limit = c.params.Max
+	}
+	var h uint64
+	for i := c.params.Min; i < limit; i++ {
+		h = (h << 1) + gear[data[i]]
+		if h&c.mask == 0 {
+			return i + 1
+		}
+	}
+	return limit
+}
@@ -0,0 +1,223 @@
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Manifest records the files of one run.
+type Manifest struct {
+	Version   int       `json:"version"`
+	Run       string    `json:"run"`
+	StartedAt time.Time `json:"started_at"`
+	// Files is sorted by Path.
+	Files []Entry `json:"files"`
+}
+
+// Entry describes one file in a manifest.
+type Entry struct {
+	// Path is slash separated and relative to the repository root.
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+	// Chunks lists the IDs of the chunks holding the content, in order.
+	Chunks []string `json:"chunks"`
+}
+
+// Run is a manifest being built by a sync. It starts as a copy of the newest
+// committed run, so files the sync does not touch carry over unchanged, and
+// becomes a new run once committed. A Run is safe for concurrent use.
+type Run struct {
+	repo      *Repository
+	stamp     string
+	startedAt time.Time
+
+	mu      sync.Mutex
+	files   map[string]Entry
+	written int64
+}
+
+// NewRun starts a run stamped with now.
+func (r *Repository) NewRun(now time.Time) (*Run, error) {
+	stamp := now.UTC().Format(stampLayout)
+	runs, err := r.Runs()
+	if err != nil {
+		return nil, err
+	}
+	run := &Run{repo: r, stamp: stamp, startedAt: now, files: make(map[string]Entry)}
+	if len(runs) == 0 {
+		return run, nil
+	}
+	if latest := runs[len(runs)-1]; latest >= stamp {
+		return nil, fmt.Errorf("repository %s already has run %s", r.Root, latest)
+	}
+	prev, err := r.Manifest(runs[len(runs)-1])
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range prev.Files {
+		run.files[entry.Path] = entry
+	}
+	return run, nil
+}
+
+// Stamp returns the name the run is committed under.
+func (run *Run) Stamp() string {
+	return run.stamp
+}
+
+// Root returns the repository directory.
+func (run *Run) Root() string {
+	return run.repo.Root
+}
+
+// StoredBytes returns the bytes of new chunk data written so far. Content
+// already present in the repository is not counted.
+func (run *Run) StoredBytes() int64 {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	return run.written
+}
+
+// Put stores the content read from r as the file rel, recording size, mode
+// and modification time from info. It returns the number of bytes read.
+func (run *Run) Put(rel string, r io.Reader, info fs.FileInfo) (int64, error) {
+	key, err := manifestKey(rel)
+	if err != nil {
+		return 0, err
+	}
+	c := newChunker(r, run.repo.params)
+	var (
+		chunks  []string
+		size    int64
+		written int64
+	)
+	for {
+		data, err := c.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return size, err
+		}
+		id, stored, err := run.repo.storeChunk(data)
+		if err != nil {
+			return size, err
+		}
+		chunks = append(chunks, id)
+		size += int64(len(data))
+		if stored {
+			written += int64(len(data))
+		}
+	}
+	if chunks == nil {
+		chunks = []string{}
+	}
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	// Replacing a directory with a file drops what the directory held.
+	run.removeLocked(key + "/")
+	run.files[key] = Entry{Path: key, Size: size, Mode: info.Mode().Perm(), ModTime: info.ModTime(), Chunks: chunks}
+	run.written += written
+	return size, nil
+}
+
+// Remove drops rel, or everything below it when rel is a directory.
+func (run *Run) Remove(rel string) error {
+	key, err := manifestKey(rel)
+	if err != nil {
+		return err
+	}
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	delete(run.files, key)
+	run.removeLocked(key + "/")
+	return nil
+}
+
+func (run *Run) removeLocked(prefix string) {
+	for p := range run.files {
+		if strings.HasPrefix(p, prefix) {
+			delete(run.files, p)
+		}
+	}
+}
+
+// List returns the files and implied directories below root, keyed by their
+// path relative to root, in the form scanner.Options.Destination expects.
+// root is a filesystem path inside the repository directory.
+func (run *Run) List(root string) (map[string]fs.FileInfo, map[string]fs.FileInfo, error) {
+	rel, err := filepath.Rel(run.repo.Root, root)
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix := ""
+	if rel != "." {
+		if prefix, err = manifestKey(rel); err != nil {
+			return nil, nil, err
+		}
+		prefix += "/"
+	}
+	files := make(map[string]fs.FileInfo)
+	dirs := make(map[string]fs.FileInfo)
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	for p, entry := range run.files {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		p = strings.TrimPrefix(p, prefix)
+		files[filepath.FromSlash(p)] = entryInfo{entry}
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			dirs[filepath.FromSlash(dir)] = entryInfo{Entry{Path: dir, Mode: fs.ModeDir | 0o755, ModTime: run.startedAt}}
+		}
+	}
+	return files, dirs, nil
+}
+
+// Commit writes the run's manifest, making it restorable.
+func (run *Run) Commit() error {
+	run.mu.Lock()
+	m := Manifest{Version: formatVersion, Run: run.stamp, StartedAt: run.startedAt, Files: make([]Entry, 0, len(run.files))}
+	for _, entry := range run.files {
+		m.Files = append(m.Files, entry)
+	}
+	run.mu.Unlock()
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return run.repo.writeAtomic(run.repo.manifestPath(run.stamp), data)
+}
+
+// manifestKey converts a relative filesystem path into a manifest path.
+func manifestKey(rel string) (string, error) {
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("path %q is not inside the repository", rel)
+	}
+	return filepath.ToSlash(filepath.Clean(rel)), nil
+}
+
+// entryInfo presents a manifest entry as fs.FileInfo.
+type entryInfo struct {
+	e Entry
+}
+
+func (i entryInfo) Name() string       { return path.Base(i.e.Path) }
+func (i entryInfo) Size() int64        { return i.e.Size }
+func (i entryInfo) Mode() fs.FileMode  { return i.e.Mode }
+func (i entryInfo) ModTime() time.Time { return i.e.ModTime }
+func (i entryInfo) IsDir() bool        { return i.e.Mode.IsDir() }
+func (i entryInfo) Sys() any           { return nil }
@@ -0,0 +1,365 @@
+// Package repo implements a content-addressed, deduplicating backup
+// repository. Files are split into content-defined chunks that are stored
+// once under their SHA-256, and every run records a manifest mapping each
+// path to its chunk list, so any run can be restored in full.
+//
+// On disk a repository looks like:
+//
+//	config.json           format version and chunking parameters
+//	chunks/ab/abcdef...   chunk contents, named by their hex SHA-256
+//	runs/<stamp>.json     one manifest per committed run
+package repo
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	formatVersion = 1
+	// stampLayout names runs. It matches the run stamps used for backup
+	// trees and snapshots.
+	stampLayout = "20060102T150405Z"
+)
+
+// ErrNotRepository is returned by Open for a directory that holds no
+// repository.
+var ErrNotRepository = errors.New("not a syncopa repository")
+
+type config struct {
+	Version int         `json:"version"`
+	Chunks  ChunkParams `json:"chunks"`
+}
+
+// Repository is an opened repository directory.
+type Repository struct {
+	// Root is the repository directory.
+	Root string
+	// Sync flushes chunks and manifests to stable storage as they are
+	// written.
+	Sync bool
+
+	params ChunkParams
+}
+
+// Open opens the repository at root.
+func Open(root string) (*Repository, error) {
+	data, err := os.ReadFile(filepath.Join(root, "config.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotRepository, root)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cfg config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", root, err)
+	}
+	if cfg.Version != formatVersion {
+		return nil, fmt.Errorf("repository %s has unsupported format version %d", root, cfg.Version)
+	}
+	if err := cfg.Chunks.Validate(); err != nil {
+		return nil, fmt.Errorf("repository %s: %w", root, err)
+	}
+	return &Repository{Root: root, params: cfg.Chunks}, nil
+}
+
+// Init creates a repository at root using params. root may exist but must
+// be empty.
+func Init(root string, params ChunkParams) (*Repository, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		return nil, fmt.Errorf("cannot create a repository in non-empty directory %s", root)
+	}
+	for _, dir := range []string{"chunks", "runs"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	data, err := json.MarshalIndent(config{Version: formatVersion, Chunks: params}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	r := &Repository{Root: root, params: params}
+	if err := r.writeAtomic(filepath.Join(root, "config.json"), data); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// OpenOrInit opens the repository at root, creating it with the default
+// chunking parameters when root is missing or empty.
+func OpenOrInit(root string) (*Repository, error) {
+	r, err := Open(root)
+	if errors.Is(err, ErrNotRepository) {
+		return Init(root, DefaultChunkParams)
+	}
+	return r, err
+}
+
+// Runs returns the committed run stamps, oldest first.
+func (r *Repository) Runs() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(r.Root, "runs"))
+	if err != nil {
+		return nil, err
+	}
+	var runs []string
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if _, err := time.Parse(stampLayout, name); err == nil {
+			runs = append(runs, name)
+		}
+	}
+	sort.Strings(runs)
+	return runs, nil
+}
+
+// Manifest loads the manifest of run. The run "latest" selects the newest
+// committed run.
+func (r *Repository) Manifest(run string) (*Manifest, error) {
+	if run == "latest" {
+		runs, err := r.Runs()
+		if err != nil {
+			return nil, err
+		}
+		if len(runs) == 0 {
+			return nil, fmt.Errorf("repository %s has no runs", r.Root)
+		}
+		run = runs[len(runs)-1]
+	} else if _, err := time.Parse(stampLayout, run); err != nil {
+		return nil, fmt.Errorf("invalid run %q: expected a stamp such as 20240601T120000Z or latest", run)
+	}
+	data, err := os.ReadFile(r.manifestPath(run))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("repository %s has no run %s", r.Root, run)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("reading run %s: %w", run, err)
+	}
+	if m.Version != formatVersion {
+		return nil, fmt.Errorf("run %s has unsupported format version %d", run, m.Version)
+	}
+	return &m, nil
+}
+
+func (r *Repository) manifestPath(run string) string {
+	return filepath.Join(r.Root, "runs", run+".json")
+}
+
+func (r *Repository) chunkPath(id string) string {
+	return filepath.Join(r.Root, "chunks", id[:2], id)
+}
+
+// storeChunk writes data under its hash unless an identical chunk is already
+// stored. It returns the chunk ID and whether new data was written.
+func (r *Repository) storeChunk(data []byte) (string, bool, error) {
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:])
+	path := r.chunkPath(id)
+	if _, err := os.Stat(path); err == nil {
+		return id, false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", false, err
+	}
+	if err := r.writeAtomic(path, data); err != nil {
+		return "", false, err
+	}
+	return id, true, nil
+}
+
+// readChunk returns the chunk with the given ID after checking its hash.
+func (r *Repository) readChunk(id string) ([]byte, error) {
+	if len(id) != sha256.Size*2 {
+		return nil, fmt.Errorf("invalid chunk id %q", id)
+	}
+	data, err := os.ReadFile(r.chunkPath(id))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != id {
+		return nil, fmt.Errorf("chunk %s is corrupt", id)
+	}
+	return data, nil
+}
+
+// writeAtomic writes data to a temporary file next to path and renames it
+// into place, so readers never observe a partial file.
+func (r *Repository) writeAtomic(path string, data []byte) error {
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return err
+	}
+	tmp := path + ".tmp-" + hex.EncodeToString(suffix[:])
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil && r.Sync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Restore materialises the files of run below dst. When prefix is not empty
+// only that path, or the files below it, are restored. Every chunk is
+// verified against its hash before it is written.
+func (r *Repository) Restore(run, prefix, dst string) (int, error) {
+	m, err := r.Manifest(run)
+	if err != nil {
+		return 0, err
+	}
+	prefix = strings.Trim(filepath.ToSlash(filepath.Clean(prefix)), "/")
+	if prefix == "." {
+		prefix = ""
+	}
+	restored := 0
+	for _, entry := range m.Files {
+		if prefix != "" && entry.Path != prefix && !strings.HasPrefix(entry.Path, prefix+"/") {
+			continue
+		}
+		rel := filepath.FromSlash(entry.Path)
+		if !filepath.IsLocal(rel) {
+			return restored, fmt.Errorf("run %s records unsafe path %q", m.Run, entry.Path)
+		}
+		if err := r.restoreFile(entry, filepath.Join(dst, rel)); err != nil {
+			return restored, fmt.Errorf("restoring %s: %w", entry.Path, err)
+		}
+		restored++
+	}
+	if prefix != "" && restored == 0 {
+		return 0, fmt.Errorf("run %s has no files below %s", m.Run, prefix)
+	}
+	return restored, nil
+}
+
+func (r *Repository) restoreFile(entry Entry, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, entry.Mode.Perm())
+	if err != nil {
+		return err
+	}
+	var written int64
+	for _, id := range entry.Chunks {
+		data, err := r.readChunk(id)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		n, err := out.Write(data)
+		written += int64(n)
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if written != entry.Size {
+		return fmt.Errorf("restored %d bytes, manifest records %d", written, entry.Size)
+	}
+	if err := os.Chmod(path, entry.Mode.Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(path, entry.ModTime, entry.ModTime)
+}
+
+// PruneResult summarises a Prune call.
+type PruneResult struct {
+	// Runs lists the manifests that were removed.
+	Runs []string
+	// Chunks and Bytes count the unreferenced chunks that were removed.
+	Chunks int
+	Bytes  int64
+}
+
+// Prune removes all but the newest keepRuns manifests and then deletes every
+// chunk no remaining manifest references. A keepRuns <= 0 keeps every run
+// and only collects chunks left behind by runs that were never committed.
+// Prune must not run concurrently with a sync into the same repository.
+func (r *Repository) Prune(keepRuns int) (PruneResult, error) {
+	var res PruneResult
+	runs, err := r.Runs()
+	if err != nil {
+		return res, err
+	}
+	if keepRuns > 0 && len(runs) > keepRuns {
+		for _, run := range runs[:len(runs)-keepRuns] {
+			if err := os.Remove(r.manifestPath(run)); err != nil {
+				return res, err
+			}
+			res.Runs = append(res.Runs, run)
+		}
+		runs = runs[len(runs)-keepRuns:]
+	}
+
+	referenced := make(map[string]struct{})
+	for _, run := range runs {
+		m, err := r.Manifest(run)
+		if err != nil {
+			return res, err
+		}
+		for _, entry := range m.Files {
+			for _, id := range entry.Chunks {
+				referenced[id] = struct{}{}
+			}
+		}
+	}
+
+	err = filepath.WalkDir(filepath.Join(r.Root, "chunks"), func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil || d.IsDir() {
+			return walkErr
+		}
+		if _, ok := referenced[d.Name()]; ok {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		res.Chunks++
+		res.Bytes += info.Size()
+		return nil
+	})
+	return res, err
+}
@@ -0,0 +1,183 @@
+package repo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testParams = ChunkParams{Min: 1 << 10, Avg: 4 << 10, Max: 16 << 10}
+
+func TestChunkerCutsAtContentBoundaries(t *testing.T) {
+	data := make([]byte, 256<<10)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	original := chunkIDs(t, data)
+	if len(original) < 10 {
+		t.Fatalf("expected content to be split into many chunks, got %d", len(original))
+	}
+	// Inserting bytes near the start only disturbs the chunks around the
+	// insertion; later chunks keep their boundaries.
+	shifted := append([]byte("inserted"), data...)
+	moved := chunkIDs(t, shifted)
+	common := 0
+	seen := make(map[string]bool, len(original))
+	for _, id := range original {
+		seen[id] = true
+	}
+	for _, id := range moved {
+		if seen[id] {
+			common++
+		}
+	}
+	if common < len(original)-2 {
+		t.Fatalf("only %d of %d chunks survived a small insertion", common, len(original))
+	}
+}
+
+func chunkIDs(t *testing.T, data []byte) []string {
+	t.Helper()
+	c := newChunker(bytes.NewReader(data), testParams)
+	var ids []string
+	var total int
+	for {
+		chunk, err := c.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next returned error: %v", err)
+		}
+		if len(chunk) > testParams.Max {
+			t.Fatalf("chunk of %d bytes exceeds the maximum", len(chunk))
+		}
+		total += len(chunk)
+		sum := sha256.Sum256(chunk)
+		ids = append(ids, hex.EncodeToString(sum[:]))
+	}
+	if total != len(data) {
+		t.Fatalf("chunks hold %d bytes, want %d", total, len(data))
+	}
+	return ids
+}
+
+func TestRunPutCommitRestoreAndPrune(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "repo")
+	r, err := Init(root, testParams)
+	if err != nil {
+		t.Fatalf("Init returned error: %v", err)
+	}
+	srcDir := t.TempDir()
+	shared := make([]byte, 64<<10)
+	rand.New(rand.NewSource(2)).Read(shared)
+	a := writeFile(t, srcDir, "a.bin", shared)
+	b := writeFile(t, srcDir, filepath.Join("dir", "b.bin"), shared)
+
+	first := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	run, err := r.NewRun(first)
+	if err != nil {
+		t.Fatalf("NewRun returned error: %v", err)
+	}
+	putFile(t, run, "a.bin", a)
+	putFile(t, run, filepath.Join("dir", "b.bin"), b)
+	if run.StoredBytes() != int64(len(shared)) {
+		t.Fatalf("identical files should be stored once, stored %d bytes", run.StoredBytes())
+	}
+	if err := run.Commit(); err != nil {
+		t.Fatalf("Commit returned error: %v", err)
+	}
+
+	// The second run starts from the first and drops the directory.
+	run, err = r.NewRun(first.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("NewRun returned error: %v", err)
+	}
+	files, dirs, err := run.List(root)
+	if err != nil || len(files) != 2 || len(dirs) != 1 {
+		t.Fatalf("unexpected listing %v %v (%v)", files, dirs, err)
+	}
+	if err := run.Remove("dir"); err != nil {
+		t.Fatalf("Remove returned error: %v", err)
+	}
+	if err := run.Commit(); err != nil {
+		t.Fatalf("Commit returned error: %v", err)
+	}
+
+	out := t.TempDir()
+	if n, err := r.Restore("20240601T000000Z", "", out); err != nil || n != 2 {
+		t.Fatalf("Restore returned %d, %v", n, err)
+	}
+	for _, rel := range []string{"a.bin", filepath.Join("dir", "b.bin")} {
+		got, err := os.ReadFile(filepath.Join(out, rel))
+		if err != nil || !bytes.Equal(got, shared) {
+			t.Fatalf("restored %s does not match (%v)", rel, err)
+		}
+	}
+	latest, err := r.Manifest("latest")
+	if err != nil || len(latest.Files) != 1 || latest.Files[0].Path != "a.bin" {
+		t.Fatalf("unexpected latest manifest %+v (%v)", latest, err)
+	}
+
+	// A stray chunk from an uncommitted run is collected; shared chunks
+	// are still referenced by the remaining run.
+	if _, _, err := r.storeChunk([]byte("orphan")); err != nil {
+		t.Fatalf("storeChunk returned error: %v", err)
+	}
+	res, err := r.Prune(1)
+	if err != nil {
+		t.Fatalf("Prune returned error: %v", err)
+	}
+	if len(res.Runs) != 1 || res.Chunks != 1 || res.Bytes != int64(len("orphan")) {
+		t.Fatalf("unexpected prune result %+v", res)
+	}
+	out = t.TempDir()
+	if _, err := r.Restore("latest", "a.bin", out); err != nil {
+		t.Fatalf("Restore after prune returned error: %v", err)
+	}
+}
+
+func TestOpenRejectsOtherDirectories(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Open(dir); !errors.Is(err, ErrNotRepository) {
+		t.Fatalf("expected ErrNotRepository, got %v", err)
+	}
+	writeFile(t, dir, "data.txt", []byte("x"))
+	if _, err := OpenOrInit(dir); err == nil {
+		t.Fatal("expected a non-empty directory to be refused")
+	}
+}
+
+func writeFile(t *testing.T, root, rel string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(root, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("failed to create parent: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+	return path
+}
+
+func putFile(t *testing.T, run *Run, rel, src string) {
+	t.Helper()
+	f, err := os.Open(src)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", src, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatalf("failed to stat %s: %v", src, err)
+	}
+	if _, err := run.Put(rel, f, info); err != nil {
+		t.Fatalf("Put(%s) returned error: %v", rel, err)
+	}
+}
@@ -0,0 +1,94 @@
+package scanner
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+const (
+	// compressionSampleSize is how much of an archive is test-compressed to
+	// decide whether compressing the whole batch is worthwhile.
+	compressionSampleSize = 64 * 1024
+	// defaultCompressionRatio is the compressed/plain ratio a sample must
+	// reach before the batch is compressed.
+	defaultCompressionRatio = 0.9
+)
+
+// ParseCompression validates an archive compression name as accepted by
+// Options.ArchiveCompression.
+func ParseCompression(s string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "none":
+		return task.CompressionNone, nil
+	case task.CompressionGzip:
+		return task.CompressionGzip, nil
+	case task.CompressionFlate:
+		return task.CompressionFlate, nil
+	default:
+		return "", fmt.Errorf("unknown archive compression %q", s)
+	}
+}
+
+// compressPayload compresses the payload archive with opts.ArchiveCompression
+// when a sample of the archive shrinks to at most opts.CompressionRatio of
+// its size. Incompressible batches are left as plain TAR.
+func compressPayload(payload *task.CopyBatchPayload, opts Options) error {
+	if opts.ArchiveCompression == task.CompressionNone || len(payload.Archive) == 0 || payload.Compression != task.CompressionNone {
+		return nil
+	}
+	minRatio := opts.CompressionRatio
+	if minRatio <= 0 {
+		minRatio = defaultCompressionRatio
+	}
+	sample := payload.Archive
+	if len(sample) > compressionSampleSize {
+		sample = sample[:compressionSampleSize]
+	}
+	compressedSample, err := compressBytes(sample, opts.ArchiveCompression)
+	if err != nil {
+		return err
+	}
+	if float64(len(compressedSample)) > minRatio*float64(len(sample)) {
+		return nil
+	}
+	compressed := compressedSample
+	if len(sample) < len(payload.Archive) {
+		compressed, err = compressBytes(payload.Archive, opts.ArchiveCompression)
+		if err != nil {
+			return err
+		}
+	}
+	payload.Archive = compressed
+	payload.Compression = opts.ArchiveCompression
+	return nil
+}
+
+func compressBytes(data []byte, codec string) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch codec {
+	case task.CompressionGzip:
+		w, err = gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	case task.CompressionFlate:
+		w, err = flate.NewWriter(&buf, flate.BestSpeed)
+	default:
+		return nil, fmt.Errorf("unknown archive compression %q", codec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,65 @@
+package scanner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/syncopasoft/syncopa-core/internal/backend"
+)
+
+// TreeFingerprint summarises the entries of a tree by name, size and
+// modification time, so a later run can tell cheaply whether the tree
+// changed since it was scanned.
+type TreeFingerprint struct {
+	// Root is the directory that was scanned.
+	Root string
+	// Source is set for the source trees of a scan.
+	Source bool
+	Files  int
+	Bytes  int64
+	// Digest is the hex SHA-256 of the sorted entry list.
+	Digest string
+}
+
+// Fingerprint walks root and returns its fingerprint. A missing root has a
+// fingerprint of its own, distinct from an empty directory.
+func Fingerprint(b backend.Backend, root string) (TreeFingerprint, error) {
+	root = filepath.Clean(root)
+	snap, err := snapshot(backend.OrLocal(b), root)
+	if err != nil {
+		return TreeFingerprint{}, err
+	}
+	return fingerprintSnapshot(root, snap), nil
+}
+
+func fingerprintSnapshot(root string, snap *snapshotResult) TreeFingerprint {
+	fp := TreeFingerprint{Root: root, Files: len(snap.Files)}
+	h := sha256.New()
+	if snap.Missing {
+		fmt.Fprint(h, "missing\n")
+	}
+	rels := make([]string, 0, len(snap.Files)+len(snap.Dirs))
+	for rel := range snap.Files {
+		rels = append(rels, rel)
+	}
+	for rel := range snap.Dirs {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	for _, rel := range rels {
+		name := filepath.ToSlash(rel)
+		if meta, ok := snap.Files[rel]; ok {
+			fp.Bytes += meta.Info.Size()
+			fmt.Fprintf(h, "f %q %d %d\n", name, meta.Info.Size(), meta.Info.ModTime().UnixNano())
+			continue
+		}
+		// Directory times change whenever an entry is added or removed, which
+		// the entries themselves already capture.
+		fmt.Fprintf(h, "d %q\n", name)
+	}
+	fp.Digest = hex.EncodeToString(h.Sum(nil))
+	return fp
+}
@@ -0,0 +1,197 @@
+package scanner
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/syncopasoft/syncopa-core/internal/backend"
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+// Source is one of the trees merged into the destination by ScanMerge.
+type Source struct {
+	// Root is the source directory.
+	Root string
+	// Prefix is the destination-relative directory the source's entries
+	// are placed in. Empty places them at the destination root.
+	Prefix string
+}
+
+// Precedence decides which source supplies a destination path that several
+// sources provide.
+type Precedence int
+
+const (
+	// PrecedenceFirst uses the file from the source listed first.
+	PrecedenceFirst Precedence = iota
+	// PrecedenceNewest uses the most recently modified file. Ties go to
+	// the source listed first.
+	PrecedenceNewest
+	// PrecedenceError refuses to plan anything when sources collide.
+	PrecedenceError
+)
+
+var precedenceNames = map[string]Precedence{
+	"first":  PrecedenceFirst,
+	"newest": PrecedenceNewest,
+	"error":  PrecedenceError,
+}
+
+// ErrCollision is returned by merged scans with PrecedenceError when several
+// sources provide the same destination path, and by every merged scan when
+// a path is a file in one source and a directory in another.
+var ErrCollision = errors.New("sources collide")
+
+// ParsePrecedence converts a string into a Precedence value.
+func ParsePrecedence(s string) (Precedence, error) {
+	p, ok := precedenceNames[strings.ToLower(s)]
+	if !ok {
+		return PrecedenceFirst, fmt.Errorf("unknown precedence %q", s)
+	}
+	return p, nil
+}
+
+// Collision describes a destination path provided by several sources.
+type Collision struct {
+	// Path is the path relative to the destination.
+	Path string
+	// Used is the source file the destination receives.
+	Used string
+	// Skipped lists the other source files, in source order.
+	Skipped []string
+}
+
+// ScanMerge merges several sources into each destination. Every source is
+// placed below its Prefix; a path provided by more than one source is taken
+// from the source chosen by Options.Precedence and reported to
+// Options.OnCollision. Mirror scans delete only destination entries that no
+// source provides, and refuse to run when any source is missing or empty
+// unless Options.AllowEmptySource is set. Bidirectional scans need a single
+// source.
+func ScanMerge(sources []Source, dsts []string, mode Mode, opts Options, tasks chan<- task.Task) error {
+	cleaned := make([]Source, len(sources))
+	for i, source := range sources {
+		if source.Root == "" {
+			return errors.New("src and dst required")
+		}
+		prefix, err := cleanPrefix(source.Prefix)
+		if err != nil {
+			return err
+		}
+		cleaned[i] = Source{Root: filepath.Clean(source.Root), Prefix: prefix}
+	}
+	return scanSources(cleaned, "", dsts, mode, opts, tasks)
+}
+
+// cleanPrefix validates a source prefix and returns it in its clean form.
+func cleanPrefix(prefix string) (string, error) {
+	if prefix == "" {
+		return "", nil
+	}
+	clean := filepath.Clean(filepath.FromSlash(prefix))
+	if filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("source prefix %q must be a relative path inside the destination", prefix)
+	}
+	if clean == "." {
+		return "", nil
+	}
+	return clean, nil
+}
+
+// mergedSources holds the sources of a scan keyed by destination path.
+type mergedSources struct {
+	files map[string]fileMeta
+	dirs  map[string]fileMeta
+	keys  []string
+	// snaps holds the snapshot of every source, in order.
+	snaps []*snapshotResult
+}
+
+// mergeSources snapshots every source and merges the entries by destination
+// path. The directories a source's Prefix creates count as source
+// directories, so mirror runs keep them.
+func mergeSources(b backend.Backend, sources []Source, opts Options) (*mergedSources, error) {
+	m := &mergedSources{
+		files: make(map[string]fileMeta),
+		dirs:  make(map[string]fileMeta),
+		snaps: make([]*snapshotResult, len(sources)),
+	}
+	// owner records the source of every file and skipped the losing files
+	// of every collided path with their source.
+	owner := make(map[string]int)
+	skipped := make(map[string][]sourceFile)
+	for i, source := range sources {
+		snap, err := snapshot(b, source.Root)
+		if err != nil {
+			return nil, err
+		}
+		m.snaps[i] = snap
+		if opts.OnFingerprint != nil {
+			fp := fingerprintSnapshot(source.Root, snap)
+			fp.Source = true
+			opts.OnFingerprint(fp)
+		}
+		if !snap.Missing && source.Prefix != "" {
+			for dir := source.Prefix; dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+				if _, ok := m.dirs[dir]; !ok {
+					m.dirs[dir] = fileMeta{Path: source.Root}
+				}
+			}
+		}
+		for rel, meta := range snap.Dirs {
+			key := withPrefix(source.Prefix, rel, true)
+			if _, ok := m.dirs[key]; !ok {
+				m.dirs[key] = meta
+			}
+		}
+		for rel, meta := range snap.Files {
+			key := withPrefix(source.Prefix, rel, true)
+			prev, ok := m.files[key]
+			if !ok {
+				m.files[key] = meta
+				owner[key] = i
+				m.keys = append(m.keys, key)
+				continue
+			}
+			loser := sourceFile{source: i, path: meta.Path}
+			if opts.Precedence == PrecedenceNewest && meta.Info.ModTime().After(prev.Info.ModTime()) {
+				loser = sourceFile{source: owner[key], path: prev.Path}
+				m.files[key] = meta
+				owner[key] = i
+			}
+			skipped[key] = append(skipped[key], loser)
+		}
+	}
+	sort.Strings(m.keys)
+	for _, key := range m.keys {
+		file := m.files[key]
+		if dir, ok := m.dirs[key]; ok {
+			return nil, fmt.Errorf("%w: %s is a file in %s and a directory in %s", ErrCollision, key, file.Path, dir.Path)
+		}
+		losers, ok := skipped[key]
+		if !ok {
+			continue
+		}
+		sort.SliceStable(losers, func(i, j int) bool { return losers[i].source < losers[j].source })
+		if opts.Precedence == PrecedenceError {
+			return nil, fmt.Errorf("%w: %s is provided by %s and %s", ErrCollision, key, file.Path, losers[0].path)
+		}
+		if opts.OnCollision != nil {
+			collision := Collision{Path: key, Used: file.Path}
+			for _, loser := range losers {
+				collision.Skipped = append(collision.Skipped, loser.path)
+			}
+			opts.OnCollision(collision)
+		}
+	}
+	return m, nil
+}
+
+// sourceFile is a source file together with the index of its source.
+type sourceFile struct {
+	source int
+	path   string
+}
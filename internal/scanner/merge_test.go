@@ -0,0 +1,126 @@
+package scanner
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+func TestScanMergeAppliesPrecedence(t *testing.T) {
+	first := t.TempDir()
+	dept := t.TempDir()
+	last := t.TempDir()
+	dst := t.TempDir()
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	for _, f := range []struct {
+		root, rel string
+		mtime     time.Time
+	}{
+		{first, "shared.txt", older},
+		{first, "only.txt", older},
+		{dept, "x.txt", older},
+		{last, "shared.txt", newer},
+	} {
+		p := writeTestFile(t, f.root, f.rel, f.root)
+		if err := os.Chtimes(p, f.mtime, f.mtime); err != nil {
+			t.Fatalf("Chtimes returned error: %v", err)
+		}
+	}
+	writeTestFile(t, dst, "stale.txt", "stale")
+	writeTestFile(t, dst, filepath.Join("dept", "old.txt"), "old")
+	sources := []Source{{Root: first}, {Root: dept, Prefix: "dept"}, {Root: last}}
+
+	plan := func(precedence Precedence) ([]string, []Collision, error) {
+		var collisions []Collision
+		opts := Options{Precedence: precedence, OnCollision: func(c Collision) { collisions = append(collisions, c) }}
+		tasks := make(chan task.Task, 16)
+		err := ScanMerge(sources, []string{dst}, ModeMirror, opts, tasks)
+		close(tasks)
+		var got []string
+		for tk := range tasks {
+			switch tk.Action {
+			case task.ActionCopy:
+				got = append(got, "copy "+tk.Src+" -> "+mustRelPath(t, dst, tk.Dst))
+			case task.ActionDelete:
+				got = append(got, "delete "+mustRelPath(t, dst, tk.Dst))
+			}
+		}
+		return got, collisions, err
+	}
+
+	got, collisions, err := plan(PrecedenceFirst)
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	want := []string{
+		"copy " + filepath.Join(dept, "x.txt") + " -> dept/x.txt",
+		"copy " + filepath.Join(first, "only.txt") + " -> only.txt",
+		"copy " + filepath.Join(first, "shared.txt") + " -> shared.txt",
+		"delete dept/old.txt",
+		"delete stale.txt",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected tasks: got %v want %v", got, want)
+	}
+	wantCollisions := []Collision{{Path: "shared.txt", Used: filepath.Join(first, "shared.txt"), Skipped: []string{filepath.Join(last, "shared.txt")}}}
+	if !reflect.DeepEqual(collisions, wantCollisions) {
+		t.Fatalf("unexpected collisions: got %+v want %+v", collisions, wantCollisions)
+	}
+
+	got, collisions, err = plan(PrecedenceNewest)
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	if got[2] != "copy "+filepath.Join(last, "shared.txt")+" -> shared.txt" {
+		t.Fatalf("expected the newest source to win, got %v", got)
+	}
+	wantCollisions = []Collision{{Path: "shared.txt", Used: filepath.Join(last, "shared.txt"), Skipped: []string{filepath.Join(first, "shared.txt")}}}
+	if !reflect.DeepEqual(collisions, wantCollisions) {
+		t.Fatalf("unexpected collisions: got %+v want %+v", collisions, wantCollisions)
+	}
+
+	got, _, err = plan(PrecedenceError)
+	if !errors.Is(err, ErrCollision) || len(got) != 0 {
+		t.Fatalf("expected the collision to refuse the scan, got %v and %v", got, err)
+	}
+}
+
+func TestScanMergeRejectsConflictingSources(t *testing.T) {
+	file := t.TempDir()
+	tree := t.TempDir()
+	empty := t.TempDir()
+	dst := t.TempDir()
+	writeTestFile(t, file, "docs", "a file")
+	writeTestFile(t, tree, "readme.txt", "in a directory")
+	writeTestFile(t, dst, "kept.txt", "kept")
+
+	cases := []struct {
+		name    string
+		sources []Source
+		mode    Mode
+		want    error
+	}{
+		{"file and directory", []Source{{Root: file}, {Root: tree, Prefix: "docs"}}, ModeUpdate, ErrCollision},
+		{"empty source", []Source{{Root: tree}, {Root: empty, Prefix: "e"}}, ModeMirror, ErrSourceEmpty},
+		{"escaping prefix", []Source{{Root: tree}, {Root: file, Prefix: "../up"}}, ModeUpdate, nil},
+		{"bidirectional", []Source{{Root: tree}, {Root: file}}, ModeSync, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tasks := make(chan task.Task, 16)
+			err := ScanMerge(tc.sources, []string{dst}, tc.mode, Options{}, tasks)
+			if err == nil || (tc.want != nil && !errors.Is(err, tc.want)) {
+				t.Fatalf("expected the scan to fail with %v, got %v", tc.want, err)
+			}
+			if len(tasks) != 0 {
+				t.Fatalf("expected nothing to be planned, got %d tasks", len(tasks))
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package scanner
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrSourceMissing is returned by mirror scans whose source root does not
+	// exist, which usually means an unmounted volume or a typo.
+	ErrSourceMissing = errors.New("source root does not exist")
+	// ErrSourceEmpty is returned by mirror scans of an empty source root
+	// that would delete everything at the destination.
+	ErrSourceEmpty = errors.New("source root is empty")
+	// ErrDeleteLimit is returned when a mirror scan plans more deletions
+	// than Options.MaxDelete or Options.MaxDeletePercent allow.
+	ErrDeleteLimit = errors.New("deletion limit exceeded")
+)
+
+// ParseDeleteLimit parses a deletion limit given either as a count ("500")
+// or as a percentage of the destination ("10%"). It returns the value for
+// Options.MaxDelete or Options.MaxDeletePercent; the other is zero, which
+// the options treat as unlimited. A limit of zero is therefore rejected
+// rather than silently lifting the safeguard.
+func ParseDeleteLimit(s string) (int, float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, 0, nil
+	}
+	if pct, ok := strings.CutSuffix(s, "%"); ok {
+		v, err := strconv.ParseFloat(strings.TrimSpace(pct), 64)
+		if err != nil || v < 0 || v > 100 {
+			return 0, 0, fmt.Errorf("invalid deletion limit %q: percentage must be between 0 and 100", s)
+		}
+		if v == 0 {
+			return 0, 0, errZeroDeleteLimit(s)
+		}
+		return 0, v, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, 0, fmt.Errorf("invalid deletion limit %q: want a count or a percentage such as 10%%", s)
+	}
+	if n == 0 {
+		return 0, 0, errZeroDeleteLimit(s)
+	}
+	return n, 0, nil
+}
+
+func errZeroDeleteLimit(s string) error {
+	return fmt.Errorf("invalid deletion limit %q: must be above zero; use --mode update to copy without deleting", s)
+}
+
+// ValidateProtectPatterns reports the first malformed pattern in patterns.
+func ValidateProtectPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid protect pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// isProtected reports whether the destination-relative path rel or one of
+// its parent directories matches a protect pattern. Patterns use
+// path.Match syntax with forward slashes; a pattern without a slash matches
+// a name at any depth.
+func isProtected(rel string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	for p := rel; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+		for _, pattern := range patterns {
+			pattern = strings.TrimSuffix(pattern, "/")
+			target := p
+			if !strings.Contains(pattern, "/") {
+				target = path.Base(p)
+			}
+			if ok, _ := path.Match(pattern, target); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkMirrorSource refuses to mirror from a missing or empty source unless
+// opts.AllowEmptySource is set.
+func checkMirrorSource(srcRoot string, srcSnap, dstSnap *snapshotResult, opts Options) error {
+	if opts.AllowEmptySource {
+		return nil
+	}
+	if srcSnap.Missing {
+		return fmt.Errorf("%w: %s (use --force to mirror anyway)", ErrSourceMissing, srcRoot)
+	}
+	dstEntries := len(dstSnap.Files) + len(dstSnap.Dirs)
+	if len(srcSnap.Files)+len(srcSnap.Dirs) == 0 && dstEntries > 0 {
+		return fmt.Errorf("%w: %s, refusing to delete %d destination entries (use --force to mirror anyway)", ErrSourceEmpty, srcRoot, dstEntries)
+	}
+	return nil
+}
+
+// planMirrorDeletes returns the destination entries a mirror run removes:
+// files first, then directories deepest first. Protected entries and
+// directories holding protected entries are left alone.
+func planMirrorDeletes(cleanDst string, dstFiles, dstDirs, srcFiles, srcDirs map[string]fileMeta, protect []string) []fileMeta {
+	var files, dirs []string
+	for key := range dstFiles {
+		if _, ok := srcFiles[key]; ok || isProtected(key, protect) {
+			continue
+		}
+		files = append(files, key)
+	}
+	// Deleting a directory removes everything below it, so it must be kept
+	// when anything inside is protected.
+	shielded := make(map[string]struct{})
+	if len(protect) > 0 {
+		for _, entries := range []map[string]fileMeta{dstFiles, dstDirs} {
+			for key := range entries {
+				if !isProtected(key, protect) {
+					continue
+				}
+				for dir := filepath.Dir(key); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+					shielded[dir] = struct{}{}
+				}
+			}
+		}
+	}
+	for key := range dstDirs {
+		if _, ok := srcDirs[key]; ok || isProtected(key, protect) {
+			continue
+		}
+		if _, ok := shielded[key]; ok {
+			continue
+		}
+		dirs = append(dirs, key)
+	}
+	sort.Strings(files)
+	sort.Slice(dirs, func(i, j int) bool {
+		if len(dirs[i]) != len(dirs[j]) {
+			return len(dirs[i]) > len(dirs[j])
+		}
+		return dirs[i] < dirs[j]
+	})
+
+	deletes := make([]fileMeta, 0, len(files)+len(dirs))
+	for _, key := range files {
+		deletes = append(deletes, dstFiles[key])
+	}
+	for _, key := range dirs {
+		deletes = append(deletes, fileMeta{Path: filepath.Join(cleanDst, key), Info: dstDirs[key].Info})
+	}
+	return deletes
+}
+
+// checkDeleteLimits enforces Options.MaxDelete and Options.MaxDeletePercent
+// against the planned deletions and the number of destination entries.
+func checkDeleteLimits(deletes, dstEntries int, opts Options) error {
+	if opts.MaxDelete > 0 && deletes > opts.MaxDelete {
+		return fmt.Errorf("%w: mirror would delete %d entries, limit is %d", ErrDeleteLimit, deletes, opts.MaxDelete)
+	}
+	if opts.MaxDeletePercent > 0 && dstEntries > 0 {
+		pct := float64(deletes) * 100 / float64(dstEntries)
+		if pct > opts.MaxDeletePercent {
+			return fmt.Errorf("%w: mirror would delete %d of %d entries (%.1f%%), limit is %g%%", ErrDeleteLimit, deletes, dstEntries, pct, opts.MaxDeletePercent)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package scanner
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+func TestScanMirrorRefusesMissingOrEmptySource(t *testing.T) {
+	dstDir := t.TempDir()
+	writeTestFile(t, dstDir, "keep.txt", "data")
+
+	missing := filepath.Join(t.TempDir(), "unmounted")
+	if err := Scan(missing, dstDir, false, ModeMirror, Options{}, make(chan task.Task, 8)); !errors.Is(err, ErrSourceMissing) {
+		t.Fatalf("expected ErrSourceMissing, got %v", err)
+	}
+
+	empty := t.TempDir()
+	tasks := make(chan task.Task, 8)
+	if err := Scan(empty, dstDir, false, ModeMirror, Options{}, tasks); !errors.Is(err, ErrSourceEmpty) {
+		t.Fatalf("expected ErrSourceEmpty, got %v", err)
+	}
+	if len(tasks) != 0 {
+		t.Fatalf("refused scan emitted %d tasks", len(tasks))
+	}
+
+	tasks = make(chan task.Task, 8)
+	if err := Scan(empty, dstDir, false, ModeMirror, Options{AllowEmptySource: true}, tasks); err != nil {
+		t.Fatalf("forced scan failed: %v", err)
+	}
+	if len(tasks) != 1 {
+		t.Fatalf("expected the forced scan to delete 1 entry, got %d tasks", len(tasks))
+	}
+}
+
+func TestScanMirrorDeleteLimits(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	writeTestFile(t, srcDir, "a.txt", "a")
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		writeTestFile(t, dstDir, name, name)
+	}
+
+	cases := []struct {
+		opts Options
+		want error
+	}{
+		{Options{MaxDelete: 2}, ErrDeleteLimit},
+		{Options{MaxDelete: 3}, nil},
+		{Options{MaxDeletePercent: 50}, ErrDeleteLimit},
+		{Options{MaxDeletePercent: 75}, nil},
+	}
+	for _, tc := range cases {
+		err := Scan(srcDir, dstDir, false, ModeMirror, tc.opts, make(chan task.Task, 8))
+		if !errors.Is(err, tc.want) {
+			t.Fatalf("opts %+v: expected %v, got %v", tc.opts, tc.want, err)
+		}
+	}
+}
+
+func TestScanMirrorSkipsProtectedPaths(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	writeTestFile(t, srcDir, "a.txt", "a")
+	writeTestFile(t, dstDir, "a.txt", "a")
+	writeTestFile(t, dstDir, "notes.keep", "n")
+	writeTestFile(t, dstDir, filepath.Join("logs", "old.log"), "l")
+	writeTestFile(t, dstDir, filepath.Join("mixed", "secret", "x.txt"), "x")
+	writeTestFile(t, dstDir, filepath.Join("mixed", "junk.txt"), "j")
+
+	tasks := make(chan task.Task, 16)
+	opts := Options{Protect: []string{"*.keep", "mixed/secret"}}
+	if err := Scan(srcDir, dstDir, false, ModeMirror, opts, tasks); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	close(tasks)
+
+	var deleted []string
+	for tk := range tasks {
+		if tk.Action != task.ActionDelete {
+			continue
+		}
+		rel, err := filepath.Rel(dstDir, tk.Dst)
+		if err != nil {
+			t.Fatalf("failed to relativise %s: %v", tk.Dst, err)
+		}
+		deleted = append(deleted, filepath.ToSlash(rel))
+	}
+	want := []string{"logs/old.log", "mixed/junk.txt", "logs"}
+	if !reflect.DeepEqual(deleted, want) {
+		t.Fatalf("unexpected deletes: got %v want %v", deleted, want)
+	}
+}
+
+func TestParseDeleteLimit(t *testing.T) {
+	if n, pct, err := ParseDeleteLimit("250"); err != nil || n != 250 || pct != 0 {
+		t.Fatalf("count: got %d, %g, %v", n, pct, err)
+	}
+	if n, pct, err := ParseDeleteLimit("12.5%"); err != nil || n != 0 || pct != 12.5 {
+		t.Fatalf("percent: got %d, %g, %v", n, pct, err)
+	}
+	for _, bad := range []string{"-1", "150%", "lots", "0", "0%", " 0.0 % "} {
+		if _, _, err := ParseDeleteLimit(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
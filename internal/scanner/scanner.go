@@ -3,6 +3,8 @@ package scanner
 import (
 	"archive/tar"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -13,6 +15,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/syncopasoft/syncopa-core/internal/backend"
 	"github.com/syncopasoft/syncopa-core/internal/task"
 )
 
@@ -49,6 +52,66 @@ type Options struct {
 	// parameters based on the observed source files. Manual values above
 	// take precedence when provided.
 	AutoTuneBatching bool
+	// EmbedArchives packs the contents of every batch into a TAR archive
+	// during the scan so the batch can be shipped to a remote agent. When
+	// false batches only list their entries and local workers stream the
+	// files directly.
+	EmbedArchives bool
+	// ArchiveBudget caps the bytes held by embedded archives that have been
+	// emitted but not yet released by a worker. The scan blocks while the
+	// budget is exhausted. A value <= 0 means unlimited.
+	ArchiveBudget int64
+	// ArchiveCompression selects the codec (task.CompressionGzip or
+	// task.CompressionFlate) used to compress embedded archives for
+	// transport. Empty disables compression.
+	ArchiveCompression string
+	// CompressionRatio is the compressed/plain size ratio a sample of the
+	// archive must reach before a batch is compressed. A value <= 0 uses 0.9.
+	CompressionRatio float64
+	// AllowEmptySource lets mirror scans proceed when the source root is
+	// missing or empty, which deletes the whole destination.
+	AllowEmptySource bool
+	// MaxDelete aborts a mirror scan that would delete more destination
+	// entries than this. A value <= 0 means unlimited.
+	MaxDelete int
+	// MaxDeletePercent aborts a mirror scan that would delete more than this
+	// percentage of the destination entries. A value <= 0 means unlimited.
+	MaxDeletePercent float64
+	// Protect lists destination-relative path patterns that mirror scans
+	// never delete; see isProtected for the matching rules.
+	Protect []string
+	// LinkDest is a reference tree laid out like the destination, usually
+	// the previous snapshot. Source files missing from the destination that
+	// are unchanged relative to the reference are hard-linked from it with
+	// task.ActionLink instead of being copied. A missing reference tree
+	// links nothing.
+	LinkDest string
+	// Destination lists the destination when it is not a plain directory
+	// tree, such as a backup repository or an encrypted or compressed
+	// destination. When nil the destination is read from
+	// DestinationBackend.
+	Destination Lister
+	// Precedence decides which source supplies a path that several sources
+	// of a ScanMerge provide.
+	Precedence Precedence
+	// OnCollision, when set, is called for every path that several sources
+	// of a ScanMerge provide, before any task is emitted.
+	OnCollision func(Collision)
+	// OnFingerprint, when set, receives the fingerprint of every source and
+	// destination tree as it is scanned, before any task is emitted.
+	OnFingerprint func(TreeFingerprint)
+	// SourceBackend and DestinationBackend hold the source and destination
+	// trees. Nil selects the local filesystem. Bidirectional scans read and
+	// plan writes on both sides and require the same backend for both.
+	SourceBackend      backend.Backend
+	DestinationBackend backend.Backend
+}
+
+// Lister lists a destination that is not a plain directory tree.
+type Lister interface {
+	// List returns the files and directories below root keyed by their
+	// path relative to root.
+	List(root string) (files, dirs map[string]fs.FileInfo, err error)
 }
 
 // ParseMode converts a string into a Mode value.
@@ -155,6 +218,36 @@ func tuneBatchingOptions(opts Options, files map[string]fileMeta) Options {
 	return opts
 }
 
+// SizeDistribution summarises a set of file sizes.
+type SizeDistribution struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+	Min   int64 `json:"min"`
+	P50   int64 `json:"p50"`
+	P90   int64 `json:"p90"`
+	P99   int64 `json:"p99"`
+	Max   int64 `json:"max"`
+}
+
+// NewSizeDistribution computes the distribution of sizes with the same
+// percentiles automatic batching uses. sizes is sorted in place.
+func NewSizeDistribution(sizes []int64) SizeDistribution {
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	d := SizeDistribution{Files: len(sizes)}
+	if len(sizes) == 0 {
+		return d
+	}
+	for _, size := range sizes {
+		d.Bytes += size
+	}
+	d.Min = percentileInt64(sizes, 0)
+	d.P50 = percentileInt64(sizes, 0.5)
+	d.P90 = percentileInt64(sizes, 0.9)
+	d.P99 = percentileInt64(sizes, 0.99)
+	d.Max = percentileInt64(sizes, 1)
+	return d
+}
+
 func percentileInt64(data []int64, pct float64) int64 {
 	if len(data) == 0 {
 		return 0
@@ -178,90 +271,150 @@ func percentileInt64(data []int64, pct float64) int64 {
 // Scan walks the source and destination directories and emits tasks based on
 // the selected mode.
 func Scan(src, dst string, includeDir bool, mode Mode, opts Options, tasks chan<- task.Task) error {
-	if src == "" || dst == "" {
+	return ScanFanout(src, []string{dst}, includeDir, mode, opts, tasks)
+}
+
+// ScanFanout is Scan for several destinations. The source is walked once and
+// compared with every destination. A file that more than one destination
+// needs is planned as a single task whose Fanout lists the destinations
+// after the first, so it is read once however many copies are written.
+// Mirror deletions are planned per destination, and every destination must
+// pass the deletion safeguards before anything is emitted. Bidirectional
+// scans, LinkDest and Destination need a single destination.
+func ScanFanout(src string, dsts []string, includeDir bool, mode Mode, opts Options, tasks chan<- task.Task) error {
+	if src == "" {
 		return errors.New("src and dst required")
 	}
-
 	cleanSrc := filepath.Clean(src)
-	cleanDst := filepath.Clean(dst)
-
 	base := ""
-	dstRoot := cleanDst
 	if includeDir {
 		base = filepath.Base(cleanSrc)
 		if base == string(os.PathSeparator) || base == "." {
-			includeDir = false
-		} else {
-			dstRoot = filepath.Join(cleanDst, base)
+			base = ""
 		}
 	}
+	return scanSources([]Source{{Root: cleanSrc, Prefix: base}}, base, dsts, mode, opts, tasks)
+}
 
-	srcSnap, err := snapshot(cleanSrc)
-	if err != nil {
-		return err
+// scanSources plans the work for sources against every destination. Only
+// the part of each destination below scope is compared, which confines a
+// source copied with its directory name to that directory.
+func scanSources(sources []Source, scope string, dsts []string, mode Mode, opts Options, tasks chan<- task.Task) error {
+	if len(sources) == 0 || len(dsts) == 0 {
+		return errors.New("src and dst required")
 	}
-	dstSnap, err := snapshot(dstRoot)
-	if err != nil {
-		return err
+	for _, dst := range dsts {
+		if dst == "" {
+			return errors.New("src and dst required")
+		}
+	}
+	if len(dsts) > 1 {
+		switch {
+		case mode == ModeSync:
+			return errors.New("bidirectional sync needs a single destination")
+		case opts.LinkDest != "" || opts.Destination != nil:
+			return errors.New("link-dest and non-directory destinations need a single destination")
+		}
+	}
+	if len(sources) > 1 && mode == ModeSync {
+		return errors.New("bidirectional sync needs a single source")
 	}
 
-	srcFiles := make(map[string]fileMeta, len(srcSnap.Files))
-	srcDirs := make(map[string]fileMeta, len(srcSnap.Dirs))
-	srcFileKeys := make([]string, 0, len(srcSnap.Files))
-	for rel, meta := range srcSnap.Files {
-		key := withPrefix(base, rel, includeDir)
-		srcFiles[key] = meta
-		srcFileKeys = append(srcFileKeys, key)
+	srcBackend := backend.OrLocal(opts.SourceBackend)
+	dstBackend := backend.OrLocal(opts.DestinationBackend)
+	if mode == ModeSync && srcBackend != dstBackend {
+		return errors.New("bidirectional sync requires source and destination on the same backend")
 	}
-	sort.Strings(srcFileKeys)
-	for rel, meta := range srcSnap.Dirs {
-		key := withPrefix(base, rel, includeDir)
-		srcDirs[key] = meta
+	merged, err := mergeSources(srcBackend, sources, opts)
+	if err != nil {
+		return err
 	}
+	srcFiles, srcDirs, srcFileKeys := merged.files, merged.dirs, merged.keys
 
-	dstFiles := make(map[string]fileMeta, len(dstSnap.Files))
-	dstDirs := make(map[string]fileMeta, len(dstSnap.Dirs))
-	dstFileKeys := make([]string, 0, len(dstSnap.Files))
-	for rel, meta := range dstSnap.Files {
-		key := withPrefix(base, rel, includeDir)
-		dstFiles[key] = meta
-		dstFileKeys = append(dstFileKeys, key)
+	refFiles := map[string]fileMeta{}
+	if opts.LinkDest != "" {
+		refRoot := filepath.Join(filepath.Clean(opts.LinkDest), scope)
+		refSnap, err := snapshot(dstBackend, refRoot)
+		if err != nil {
+			return err
+		}
+		for rel, meta := range refSnap.Files {
+			refFiles[withPrefix(scope, rel, true)] = meta
+		}
 	}
-	sort.Strings(dstFileKeys)
-	for rel, meta := range dstSnap.Dirs {
-		key := withPrefix(base, rel, includeDir)
-		dstDirs[key] = meta
+
+	targets := make([]*scanTarget, len(dsts))
+	for i, dst := range dsts {
+		target, err := newScanTarget(dst, scope, dstBackend, opts)
+		if err != nil {
+			return err
+		}
+		// Plan and check deletions before emitting anything so a refused
+		// mirror run leaves every destination untouched.
+		if mode == ModeMirror {
+			for i, source := range sources {
+				if err := checkMirrorSource(source.Root, merged.snaps[i], target.snap, opts); err != nil {
+					return err
+				}
+			}
+			target.deletes = planMirrorDeletes(target.root, target.files, target.dirs, srcFiles, srcDirs, opts.Protect)
+			if err := checkDeleteLimits(len(target.deletes), len(target.files)+len(target.dirs), opts); err != nil {
+				if len(dsts) > 1 {
+					return fmt.Errorf("%s: %w", target.root, err)
+				}
+				return err
+			}
+		}
+		targets[i] = target
 	}
 
 	tunedOpts := tuneBatchingOptions(opts, srcFiles)
-	batcher := newCopyBatcher(tunedOpts)
+	batchers := newBatcherSet(tunedOpts)
 
 	for _, key := range srcFileKeys {
 		srcMeta := srcFiles[key]
-		dstPath := filepath.Join(cleanDst, key)
-		dstMeta, exists := dstFiles[key]
-		if !exists {
-			if err := batcher.Add(srcMeta.Path, dstPath, srcMeta.Info, tasks); err != nil {
-				return err
+		var paths []string
+		var explanations []task.Explanation
+		var set strings.Builder
+		for i, target := range targets {
+			dstPath := filepath.Join(target.root, key)
+			var dstInfo fs.FileInfo
+			if dstMeta, exists := target.files[key]; exists {
+				dstInfo = dstMeta.Info
+			} else if ref, ok := refFiles[key]; ok && ref.Info.Mode().IsRegular() && !shouldCopy(srcMeta.Info, ref.Info) {
+				tasks <- task.Task{Action: task.ActionLink, Src: ref.Path, Dst: dstPath, Size: ref.Info.Size(), ModTime: ref.Info.ModTime(), Explanations: []task.Explanation{{Reason: task.ReasonNew, Src: task.NewMetadata(srcMeta.Info)}}}
+				continue
 			}
+			reason := copyReason(srcMeta.Info, dstInfo)
+			if reason == "" {
+				continue
+			}
+			paths = append(paths, dstPath)
+			explanations = append(explanations, task.Explanation{Reason: reason, Src: task.NewMetadata(srcMeta.Info), Dst: task.NewMetadata(dstInfo)})
+			fmt.Fprintf(&set, "%d,", i)
+		}
+		if len(paths) == 0 {
 			continue
 		}
-		if shouldCopy(srcMeta.Info, dstMeta.Info) {
-			if err := batcher.Add(srcMeta.Path, dstPath, srcMeta.Info, tasks); err != nil {
-				return err
-			}
+		if err := batchers.get(set.String()).Add(srcMeta.Path, paths, explanations, srcMeta.Info, tasks); err != nil {
+			return err
 		}
 	}
 
-	if err := batcher.Flush(tasks); err != nil {
+	if err := batchers.Flush(tasks); err != nil {
 		return err
 	}
 
 	switch mode {
 	case ModeMirror:
-		enqueueMirrorDeletes(cleanDst, dstFiles, dstDirs, srcFiles, srcDirs, tasks)
+		for _, target := range targets {
+			for _, meta := range target.deletes {
+				tasks <- task.Task{Action: task.ActionDelete, Dst: meta.Path, Explanations: []task.Explanation{{Reason: task.ReasonExtra, Dst: task.NewMetadata(meta.Info)}}}
+			}
+		}
 	case ModeSync:
-		if err := enqueueSyncTasks(cleanSrc, cleanDst, base, includeDir, srcFiles, dstFiles, srcFileKeys, dstFileKeys, batcher, tasks); err != nil {
+		target := targets[0]
+		if err := enqueueSyncTasks(sources[0].Root, target.root, scope, scope != "", srcFiles, target.files, srcFileKeys, target.keys, batchers.get(""), tasks); err != nil {
 			return err
 		}
 	}
@@ -269,27 +422,49 @@ func Scan(src, dst string, includeDir bool, mode Mode, opts Options, tasks chan<
 	return nil
 }
 
-func enqueueMirrorDeletes(cleanDst string, dstFiles, dstDirs, srcFiles, srcDirs map[string]fileMeta, tasks chan<- task.Task) {
-	for key, dstMeta := range dstFiles {
-		if _, ok := srcFiles[key]; ok {
-			continue
-		}
-		tasks <- task.Task{Action: task.ActionDelete, Dst: dstMeta.Path}
-	}
+// scanTarget is the snapshot of one destination, keyed like the source.
+type scanTarget struct {
+	root    string
+	snap    *snapshotResult
+	files   map[string]fileMeta
+	dirs    map[string]fileMeta
+	keys    []string
+	deletes []fileMeta
+}
 
-	missingDirs := make([]string, 0, len(dstDirs))
-	for key := range dstDirs {
-		if _, ok := srcDirs[key]; ok {
-			continue
-		}
-		missingDirs = append(missingDirs, key)
+func newScanTarget(dst, scope string, b backend.Backend, opts Options) (*scanTarget, error) {
+	cleanDst := filepath.Clean(dst)
+	dstRoot := filepath.Join(cleanDst, scope)
+	var snap *snapshotResult
+	var err error
+	if opts.Destination != nil {
+		snap, err = listSnapshot(opts.Destination, dstRoot)
+	} else {
+		snap, err = snapshot(b, dstRoot)
 	}
-	sort.Slice(missingDirs, func(i, j int) bool {
-		return len(missingDirs[i]) > len(missingDirs[j])
-	})
-	for _, rel := range missingDirs {
-		tasks <- task.Task{Action: task.ActionDelete, Dst: filepath.Join(cleanDst, rel)}
+	if err != nil {
+		return nil, err
+	}
+	if opts.OnFingerprint != nil {
+		opts.OnFingerprint(fingerprintSnapshot(dstRoot, snap))
 	}
+	t := &scanTarget{
+		root:  cleanDst,
+		snap:  snap,
+		files: make(map[string]fileMeta, len(snap.Files)),
+		dirs:  make(map[string]fileMeta, len(snap.Dirs)),
+		keys:  make([]string, 0, len(snap.Files)),
+	}
+	for rel, meta := range snap.Files {
+		key := withPrefix(scope, rel, true)
+		t.files[key] = meta
+		t.keys = append(t.keys, key)
+	}
+	sort.Strings(t.keys)
+	for rel, meta := range snap.Dirs {
+		t.dirs[withPrefix(scope, rel, true)] = meta
+	}
+	return t, nil
 }
 
 func enqueueSyncTasks(cleanSrc, cleanDst, base string, includeDir bool, srcFiles, dstFiles map[string]fileMeta, srcKeys, dstKeys []string, batcher *copyBatcher, tasks chan<- task.Task) error {
@@ -302,7 +477,7 @@ func enqueueSyncTasks(cleanSrc, cleanDst, base string, includeDir bool, srcFiles
 		if !ok {
 			continue
 		}
-		if err := batcher.Add(dstMeta.Path, srcPath, dstMeta.Info, tasks); err != nil {
+		if err := batcher.Add(dstMeta.Path, []string{srcPath}, []task.Explanation{{Reason: task.ReasonNew, Src: task.NewMetadata(dstMeta.Info)}}, dstMeta.Info, tasks); err != nil {
 			return err
 		}
 	}
@@ -318,7 +493,7 @@ func enqueueSyncTasks(cleanSrc, cleanDst, base string, includeDir bool, srcFiles
 			if !ok {
 				continue
 			}
-			if err := batcher.Add(dstMeta.Path, srcPath, dstMeta.Info, tasks); err != nil {
+			if err := batcher.Add(dstMeta.Path, []string{srcPath}, []task.Explanation{{Reason: task.ReasonTime, Src: task.NewMetadata(dstMeta.Info), Dst: task.NewMetadata(srcMeta.Info)}}, dstMeta.Info, tasks); err != nil {
 				return err
 			}
 		}
@@ -329,15 +504,53 @@ func enqueueSyncTasks(cleanSrc, cleanDst, base string, includeDir bool, srcFiles
 
 type copyBatcher struct {
 	opts       Options
+	source     backend.Backend
 	buf        bytes.Buffer
 	tw         *tar.Writer
 	entries    []task.CopyBatchEntry
 	totalBytes int64
 	copyBuf    []byte
+	budget     *task.ArchiveBudget
+}
+
+// batcherSet keeps a copyBatcher per set of destinations, keyed by the
+// indices of the destinations, and flushes them in the order they were
+// first used.
+type batcherSet struct {
+	opts     Options
+	batchers map[string]*copyBatcher
+	order    []string
+}
+
+func newBatcherSet(opts Options) *batcherSet {
+	return &batcherSet{opts: opts, batchers: make(map[string]*copyBatcher)}
+}
+
+func (s *batcherSet) get(key string) *copyBatcher {
+	b, ok := s.batchers[key]
+	if !ok {
+		b = newCopyBatcher(s.opts)
+		s.batchers[key] = b
+		s.order = append(s.order, key)
+	}
+	return b
+}
+
+func (s *batcherSet) Flush(tasks chan<- task.Task) error {
+	for _, key := range s.order {
+		if err := s.batchers[key].Flush(tasks); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func newCopyBatcher(opts Options) *copyBatcher {
-	b := &copyBatcher{opts: opts}
+	b := &copyBatcher{opts: opts, source: backend.OrLocal(opts.SourceBackend)}
+	if !opts.EmbedArchives {
+		return b
+	}
+	b.budget = task.NewArchiveBudget(opts.ArchiveBudget)
 	if opts.BatchMaxBytes > 0 {
 		// Reserve enough capacity for the expected payload plus some headroom
 		// for tar headers while keeping memory usage bounded.
@@ -393,16 +606,25 @@ func (b *copyBatcher) reachedLimits() bool {
 	return false
 }
 
-func (b *copyBatcher) Add(src, dst string, info fs.FileInfo, tasks chan<- task.Task) error {
+// Add plans a copy of src to every path in dsts, for the matching
+// explanations.
+// Callers keep one batcher per set of destinations so that all entries of a
+// batch fan out alike.
+func (b *copyBatcher) Add(src string, dsts []string, explanations []task.Explanation, info fs.FileInfo, tasks chan<- task.Task) error {
+	dst := dsts[0]
+	var fanout []string
+	if len(dsts) > 1 {
+		fanout = dsts[1:]
+	}
 	if !b.enabled() {
-		tasks <- task.Task{Action: task.ActionCopy, Src: src, Dst: dst}
+		tasks <- copyTask(src, dst, fanout, explanations, info)
 		return nil
 	}
 	if info == nil || info.Size() > b.opts.BatchThreshold {
 		if err := b.Flush(tasks); err != nil {
 			return err
 		}
-		tasks <- task.Task{Action: task.ActionCopy, Src: src, Dst: dst}
+		tasks <- copyTask(src, dst, fanout, explanations, info)
 		return nil
 	}
 	if !b.canAdd(info.Size()) {
@@ -410,38 +632,19 @@ func (b *copyBatcher) Add(src, dst string, info fs.FileInfo, tasks chan<- task.T
 			return err
 		}
 	}
-	if b.tw == nil {
-		b.tw = tar.NewWriter(&b.buf)
-	}
-	f, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	header, err := tar.FileInfoHeader(info, "")
-	if err != nil {
-		b.reset()
-		return err
-	}
-	header.Name = fmt.Sprintf("file-%d", len(b.entries))
-	if err := b.tw.WriteHeader(header); err != nil {
-		b.reset()
-		return err
-	}
-	if b.copyBuf != nil {
-		if _, err := io.CopyBuffer(b.tw, f, b.copyBuf); err != nil {
-			b.reset()
-			return err
+	if b.opts.EmbedArchives {
+		if b.tw == nil {
+			b.tw = tar.NewWriter(&b.buf)
 		}
-	} else {
-		if _, err := io.Copy(b.tw, f); err != nil {
+		hash, err := writeArchiveEntry(b.source, b.tw, len(b.entries), src, info, b.copyBuf)
+		if err != nil {
 			b.reset()
 			return err
 		}
+		b.entries = append(b.entries, task.CopyBatchEntry{Source: src, Destination: dst, Fanout: fanout, Size: info.Size(), ModTime: info.ModTime(), Explanations: explanations, Hash: hash})
+	} else {
+		b.entries = append(b.entries, task.CopyBatchEntry{Source: src, Destination: dst, Fanout: fanout, Size: info.Size(), ModTime: info.ModTime(), Explanations: explanations})
 	}
-
-	b.entries = append(b.entries, task.CopyBatchEntry{Source: src, Destination: dst, Size: info.Size()})
 	b.totalBytes += info.Size()
 
 	if b.reachedLimits() {
@@ -450,6 +653,15 @@ func (b *copyBatcher) Add(src, dst string, info fs.FileInfo, tasks chan<- task.T
 	return nil
 }
 
+// copyTask plans a single copy of src described by info.
+func copyTask(src, dst string, fanout []string, explanations []task.Explanation, info fs.FileInfo) task.Task {
+	t := task.Task{Action: task.ActionCopy, Src: src, Dst: dst, Fanout: fanout, Explanations: explanations}
+	if info != nil {
+		t.Size, t.ModTime = info.Size(), info.ModTime()
+	}
+	return t
+}
+
 func (b *copyBatcher) Flush(tasks chan<- task.Task) error {
 	if !b.enabled() {
 		return nil
@@ -458,15 +670,23 @@ func (b *copyBatcher) Flush(tasks chan<- task.Task) error {
 		b.reset()
 		return nil
 	}
-	if b.tw != nil {
-		if err := b.tw.Close(); err != nil {
+	entries := make([]task.CopyBatchEntry, len(b.entries))
+	copy(entries, b.entries)
+	payload := &task.CopyBatchPayload{Entries: entries}
+	if b.opts.EmbedArchives {
+		if b.tw != nil {
+			if err := b.tw.Close(); err != nil {
+				return err
+			}
+		}
+		payload.Archive = make([]byte, b.buf.Len())
+		copy(payload.Archive, b.buf.Bytes())
+		if err := compressPayload(payload, b.opts); err != nil {
 			return err
 		}
+		payload.ArchiveHash = hashBytes(payload.Archive)
+		b.budget.Charge(payload)
 	}
-	archive := make([]byte, b.buf.Len())
-	copy(archive, b.buf.Bytes())
-	entries := make([]task.CopyBatchEntry, len(b.entries))
-	copy(entries, b.entries)
 
 	src := ""
 	dst := ""
@@ -475,11 +695,85 @@ func (b *copyBatcher) Flush(tasks chan<- task.Task) error {
 		dst = entries[0].Destination
 	}
 
-	tasks <- task.Task{Action: task.ActionCopyBatch, Src: src, Dst: dst, Batch: &task.CopyBatchPayload{Entries: entries, Archive: archive}}
+	tasks <- task.Task{Action: task.ActionCopyBatch, Src: src, Dst: dst, Batch: payload}
 	b.reset()
 	return nil
 }
 
+// PackBatch fills in the TAR archive of a lazy batch by reading every entry
+// from its source, compressing it according to opts.ArchiveCompression.
+// Orchestrators call it before sending a batch planned for local execution to
+// a remote agent.
+func PackBatch(payload *task.CopyBatchPayload, opts Options) error {
+	if payload == nil {
+		return errors.New("batch payload is nil")
+	}
+	if !payload.Lazy() {
+		return nil
+	}
+	source := backend.OrLocal(opts.SourceBackend)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i, entry := range payload.Entries {
+		info, err := source.Stat(entry.Source)
+		if err != nil {
+			return err
+		}
+		if info.Size() != entry.Size {
+			return fmt.Errorf("%s changed size since it was scanned: %d != %d", entry.Source, info.Size(), entry.Size)
+		}
+		hash, err := writeArchiveEntry(source, tw, i, entry.Source, info, nil)
+		if err != nil {
+			return err
+		}
+		payload.Entries[i].Hash = hash
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	payload.Archive = buf.Bytes()
+	if err := compressPayload(payload, opts); err != nil {
+		return err
+	}
+	payload.ArchiveHash = hashBytes(payload.Archive)
+	return nil
+}
+
+// writeArchiveEntry appends src to the archive and returns the hex SHA-256 of
+// the contents that were written.
+func writeArchiveEntry(b backend.Backend, tw *tar.Writer, index int, src string, info fs.FileInfo, copyBuf []byte) (string, error) {
+	f, err := b.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return "", err
+	}
+	header.Name = fmt.Sprintf("file-%d", index)
+	if err := tw.WriteHeader(header); err != nil {
+		return "", err
+	}
+	hasher := sha256.New()
+	w := io.MultiWriter(tw, hasher)
+	if copyBuf != nil {
+		_, err = io.CopyBuffer(w, f, copyBuf)
+	} else {
+		_, err = io.Copy(w, f)
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
 func (b *copyBatcher) reset() {
 	if b == nil {
 		return
@@ -490,14 +784,40 @@ func (b *copyBatcher) reset() {
 	b.tw = nil
 }
 
+// NeedsCopy reports whether a scan plans a copy of the source file src over
+// the destination file dst. A nil dst stands for a missing destination.
+func NeedsCopy(src, dst fs.FileInfo) bool {
+	return shouldCopy(src, dst)
+}
+
 func shouldCopy(srcInfo, dstInfo fs.FileInfo) bool {
+	return copyReason(srcInfo, dstInfo) != ""
+}
+
+// copyReason returns why a scan copies srcInfo over dstInfo, or an empty
+// Reason when the destination is up to date.
+func copyReason(srcInfo, dstInfo fs.FileInfo) task.Reason {
 	if dstInfo == nil {
-		return true
+		return task.ReasonNew
 	}
 	if srcInfo.Size() != dstInfo.Size() {
-		return true
+		return task.ReasonSize
+	}
+	// Objects copied between object stores keep their ETag, which is more
+	// reliable than upload times. Multipart ETags depend on the part size
+	// and are only compared when they are equal.
+	if srcTag, dstTag := backend.ETag(srcInfo), backend.ETag(dstInfo); srcTag != "" && dstTag != "" {
+		if srcTag == dstTag {
+			return ""
+		}
+		if !strings.Contains(srcTag, "-") && !strings.Contains(dstTag, "-") {
+			return task.ReasonETag
+		}
 	}
-	return srcInfo.ModTime().After(dstInfo.ModTime())
+	if srcInfo.ModTime().After(dstInfo.ModTime()) {
+		return task.ReasonTime
+	}
+	return ""
 }
 
 type fileMeta struct {
@@ -505,15 +825,16 @@ type fileMeta struct {
 	Info fs.FileInfo
 }
 
-func snapshot(root string) (*snapshotResult, error) {
+func snapshot(b backend.Backend, root string) (*snapshotResult, error) {
 	res := &snapshotResult{
 		Files: make(map[string]fileMeta),
 		Dirs:  make(map[string]fileMeta),
 	}
 
-	info, err := os.Stat(root)
+	info, err := b.Stat(root)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, fs.ErrNotExist) {
+			res.Missing = true
 			return res, nil
 		}
 		return nil, err
@@ -522,7 +843,7 @@ func snapshot(root string) (*snapshotResult, error) {
 		return nil, fmt.Errorf("%s is not a directory", root)
 	}
 
-	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
+	err = backend.Walk(b, root, func(path string, d fs.DirEntry, walkErr error) error {
 		if walkErr != nil {
 			return walkErr
 		}
@@ -547,9 +868,30 @@ func snapshot(root string) (*snapshotResult, error) {
 	return res, err
 }
 
+// listSnapshot builds a snapshot of root from a Lister.
+func listSnapshot(l Lister, root string) (*snapshotResult, error) {
+	files, dirs, err := l.List(root)
+	if err != nil {
+		return nil, err
+	}
+	res := &snapshotResult{
+		Files: make(map[string]fileMeta, len(files)),
+		Dirs:  make(map[string]fileMeta, len(dirs)),
+	}
+	for rel, info := range files {
+		res.Files[rel] = fileMeta{Path: filepath.Join(root, rel), Info: info}
+	}
+	for rel, info := range dirs {
+		res.Dirs[rel] = fileMeta{Path: filepath.Join(root, rel), Info: info}
+	}
+	return res, nil
+}
+
 type snapshotResult struct {
 	Files map[string]fileMeta
 	Dirs  map[string]fileMeta
+	// Missing is set when the root does not exist.
+	Missing bool
 }
 
 func withPrefix(prefix, rel string, include bool) string {
@@ -1,6 +1,8 @@
 package scanner
 
 import (
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"io/fs"
 	"path/filepath"
@@ -12,6 +14,7 @@ import (
 
 	"os"
 
+	"github.com/syncopasoft/syncopa-core/internal/backend"
 	"github.com/syncopasoft/syncopa-core/internal/task"
 )
 
@@ -91,8 +94,8 @@ func TestScanEmitsCopyBatch(t *testing.T) {
 	if len(batchTask.Batch.Entries) != len(files) {
 		t.Fatalf("unexpected entry count: got %d want %d", len(batchTask.Batch.Entries), len(files))
 	}
-	if len(batchTask.Batch.Archive) == 0 {
-		t.Fatal("batch archive is empty")
+	if !batchTask.Batch.Lazy() {
+		t.Fatal("local batches should not embed an archive")
 	}
 
 	seen := make(map[string]task.CopyBatchEntry)
@@ -112,6 +115,96 @@ func TestScanEmitsCopyBatch(t *testing.T) {
 	}
 }
 
+func TestScanEmbedsArchivesWithinBudget(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	files := map[string]string{
+		"a.txt": "alpha",
+		"b.txt": "bravo",
+		"c.txt": "charlie",
+	}
+	for rel, contents := range files {
+		writeTestFile(t, srcDir, rel, contents)
+	}
+
+	// One file per batch and a budget smaller than a single archive forces
+	// the scanner to wait for each batch to be released.
+	opts := Options{BatchThreshold: 1024, BatchMaxFiles: 1, EmbedArchives: true, ArchiveBudget: 1}
+	tasksCh := make(chan task.Task)
+	scanErr := make(chan error, 1)
+	go func() {
+		defer close(tasksCh)
+		scanErr <- Scan(srcDir, dstDir, false, ModeUpdate, opts, tasksCh)
+	}()
+
+	count := 0
+	for tk := range tasksCh {
+		if tk.Action != task.ActionCopyBatch || tk.Batch == nil {
+			t.Fatalf("unexpected task: %+v", tk)
+		}
+		if tk.Batch.Lazy() {
+			t.Fatal("expected an embedded archive")
+		}
+		tk.Batch.Release()
+		count++
+	}
+	if err := <-scanErr; err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	if count != len(files) {
+		t.Fatalf("expected %d batches, got %d", len(files), count)
+	}
+}
+
+func TestPackBatch(t *testing.T) {
+	srcDir := t.TempDir()
+	path := writeTestFile(t, srcDir, "a.txt", "alpha")
+
+	payload := &task.CopyBatchPayload{Entries: []task.CopyBatchEntry{{Source: path, Destination: "/dst/a.txt", Size: 5}}}
+	if err := PackBatch(payload, Options{}); err != nil {
+		t.Fatalf("PackBatch failed: %v", err)
+	}
+	if payload.Lazy() {
+		t.Fatal("expected PackBatch to fill in the archive")
+	}
+
+	payload = &task.CopyBatchPayload{Entries: []task.CopyBatchEntry{{Source: path, Destination: "/dst/a.txt", Size: 3}}}
+	if err := PackBatch(payload, Options{}); err == nil {
+		t.Fatal("expected an error when the source changed size")
+	}
+}
+
+func TestPackBatchCompressesCompressibleArchives(t *testing.T) {
+	srcDir := t.TempDir()
+	text := strings.Repeat("2024-05-20 INFO request served\n", 200)
+	logPath := writeTestFile(t, srcDir, "app.log", text)
+
+	payload := &task.CopyBatchPayload{Entries: []task.CopyBatchEntry{{Source: logPath, Destination: "/dst/app.log", Size: int64(len(text))}}}
+	if err := PackBatch(payload, Options{ArchiveCompression: task.CompressionGzip}); err != nil {
+		t.Fatalf("PackBatch failed: %v", err)
+	}
+	if payload.Compression != task.CompressionGzip {
+		t.Fatalf("expected gzip compression, got %q", payload.Compression)
+	}
+	if len(payload.Archive) >= len(text) {
+		t.Fatalf("compressed archive is not smaller: %d bytes", len(payload.Archive))
+	}
+
+	noise := make([]byte, 32*1024)
+	if _, err := rand.Read(noise); err != nil {
+		t.Fatalf("failed to generate random data: %v", err)
+	}
+	noisePath := writeTestFile(t, srcDir, "noise.bin", string(noise))
+	payload = &task.CopyBatchPayload{Entries: []task.CopyBatchEntry{{Source: noisePath, Destination: "/dst/noise.bin", Size: int64(len(noise))}}}
+	if err := PackBatch(payload, Options{ArchiveCompression: task.CompressionFlate}); err != nil {
+		t.Fatalf("PackBatch failed: %v", err)
+	}
+	if payload.Compression != task.CompressionNone {
+		t.Fatalf("expected incompressible batch to stay plain, got %q", payload.Compression)
+	}
+}
+
 func TestScanDeterministicOrderSync(t *testing.T) {
 	srcDir := t.TempDir()
 	dstDir := t.TempDir()
@@ -171,6 +264,82 @@ func TestScanDeterministicOrderSync(t *testing.T) {
 	}
 }
 
+func TestScanLinksUnchangedFilesFromReference(t *testing.T) {
+	srcDir := t.TempDir()
+	refDir := t.TempDir()
+	dstDir := filepath.Join(t.TempDir(), "snapshot")
+
+	writeTestFile(t, srcDir, "same.txt", "same")
+	writeTestFile(t, srcDir, "changed.txt", "new contents")
+	writeTestFile(t, srcDir, "added.txt", "added")
+	sameRef := writeTestFile(t, refDir, "same.txt", "same")
+	writeTestFile(t, refDir, "changed.txt", "old")
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(sameRef, future, future); err != nil {
+		t.Fatalf("failed to adjust reference time: %v", err)
+	}
+
+	tasks := make(chan task.Task, 8)
+	if err := Scan(srcDir, dstDir, false, ModeMirror, Options{LinkDest: refDir}, tasks); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	close(tasks)
+
+	got := map[string]string{}
+	for tk := range tasks {
+		got[filepath.Base(tk.Dst)] = tk.Action.String() + ":" + tk.Src
+	}
+	want := map[string]string{
+		"added.txt":   "copy:" + filepath.Join(srcDir, "added.txt"),
+		"changed.txt": "copy:" + filepath.Join(srcDir, "changed.txt"),
+		"same.txt":    "link:" + sameRef,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected tasks: got %v want %v", got, want)
+	}
+}
+
+func TestScanUsesDestinationLister(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := filepath.Join(t.TempDir(), "repo")
+	writeTestFile(t, srcDir, "same.txt", "same")
+	writeTestFile(t, srcDir, "new.txt", "new")
+
+	lister := stubLister{
+		files: map[string]fs.FileInfo{
+			"same.txt":                       stubFileInfo{name: "same.txt", size: 4, modTime: time.Now().Add(time.Hour)},
+			filepath.Join("old", "gone.txt"): stubFileInfo{name: "gone.txt", size: 1},
+		},
+		dirs: map[string]fs.FileInfo{"old": stubFileInfo{name: "old", mode: fs.ModeDir}},
+	}
+	tasks := make(chan task.Task, 8)
+	if err := Scan(srcDir, dstDir, false, ModeMirror, Options{Destination: lister}, tasks); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	close(tasks)
+
+	var got []string
+	for tk := range tasks {
+		rel, err := filepath.Rel(dstDir, tk.Dst)
+		if err != nil {
+			t.Fatalf("failed to relativise %s: %v", tk.Dst, err)
+		}
+		got = append(got, tk.Action.String()+":"+filepath.ToSlash(rel))
+	}
+	want := []string{"copy:new.txt", "delete:old/gone.txt", "delete:old"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected tasks: got %v want %v", got, want)
+	}
+}
+
+type stubLister struct {
+	files, dirs map[string]fs.FileInfo
+}
+
+func (l stubLister) List(string) (map[string]fs.FileInfo, map[string]fs.FileInfo, error) {
+	return l.files, l.dirs, nil
+}
+
 func TestTuneBatchingOptionsAutoBatch(t *testing.T) {
 	files := make(map[string]fileMeta)
 	now := time.Now()
@@ -272,3 +441,338 @@ func writeTestFile(t *testing.T, root, rel, contents string) string {
 	}
 	return fullPath
 }
+
+func TestScanReadsBackends(t *testing.T) {
+	src := backend.NewMemory()
+	dst := backend.NewMemory()
+	old := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := old.Add(time.Hour)
+	seed := func(b *backend.Memory, path, data string, mtime time.Time) {
+		t.Helper()
+		if err := b.WriteFile(path, []byte(data), 0o644, mtime); err != nil {
+			t.Fatalf("failed to seed %s: %v", path, err)
+		}
+	}
+	srcRoot := filepath.Join(string(filepath.Separator), "src")
+	dstRoot := filepath.Join(string(filepath.Separator), "dst")
+	seed(src, filepath.Join(srcRoot, "same.txt"), "same", old)
+	seed(src, filepath.Join(srcRoot, "changed.txt"), "changed", newer)
+	seed(src, filepath.Join(srcRoot, "dir", "new.txt"), "new", old)
+	seed(dst, filepath.Join(dstRoot, "same.txt"), "same", old)
+	seed(dst, filepath.Join(dstRoot, "changed.txt"), "stale", old)
+	seed(dst, filepath.Join(dstRoot, "extra.txt"), "extra", old)
+
+	opts := Options{
+		SourceBackend:      src,
+		DestinationBackend: dst,
+		BatchThreshold:     1024,
+		EmbedArchives:      true,
+	}
+	tasks := make(chan task.Task, 8)
+	if err := Scan(srcRoot, dstRoot, false, ModeMirror, opts, tasks); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	close(tasks)
+
+	var got []string
+	for tk := range tasks {
+		switch tk.Action {
+		case task.ActionCopyBatch:
+			if tk.Batch.Lazy() {
+				t.Fatalf("expected an embedded archive read from the source backend")
+			}
+			for _, entry := range tk.Batch.Entries {
+				rel, _ := filepath.Rel(dstRoot, entry.Destination)
+				got = append(got, "batch:"+filepath.ToSlash(rel))
+			}
+		default:
+			rel, _ := filepath.Rel(dstRoot, tk.Dst)
+			got = append(got, tk.Action.String()+":"+filepath.ToSlash(rel))
+		}
+	}
+	want := []string{"batch:changed.txt", "batch:dir/new.txt", "delete:extra.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected tasks: got %v want %v", got, want)
+	}
+
+	err := Scan(srcRoot, dstRoot, false, ModeSync, opts, make(chan task.Task, 8))
+	if err == nil || !strings.Contains(err.Error(), "same backend") {
+		t.Fatalf("expected bidirectional scans across backends to be refused, got %v", err)
+	}
+}
+
+type objectInfo struct {
+	stubFileInfo
+	etag string
+}
+
+func (o objectInfo) ETag() string { return o.etag }
+
+func TestShouldCopyComparesETags(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	object := func(etag string, modTime time.Time) fs.FileInfo {
+		return objectInfo{stubFileInfo{name: "a", size: 4, mode: 0o644, modTime: modTime}, etag}
+	}
+	for _, tc := range []struct {
+		name     string
+		src, dst fs.FileInfo
+		want     bool
+	}{
+		{"equal etags ignore a newer source", object(`"abc"`, newer), object(`"abc"`, older), false},
+		{"different etags copy an older source", object(`"abc"`, older), object(`"def"`, newer), true},
+		{"multipart etags fall back to times", object(`"abc-2"`, older), object(`"def"`, newer), false},
+		{"plain files fall back to times", stubFileInfo{name: "a", size: 4, modTime: newer}, object(`"abc"`, older), true},
+	} {
+		if got := shouldCopy(tc.src, tc.dst); got != tc.want {
+			t.Fatalf("%s: shouldCopy = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestScanUpdatesFromArchive(t *testing.T) {
+	old := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := old.Add(time.Hour)
+	root := string(filepath.Separator)
+	path := filepath.Join(t.TempDir(), "tree.tar.gz")
+	archive, err := backend.OpenArchive(path)
+	if err != nil {
+		t.Fatalf("OpenArchive returned error: %v", err)
+	}
+	if err := archive.MkdirAll(filepath.Join(root, "dir"), 0o755); err != nil {
+		t.Fatalf("MkdirAll returned error: %v", err)
+	}
+	for rel, mtime := range map[string]time.Time{"same.txt": old, "changed.txt": newer, "local-newer.txt": old, filepath.Join("dir", "new.txt"): old} {
+		w, err := archive.Create(filepath.Join(root, rel))
+		if err != nil {
+			t.Fatalf("Create returned error: %v", err)
+		}
+		w.Write([]byte(rel))
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close returned error: %v", err)
+		}
+		if err := archive.SetMetadata(filepath.Join(root, rel), 0o644, mtime); err != nil {
+			t.Fatalf("SetMetadata returned error: %v", err)
+		}
+	}
+	if err := archive.Commit(); err != nil {
+		t.Fatalf("Commit returned error: %v", err)
+	}
+	archive.Close()
+
+	dstDir := t.TempDir()
+	for rel, mtime := range map[string]time.Time{"same.txt": old, "changed.txt": old, "local-newer.txt": newer} {
+		p := writeTestFile(t, dstDir, rel, rel)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatalf("Chtimes returned error: %v", err)
+		}
+	}
+
+	archive, err = backend.OpenArchive(path)
+	if err != nil {
+		t.Fatalf("OpenArchive returned error: %v", err)
+	}
+	defer archive.Close()
+	tasks := make(chan task.Task, 8)
+	if err := Scan(root, dstDir, false, ModeUpdate, Options{SourceBackend: archive}, tasks); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	close(tasks)
+	var got []string
+	for tk := range tasks {
+		rel, _ := filepath.Rel(dstDir, tk.Dst)
+		got = append(got, tk.Action.String()+":"+filepath.ToSlash(rel))
+	}
+	want := []string{"copy:changed.txt", "copy:dir/new.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected tasks: got %v want %v", got, want)
+	}
+}
+
+func TestScanFanoutComparesEveryDestination(t *testing.T) {
+	srcDir := t.TempDir()
+	first := t.TempDir()
+	second := t.TempDir()
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, rel := range []string{"a.txt", "b.txt"} {
+		p := writeTestFile(t, srcDir, rel, rel)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatalf("Chtimes returned error: %v", err)
+		}
+	}
+	writeTestFile(t, srcDir, "big.bin", strings.Repeat("x", 64))
+	// The second destination already holds a.txt and an extra file.
+	p := writeTestFile(t, second, "a.txt", "a.txt")
+	if err := os.Chtimes(p, mtime, mtime); err != nil {
+		t.Fatalf("Chtimes returned error: %v", err)
+	}
+	writeTestFile(t, second, "extra.txt", "extra")
+
+	describe := func(src, dst string, fanout []string) string {
+		s := mustRelPath(t, srcDir, src) + "->" + mustRelPath(t, first, dst)
+		for _, f := range fanout {
+			s += "," + mustRelPath(t, second, f)
+		}
+		return s
+	}
+	tasks := make(chan task.Task, 16)
+	if err := ScanFanout(srcDir+string(filepath.Separator), []string{first, second}, false, ModeMirror, Options{BatchThreshold: 16}, tasks); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	close(tasks)
+	var got []string
+	for tk := range tasks {
+		switch tk.Action {
+		case task.ActionCopyBatch:
+			for _, entry := range tk.Batch.Entries {
+				got = append(got, "batch:"+describe(entry.Source, entry.Destination, entry.Fanout))
+			}
+		case task.ActionCopy:
+			got = append(got, "copy:"+describe(tk.Src, tk.Dst, tk.Fanout))
+		case task.ActionDelete:
+			got = append(got, "delete:"+mustRelPath(t, second, tk.Dst))
+		}
+	}
+	want := []string{
+		"batch:b.txt->b.txt,b.txt",
+		"copy:big.bin->big.bin,big.bin",
+		"batch:a.txt->a.txt",
+		"delete:extra.txt",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected tasks: got %v want %v", got, want)
+	}
+
+	writeTestFile(t, second, "extra2.txt", "extra")
+	tasks = make(chan task.Task, 16)
+	err := ScanFanout(srcDir+string(filepath.Separator), []string{first, second}, false, ModeMirror, Options{MaxDelete: 1}, tasks)
+	if !errors.Is(err, ErrDeleteLimit) || !strings.Contains(err.Error(), second) {
+		t.Fatalf("expected the second destination to exceed the deletion limit, got %v", err)
+	}
+	if len(tasks) != 0 {
+		t.Fatalf("expected nothing to be planned for any destination, got %d tasks", len(tasks))
+	}
+
+	if err := ScanFanout(srcDir, []string{first, second}, false, ModeSync, Options{}, make(chan task.Task, 16)); err == nil {
+		t.Fatalf("expected bidirectional fan-out to be refused")
+	}
+}
+
+func mustRelPath(t *testing.T, base, path string) string {
+	t.Helper()
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		t.Fatalf("Rel returned error: %v", err)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func TestFingerprintTracksEntries(t *testing.T) {
+	root := t.TempDir()
+	fingerprint := func(path string) TreeFingerprint {
+		t.Helper()
+		fp, err := Fingerprint(nil, path)
+		if err != nil {
+			t.Fatalf("Fingerprint returned error: %v", err)
+		}
+		return fp
+	}
+	empty := fingerprint(root)
+	if missing := fingerprint(filepath.Join(root, "missing")); missing.Digest == empty.Digest {
+		t.Fatalf("expected a missing tree to differ from an empty one")
+	}
+	p := writeTestFile(t, root, filepath.Join("dir", "file.txt"), "data")
+	filled := fingerprint(root)
+	if filled.Digest == empty.Digest || filled.Files != 1 || filled.Bytes != 4 {
+		t.Fatalf("unexpected fingerprint after adding a file: %+v", filled)
+	}
+	if again := fingerprint(root); again != filled {
+		t.Fatalf("expected an unchanged tree to keep its fingerprint: %+v != %+v", again, filled)
+	}
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(p, mtime, mtime); err != nil {
+		t.Fatalf("Chtimes returned error: %v", err)
+	}
+	if touched := fingerprint(root); touched.Digest == filled.Digest {
+		t.Fatalf("expected a new modification time to change the fingerprint")
+	}
+
+	var seen []TreeFingerprint
+	dst := t.TempDir()
+	opts := Options{OnFingerprint: func(fp TreeFingerprint) { seen = append(seen, fp) }}
+	if err := Scan(root+string(filepath.Separator), dst, false, ModeUpdate, opts, make(chan task.Task, 8)); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	if len(seen) != 2 || !seen[0].Source || seen[0].Digest != fingerprint(root).Digest || seen[1].Source || seen[1].Root != dst {
+		t.Fatalf("unexpected fingerprints reported by the scan: %+v", seen)
+	}
+}
+
+func TestScanExplainsTasks(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	for rel, mtime := range map[string]time.Time{"new.txt": newer, "size.txt": newer, "time.txt": newer, "same.txt": older} {
+		p := writeTestFile(t, srcDir, rel, "data")
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatalf("Chtimes returned error: %v", err)
+		}
+	}
+	for rel, contents := range map[string]string{"size.txt": "longer", "time.txt": "data", "same.txt": "data", "extra.txt": "x"} {
+		p := writeTestFile(t, dstDir, rel, contents)
+		if err := os.Chtimes(p, older, older); err != nil {
+			t.Fatalf("Chtimes returned error: %v", err)
+		}
+	}
+
+	tasks := make(chan task.Task, 16)
+	if err := Scan(srcDir+string(filepath.Separator), dstDir, false, ModeMirror, Options{}, tasks); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	close(tasks)
+	got := make(map[string]task.Reason)
+	for tk := range tasks {
+		rel := mustRelPath(t, dstDir, tk.Dst)
+		got[rel] = tk.ReasonFor(0)
+		e := tk.ExplanationFor(0)
+		switch rel {
+		case "size.txt":
+			if e.Src == nil || e.Dst == nil || e.Src.Size != 4 || e.Dst.Size != 6 || !e.Src.ModTime.Equal(newer) || !e.Dst.ModTime.Equal(older) || !e.Src.Mode.IsRegular() {
+				t.Fatalf("unexpected comparison for %s: %s", rel, e)
+			}
+		case "new.txt":
+			if e.Src == nil || e.Dst != nil {
+				t.Fatalf("expected a missing destination for %s: %s", rel, e)
+			}
+		case "extra.txt":
+			if e.Src != nil || e.Dst == nil || e.Dst.Size != 1 {
+				t.Fatalf("expected only the destination to be described for %s: %s", rel, e)
+			}
+		}
+	}
+	want := map[string]task.Reason{
+		"new.txt":   task.ReasonNew,
+		"size.txt":  task.ReasonSize,
+		"time.txt":  task.ReasonTime,
+		"extra.txt": task.ReasonExtra,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected reasons: got %v want %v", got, want)
+	}
+}
+
+func TestNewSizeDistribution(t *testing.T) {
+	sizes := make([]int64, 0, 100)
+	for i := 100; i >= 1; i-- {
+		sizes = append(sizes, int64(i))
+	}
+	got := NewSizeDistribution(sizes)
+	want := SizeDistribution{Files: 100, Bytes: 5050, Min: 1, P50: 50, P90: 90, P99: 99, Max: 100}
+	if got != want {
+		t.Fatalf("unexpected distribution: got %+v want %+v", got, want)
+	}
+	if empty := NewSizeDistribution(nil); empty != (SizeDistribution{}) {
+		t.Fatalf("expected an empty distribution, got %+v", empty)
+	}
+}
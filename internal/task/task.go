@@ -1,5 +1,13 @@
 package task
 
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+	"sync"
+	"time"
+)
+
 // Action represents the type of work to perform for a task.
 type Action int
 
@@ -10,28 +18,263 @@ const (
 	ActionDelete
 	// ActionCopyBatch copies multiple files described in Batch.
 	ActionCopyBatch
+	// ActionLink hard-links the unchanged reference file at Src to Dst.
+	ActionLink
+)
+
+// String returns the canonical lower-case name of the action.
+func (a Action) String() string {
+	switch a {
+	case ActionCopy:
+		return "copy"
+	case ActionDelete:
+		return "delete"
+	case ActionCopyBatch:
+		return "copy_batch"
+	case ActionLink:
+		return "link"
+	default:
+		return fmt.Sprintf("action_%d", int(a))
+	}
+}
+
+// ParseAction converts a name produced by Action.String back into an Action.
+func ParseAction(s string) (Action, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "copy":
+		return ActionCopy, nil
+	case "delete":
+		return ActionDelete, nil
+	case "copy_batch":
+		return ActionCopyBatch, nil
+	case "link":
+		return ActionLink, nil
+	default:
+		return ActionCopy, fmt.Errorf("unknown action %q", s)
+	}
+}
+
+// Reason explains why the scanner planned a task for a destination.
+type Reason string
+
+const (
+	// ReasonNew means the destination does not exist.
+	ReasonNew Reason = "new"
+	// ReasonSize means the source and destination sizes differ.
+	ReasonSize Reason = "size"
+	// ReasonTime means the source was modified after the destination.
+	ReasonTime Reason = "time"
+	// ReasonETag means the source and destination objects have different
+	// ETags.
+	ReasonETag Reason = "etag"
+	// ReasonExtra means the destination entry exists in no source and is
+	// deleted by a mirror run.
+	ReasonExtra Reason = "extra"
 )
 
+// Metadata is the file metadata the scanner compared.
+type Metadata struct {
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mtime"`
+	Mode    fs.FileMode `json:"mode"`
+}
+
+// NewMetadata returns the metadata of info, or nil when info is nil.
+func NewMetadata(info fs.FileInfo) *Metadata {
+	if info == nil {
+		return nil
+	}
+	return &Metadata{Size: info.Size(), ModTime: info.ModTime(), Mode: info.Mode()}
+}
+
+func (m *Metadata) String() string {
+	if m == nil {
+		return "missing"
+	}
+	return fmt.Sprintf("%d bytes, %s, %s", m.Size, m.ModTime.UTC().Format(time.RFC3339Nano), m.Mode)
+}
+
+// Explanation records why the scanner planned a task for one destination
+// and what it compared to decide.
+type Explanation struct {
+	Reason Reason `json:"reason"`
+	// Src is the source file the scanner compared; nil for deletes.
+	Src *Metadata `json:"src,omitempty"`
+	// Dst is the destination as it was found; nil when it did not exist.
+	Dst *Metadata `json:"dst,omitempty"`
+}
+
+func (e Explanation) String() string {
+	if e.Src == nil {
+		return fmt.Sprintf("%s: dst %s", e.Reason, e.Dst)
+	}
+	return fmt.Sprintf("%s: src %s; dst %s", e.Reason, e.Src, e.Dst)
+}
+
 // Task represents work to be completed by the worker pool.
 type Task struct {
 	Action Action
 	Src    string
 	Dst    string
-	Batch  *CopyBatchPayload
+	// Fanout lists further destinations of a copy that receive the same
+	// content as Dst, so the source is read once for all of them. Batches
+	// fan out per entry instead.
+	Fanout []string
+	// Size and ModTime describe the file at Src as the scanner saw it, so a
+	// copy or link executed later can be checked against the file it reads.
+	// They are zero when unknown.
+	Size    int64
+	ModTime time.Time
+	// Explanations holds, for each of Destinations, why the scanner planned
+	// the task. It is empty when unknown.
+	Explanations []Explanation
+	Batch        *CopyBatchPayload
+}
+
+// ExplanationFor returns the explanation recorded for the i-th of
+// Destinations, or nil when there is none.
+func (t Task) ExplanationFor(i int) *Explanation {
+	return explanationAt(t.Explanations, i)
+}
+
+// ReasonFor returns the reason recorded for the i-th of Destinations.
+func (t Task) ReasonFor(i int) Reason {
+	if e := t.ExplanationFor(i); e != nil {
+		return e.Reason
+	}
+	return ""
+}
+
+func explanationAt(explanations []Explanation, i int) *Explanation {
+	if i < 0 || i >= len(explanations) {
+		return nil
+	}
+	e := explanations[i]
+	return &e
 }
 
-// CopyBatchPayload contains the metadata and serialized content for a batch
-// of files. The Archive field holds a TAR archive that includes all file
-// contents. Entries is ordered to match the files encoded in the archive so a
-// worker can reconstruct each target.
+// Destinations returns Dst followed by Fanout.
+func (t Task) Destinations() []string {
+	return append([]string{t.Dst}, t.Fanout...)
+}
+
+// CopyBatchPayload contains the metadata and optionally the serialized
+// content for a batch of files. When Archive is empty the batch is lazy and
+// the worker streams each entry directly from its Source. Otherwise Archive
+// holds a TAR archive with all file contents, ordered to match Entries, so a
+// remote worker can reconstruct each target without access to the source.
 type CopyBatchPayload struct {
 	Entries []CopyBatchEntry
 	Archive []byte
+	// Compression names the codec applied to Archive. It is empty for a
+	// plain TAR archive.
+	Compression string
+	// ArchiveHash is the hex SHA-256 of Archive as transported. Executors
+	// reject archives without it or that do not match it.
+	ArchiveHash string
+
+	release func()
+}
+
+// Archive compression codecs understood by the executor.
+const (
+	CompressionNone  = ""
+	CompressionGzip  = "gzip"
+	CompressionFlate = "flate"
+)
+
+// Lazy reports whether the batch carries only its entry list.
+func (p *CopyBatchPayload) Lazy() bool {
+	return p != nil && len(p.Archive) == 0
+}
+
+// Release returns the archive's share of the in-flight memory budget once
+// the batch has been processed or handed off. It is safe to call more than
+// once and on payloads that were never charged against a budget.
+func (p *CopyBatchPayload) Release() {
+	if p == nil || p.release == nil {
+		return
+	}
+	release := p.release
+	p.release = nil
+	release()
+}
+
+// ArchiveBudget bounds the memory held by batch archives that have been
+// produced but not yet released by a consumer.
+type ArchiveBudget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int64
+	used  int64
+}
+
+// NewArchiveBudget creates a budget of limit bytes. A limit <= 0 returns nil,
+// which never blocks.
+func NewArchiveBudget(limit int64) *ArchiveBudget {
+	if limit <= 0 {
+		return nil
+	}
+	b := &ArchiveBudget{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// Charge blocks until the payload's archive fits in the budget and arranges
+// for Release to return it. An archive larger than the whole budget is
+// admitted once nothing else is in flight so it cannot block forever.
+func (b *ArchiveBudget) Charge(p *CopyBatchPayload) {
+	if b == nil || p == nil {
+		return
+	}
+	size := int64(len(p.Archive))
+	b.mu.Lock()
+	for b.used > 0 && b.used+size > b.limit {
+		b.cond.Wait()
+	}
+	b.used += size
+	b.mu.Unlock()
+	p.release = func() {
+		b.mu.Lock()
+		b.used -= size
+		b.mu.Unlock()
+		b.cond.Broadcast()
+	}
+}
+
+// InFlight returns the number of archive bytes currently charged.
+func (b *ArchiveBudget) InFlight() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
 }
 
 // CopyBatchEntry describes a single file within a batched copy request.
 type CopyBatchEntry struct {
 	Source      string
 	Destination string
-	Size        int64
+	// Fanout lists further destinations that receive the same content as
+	// Destination. All entries of a batch have the same number of them.
+	Fanout []string `json:",omitempty"`
+	Size   int64
+	// ModTime is the modification time of Source as the scanner saw it.
+	ModTime time.Time
+	// Explanations holds why the scanner planned the copy to Destination
+	// and to each Fanout destination, in that order.
+	Explanations []Explanation `json:",omitempty"`
+	// Hash is the hex digest of the file contents. Scanners set it to the
+	// SHA-256 when the file is packed into the archive, where executors
+	// require it, and leave it empty for lazy batches; executors report the
+	// hash of the content they actually wrote using their configured
+	// algorithm.
+	Hash string
+}
+
+// ExplanationFor returns the explanation recorded for the i-th destination
+// of the entry, Destination first, or nil when there is none.
+func (e CopyBatchEntry) ExplanationFor(i int) *Explanation {
+	return explanationAt(e.Explanations, i)
 }
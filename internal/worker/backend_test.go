@@ -0,0 +1,210 @@
+package worker
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/backend"
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+func TestPoolCopiesBetweenBackends(t *testing.T) {
+	src := backend.NewMemory()
+	dst := backend.NewMemory()
+	srcRoot := filepath.Join(string(filepath.Separator), "src")
+	dstRoot := filepath.Join(string(filepath.Separator), "dst")
+	mtime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for rel, data := range map[string]string{"a.txt": "alpha", "b.txt": "beta"} {
+		if err := src.WriteFile(filepath.Join(srcRoot, rel), []byte(data), 0o644, mtime); err != nil {
+			t.Fatalf("failed to seed %s: %v", rel, err)
+		}
+	}
+	if err := dst.WriteFile(filepath.Join(dstRoot, "old", "gone.txt"), []byte("gone"), 0o644, mtime); err != nil {
+		t.Fatalf("failed to seed destination: %v", err)
+	}
+
+	pool := New(2, false, 0)
+	pool.SourceBackend = src
+	pool.DestinationBackend = dst
+	pool.DestinationRoots = []string{dstRoot}
+	pool.Durability = DurabilityFileDir
+	report, err := pool.Run(taskChannel(
+		task.Task{Action: task.ActionCopy, Src: filepath.Join(srcRoot, "a.txt"), Dst: filepath.Join(dstRoot, "a.txt")},
+		task.Task{Action: task.ActionCopyBatch, Batch: &task.CopyBatchPayload{Entries: []task.CopyBatchEntry{
+			{Source: filepath.Join(srcRoot, "b.txt"), Destination: filepath.Join(dstRoot, "lazy", "b.txt"), Size: 4},
+		}}},
+		task.Task{Action: task.ActionCopyBatch, Batch: sealed(&task.CopyBatchPayload{
+			Entries: []task.CopyBatchEntry{{Destination: filepath.Join(dstRoot, "packed", "c.txt"), Size: 5, Hash: sha256Hex("gamma")}},
+			Archive: buildArchive(t, []archiveMember{{name: "file-0", content: "gamma"}}),
+		})},
+		task.Task{Action: task.ActionDelete, Dst: filepath.Join(dstRoot, "old")},
+	))
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if report.copiedFileCount() != 3 {
+		t.Fatalf("expected 3 copied files, got %d", report.copiedFileCount())
+	}
+
+	for rel, want := range map[string]string{"a.txt": "alpha", filepath.Join("lazy", "b.txt"): "beta", filepath.Join("packed", "c.txt"): "gamma"} {
+		f, err := dst.Open(filepath.Join(dstRoot, rel))
+		if err != nil {
+			t.Fatalf("expected %s in the destination backend: %v", rel, err)
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil || string(data) != want {
+			t.Fatalf("%s holds %q, %v; want %q", rel, data, err, want)
+		}
+	}
+	entries, err := dst.List(filepath.Join(dstRoot, "packed"))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected the temporary file to be renamed away, got %v, %v", entries, err)
+	}
+	if _, err := dst.Stat(filepath.Join(dstRoot, "old")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the delete to reach the destination backend, got %v", err)
+	}
+	if _, err := src.Stat(filepath.Join(srcRoot, "a.txt")); err != nil {
+		t.Fatalf("the source backend must be left alone: %v", err)
+	}
+
+	_, err = pool.Run(taskChannel(task.Task{Action: task.ActionLink, Src: filepath.Join(dstRoot, "a.txt"), Dst: filepath.Join(dstRoot, "link.txt")}))
+	if err == nil {
+		t.Fatalf("expected hard links to be refused outside the local filesystem")
+	}
+}
+
+func TestObjectStoreThrottlingIsRetried(t *testing.T) {
+	err := &fs.PathError{Op: "open", Path: "/a", Err: &backend.S3Error{Method: "GET", Key: "a", StatusCode: 503, Code: "SlowDown"}}
+	class, _ := ClassifyError(err)
+	if class != ErrorClassBusy || !DefaultRetryPolicy().Retryable(class) {
+		t.Fatalf("expected throttling to be retryable, got class %q", class)
+	}
+	if class, _ := ClassifyError(&backend.S3Error{StatusCode: 404, Code: "NoSuchKey"}); class != ErrorClassNotFound {
+		t.Fatalf("expected a missing object to be not_found, got %q", class)
+	}
+}
+
+// brokenSource serves files whose content cannot be read past the first
+// bytes.
+type brokenSource struct {
+	*backend.Memory
+}
+
+func (b brokenSource) Open(path string) (fs.File, error) {
+	f, err := b.Memory.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &brokenFile{File: f}, nil
+}
+
+type brokenFile struct {
+	fs.File
+	read bool
+}
+
+func (f *brokenFile) Read(p []byte) (int, error) {
+	if f.read {
+		return 0, errors.New("device went away")
+	}
+	f.read = true
+	return copy(p, "partial"), nil
+}
+
+// recordingS3 is an S3 endpoint that accepts every request and records its
+// method, path and mode metadata.
+type recordingS3 struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func newRecordingS3(t *testing.T) (*recordingS3, *backend.S3) {
+	rec := &recordingS3{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		request := r.Method + " " + r.URL.Path
+		if mode := r.Header.Get("X-Amz-Meta-Mode"); mode != "" {
+			request += " mode=" + mode
+		}
+		rec.requests = append(rec.requests, request)
+	}))
+	t.Cleanup(srv.Close)
+	s, err := backend.NewS3(backend.S3Config{
+		Endpoint:        srv.URL,
+		Region:          "eu-west-1",
+		Bucket:          "data",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		Client:          srv.Client(),
+	})
+	if err != nil {
+		t.Fatalf("NewS3 returned error: %v", err)
+	}
+	return rec, s
+}
+
+func (r *recordingS3) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.requests...)
+}
+
+func TestPackedBatchEntryIsUploadedOnce(t *testing.T) {
+	rec, dst := newRecordingS3(t)
+	dstRoot := filepath.Join(string(filepath.Separator), "dst")
+	exec := NewExecutor(false, 0)
+	exec.DestinationBackend = dst
+	exec.DestinationRoots = []string{dstRoot}
+	_, err := exec.RunTask(task.Task{Action: task.ActionCopyBatch, Batch: sealed(&task.CopyBatchPayload{
+		Entries: []task.CopyBatchEntry{{Destination: filepath.Join(dstRoot, "packed", "c.txt"), Size: 5, Hash: sha256Hex("gamma")}},
+		Archive: buildArchive(t, []archiveMember{{name: "file-0", content: "gamma"}}),
+	})})
+	if err != nil {
+		t.Fatalf("RunTask returned error: %v", err)
+	}
+	want := []string{"PUT /data/dst/packed/c.txt mode=644"}
+	if got := rec.recorded(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected a single upload to the final key, got %v", got)
+	}
+}
+
+func TestFailedCopyPublishesNothingToS3(t *testing.T) {
+	rec, dst := newRecordingS3(t)
+	src := brokenSource{backend.NewMemory()}
+	srcRoot := filepath.Join(string(filepath.Separator), "src")
+	dstRoot := filepath.Join(string(filepath.Separator), "dst")
+	if err := src.WriteFile(filepath.Join(srcRoot, "a.txt"), []byte("partial content"), 0o644, time.Now()); err != nil {
+		t.Fatalf("failed to seed source: %v", err)
+	}
+
+	exec := NewExecutor(false, 0)
+	exec.SourceBackend = src
+	exec.DestinationBackend = dst
+	exec.DestinationRoots = []string{dstRoot}
+	for _, tk := range []task.Task{
+		{Action: task.ActionCopy, Src: filepath.Join(srcRoot, "a.txt"), Dst: filepath.Join(dstRoot, "a.txt")},
+		{Action: task.ActionCopyBatch, Batch: &task.CopyBatchPayload{Entries: []task.CopyBatchEntry{
+			{Source: filepath.Join(srcRoot, "a.txt"), Destination: filepath.Join(dstRoot, "b.txt"), Size: 15},
+		}}},
+	} {
+		if _, err := exec.RunTask(tk); err == nil {
+			t.Fatalf("expected the %v task to fail", tk.Action)
+		}
+	}
+	for _, r := range rec.recorded() {
+		if strings.HasPrefix(r, "PUT ") || strings.HasPrefix(r, "POST ") {
+			t.Fatalf("expected no upload after the source read failed, got %v", rec.recorded())
+		}
+	}
+}
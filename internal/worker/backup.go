@@ -0,0 +1,201 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// backupStampLayout names the per-run directories inside a backup tree. It
+// sorts chronologically and contains no characters that are awkward on any
+// supported filesystem.
+const backupStampLayout = "20060102T150405Z"
+
+// BackupTree keeps destination entries that a run deletes or overwrites.
+// Instead of being discarded they are moved to Dir/<run stamp>/<path relative
+// to its destination root>. Nothing already in the tree is replaced: when
+// the same path is preserved twice under one stamp, for example by two runs
+// started in the same second, the later entry gets a numbered suffix.
+type BackupTree struct {
+	// Dir is the directory holding one subdirectory per run.
+	Dir string
+	// Stamp names this run's subdirectory.
+	Stamp string
+	// Roots are the destination roots paths are made relative to. Paths
+	// outside every root keep their absolute layout below the stamp.
+	Roots []string
+}
+
+// NewBackupTree returns a BackupTree for a run started at now.
+func NewBackupTree(dir string, roots []string, now time.Time) *BackupTree {
+	return &BackupTree{Dir: dir, Stamp: now.UTC().Format(backupStampLayout), Roots: roots}
+}
+
+// Preserve moves path into the tree and returns its new location. It returns
+// an empty location when path does not exist. When the tree already holds
+// an entry for path, path is moved next to it under the first free name
+// chosen by unusedPath.
+func (b *BackupTree) Preserve(path string) (string, error) {
+	if b == nil {
+		return "", nil
+	}
+	if _, err := os.Lstat(path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	rel, err := relativeToRoots(b.Roots, path)
+	if err != nil {
+		return "", err
+	}
+	target, err := unusedPath(filepath.Join(b.Dir, b.Stamp, rel))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return "", err
+	}
+	if err := moveEntry(path, target); err != nil {
+		return "", fmt.Errorf("preserving %s in %s: %w", path, b.Dir, err)
+	}
+	return target, nil
+}
+
+// unusedPath returns path when nothing exists there, and otherwise the first
+// of path.1, path.2, ... that is free.
+func unusedPath(path string) (string, error) {
+	candidate := path
+	for n := 1; ; n++ {
+		_, err := os.Lstat(candidate)
+		if errors.Is(err, fs.ErrNotExist) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s.%d", path, n)
+	}
+}
+
+// relativeToRoots returns path relative to the first root containing it.
+// Paths outside every root keep their absolute layout without the volume
+// name.
+func relativeToRoots(roots []string, path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	for _, root := range roots {
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			return "", err
+		}
+		if withinRoot(absRoot, absPath) {
+			return filepath.Rel(absRoot, absPath)
+		}
+	}
+	rel := strings.TrimPrefix(absPath, filepath.VolumeName(absPath))
+	return strings.TrimLeft(rel, string(filepath.Separator)), nil
+}
+
+// moveEntry renames src to dst, copying and removing src when they are on
+// different filesystems.
+func moveEntry(src, dst string) error {
+	err := os.Rename(src, dst)
+	if errors.Is(err, syscall.EXDEV) {
+		if err = copyTree(src, dst); err == nil {
+			err = os.RemoveAll(src)
+		}
+	}
+	return err
+}
+
+// copyTree copies a file or directory tree from src to dst, keeping
+// permissions and modification times.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case !d.Type().IsRegular():
+			return fmt.Errorf("cannot preserve special file %s", path)
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_EXCL, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(target, info.ModTime(), info.ModTime())
+	})
+}
+
+// PurgeBackups removes run directories in dir that are older than retention
+// relative to now and returns the removed paths. Entries whose names are not
+// run stamps are never touched. A retention <= 0 keeps everything.
+func PurgeBackups(dir string, retention time.Duration, now time.Time) ([]string, error) {
+	if retention <= 0 {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cutoff := now.Add(-retention)
+	var removed []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		stamp, err := time.Parse(backupStampLayout, entry.Name())
+		if err != nil || !stamp.Before(cutoff) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := os.RemoveAll(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	sort.Strings(removed)
+	return removed, nil
+}
@@ -0,0 +1,139 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/backend"
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+func TestRunTaskPreservesOverwrittenAndDeletedFiles(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	keepDir := t.TempDir()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	src := filepath.Join(srcDir, "a.txt")
+	dst := filepath.Join(dstDir, "sub", "a.txt")
+	stale := filepath.Join(dstDir, "stale", "b.txt")
+	for path, data := range map[string]string{src: "new", dst: "old", stale: "extra"} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create parent: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	exec := NewExecutor(false, 0)
+	exec.Backup = NewBackupTree(filepath.Join(keepDir, "backup"), []string{dstDir}, now)
+	exec.Trash = NewBackupTree(filepath.Join(keepDir, "trash"), []string{dstDir}, now)
+
+	res, err := exec.RunTask(task.Task{Action: task.ActionCopy, Src: src, Dst: dst})
+	if err != nil {
+		t.Fatalf("copy returned error: %v", err)
+	}
+	wantBackup := filepath.Join(keepDir, "backup", "20240601T120000Z", "sub", "a.txt")
+	if res.BackupPath != wantBackup {
+		t.Fatalf("unexpected backup path %q, want %q", res.BackupPath, wantBackup)
+	}
+	assertContents(t, wantBackup, "old")
+	assertContents(t, dst, "new")
+
+	// A second copy under the same stamp keeps both preserved versions.
+	if err := os.WriteFile(src, []byte("newer"), 0o644); err != nil {
+		t.Fatalf("failed to rewrite source: %v", err)
+	}
+	res, err = exec.RunTask(task.Task{Action: task.ActionCopy, Src: src, Dst: dst})
+	if err != nil {
+		t.Fatalf("second copy returned error: %v", err)
+	}
+	if res.BackupPath != wantBackup+".1" {
+		t.Fatalf("unexpected second backup path %q, want %q", res.BackupPath, wantBackup+".1")
+	}
+	assertContents(t, wantBackup, "old")
+	assertContents(t, wantBackup+".1", "new")
+	assertContents(t, dst, "newer")
+
+	res, err = exec.RunTask(task.Task{Action: task.ActionDelete, Dst: filepath.Dir(stale)})
+	if err != nil {
+		t.Fatalf("delete returned error: %v", err)
+	}
+	wantTrash := filepath.Join(keepDir, "trash", "20240601T120000Z", "stale")
+	if res.BackupPath != wantTrash {
+		t.Fatalf("unexpected trash path %q, want %q", res.BackupPath, wantTrash)
+	}
+	assertContents(t, filepath.Join(wantTrash, "b.txt"), "extra")
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed, got %v", stale, err)
+	}
+}
+
+// inPlaceDestination is the local filesystem seen as a remote backend, so
+// replacements are written straight to their final path.
+type inPlaceDestination struct {
+	backend.Local
+}
+
+func TestCopyBatchPreservesOverwrittenFilesBeforeWriting(t *testing.T) {
+	dstDir := t.TempDir()
+	keepDir := t.TempDir()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	dst := filepath.Join(dstDir, "a.txt")
+	if err := os.WriteFile(dst, []byte("old"), 0o644); err != nil {
+		t.Fatalf("failed to write destination: %v", err)
+	}
+
+	exec := NewExecutor(false, 0)
+	exec.DestinationBackend = inPlaceDestination{}
+	exec.Backup = NewBackupTree(filepath.Join(keepDir, "backup"), []string{dstDir}, now)
+	payload := sealed(&task.CopyBatchPayload{
+		Entries: []task.CopyBatchEntry{{Destination: dst, Size: 3, Hash: sha256Hex("new")}},
+		Archive: buildArchive(t, []archiveMember{{name: "file-0", content: "new"}}),
+	})
+	if _, err := exec.RunTask(task.Task{Action: task.ActionCopyBatch, Batch: payload}); err != nil {
+		t.Fatalf("copy batch returned error: %v", err)
+	}
+	assertContents(t, filepath.Join(keepDir, "backup", "20240601T120000Z", "a.txt"), "old")
+	assertContents(t, dst, "new")
+}
+
+func TestPurgeBackups(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	for _, name := range []string{"20240501T000000Z", "20240609T000000Z", "notes"} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+	}
+
+	removed, err := PurgeBackups(dir, 7*24*time.Hour, now)
+	if err != nil {
+		t.Fatalf("PurgeBackups returned error: %v", err)
+	}
+	if len(removed) != 1 || filepath.Base(removed[0]) != "20240501T000000Z" {
+		t.Fatalf("unexpected removals: %v", removed)
+	}
+	for _, name := range []string{"20240609T000000Z", "notes"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("expected %s to be kept: %v", name, err)
+		}
+	}
+	if removed, err := PurgeBackups(dir, 0, now); err != nil || len(removed) != 0 {
+		t.Fatalf("zero retention should keep everything, removed %v (%v)", removed, err)
+	}
+}
+
+func assertContents(t *testing.T, path, want string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	if string(data) != want {
+		t.Fatalf("unexpected contents of %s: got %q want %q", path, data, want)
+	}
+}
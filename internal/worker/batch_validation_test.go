@@ -0,0 +1,162 @@
+package worker
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+type archiveMember struct {
+	name    string
+	content string
+	size    int64
+}
+
+func buildArchive(t *testing.T, members []archiveMember) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, m := range members {
+		size := m.size
+		if size == 0 {
+			size = int64(len(m.content))
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: m.name, Mode: 0o644, Size: size, Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("failed to write header: %v", err)
+		}
+		if _, err := tw.Write([]byte(m.content)); err != nil {
+			t.Fatalf("failed to write contents: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// sealed sets the archive hash of p the way scanners do.
+func sealed(p *task.CopyBatchPayload) *task.CopyBatchPayload {
+	sum := sha256.Sum256(p.Archive)
+	p.ArchiveHash = hex.EncodeToString(sum[:])
+	return p
+}
+
+func TestCopyBatchRejectsInvalidPayloads(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "a.txt")
+	good := buildArchive(t, []archiveMember{{name: "file-0", content: "alpha"}})
+
+	alpha := sha256Hex("alpha")
+
+	cases := []struct {
+		name    string
+		payload *task.CopyBatchPayload
+		want    error
+	}{
+		{
+			name:    "size mismatch",
+			payload: sealed(&task.CopyBatchPayload{Entries: []task.CopyBatchEntry{{Destination: dst, Size: 4, Hash: alpha}}, Archive: good}),
+			want:    ErrIntegrity,
+		},
+		{
+			name:    "entry hash mismatch",
+			payload: sealed(&task.CopyBatchPayload{Entries: []task.CopyBatchEntry{{Destination: dst, Size: 5, Hash: sha256Hex("bravo")}}, Archive: good}),
+			want:    ErrIntegrity,
+		},
+		{
+			name:    "archive hash mismatch",
+			payload: &task.CopyBatchPayload{Entries: []task.CopyBatchEntry{{Destination: dst, Size: 5, Hash: alpha}}, Archive: good, ArchiveHash: sha256Hex("other")},
+			want:    ErrIntegrity,
+		},
+		{
+			name:    "missing archive hash",
+			payload: &task.CopyBatchPayload{Entries: []task.CopyBatchEntry{{Destination: dst, Size: 5, Hash: alpha}}, Archive: good},
+			want:    ErrInvalidPayload,
+		},
+		{
+			name:    "missing entry hash",
+			payload: sealed(&task.CopyBatchPayload{Entries: []task.CopyBatchEntry{{Destination: dst, Size: 5}}, Archive: good}),
+			want:    ErrInvalidPayload,
+		},
+		{
+			name: "extra tar entry",
+			payload: sealed(&task.CopyBatchPayload{
+				Entries: []task.CopyBatchEntry{{Destination: dst, Size: 5, Hash: alpha}},
+				Archive: buildArchive(t, []archiveMember{{name: "file-0", content: "alpha"}, {name: "file-1", content: "extra"}}),
+			}),
+			want: ErrInvalidPayload,
+		},
+		{
+			name:    "missing tar entry",
+			payload: sealed(&task.CopyBatchPayload{Entries: []task.CopyBatchEntry{{Destination: dst, Size: 5, Hash: alpha}, {Destination: dst + "2", Size: 5, Hash: alpha}}, Archive: good}),
+			want:    ErrInvalidPayload,
+		},
+		{
+			name:    "trailing data",
+			payload: sealed(&task.CopyBatchPayload{Entries: []task.CopyBatchEntry{{Destination: dst, Size: 5, Hash: alpha}}, Archive: append(append([]byte(nil), good...), []byte("smuggled")...)}),
+			want:    ErrInvalidPayload,
+		},
+		{
+			name:    "outside root",
+			payload: sealed(&task.CopyBatchPayload{Entries: []task.CopyBatchEntry{{Destination: filepath.Join(dir, "..", "escape.txt"), Size: 5, Hash: alpha}}, Archive: good}),
+			want:    ErrInvalidPayload,
+		},
+	}
+
+	exec := NewExecutor(false, 0)
+	exec.DestinationRoots = []string{dir}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, _, err := exec.copyBatch(tc.payload, nil)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to list destination: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("rejected payloads left files behind: %v", entries)
+	}
+}
+
+func TestCopyBatchRejectsSymlinkEscape(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+
+	exec := NewExecutor(false, 0)
+	exec.DestinationRoots = []string{root}
+	payload := sealed(&task.CopyBatchPayload{
+		Entries: []task.CopyBatchEntry{{Destination: filepath.Join(root, "link", "a.txt"), Size: 5, Hash: sha256Hex("alpha")}},
+		Archive: buildArchive(t, []archiveMember{{name: "file-0", content: "alpha"}}),
+	})
+	if _, _, _, err := exec.copyBatch(payload, nil); !errors.Is(err, ErrInvalidPayload) {
+		t.Fatalf("expected ErrInvalidPayload, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "a.txt")); !os.IsNotExist(err) {
+		t.Fatalf("file was written outside the root: %v", err)
+	}
+
+	payload.Entries[0].Destination = filepath.Join(root, "nested", "a.txt")
+	if _, _, _, err := exec.copyBatch(payload, nil); err != nil {
+		t.Fatalf("valid payload rejected: %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+// storageCodec stores destination files in a transformed form, such as
+// encrypted or compressed. Task paths stay the plain destination paths; the
+// codec decides where and how each file is stored.
+type storageCodec interface {
+	// Path returns where the destination file path is stored.
+	Path(path string) (string, error)
+	// dirPath returns where the destination directory path is stored.
+	dirPath(path string) (string, error)
+	// encode returns a writer that stores the content of the file described
+	// by info into w. Closing it flushes the encoding but not w.
+	encode(w io.Writer, info fs.FileInfo) (io.WriteCloser, error)
+	// kind names the destination in messages.
+	kind() string
+}
+
+// storedTempPrefix starts the names of the files encodeFile writes before
+// renaming them into place. Listings and restores of a stored tree skip
+// them, so a file left behind by an interrupted run does not break it.
+const storedTempPrefix = ".syncopa-tmp-"
+
+// isStoredTemp reports whether d is a file left by encodeFile.
+func isStoredTemp(d fs.DirEntry) bool {
+	return !d.IsDir() && strings.HasPrefix(d.Name(), storedTempPrefix)
+}
+
+// plainInfo reports the plain name and size of a stored file.
+type plainInfo struct {
+	fs.FileInfo
+	name string
+	size int64
+}
+
+func (i plainInfo) Name() string { return i.name }
+func (i plainInfo) Size() int64  { return i.size }
+
+// runEncoded executes t against a destination stored through codec.
+func (e *Executor) runEncoded(t task.Task, codec storageCodec) (*TaskReport, error) {
+	switch t.Action {
+	case task.ActionCopy:
+		if e.Verbose {
+			log.Printf("copy %s -> %s (%s)", t.Src, t.Dst, codec.kind())
+		}
+		if err := e.confine(t.Dst); err != nil {
+			return nil, err
+		}
+		dst, err := codec.Path(t.Dst)
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		sync := e.newSyncer()
+		bytes, hash, err := e.encodeFile(codec, t.Src, dst, sync)
+		if err != nil {
+			return nil, err
+		}
+		if err := removeUnencoded(codec, t.Dst, dst); err != nil {
+			return nil, err
+		}
+		return &TaskReport{
+			Action:        t.Action,
+			Source:        t.Src,
+			Destination:   t.Dst,
+			Bytes:         bytes,
+			Hash:          hash,
+			HashAlgorithm: e.HashAlgorithm.orDefault(),
+			StartedAt:     start,
+			Duration:      time.Since(start),
+			SyncDuration:  sync.elapsed,
+		}, nil
+	case task.ActionCopyBatch:
+		if t.Batch == nil {
+			return nil, fmt.Errorf("copy batch task missing payload")
+		}
+		if !t.Batch.Lazy() {
+			return nil, fmt.Errorf("%s destinations do not accept embedded batch archives", codec.kind())
+		}
+		if e.Verbose {
+			log.Printf("copy batch (%d files, %s)", len(t.Batch.Entries), codec.kind())
+		}
+		start := time.Now()
+		sync := e.newSyncer()
+		entries := make([]task.CopyBatchEntry, len(t.Batch.Entries))
+		var total int64
+		for i, entry := range t.Batch.Entries {
+			if err := e.confine(entry.Destination); err != nil {
+				return nil, err
+			}
+			dst, err := codec.Path(entry.Destination)
+			if err != nil {
+				return nil, err
+			}
+			bytes, hash, err := e.encodeFile(codec, entry.Source, dst, sync)
+			if err != nil {
+				return nil, err
+			}
+			if err := removeUnencoded(codec, entry.Destination, dst); err != nil {
+				return nil, err
+			}
+			entry.Size = bytes
+			entry.Hash = hash
+			entries[i] = entry
+			total += bytes
+		}
+		destination := fmt.Sprintf("batch of %d files", len(entries))
+		source := ""
+		if len(entries) > 0 {
+			source = entries[0].Source
+			destination = fmt.Sprintf("%s (batch of %d files)", entries[0].Destination, len(entries))
+		}
+		return &TaskReport{
+			Action:        t.Action,
+			Source:        source,
+			Destination:   destination,
+			Bytes:         total,
+			HashAlgorithm: e.HashAlgorithm.orDefault(),
+			StartedAt:     start,
+			Duration:      time.Since(start),
+			SyncDuration:  sync.elapsed,
+			BatchEntries:  entries,
+		}, nil
+	case task.ActionDelete:
+		if e.Verbose {
+			log.Printf("delete %s", t.Dst)
+		}
+		if err := e.confine(t.Dst); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		sync := e.newSyncer()
+		// Delete tasks do not say whether t.Dst was a file or a
+		// directory, so both stored forms are removed. Stored trees are
+		// written to the local filesystem rather than through the
+		// destination backend, so they are removed the same way; a form
+		// that does not exist is not an error.
+		stored, err := codec.Path(t.Dst)
+		if err != nil {
+			return nil, err
+		}
+		dir, err := codec.dirPath(t.Dst)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range []string{stored, dir} {
+			if err := os.RemoveAll(path); err != nil {
+				return nil, err
+			}
+		}
+		if err := sync.dir(stored); err != nil {
+			return nil, err
+		}
+		return &TaskReport{
+			Action:       t.Action,
+			Destination:  t.Dst,
+			StartedAt:    start,
+			Duration:     time.Since(start),
+			SyncDuration: sync.elapsed,
+		}, nil
+	default:
+		return nil, fmt.Errorf("action %s is not supported by %s destinations", t.Action, codec.kind())
+	}
+}
+
+// encodeFile stores src at dst, a path in the stored tree, through codec and
+// returns the plain size and hash. The stored file carries the source mode
+// and mtime so later scans can tell whether it is current.
+func (e *Executor) encodeFile(codec storageCodec, src, dst string, sync *syncer) (int64, string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, "", err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return 0, "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return 0, "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), storedTempPrefix+"*")
+	if err != nil {
+		return 0, "", err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+	w, err := codec.encode(tmp, info)
+	if err != nil {
+		tmp.Close()
+		return 0, "", err
+	}
+	written, hash, err := copyWithBandwidth(w, in, e.BandwidthLimit, e.HashAlgorithm)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil && written != info.Size() {
+		err = fmt.Errorf("%w: %s changed size while being stored (%d of %d bytes)", ErrIntegrity, src, written, info.Size())
+	}
+	if err == nil {
+		err = sync.file(tmp)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return written, "", err
+	}
+	if err := os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		return written, "", err
+	}
+	if err := os.Chtimes(tmpPath, time.Now(), info.ModTime()); err != nil {
+		return written, "", err
+	}
+	if err := os.Rename(tmpPath, dst); err != nil {
+		return written, "", err
+	}
+	return written, hash, sync.dir(dst)
+}
+
+// removeUnencoded removes a file left at the plain path of dst by a copy
+// that did not go through codec, once stored holds the encoded copy.
+func removeUnencoded(codec storageCodec, dst, stored string) error {
+	plain, err := codec.dirPath(dst)
+	if err != nil || plain == stored {
+		return err
+	}
+	info, err := os.Lstat(plain)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil || info.IsDir() {
+		return err
+	}
+	return os.Remove(plain)
+}
@@ -0,0 +1,325 @@
+package worker
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+// Compressed destination files are stored under their name plus a suffix
+// naming the codec. Gzip files are standard gzip members whose header extra
+// field records the original size, so gunzip can read them too. Flate files
+// start with flateMagic and the original size, followed by the raw deflate
+// stream and the CRC-32 of the content.
+const (
+	gzipSuffix  = ".gz"
+	flateSuffix = ".flate"
+	flateMagic  = "SYNCFLT\x01"
+	// gzipSizeField identifies the gzip extra subfield holding the size.
+	gzipSizeField = "SY"
+)
+
+// Compressor maps a destination tree onto one whose files are stored
+// compressed below Root. Directories keep their names.
+type Compressor struct {
+	// Root is the destination root holding the compressed tree.
+	Root string
+	// Codec is task.CompressionGzip or task.CompressionFlate.
+	Codec string
+}
+
+// NewCompressor returns a Compressor for root using codec.
+func NewCompressor(root, codec string) (*Compressor, error) {
+	if compressionSuffix(codec) == "" {
+		return nil, fmt.Errorf("unknown destination compression %q", codec)
+	}
+	return &Compressor{Root: root, Codec: codec}, nil
+}
+
+func compressionSuffix(codec string) string {
+	switch codec {
+	case task.CompressionGzip:
+		return gzipSuffix
+	case task.CompressionFlate:
+		return flateSuffix
+	default:
+		return ""
+	}
+}
+
+// codecForName returns the codec a stored name was written with and the
+// name without its suffix.
+func codecForName(name string) (string, string, bool) {
+	if plain, ok := strings.CutSuffix(name, gzipSuffix); ok && plain != "" {
+		return task.CompressionGzip, plain, true
+	}
+	if plain, ok := strings.CutSuffix(name, flateSuffix); ok && plain != "" {
+		return task.CompressionFlate, plain, true
+	}
+	return "", "", false
+}
+
+// Path returns where the destination file path is stored.
+func (c *Compressor) Path(path string) (string, error) {
+	return path + compressionSuffix(c.Codec), nil
+}
+
+func (c *Compressor) dirPath(path string) (string, error) {
+	return path, nil
+}
+
+func (c *Compressor) kind() string { return "compressed" }
+
+func (c *Compressor) encode(w io.Writer, info fs.FileInfo) (io.WriteCloser, error) {
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(info.Size()))
+	switch c.Codec {
+	case task.CompressionGzip:
+		zw := gzip.NewWriter(w)
+		zw.Name = info.Name()
+		zw.ModTime = info.ModTime()
+		zw.Extra = append([]byte{gzipSizeField[0], gzipSizeField[1], byte(len(size)), 0}, size[:]...)
+		return zw, nil
+	case task.CompressionFlate:
+		if _, err := io.WriteString(w, flateMagic); err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(size[:]); err != nil {
+			return nil, err
+		}
+		fw, err := flate.NewWriter(w, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		return &flateFileWriter{w: w, fw: fw, crc: crc32.NewIEEE()}, nil
+	default:
+		return nil, fmt.Errorf("unknown destination compression %q", c.Codec)
+	}
+}
+
+// flateFileWriter compresses into the flate container and appends the
+// CRC-32 trailer on Close.
+type flateFileWriter struct {
+	w   io.Writer
+	fw  *flate.Writer
+	crc hash.Hash32
+}
+
+func (f *flateFileWriter) Write(p []byte) (int, error) {
+	f.crc.Write(p)
+	return f.fw.Write(p)
+}
+
+func (f *flateFileWriter) Close() error {
+	if err := f.fw.Close(); err != nil {
+		return err
+	}
+	return binary.Write(f.w, binary.BigEndian, f.crc.Sum32())
+}
+
+// originalSize reads the size recorded in the header of a stored file.
+func originalSize(path, codec string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	switch codec {
+	case task.CompressionGzip:
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, err
+		}
+		return gzipOriginalSize(zr.Header)
+	default:
+		var header [len(flateMagic) + 8]byte
+		if _, err := io.ReadFull(f, header[:]); err != nil || string(header[:len(flateMagic)]) != flateMagic {
+			return 0, errors.New("missing flate header")
+		}
+		return int64(binary.BigEndian.Uint64(header[len(flateMagic):])), nil
+	}
+}
+
+func gzipOriginalSize(h gzip.Header) (int64, error) {
+	extra := h.Extra
+	for len(extra) >= 4 {
+		n := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if len(extra) < 4+n {
+			break
+		}
+		if string(extra[:2]) == gzipSizeField && n == 8 {
+			return int64(binary.BigEndian.Uint64(extra[4:12])), nil
+		}
+		extra = extra[4+n:]
+	}
+	return 0, errors.New("gzip header records no original size")
+}
+
+// List implements scanner.Lister, presenting the compressed tree below root
+// with original names and sizes so it can be compared against the source.
+// Every file's header is read to find its size. Files that were not written
+// with the codec are listed under their own name with a size of -1, so they
+// are seen as stale and get replaced, or deleted in mirror mode. Temporary
+// files of interrupted writes are skipped.
+func (c *Compressor) List(root string) (map[string]fs.FileInfo, map[string]fs.FileInfo, error) {
+	files := make(map[string]fs.FileInfo)
+	dirs := make(map[string]fs.FileInfo)
+	suffix := compressionSuffix(c.Codec)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if path == root || isStoredTemp(d) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			dirs[rel] = info
+			return nil
+		}
+		plain, ok := strings.CutSuffix(rel, suffix)
+		if !ok || !d.Type().IsRegular() {
+			// A compressed file of the same name sorts after this one
+			// and takes precedence.
+			if _, seen := files[rel]; !seen {
+				files[rel] = plainInfo{FileInfo: info, name: d.Name(), size: -1}
+			}
+			return nil
+		}
+		size, err := originalSize(path, c.Codec)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		files[plain] = plainInfo{FileInfo: info, name: filepath.Base(plain), size: size}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return files, dirs, nil
+	}
+	return files, dirs, err
+}
+
+// DecompressTree restores every file of the compressed tree at src into
+// dst, removing the codec suffix and keeping modes and modification times.
+// Sizes and checksums are verified, and temporary files of interrupted writes
+// are skipped. It returns the number of files restored.
+func DecompressTree(src, dst string) (int, error) {
+	restored := 0
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if isStoredTemp(d) {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0o755)
+		}
+		codec, plain, ok := codecForName(rel)
+		if !ok || !d.Type().IsRegular() {
+			return fmt.Errorf("%s is not a compressed file", path)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if err := decompressFile(path, filepath.Join(dst, plain), codec, info); err != nil {
+			return fmt.Errorf("decompressing %s: %w", rel, err)
+		}
+		restored++
+		return nil
+	})
+	return restored, err
+}
+
+func decompressFile(src, dst, codec string, info fs.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	err = decompress(out, in, codec)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Chtimes(dst, time.Now(), info.ModTime())
+}
+
+// decompress writes the content of a stored file to dst, checking it
+// against the recorded size and checksum.
+func decompress(dst io.Writer, src io.Reader, codec string) error {
+	var (
+		want    int64
+		written int64
+	)
+	switch codec {
+	case task.CompressionGzip:
+		zr, err := gzip.NewReader(src)
+		if err != nil {
+			return err
+		}
+		// Only the first member is read; the gzip reader checks its CRC.
+		zr.Multistream(false)
+		if want, err = gzipOriginalSize(zr.Header); err != nil {
+			return err
+		}
+		if written, err = io.Copy(dst, zr); err != nil {
+			return fmt.Errorf("%w: %v", ErrIntegrity, err)
+		}
+	default:
+		br := bufio.NewReader(src)
+		var header [len(flateMagic) + 8]byte
+		if _, err := io.ReadFull(br, header[:]); err != nil || string(header[:len(flateMagic)]) != flateMagic {
+			return errors.New("missing flate header")
+		}
+		want = int64(binary.BigEndian.Uint64(header[len(flateMagic):]))
+		crc := crc32.NewIEEE()
+		// br is an io.ByteReader, so the decompressor stops exactly at the
+		// end of the deflate stream and the trailer can be read after it.
+		fr := flate.NewReader(br)
+		var err error
+		if written, err = io.Copy(io.MultiWriter(dst, crc), fr); err != nil {
+			return fmt.Errorf("%w: %v", ErrIntegrity, err)
+		}
+		var sum uint32
+		if err := binary.Read(br, binary.BigEndian, &sum); err != nil || sum != crc.Sum32() {
+			return fmt.Errorf("%w: checksum mismatch", ErrIntegrity)
+		}
+	}
+	if written != want {
+		return fmt.Errorf("%w: decompressed %d bytes, header records %d", ErrIntegrity, written, want)
+	}
+	return nil
+}
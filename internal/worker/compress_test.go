@@ -0,0 +1,238 @@
+package worker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+func TestPoolWritesCompressedDestination(t *testing.T) {
+	content := strings.Repeat("compressible content ", 4096)
+	mtime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, codec := range []string{task.CompressionGzip, task.CompressionFlate} {
+		t.Run(codec, func(t *testing.T) {
+			srcDir := t.TempDir()
+			dstDir := t.TempDir()
+			for rel, data := range map[string]string{"a.txt": content, filepath.Join("dir", "b.txt"): "beta", "empty": ""} {
+				path := filepath.Join(srcDir, rel)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatalf("failed to create parent: %v", err)
+				}
+				if err := os.WriteFile(path, []byte(data), 0o640); err != nil {
+					t.Fatalf("failed to write %s: %v", path, err)
+				}
+				if err := os.Chtimes(path, mtime, mtime); err != nil {
+					t.Fatalf("failed to set mtime: %v", err)
+				}
+			}
+			comp, err := NewCompressor(dstDir, codec)
+			if err != nil {
+				t.Fatalf("NewCompressor returned error: %v", err)
+			}
+
+			pool := New(2, false, 0)
+			pool.Compression = comp
+			report, err := pool.Run(taskChannel(
+				task.Task{Action: task.ActionCopy, Src: filepath.Join(srcDir, "a.txt"), Dst: filepath.Join(dstDir, "a.txt")},
+				task.Task{Action: task.ActionCopy, Src: filepath.Join(srcDir, "empty"), Dst: filepath.Join(dstDir, "empty")},
+				task.Task{Action: task.ActionCopyBatch, Batch: &task.CopyBatchPayload{Entries: []task.CopyBatchEntry{
+					{Source: filepath.Join(srcDir, "dir", "b.txt"), Destination: filepath.Join(dstDir, "dir", "b.txt"), Size: 4},
+				}}},
+			))
+			if err != nil {
+				t.Fatalf("Run returned error: %v", err)
+			}
+			if report.TotalBytes() != int64(len(content)+len("beta")) {
+				t.Fatalf("expected plain bytes in the report, got %d", report.TotalBytes())
+			}
+			for _, copy := range report.Copies() {
+				if copy.Source == filepath.Join(srcDir, "a.txt") && copy.Hash != sha256Hex(content) {
+					t.Fatalf("expected the plain hash, got %s", copy.Hash)
+				}
+			}
+
+			stored, _ := comp.Path(filepath.Join(dstDir, "a.txt"))
+			info, err := os.Stat(stored)
+			if err != nil {
+				t.Fatalf("expected %s to exist: %v", stored, err)
+			}
+			if info.Size() >= int64(len(content)) {
+				t.Fatalf("expected the stored file to be compressed, got %d bytes", info.Size())
+			}
+
+			files, dirs, err := comp.List(dstDir)
+			if err != nil {
+				t.Fatalf("List returned error: %v", err)
+			}
+			if _, ok := dirs["dir"]; !ok || len(files) != 3 {
+				t.Fatalf("unexpected listing: files %v, dirs %v", files, dirs)
+			}
+			if got := files["a.txt"]; got.Size() != int64(len(content)) || !got.ModTime().Equal(mtime) || got.Name() != "a.txt" {
+				t.Fatalf("unexpected original info: %s %d %s", got.Name(), got.Size(), got.ModTime())
+			}
+
+			out := t.TempDir()
+			if n, err := DecompressTree(dstDir, out); err != nil || n != 3 {
+				t.Fatalf("DecompressTree returned %d, %v", n, err)
+			}
+			assertContents(t, filepath.Join(out, "a.txt"), content)
+			assertContents(t, filepath.Join(out, "dir", "b.txt"), "beta")
+			if info, err := os.Stat(filepath.Join(out, "a.txt")); err != nil || !info.ModTime().Equal(mtime) || info.Mode().Perm() != 0o640 {
+				t.Fatalf("expected mode and mtime to be restored, got %v, %v", info, err)
+			}
+
+			if _, err := pool.Run(taskChannel(
+				task.Task{Action: task.ActionDelete, Dst: filepath.Join(dstDir, "a.txt")},
+				task.Task{Action: task.ActionDelete, Dst: filepath.Join(dstDir, "dir")},
+			)); err != nil {
+				t.Fatalf("delete returned error: %v", err)
+			}
+			if files, dirs, err := comp.List(dstDir); err != nil || len(files) != 1 || len(dirs) != 0 {
+				t.Fatalf("expected only the empty file after the deletes, got %v %v, %v", files, dirs, err)
+			}
+		})
+	}
+}
+
+func TestCompressedListReplacesUnsuffixedFiles(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	src := filepath.Join(srcDir, "a.txt")
+	dst := filepath.Join(dstDir, "a.txt")
+	if err := os.WriteFile(src, []byte("alpha"), 0o644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	// Left behind by an earlier sync without compression.
+	if err := os.WriteFile(dst, []byte("alpha"), 0o644); err != nil {
+		t.Fatalf("failed to write destination: %v", err)
+	}
+	comp, err := NewCompressor(dstDir, task.CompressionGzip)
+	if err != nil {
+		t.Fatalf("NewCompressor returned error: %v", err)
+	}
+
+	files, _, err := comp.List(dstDir)
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if got, ok := files["a.txt"]; !ok || got.Size() != -1 {
+		t.Fatalf("expected the uncompressed file to be listed as stale, got %v", files)
+	}
+
+	pool := New(1, false, 0)
+	pool.Compression = comp
+	if _, err := pool.Run(taskChannel(task.Task{Action: task.ActionCopy, Src: src, Dst: dst})); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if _, err := os.Lstat(dst); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the uncompressed file to be replaced, got %v", err)
+	}
+	files, _, err = comp.List(dstDir)
+	if err != nil || files["a.txt"].Size() != 5 {
+		t.Fatalf("expected the compressed copy to be listed, got %v, %v", files, err)
+	}
+}
+
+func TestCompressedTreeSkipsInterruptedWrites(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	src := filepath.Join(srcDir, "a.txt")
+	if err := os.WriteFile(src, []byte("alpha"), 0o644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	comp, err := NewCompressor(dstDir, task.CompressionGzip)
+	if err != nil {
+		t.Fatalf("NewCompressor returned error: %v", err)
+	}
+	pool := New(1, false, 0)
+	pool.Compression = comp
+	if _, err := pool.Run(taskChannel(task.Task{Action: task.ActionCopy, Src: src, Dst: filepath.Join(dstDir, "a.txt")})); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	// Left behind by a run that was killed while writing.
+	if err := os.WriteFile(filepath.Join(dstDir, storedTempPrefix+"123"), []byte("partial"), 0o600); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+
+	files, _, err := comp.List(dstDir)
+	if err != nil || len(files) != 1 || files["a.txt"] == nil {
+		t.Fatalf("expected only a.txt to be listed, got %v, %v", files, err)
+	}
+	out := t.TempDir()
+	if n, err := DecompressTree(dstDir, out); err != nil || n != 1 {
+		t.Fatalf("DecompressTree returned %d, %v", n, err)
+	}
+	assertContents(t, filepath.Join(out, "a.txt"), "alpha")
+}
+
+func TestCompressedFilesAreStandardGzip(t *testing.T) {
+	comp, err := NewCompressor(t.TempDir(), task.CompressionGzip)
+	if err != nil {
+		t.Fatalf("NewCompressor returned error: %v", err)
+	}
+	src := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(src, []byte("hello"), 0o644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatalf("failed to stat source: %v", err)
+	}
+	var buf bytes.Buffer
+	w, err := comp.encode(&buf, info)
+	if err != nil {
+		t.Fatalf("encode returned error: %v", err)
+	}
+	io.WriteString(w, "hello")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("gzip.NewReader returned error: %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil || string(data) != "hello" || zr.Name != "notes.txt" {
+		t.Fatalf("unexpected gzip member: %q %q, %v", data, zr.Name, err)
+	}
+}
+
+func TestDecompressDetectsCorruption(t *testing.T) {
+	comp, err := NewCompressor(t.TempDir(), task.CompressionFlate)
+	if err != nil {
+		t.Fatalf("NewCompressor returned error: %v", err)
+	}
+	src := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(src, []byte("payload"), 0o644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatalf("failed to stat source: %v", err)
+	}
+	var buf bytes.Buffer
+	w, err := comp.encode(&buf, info)
+	if err != nil {
+		t.Fatalf("encode returned error: %v", err)
+	}
+	io.WriteString(w, "payload")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	if err := decompress(io.Discard, bytes.NewReader(buf.Bytes()), task.CompressionFlate); err != nil {
+		t.Fatalf("decompress returned error: %v", err)
+	}
+	corrupt := append([]byte(nil), buf.Bytes()...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	if err := decompress(io.Discard, bytes.NewReader(corrupt), task.CompressionFlate); !errors.Is(err, ErrIntegrity) {
+		t.Fatalf("expected ErrIntegrity, got %v", err)
+	}
+}
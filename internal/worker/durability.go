@@ -0,0 +1,82 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Durability controls how hard the executor works to make written data
+// survive a crash or power loss.
+type Durability string
+
+const (
+	// DurabilityNone leaves flushing to the operating system.
+	DurabilityNone Durability = "none"
+	// DurabilityFile fsyncs every file after it has been written.
+	DurabilityFile Durability = "file"
+	// DurabilityFileDir fsyncs every file and then its parent directory so
+	// the directory entry created by the copy is durable as well.
+	DurabilityFileDir Durability = "file+dir"
+	// DurabilitySyncFS skips per-file syncs and flushes the filesystems
+	// holding the destination roots once all tasks have completed. Where a
+	// single filesystem cannot be flushed it behaves like DurabilityFile.
+	DurabilitySyncFS Durability = "syncfs"
+)
+
+// ParseDurability validates a durability mode name. The empty string selects
+// DurabilityFile, which keeps copies as durable as they have always been.
+func ParseDurability(s string) (Durability, error) {
+	switch mode := Durability(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "":
+		return DurabilityFile, nil
+	case DurabilityNone, DurabilityFile, DurabilityFileDir, DurabilitySyncFS:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown durability mode %q", s)
+	}
+}
+
+// syncer applies the per-file part of a durability mode for one task and
+// accumulates the time spent waiting on the storage.
+type syncer struct {
+	mode Durability
+	// skipDirs disables directory syncs for destinations that are not on
+	// the local filesystem.
+	skipDirs bool
+	elapsed  time.Duration
+}
+
+// file flushes f's data when the mode asks for per-file syncs.
+func (s *syncer) file(f interface{ Sync() error }) error {
+	if s == nil || (s.mode != DurabilityFile && s.mode != DurabilityFileDir) {
+		return nil
+	}
+	start := time.Now()
+	err := f.Sync()
+	s.elapsed += time.Since(start)
+	return err
+}
+
+// dir flushes the directory holding path so a created, renamed or removed
+// entry is durable.
+func (s *syncer) dir(path string) error {
+	// Windows cannot open directories for syncing; NTFS journals metadata.
+	if s == nil || s.mode != DurabilityFileDir || s.skipDirs || runtime.GOOS == "windows" {
+		return nil
+	}
+	start := time.Now()
+	defer func() { s.elapsed += time.Since(start) }()
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,43 @@
+//go:build linux
+
+package worker
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const syncFSSupported = true
+
+// syncFilesystems flushes the filesystem holding each root with syncfs(2),
+// leaving unrelated mounts alone. A root that does not exist yet is flushed
+// through its nearest existing parent.
+func syncFilesystems(roots []string) error {
+	var errs []error
+	for _, root := range roots {
+		if err := syncFS(root); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func syncFS(root string) error {
+	path := filepath.Clean(root)
+	f, err := os.Open(path)
+	for errors.Is(err, fs.ErrNotExist) && filepath.Dir(path) != path {
+		path = filepath.Dir(path)
+		f, err = os.Open(path)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, _, errno := syscall.Syscall(sysSyncFS, f.Fd(), 0, 0); errno != 0 {
+		return &os.PathError{Op: "syncfs", Path: path, Err: errno}
+	}
+	return nil
+}
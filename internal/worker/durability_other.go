@@ -0,0 +1,13 @@
+//go:build !linux
+
+package worker
+
+import "errors"
+
+// Only Linux can flush a single filesystem; elsewhere DurabilitySyncFS falls
+// back to syncing every file as it is written.
+const syncFSSupported = false
+
+func syncFilesystems(roots []string) error {
+	return errors.New("flushing a single filesystem is not supported on this platform")
+}
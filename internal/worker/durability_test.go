@@ -0,0 +1,98 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+func TestRunTaskDurabilityModes(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.txt")
+	if err := os.WriteFile(src, []byte("durable"), 0o644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	for _, mode := range []Durability{DurabilityNone, DurabilityFile, DurabilityFileDir} {
+		for _, bandwidth := range []int64{0, 1 << 20} {
+			exec := NewExecutor(false, bandwidth)
+			exec.Durability = mode
+			dst := filepath.Join(dir, "dst", string(mode)+".txt")
+			res, err := exec.RunTask(task.Task{Action: task.ActionCopy, Src: src, Dst: dst})
+			if err != nil {
+				t.Fatalf("%s: RunTask returned error: %v", mode, err)
+			}
+			if mode == DurabilityNone && res.SyncDuration != 0 {
+				t.Fatalf("%s: expected no fsync time, got %s", mode, res.SyncDuration)
+			}
+			if mode != DurabilityNone && res.SyncDuration <= 0 {
+				t.Fatalf("%s (bandwidth %d): expected fsync time to be recorded", mode, bandwidth)
+			}
+			if res.SyncDuration > res.Duration {
+				t.Fatalf("%s: fsync time %s exceeds task duration %s", mode, res.SyncDuration, res.Duration)
+			}
+		}
+	}
+}
+
+func TestPoolSyncFSFlushesAtEnd(t *testing.T) {
+	if !syncFSSupported {
+		t.Skip("flushing a single filesystem is not supported on this platform")
+	}
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	src := filepath.Join(srcDir, "a.txt")
+	if err := os.WriteFile(src, []byte("alpha"), 0o644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	pool := New(1, false, 0)
+	pool.Durability = DurabilitySyncFS
+	pool.DestinationRoots = []string{dstDir}
+	report, err := pool.Run(taskChannel(task.Task{Action: task.ActionCopy, Src: src, Dst: filepath.Join(dstDir, "a.txt")}))
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if report.copies[0].SyncDuration != 0 {
+		t.Fatalf("syncfs mode should not fsync individual files")
+	}
+	if report.finalSync <= 0 {
+		t.Fatalf("expected the final flush to be timed")
+	}
+}
+
+func TestSyncFSWithoutRootsSyncsEachFile(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	src := filepath.Join(srcDir, "a.txt")
+	if err := os.WriteFile(src, []byte("alpha"), 0o644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	pool := New(1, false, 0)
+	pool.Durability = DurabilitySyncFS
+	report, err := pool.Run(taskChannel(task.Task{Action: task.ActionCopy, Src: src, Dst: filepath.Join(dstDir, "a.txt")}))
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if report.copies[0].SyncDuration <= 0 {
+		t.Fatalf("expected the file to be synced when no filesystem can be flushed")
+	}
+	if report.finalSync != 0 {
+		t.Fatalf("expected no final flush without destination roots, got %s", report.finalSync)
+	}
+}
+
+func TestParseDurability(t *testing.T) {
+	for in, want := range map[string]Durability{"": DurabilityFile, "none": DurabilityNone, "FILE": DurabilityFile, "file+dir": DurabilityFileDir, "syncfs": DurabilitySyncFS} {
+		got, err := ParseDurability(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseDurability(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseDurability("always"); err == nil {
+		t.Fatal("expected error for unknown mode")
+	}
+}
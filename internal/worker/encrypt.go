@@ -0,0 +1,549 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Encrypted files start with encryptMagic and a random salt from which the
+// file's AES-256-GCM key is derived. The plaintext follows in chunks of
+// encryptChunkSize bytes, each sealed separately with a nonce made of the
+// chunk counter and a flag marking the final chunk, so truncated, reordered
+// or spliced files fail to decrypt.
+const (
+	encryptMagic      = "SYNCENC\x01"
+	encryptSaltSize   = 32
+	encryptHeaderSize = len(encryptMagic) + encryptSaltSize
+	encryptChunkSize  = 64 << 10
+	encryptTagSize    = 16
+	// EncryptionMarker is the file in an encrypted destination root that
+	// records whether names are encrypted and lets a wrong key be detected
+	// before anything is written.
+	EncryptionMarker = ".syncopa-encryption.json"
+	markerCheck      = "syncopa encryption check"
+)
+
+// ErrWrongKey is returned when an encrypted destination was written with a
+// different key.
+var ErrWrongKey = errors.New("encryption key does not match the destination")
+
+// ErrNameTooLong is returned when a name would not fit into a single path
+// component once encrypted.
+var ErrNameTooLong = errors.New("name is too long to encrypt")
+
+// nameEncoding is case-insensitive so encrypted names survive filesystems
+// that fold case.
+var nameEncoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// maxStoredName is the NAME_MAX of common filesystems. Longer encrypted names
+// cannot be created.
+const maxStoredName = 255
+
+// Encryptor maps a plaintext destination tree onto an encrypted one below
+// Root. File contents are always encrypted; names are encrypted when
+// EncryptNames is set, deterministically so the same path always maps to the
+// same stored name.
+type Encryptor struct {
+	// Root is the destination root holding the encrypted tree.
+	Root string
+	// EncryptNames encrypts every path component below Root.
+	EncryptNames bool
+
+	contentKey []byte
+	nameKey    []byte
+	nameIVKey  []byte
+}
+
+type encryptionMarker struct {
+	Version      int    `json:"version"`
+	EncryptNames bool   `json:"encrypt_names"`
+	Check        string `json:"check"`
+}
+
+// LoadEncryptionKey reads a 256-bit key from file, or from the environment
+// variable env when file is empty. The key may be given as 32 raw bytes, 64
+// hex digits or standard base64.
+func LoadEncryptionKey(file, env string) ([]byte, error) {
+	var data []byte
+	switch {
+	case file != "":
+		var err error
+		if data, err = os.ReadFile(file); err != nil {
+			return nil, err
+		}
+	case env != "":
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", env)
+		}
+		data = []byte(value)
+	default:
+		return nil, errors.New("no encryption key source given")
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("encryption key must be 32 bytes, given raw, as 64 hex digits or as base64")
+}
+
+// NewEncryptor returns an Encryptor for root using the 256-bit key.
+func NewEncryptor(root string, key []byte, encryptNames bool) (*Encryptor, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	return &Encryptor{
+		Root:         root,
+		EncryptNames: encryptNames,
+		contentKey:   deriveKey(key, "syncopa content"),
+		nameKey:      deriveKey(key, "syncopa names"),
+		nameIVKey:    deriveKey(key, "syncopa name iv"),
+	}, nil
+}
+
+// OpenEncryptor returns an Encryptor for an existing encrypted tree, taking
+// the name mode from its marker and verifying the key.
+func OpenEncryptor(root string, key []byte) (*Encryptor, error) {
+	marker, err := readEncryptionMarker(root)
+	if err != nil {
+		return nil, err
+	}
+	if marker == nil {
+		return nil, fmt.Errorf("%s is not an encrypted destination (no %s)", root, EncryptionMarker)
+	}
+	e, err := NewEncryptor(root, key, marker.EncryptNames)
+	if err != nil {
+		return nil, err
+	}
+	return e, e.verify(marker)
+}
+
+// Prepare verifies the key against the marker in Root, writing the marker
+// when Root holds none yet. A marker recording a different name mode is an
+// error because the existing names would no longer be recognised.
+func (e *Encryptor) Prepare() error {
+	marker, err := readEncryptionMarker(e.Root)
+	if err != nil {
+		return err
+	}
+	if marker != nil {
+		if marker.EncryptNames != e.EncryptNames {
+			return fmt.Errorf("%s was written with encrypt_names=%t", e.Root, marker.EncryptNames)
+		}
+		return e.verify(marker)
+	}
+	var check bytes.Buffer
+	w, err := e.newWriter(&check)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, markerCheck); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(encryptionMarker{
+		Version:      1,
+		EncryptNames: e.EncryptNames,
+		Check:        base64.StdEncoding.EncodeToString(check.Bytes()),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(e.Root, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(e.Root, EncryptionMarker), data, 0o644)
+}
+
+func readEncryptionMarker(root string) (*encryptionMarker, error) {
+	data, err := os.ReadFile(filepath.Join(root, EncryptionMarker))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var marker encryptionMarker
+	if err := json.Unmarshal(data, &marker); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", EncryptionMarker, err)
+	}
+	if marker.Version != 1 {
+		return nil, fmt.Errorf("%s has unsupported version %d", EncryptionMarker, marker.Version)
+	}
+	return &marker, nil
+}
+
+func (e *Encryptor) verify(marker *encryptionMarker) error {
+	check, err := base64.StdEncoding.DecodeString(marker.Check)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", EncryptionMarker, err)
+	}
+	var plain bytes.Buffer
+	if _, err := e.decrypt(&plain, bytes.NewReader(check)); err != nil || plain.String() != markerCheck {
+		return fmt.Errorf("%w %s", ErrWrongKey, e.Root)
+	}
+	return nil
+}
+
+func deriveKey(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce builds the nonce of chunk counter; the last byte flags the
+// final chunk.
+func chunkNonce(counter uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptWriter encrypts everything written to it. Close seals the final
+// chunk and must be called.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+}
+
+func (e *Encryptor) newWriter(w io.Writer) (*encryptWriter, error) {
+	salt := make([]byte, encryptSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(deriveKey(e.contentKey, string(salt)))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, encryptMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, buf: make([]byte, 0, encryptChunkSize+1)}, nil
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// Keep a full chunk buffered until more data arrives so the last
+		// chunk can be flagged as final on Close.
+		if len(w.buf) == encryptChunkSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):encryptChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *encryptWriter) Close() error {
+	return w.seal(true)
+}
+
+func (w *encryptWriter) seal(final bool) error {
+	sealed := w.aead.Seal(nil, chunkNonce(w.counter, final), w.buf, nil)
+	w.counter++
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(sealed)
+	return err
+}
+
+// decrypt writes the plaintext of an encrypted stream to dst and returns
+// its size. Any tampering, truncation or trailing data is an error.
+func (e *Encryptor) decrypt(dst io.Writer, src io.Reader) (int64, error) {
+	header := make([]byte, encryptHeaderSize)
+	if _, err := io.ReadFull(src, header); err != nil || string(header[:len(encryptMagic)]) != encryptMagic {
+		return 0, errors.New("not an encrypted file")
+	}
+	aead, err := newGCM(deriveKey(e.contentKey, string(header[len(encryptMagic):])))
+	if err != nil {
+		return 0, err
+	}
+	r := bufio.NewReaderSize(src, encryptChunkSize+encryptTagSize+1)
+	chunk := make([]byte, encryptChunkSize+encryptTagSize)
+	var total int64
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(r, chunk)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return total, err
+		}
+		final := n < len(chunk)
+		if !final {
+			if _, err := r.Peek(1); errors.Is(err, io.EOF) {
+				final = true
+			}
+		}
+		plain, err := aead.Open(chunk[:0], chunkNonce(counter, final), chunk[:n], nil)
+		if err != nil {
+			return total, fmt.Errorf("%w: encrypted data is corrupt or truncated", ErrIntegrity)
+		}
+		if _, err := dst.Write(plain); err != nil {
+			return total, err
+		}
+		total += int64(len(plain))
+		if final {
+			return total, nil
+		}
+	}
+}
+
+// plaintextSize derives the plaintext size from the size of an encrypted
+// file without reading it.
+func plaintextSize(size int64) (int64, bool) {
+	body := size - int64(encryptHeaderSize)
+	if body < encryptTagSize {
+		return 0, false
+	}
+	full := int64(encryptChunkSize + encryptTagSize)
+	chunks := (body + full - 1) / full
+	plain := body - chunks*encryptTagSize
+	if plain < 0 || (chunks > 1 && plain <= (chunks-1)*encryptChunkSize) {
+		return 0, false
+	}
+	return plain, true
+}
+
+func (e *Encryptor) encryptName(name string) (string, error) {
+	aead, err := newGCM(e.nameKey)
+	if err != nil {
+		return "", err
+	}
+	if limit := nameEncoding.DecodedLen(maxStoredName) - aead.NonceSize() - aead.Overhead(); len(name) > limit {
+		return "", fmt.Errorf("%w: %q has %d bytes, encrypted names hold at most %d", ErrNameTooLong, name, len(name), limit)
+	}
+	mac := hmac.New(sha256.New, e.nameIVKey)
+	mac.Write([]byte(name))
+	nonce := mac.Sum(nil)[:aead.NonceSize()]
+	sealed := aead.Seal(nonce, nonce, []byte(name), nil)
+	return strings.ToLower(nameEncoding.EncodeToString(sealed)), nil
+}
+
+func (e *Encryptor) decryptName(stored string) (string, error) {
+	sealed, err := nameEncoding.DecodeString(strings.ToUpper(stored))
+	if err != nil {
+		return "", fmt.Errorf("%q is not an encrypted name", stored)
+	}
+	aead, err := newGCM(e.nameKey)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("%q is not an encrypted name", stored)
+	}
+	name, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt name %q: %w", stored, ErrWrongKey)
+	}
+	return string(name), nil
+}
+
+// Path returns where the plaintext destination path is stored.
+func (e *Encryptor) Path(path string) (string, error) {
+	return e.dirPath(path)
+}
+
+// dirPath maps directories the same way as files.
+func (e *Encryptor) dirPath(path string) (string, error) {
+	if !e.EncryptNames {
+		return path, nil
+	}
+	rel, err := filepath.Rel(e.Root, path)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." {
+		return e.Root, nil
+	}
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%s is outside the encrypted destination %s", path, e.Root)
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		if parts[i], err = e.encryptName(part); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(e.Root, filepath.Join(parts...)), nil
+}
+
+func (e *Encryptor) encode(w io.Writer, _ fs.FileInfo) (io.WriteCloser, error) {
+	return e.newWriter(w)
+}
+
+func (e *Encryptor) kind() string { return "encrypted" }
+
+// plainRel converts a path relative to the stored tree into the plaintext
+// relative path.
+func (e *Encryptor) plainRel(rel string) (string, error) {
+	if !e.EncryptNames {
+		return rel, nil
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		name, err := e.decryptName(part)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = name
+	}
+	return filepath.Join(parts...), nil
+}
+
+// List implements scanner.Lister, presenting the encrypted tree below root
+// with plaintext names and sizes so it can be compared against the source.
+func (e *Encryptor) List(root string) (map[string]fs.FileInfo, map[string]fs.FileInfo, error) {
+	files := make(map[string]fs.FileInfo)
+	dirs := make(map[string]fs.FileInfo)
+	stored, err := e.Path(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	marker := filepath.Join(e.Root, EncryptionMarker)
+	err = e.walk(stored, func(path, rel string, d fs.DirEntry) error {
+		if path == marker {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			dirs[rel] = info
+			return nil
+		}
+		size, ok := plaintextSize(info.Size())
+		if !ok {
+			return fmt.Errorf("%s is not an encrypted file", path)
+		}
+		files[rel] = plainInfo{FileInfo: info, name: filepath.Base(rel), size: size}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return files, dirs, nil
+	}
+	return files, dirs, err
+}
+
+// walk visits every entry below the stored directory root, passing its
+// plaintext path relative to root. Temporary files of interrupted writes are
+// skipped.
+func (e *Encryptor) walk(root string, fn func(path, rel string, d fs.DirEntry) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if path == root || isStoredTemp(d) {
+			return nil
+		}
+		storedRel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if path == filepath.Join(e.Root, EncryptionMarker) {
+			return fn(path, storedRel, d)
+		}
+		rel, err := e.plainRel(storedRel)
+		if err != nil {
+			return err
+		}
+		return fn(path, rel, d)
+	})
+}
+
+// DecryptTree restores the plaintext of every file in the encrypted tree
+// into dst, keeping modes and modification times, and returns the number of
+// files restored.
+func (e *Encryptor) DecryptTree(dst string) (int, error) {
+	restored := 0
+	err := e.walk(e.Root, func(path, rel string, d fs.DirEntry) error {
+		if path == filepath.Join(e.Root, EncryptionMarker) {
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		if !d.Type().IsRegular() {
+			return fmt.Errorf("%s is not an encrypted file", path)
+		}
+		if err := e.decryptFile(path, target, info); err != nil {
+			return fmt.Errorf("decrypting %s: %w", rel, err)
+		}
+		restored++
+		return nil
+	})
+	return restored, err
+}
+
+func (e *Encryptor) decryptFile(src, dst string, info fs.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = e.decrypt(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Chtimes(dst, time.Now(), info.ModTime())
+}
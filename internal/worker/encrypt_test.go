@@ -0,0 +1,257 @@
+package worker
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+var testEncryptionKey = bytes.Repeat([]byte{0x42}, 32)
+
+func TestEncryptedStreamRoundTrip(t *testing.T) {
+	e, err := NewEncryptor(t.TempDir(), testEncryptionKey, false)
+	if err != nil {
+		t.Fatalf("NewEncryptor returned error: %v", err)
+	}
+	for _, size := range []int{0, 1, encryptChunkSize - 1, encryptChunkSize, encryptChunkSize + 1, 3*encryptChunkSize + 7} {
+		plain := bytes.Repeat([]byte("syncopa"), size/7+1)[:size]
+		var sealed bytes.Buffer
+		w, err := e.newWriter(&sealed)
+		if err != nil {
+			t.Fatalf("newWriter returned error: %v", err)
+		}
+		if _, err := w.Write(plain); err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close returned error: %v", err)
+		}
+		if got, ok := plaintextSize(int64(sealed.Len())); !ok || got != int64(size) {
+			t.Fatalf("plaintextSize(%d) = %d, %t; want %d", sealed.Len(), got, ok, size)
+		}
+
+		var out bytes.Buffer
+		if _, err := e.decrypt(&out, bytes.NewReader(sealed.Bytes())); err != nil {
+			t.Fatalf("decrypt of %d bytes returned error: %v", size, err)
+		}
+		if !bytes.Equal(out.Bytes(), plain) {
+			t.Fatalf("decrypt of %d bytes returned different content", size)
+		}
+		if size > encryptChunkSize {
+			truncated := sealed.Bytes()[:encryptHeaderSize+encryptChunkSize+encryptTagSize]
+			if _, err := e.decrypt(&bytes.Buffer{}, bytes.NewReader(truncated)); !errors.Is(err, ErrIntegrity) {
+				t.Fatalf("expected truncation to be detected, got %v", err)
+			}
+		}
+	}
+}
+
+func TestPoolWritesEncryptedDestination(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	mtime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for rel, data := range map[string]string{"a.txt": "alpha", filepath.Join("dir", "b.txt"): "beta"} {
+		path := filepath.Join(srcDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create parent: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("failed to set mtime: %v", err)
+		}
+	}
+	enc, err := NewEncryptor(dstDir, testEncryptionKey, true)
+	if err != nil {
+		t.Fatalf("NewEncryptor returned error: %v", err)
+	}
+	if err := enc.Prepare(); err != nil {
+		t.Fatalf("Prepare returned error: %v", err)
+	}
+
+	pool := New(2, false, 0)
+	pool.Encryption = enc
+	report, err := pool.Run(taskChannel(
+		task.Task{Action: task.ActionCopy, Src: filepath.Join(srcDir, "a.txt"), Dst: filepath.Join(dstDir, "a.txt")},
+		task.Task{Action: task.ActionCopyBatch, Batch: &task.CopyBatchPayload{Entries: []task.CopyBatchEntry{
+			{Source: filepath.Join(srcDir, "dir", "b.txt"), Destination: filepath.Join(dstDir, "dir", "b.txt"), Size: 4},
+		}}},
+	))
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	for _, copy := range report.Copies() {
+		if copy.Source == filepath.Join(srcDir, "a.txt") && copy.Hash != sha256Hex("alpha") {
+			t.Fatalf("expected the plaintext hash, got %s", copy.Hash)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dstDir, "a.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected the plaintext name to be hidden, got %v", err)
+	}
+	stored, err := enc.Path(filepath.Join(dstDir, "a.txt"))
+	if err != nil {
+		t.Fatalf("Path returned error: %v", err)
+	}
+	data, err := os.ReadFile(stored)
+	if err != nil {
+		t.Fatalf("failed to read stored file: %v", err)
+	}
+	if bytes.Contains(data, []byte("alpha")) {
+		t.Fatalf("stored file contains plaintext")
+	}
+
+	files, dirs, err := enc.List(dstDir)
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if _, ok := dirs["dir"]; !ok || len(files) != 2 {
+		t.Fatalf("unexpected listing: files %v, dirs %v", files, dirs)
+	}
+	if info := files[filepath.Join("dir", "b.txt")]; info.Size() != 4 || !info.ModTime().Equal(mtime) || info.Name() != "b.txt" {
+		t.Fatalf("unexpected plaintext info: %s %d %s", info.Name(), info.Size(), info.ModTime())
+	}
+
+	opened, err := OpenEncryptor(dstDir, testEncryptionKey)
+	if err != nil {
+		t.Fatalf("OpenEncryptor returned error: %v", err)
+	}
+	out := t.TempDir()
+	if n, err := opened.DecryptTree(out); err != nil || n != 2 {
+		t.Fatalf("DecryptTree returned %d, %v", n, err)
+	}
+	assertContents(t, filepath.Join(out, "dir", "b.txt"), "beta")
+	if _, err := os.Stat(filepath.Join(out, EncryptionMarker)); !os.IsNotExist(err) {
+		t.Fatalf("the marker must not be restored, got %v", err)
+	}
+
+	if _, err := pool.Run(taskChannel(task.Task{Action: task.ActionDelete, Dst: filepath.Join(dstDir, "dir")})); err != nil {
+		t.Fatalf("delete returned error: %v", err)
+	}
+	if files, _, err := enc.List(dstDir); err != nil || len(files) != 1 {
+		t.Fatalf("expected one file after the delete, got %v, %v", files, err)
+	}
+}
+
+func TestEncryptedTreeSkipsInterruptedWrites(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	src := filepath.Join(srcDir, "a.txt")
+	if err := os.WriteFile(src, []byte("alpha"), 0o644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	enc, err := NewEncryptor(dstDir, testEncryptionKey, true)
+	if err != nil {
+		t.Fatalf("NewEncryptor returned error: %v", err)
+	}
+	if err := enc.Prepare(); err != nil {
+		t.Fatalf("Prepare returned error: %v", err)
+	}
+	pool := New(1, false, 0)
+	pool.Encryption = enc
+	if _, err := pool.Run(taskChannel(task.Task{Action: task.ActionCopy, Src: src, Dst: filepath.Join(dstDir, "a.txt")})); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	// Left behind by a run that was killed while writing.
+	if err := os.WriteFile(filepath.Join(dstDir, storedTempPrefix+"123"), []byte("partial"), 0o600); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+
+	files, _, err := enc.List(dstDir)
+	if err != nil || len(files) != 1 || files["a.txt"] == nil {
+		t.Fatalf("expected only a.txt to be listed, got %v, %v", files, err)
+	}
+	out := t.TempDir()
+	if n, err := enc.DecryptTree(out); err != nil || n != 1 {
+		t.Fatalf("DecryptTree returned %d, %v", n, err)
+	}
+	assertContents(t, filepath.Join(out, "a.txt"), "alpha")
+}
+
+func TestEncryptorRejectsLongNames(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	long := strings.Repeat("n", 200)
+	src := filepath.Join(srcDir, long)
+	if err := os.WriteFile(src, []byte("alpha"), 0o644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	enc, err := NewEncryptor(dstDir, testEncryptionKey, true)
+	if err != nil {
+		t.Fatalf("NewEncryptor returned error: %v", err)
+	}
+	if err := enc.Prepare(); err != nil {
+		t.Fatalf("Prepare returned error: %v", err)
+	}
+
+	fits := strings.Repeat("n", 131)
+	stored, err := enc.Path(filepath.Join(dstDir, fits))
+	if err != nil || len(filepath.Base(stored)) > 255 {
+		t.Fatalf("expected a %d byte name to be encrypted within 255 bytes, got %d, %v", len(fits), len(filepath.Base(stored)), err)
+	}
+	if _, err := enc.Path(filepath.Join(dstDir, fits+"n")); !errors.Is(err, ErrNameTooLong) {
+		t.Fatalf("expected ErrNameTooLong one byte over the limit, got %v", err)
+	}
+
+	pool := New(1, false, 0)
+	pool.Encryption = enc
+	_, err = pool.Run(taskChannel(task.Task{Action: task.ActionCopy, Src: src, Dst: filepath.Join(dstDir, long)}))
+	if !errors.Is(err, ErrNameTooLong) {
+		t.Fatalf("expected the copy to fail with ErrNameTooLong, got %v", err)
+	}
+	if entries, err := os.ReadDir(dstDir); err != nil || len(entries) != 1 {
+		t.Fatalf("expected only the marker in the destination, got %v, %v", entries, err)
+	}
+}
+
+func TestEncryptorRejectsWrongKey(t *testing.T) {
+	dstDir := t.TempDir()
+	enc, err := NewEncryptor(dstDir, testEncryptionKey, false)
+	if err != nil {
+		t.Fatalf("NewEncryptor returned error: %v", err)
+	}
+	if err := enc.Prepare(); err != nil {
+		t.Fatalf("Prepare returned error: %v", err)
+	}
+	other, err := NewEncryptor(dstDir, bytes.Repeat([]byte{0x17}, 32), false)
+	if err != nil {
+		t.Fatalf("NewEncryptor returned error: %v", err)
+	}
+	if err := other.Prepare(); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("expected ErrWrongKey, got %v", err)
+	}
+	named, err := NewEncryptor(dstDir, testEncryptionKey, true)
+	if err != nil {
+		t.Fatalf("NewEncryptor returned error: %v", err)
+	}
+	if err := named.Prepare(); err == nil || !strings.Contains(err.Error(), "encrypt_names") {
+		t.Fatalf("expected a name mode mismatch, got %v", err)
+	}
+}
+
+func TestLoadEncryptionKey(t *testing.T) {
+	hexKey := strings.Repeat("ab", 32)
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte(hexKey+"\n"), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	key, err := LoadEncryptionKey(path, "")
+	if err != nil || len(key) != 32 || key[0] != 0xab {
+		t.Fatalf("LoadEncryptionKey(file) = %x, %v", key, err)
+	}
+	t.Setenv("SYNCOPA_TEST_KEY", "q83vq83vq83vq83vq83vq83vq83vq83vq83vq83vq80=")
+	if key, err := LoadEncryptionKey("", "SYNCOPA_TEST_KEY"); err != nil || len(key) != 32 {
+		t.Fatalf("LoadEncryptionKey(env) = %x, %v", key, err)
+	}
+	t.Setenv("SYNCOPA_TEST_KEY", "short")
+	if _, err := LoadEncryptionKey("", "SYNCOPA_TEST_KEY"); err == nil {
+		t.Fatalf("expected a short key to be rejected")
+	}
+}
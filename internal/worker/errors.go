@@ -0,0 +1,156 @@
+package worker
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/backend"
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+// ErrTooManyFailures is returned by Pool.Run when the number of failed tasks
+// reaches the configured MaxErrors limit and the run is aborted.
+var ErrTooManyFailures = errors.New("too many failed tasks")
+
+// ErrIntegrity marks data that does not match the size or hash it was
+// announced with.
+var ErrIntegrity = errors.New("integrity check failed")
+
+// ErrInvalidPayload marks tasks that are malformed or would write outside
+// the job's destination roots.
+var ErrInvalidPayload = errors.New("invalid task payload")
+
+// ErrorClass groups task errors into broad categories so reports and retry
+// policies can reason about them without inspecting raw error strings.
+type ErrorClass string
+
+const (
+	// ErrorClassNotFound covers missing sources or destination parents.
+	ErrorClassNotFound ErrorClass = "not_found"
+	// ErrorClassPermission covers EACCES/EPERM style failures.
+	ErrorClassPermission ErrorClass = "permission"
+	// ErrorClassNoSpace covers ENOSPC and quota exhaustion.
+	ErrorClassNoSpace ErrorClass = "no_space"
+	// ErrorClassIO covers low level I/O errors reported by the device.
+	ErrorClassIO ErrorClass = "io"
+	// ErrorClassStale covers stale NFS file handles.
+	ErrorClassStale ErrorClass = "stale"
+	// ErrorClassTimeout covers timed out operations.
+	ErrorClassTimeout ErrorClass = "timeout"
+	// ErrorClassBusy covers resources that are temporarily unavailable.
+	ErrorClassBusy ErrorClass = "busy"
+	// ErrorClassIntegrity covers size and hash mismatches.
+	ErrorClassIntegrity ErrorClass = "integrity"
+	// ErrorClassInvalid covers malformed or unsafe task payloads.
+	ErrorClassInvalid ErrorClass = "invalid"
+	// ErrorClassOther is used when no more specific class applies.
+	ErrorClassOther ErrorClass = "other"
+)
+
+// ClassifyError maps err onto an ErrorClass and extracts the underlying errno
+// when one is available. The errno is zero for errors that did not originate
+// from a system call.
+func ClassifyError(err error) (ErrorClass, int) {
+	if err == nil {
+		return "", 0
+	}
+	switch {
+	case errors.Is(err, ErrIntegrity):
+		return ErrorClassIntegrity, 0
+	case errors.Is(err, ErrInvalidPayload):
+		return ErrorClassInvalid, 0
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return classifyErrno(errno), int(errno)
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return ErrorClassNotFound, 0
+	case errors.Is(err, fs.ErrPermission):
+		return ErrorClassPermission, 0
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrorClassTimeout, 0
+	case errors.Is(err, backend.ErrUnavailable):
+		return ErrorClassBusy, 0
+	}
+	return ErrorClassOther, 0
+}
+
+func classifyErrno(errno syscall.Errno) ErrorClass {
+	switch errno {
+	case syscall.ENOENT, syscall.ENOTDIR:
+		return ErrorClassNotFound
+	case syscall.EACCES, syscall.EPERM, syscall.EROFS:
+		return ErrorClassPermission
+	case syscall.ENOSPC, syscall.EDQUOT:
+		return ErrorClassNoSpace
+	case syscall.EIO:
+		return ErrorClassIO
+	case syscall.ESTALE:
+		return ErrorClassStale
+	case syscall.ETIMEDOUT:
+		return ErrorClassTimeout
+	case syscall.EAGAIN, syscall.EBUSY, syscall.EINTR:
+		return ErrorClassBusy
+	default:
+		return ErrorClassOther
+	}
+}
+
+// TaskFailure records a task that could not be completed along with enough
+// context to diagnose why.
+type TaskFailure struct {
+	Action       task.Action
+	Source       string
+	Destination  string
+	Error        string
+	Class        ErrorClass
+	Errno        int
+	Attempts     int
+	FailedAt     time.Time
+	BatchEntries []task.CopyBatchEntry
+}
+
+// NewTaskFailure builds a TaskFailure for t from the error returned by the
+// executor. It is exported for distributed orchestrators that receive
+// failures from remote agents.
+func NewTaskFailure(t task.Task, err error, attempts int) TaskFailure {
+	class, errno := ClassifyError(err)
+	if attempts <= 0 {
+		attempts = 1
+	}
+	failure := TaskFailure{
+		Action:      t.Action,
+		Source:      t.Src,
+		Destination: t.Dst,
+		Class:       class,
+		Errno:       errno,
+		Attempts:    attempts,
+		FailedAt:    time.Now(),
+	}
+	if err != nil {
+		failure.Error = err.Error()
+	}
+	if t.Batch != nil && len(t.Batch.Entries) > 0 {
+		failure.BatchEntries = append([]task.CopyBatchEntry(nil), t.Batch.Entries...)
+		if failure.Source == "" {
+			failure.Source = t.Batch.Entries[0].Source
+		}
+		if failure.Destination == "" {
+			failure.Destination = t.Batch.Entries[0].Destination
+		}
+	}
+	return failure
+}
+
+func cloneTaskFailure(src TaskFailure) TaskFailure {
+	dup := src
+	if len(src.BatchEntries) > 0 {
+		dup.BatchEntries = append([]task.CopyBatchEntry(nil), src.BatchEntries...)
+	}
+	return dup
+}
@@ -3,15 +3,24 @@ package worker
 import (
 	"archive/tar"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/syncopasoft/syncopa-core/internal/backend"
+	"github.com/syncopasoft/syncopa-core/internal/repo"
 	"github.com/syncopasoft/syncopa-core/internal/task"
 )
 
@@ -22,6 +31,52 @@ type Executor struct {
 	// BandwidthLimit limits the number of bytes per second used when copying files.
 	// A value <= 0 disables throttling.
 	BandwidthLimit int64
+	// Retry is the retry policy applied to every action without an entry in
+	// ActionRetry. The zero value disables retries.
+	Retry RetryPolicy
+	// ActionRetry overrides Retry for individual actions.
+	ActionRetry map[task.Action]RetryPolicy
+	// DestinationRoots confines every write and delete to paths below one of
+	// these directories. Tasks received from remote orchestrators must set
+	// it; an empty list disables the check.
+	DestinationRoots []string
+	// HashAlgorithm selects the digest recorded for copied content. The zero
+	// value selects HashSHA256; HashNone skips hashing entirely.
+	HashAlgorithm HashAlgorithm
+	// Durability selects when written data is flushed to stable storage. The
+	// zero value leaves flushing to the operating system. DurabilitySyncFS is
+	// completed by calling SyncFilesystems once all tasks have run and needs
+	// DestinationRoots to know which filesystems to flush.
+	Durability Durability
+	// Backup, when set, receives destination files before they are
+	// overwritten.
+	Backup *BackupTree
+	// Trash, when set, receives deleted destination entries instead of
+	// removing them.
+	Trash *BackupTree
+	// Versions, when set, keeps every overwritten or deleted destination
+	// file as a revision. It takes precedence over Backup and Trash.
+	Versions *VersionStore
+	// Repository, when set, receives copies and deletes instead of the
+	// filesystem below the destination: contents are chunked into the
+	// repository and recorded in this run's manifest.
+	Repository *repo.Run
+	// Encryption, when set, stores every copy encrypted below its Root,
+	// optionally under encrypted names. Reported hashes cover the
+	// plaintext.
+	Encryption *Encryptor
+	// Compression, when set, stores every copy compressed below its Root
+	// under a suffix naming the codec.
+	Compression *Compressor
+	// SourceBackend and DestinationBackend hold the trees task sources are
+	// read from and destinations are written to. Nil selects the local
+	// filesystem. Hard links, zero-copy transfers, backups, versions,
+	// repositories, encryption and compression need the local filesystem.
+	SourceBackend      backend.Backend
+	DestinationBackend backend.Backend
+
+	// sleep waits between retries. Tests replace it to avoid real delays.
+	sleep func(time.Duration)
 }
 
 // NewExecutor constructs an Executor configured with the supplied options.
@@ -29,27 +84,115 @@ func NewExecutor(verbose bool, bandwidthLimit int64) *Executor {
 	return &Executor{Verbose: verbose, BandwidthLimit: bandwidthLimit}
 }
 
-// RunTask executes a single task and returns a TaskReport describing the outcome.
+// RunTask executes a single task and returns a TaskReport describing the
+// outcome. Failures classified as retryable by the action's RetryPolicy are
+// attempted again after a backoff. When the task ultimately fails the
+// returned TaskReport is still populated with the attempts made. Tasks that
+// fan out report their first destination and fail when any destination
+// failed; RunFanout returns the outcome of each.
 func (e *Executor) RunTask(t task.Task) (*TaskReport, error) {
+	if FansOut(t) {
+		results := e.RunFanout(t)
+		for _, res := range results {
+			if res.Err != nil {
+				return res.Report, res.Err
+			}
+		}
+		return results[0].Report, nil
+	}
+	// Return the batch archive's share of the memory budget to the scanner.
+	defer t.Batch.Release()
+	policy := e.retryPolicyFor(t.Action)
+	start := time.Now()
+	var retries []RetryAttempt
+	for attempt := 1; ; attempt++ {
+		res, err := e.runOnce(t)
+		if err == nil {
+			res.Attempts = attempt
+			res.Retries = retries
+			res.Explanation = t.ExplanationFor(0)
+			return res, nil
+		}
+		class, errno := ClassifyError(err)
+		if attempt >= policy.attempts() || !policy.Retryable(class) {
+			return &TaskReport{
+				Action:      t.Action,
+				Source:      t.Src,
+				Destination: t.Dst,
+				StartedAt:   start,
+				Duration:    time.Since(start),
+				Attempts:    attempt,
+				Retries:     retries,
+				Explanation: t.ExplanationFor(0),
+			}, err
+		}
+		delay := policy.Backoff(attempt)
+		retries = append(retries, RetryAttempt{Attempt: attempt, Error: err.Error(), Class: class, Errno: errno, Backoff: delay})
+		if e.Verbose {
+			log.Printf("retrying %s %s after %s (attempt %d/%d): %v", t.Action, t.Dst, delay, attempt+1, policy.attempts(), err)
+		}
+		e.wait(delay)
+	}
+}
+
+func (e *Executor) retryPolicyFor(action task.Action) RetryPolicy {
+	if policy, ok := e.ActionRetry[action]; ok {
+		return policy
+	}
+	return e.Retry
+}
+
+func (e *Executor) wait(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	if e.sleep != nil {
+		e.sleep(d)
+		return
+	}
+	time.Sleep(d)
+}
+
+func (e *Executor) runOnce(t task.Task) (*TaskReport, error) {
+	if e.Repository != nil {
+		return e.runRepository(t)
+	}
+	if e.Encryption != nil {
+		return e.runEncoded(t, e.Encryption)
+	}
+	if e.Compression != nil {
+		return e.runEncoded(t, e.Compression)
+	}
 	switch t.Action {
 	case task.ActionCopy:
 		if e.Verbose {
 			log.Printf("copy %s -> %s", t.Src, t.Dst)
 		}
+		if err := e.confine(t.Dst); err != nil {
+			return nil, err
+		}
 		start := time.Now()
-		bytes, hash, err := e.copyFile(t.Src, t.Dst)
+		previous, err := e.preserveOverwritten(t.Dst)
+		if err != nil {
+			return nil, err
+		}
+		sync := e.newSyncer()
+		bytes, hash, err := e.copyFile(t.Src, t.Dst, sync)
 		duration := time.Since(start)
 		if err != nil {
 			return nil, err
 		}
 		return &TaskReport{
-			Action:      t.Action,
-			Source:      t.Src,
-			Destination: t.Dst,
-			Bytes:       bytes,
-			Hash:        hash,
-			StartedAt:   start,
-			Duration:    duration,
+			Action:        t.Action,
+			Source:        t.Src,
+			Destination:   t.Dst,
+			Bytes:         bytes,
+			Hash:          hash,
+			HashAlgorithm: e.HashAlgorithm.orDefault(),
+			StartedAt:     start,
+			Duration:      duration,
+			SyncDuration:  sync.elapsed,
+			BackupPath:    previous,
 		}, nil
 	case task.ActionCopyBatch:
 		if t.Batch == nil {
@@ -59,12 +202,12 @@ func (e *Executor) RunTask(t task.Task) (*TaskReport, error) {
 			log.Printf("copy batch (%d files)", len(t.Batch.Entries))
 		}
 		start := time.Now()
-		bytesCopied, hash, err := e.copyBatch(t.Batch)
+		sync := e.newSyncer()
+		bytesCopied, hash, entries, err := e.copyBatch(t.Batch, sync)
 		duration := time.Since(start)
 		if err != nil {
 			return nil, err
 		}
-		entries := append([]task.CopyBatchEntry(nil), t.Batch.Entries...)
 		destination := fmt.Sprintf("batch of %d files", len(entries))
 		source := ""
 		if len(entries) > 0 {
@@ -72,114 +215,603 @@ func (e *Executor) RunTask(t task.Task) (*TaskReport, error) {
 			destination = fmt.Sprintf("%s (batch of %d files)", entries[0].Destination, len(entries))
 		}
 		return &TaskReport{
-			Action:       t.Action,
-			Source:       source,
-			Destination:  destination,
-			Bytes:        bytesCopied,
-			Hash:         hash,
-			StartedAt:    start,
-			Duration:     duration,
-			BatchEntries: entries,
+			Action:        t.Action,
+			Source:        source,
+			Destination:   destination,
+			Bytes:         bytesCopied,
+			Hash:          hash,
+			HashAlgorithm: e.HashAlgorithm.orDefault(),
+			StartedAt:     start,
+			Duration:      duration,
+			SyncDuration:  sync.elapsed,
+			BatchEntries:  entries,
 		}, nil
 	case task.ActionDelete:
 		if e.Verbose {
 			log.Printf("delete %s", t.Dst)
 		}
+		if err := e.confine(t.Dst); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		previous, err := e.preserveDeleted(t.Dst)
+		if err != nil {
+			return nil, err
+		}
+		if err := e.destination().Remove(t.Dst); err != nil {
+			return nil, err
+		}
+		sync := e.newSyncer()
+		if err := sync.dir(t.Dst); err != nil {
+			return nil, err
+		}
+		return &TaskReport{
+			Action:       t.Action,
+			Destination:  t.Dst,
+			StartedAt:    start,
+			Duration:     time.Since(start),
+			SyncDuration: sync.elapsed,
+			BackupPath:   previous,
+		}, nil
+	case task.ActionLink:
+		if e.Verbose {
+			log.Printf("link %s -> %s", t.Src, t.Dst)
+		}
+		if !backend.IsLocal(e.SourceBackend) || !backend.IsLocal(e.DestinationBackend) {
+			return nil, fmt.Errorf("hard links need source and destination on the local filesystem")
+		}
+		if err := e.confine(t.Dst); err != nil {
+			return nil, err
+		}
 		start := time.Now()
-		if err := deletePath(t.Dst); err != nil {
+		previous, err := e.preserveOverwritten(t.Dst)
+		if err != nil {
+			return nil, err
+		}
+		sync := e.newSyncer()
+		err = linkFile(t.Src, t.Dst, sync)
+		if linkUnsupported(err) {
+			// The reference cannot be linked from here, for example
+			// because it lives on another filesystem; copy it instead.
+			bytes, hash, err := e.copyFile(t.Src, t.Dst, sync)
+			if err != nil {
+				return nil, err
+			}
+			return &TaskReport{
+				Action:        task.ActionCopy,
+				Source:        t.Src,
+				Destination:   t.Dst,
+				Bytes:         bytes,
+				Hash:          hash,
+				HashAlgorithm: e.HashAlgorithm.orDefault(),
+				StartedAt:     start,
+				Duration:      time.Since(start),
+				SyncDuration:  sync.elapsed,
+				BackupPath:    previous,
+			}, nil
+		}
+		if err != nil {
 			return nil, err
 		}
 		return &TaskReport{
-			Action:      t.Action,
-			Destination: t.Dst,
-			StartedAt:   start,
-			Duration:    time.Since(start),
+			Action:       t.Action,
+			Source:       t.Src,
+			Destination:  t.Dst,
+			StartedAt:    start,
+			Duration:     time.Since(start),
+			SyncDuration: sync.elapsed,
+			BackupPath:   previous,
 		}, nil
 	default:
 		return nil, fmt.Errorf("unknown task action: %d", t.Action)
 	}
 }
 
-func (e *Executor) copyFile(src, dst string) (int64, string, error) {
+// linkFile hard-links src to dst, replacing any file already at dst.
+func linkFile(src, dst string, sync *syncer) error {
 	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Link(src, dst); err != nil {
+		return err
+	}
+	return sync.dir(dst)
+}
+
+// linkUnsupported reports whether a failed link should fall back to a copy:
+// the files are on different filesystems, the reference has too many links,
+// or the filesystem has no hard links at all. EPERM is not among them: on
+// Linux it usually means a permission or protected_hardlinks refusal, which
+// is reported rather than hidden behind a full copy.
+func linkUnsupported(err error) bool {
+	return errors.Is(err, syscall.EXDEV) || errors.Is(err, syscall.EMLINK) || errors.Is(err, syscall.ENOTSUP) || errors.Is(err, errors.ErrUnsupported)
+}
+
+// preserveOverwritten keeps the current content of path before a copy
+// replaces it and returns where it was kept.
+func (e *Executor) preserveOverwritten(path string) (string, error) {
+	if e.Versions != nil {
+		return e.Versions.Save(path)
+	}
+	return e.Backup.Preserve(path)
+}
+
+// preserveDeleted keeps path before a delete removes it and returns where it
+// was kept.
+func (e *Executor) preserveDeleted(path string) (string, error) {
+	if e.Versions != nil {
+		return e.Versions.Save(path)
+	}
+	return e.Trash.Preserve(path)
+}
+
+func (e *Executor) copyFile(src, dst string, sync *syncer) (int64, string, error) {
+	source, destination := e.source(), e.destination()
+	if err := destination.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
 		return 0, "", err
 	}
-	if e.BandwidthLimit <= 0 {
-		if written, hash, used, err := tryZeroCopy(src, dst); err != nil {
-			return written, hash, err
-		} else if used {
-			return written, hash, nil
+	if e.BandwidthLimit <= 0 && backend.IsLocal(source) && backend.IsLocal(destination) {
+		tmpPath, err := tempPath(dst)
+		if err != nil {
+			return 0, "", err
+		}
+		written, hash, used, err := tryZeroCopy(src, tmpPath, e.HashAlgorithm, sync)
+		if err == nil && used {
+			err = os.Rename(tmpPath, dst)
+		}
+		if err != nil {
+			_ = os.Remove(tmpPath)
+			return written, "", err
+		}
+		if used {
+			return written, hash, sync.dir(dst)
 		}
 	}
 
-	in, err := os.Open(src)
+	in, err := source.Open(src)
 	if err != nil {
 		return 0, "", err
 	}
 	defer in.Close()
 
-	out, err := os.Create(dst)
+	out, err := e.createReplacement(dst, 0)
 	if err != nil {
 		return 0, "", err
 	}
-	defer out.Close()
+	written, hash, err := copyWithBandwidth(out, in, e.BandwidthLimit, e.HashAlgorithm)
+	if err == nil {
+		err = sync.file(out)
+	}
+	if err != nil {
+		out.discard()
+		return written, "", err
+	}
+	if err := out.commit(); err != nil {
+		return written, "", err
+	}
+	return written, hash, sync.dir(dst)
+}
 
-	written, hash, err := copyWithBandwidth(out, in, e.BandwidthLimit)
-	return written, hash, err
+// replacement is a file written next to its destination and renamed over it
+// once complete. Replacing the destination instead of truncating it leaves
+// alone any other path sharing its inode, such as a reference file that
+// --link-dest hard-linked into the tree. Only the local filesystem has hard
+// links and publishes writes as they happen; other backends publish a file
+// when it is closed, so they write the destination directly.
+type replacement struct {
+	backend.Writer
+	destination backend.Backend
+	tmp, path   string
 }
 
-func (e *Executor) copyBatch(payload *task.CopyBatchPayload) (int64, string, error) {
+// createReplacement opens a replacement for path. A non-zero perm gives the
+// file those permission bits; zero leaves them to the backend.
+func (e *Executor) createReplacement(path string, perm fs.FileMode) (*replacement, error) {
+	destination := e.destination()
+	r := &replacement{destination: destination, tmp: path, path: path}
+	if backend.IsLocal(destination) {
+		tmp, err := tempPath(path)
+		if err != nil {
+			return nil, err
+		}
+		r.tmp = tmp
+	}
+	var w backend.Writer
+	var err error
+	if perm != 0 {
+		w, err = backend.CreateMode(destination, r.tmp, perm)
+	} else {
+		w, err = destination.Create(r.tmp)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.Writer = w
+	return r, nil
+}
+
+// commit closes the file and moves it over the destination.
+func (r *replacement) commit() error {
+	err := r.Writer.Close()
+	if err == nil && r.tmp != r.path {
+		err = r.destination.Rename(r.tmp, r.path)
+	}
+	if err != nil && r.tmp != r.path {
+		_ = r.destination.Remove(r.tmp)
+	}
+	return err
+}
+
+// discard aborts the file so that nothing written reaches the destination.
+func (r *replacement) discard() {
+	_ = r.Writer.Abort()
+}
+
+// source returns the backend task sources are read from.
+func (e *Executor) source() backend.Backend {
+	return backend.OrLocal(e.SourceBackend)
+}
+
+// destination returns the backend task destinations are written to.
+func (e *Executor) destination() backend.Backend {
+	return backend.OrLocal(e.DestinationBackend)
+}
+
+// newSyncer returns the syncer for one task. Directory syncs only apply to
+// the local filesystem, and DurabilitySyncFS syncs every file when the
+// destination filesystems cannot be flushed at the end.
+func (e *Executor) newSyncer() *syncer {
+	mode := e.Durability
+	if mode == DurabilitySyncFS && !e.flushesFilesystems() {
+		mode = DurabilityFile
+	}
+	return &syncer{mode: mode, skipDirs: !backend.IsLocal(e.DestinationBackend)}
+}
+
+// flushesFilesystems reports whether SyncFilesystems can flush the
+// filesystems holding the destination roots.
+func (e *Executor) flushesFilesystems() bool {
+	return syncFSSupported && len(e.DestinationRoots) > 0 && backend.IsLocal(e.DestinationBackend)
+}
+
+// copyBatch writes every entry of the batch and returns the bytes written,
+// the batch hash, and the entries annotated with the hash of the content that
+// was written for each file. Both hashes use the executor's HashAlgorithm;
+// the SHA-256 digests announced in the payload are always verified.
+func (e *Executor) copyBatch(payload *task.CopyBatchPayload, sync *syncer) (int64, string, []task.CopyBatchEntry, error) {
 	if payload == nil {
-		return 0, "", fmt.Errorf("batch payload is nil")
+		return 0, "", nil, fmt.Errorf("batch payload is nil")
+	}
+	for i, entry := range payload.Entries {
+		if entry.Size < 0 {
+			return 0, "", nil, fmt.Errorf("%w: batch entry %d has negative size", ErrInvalidPayload, i)
+		}
+		if err := e.confine(entry.Destination); err != nil {
+			return 0, "", nil, err
+		}
+	}
+	if payload.Lazy() {
+		return e.copyLazyBatch(payload, sync)
+	}
+
+	// Packed content is only trusted when the payload announces its
+	// digests.
+	if payload.ArchiveHash == "" {
+		return 0, "", nil, fmt.Errorf("%w: batch archive has no sha256", ErrInvalidPayload)
 	}
-	reader := bytes.NewReader(payload.Archive)
-	tr := tar.NewReader(reader)
-	hashBytes := sha256.Sum256(payload.Archive)
+	for i, entry := range payload.Entries {
+		if entry.Hash == "" {
+			return 0, "", nil, fmt.Errorf("%w: batch entry %d (%s) has no sha256", ErrInvalidPayload, i, entry.Destination)
+		}
+	}
+	sum := sha256.Sum256(payload.Archive)
+	if got := hex.EncodeToString(sum[:]); !strings.EqualFold(payload.ArchiveHash, got) {
+		return 0, "", nil, fmt.Errorf("%w: batch archive sha256 %s, expected %s", ErrIntegrity, got, payload.ArchiveHash)
+	}
+	archiveDigest := newDigest(e.HashAlgorithm)
+	archiveDigest.Write(payload.Archive)
+	hash := archiveDigest.Sum()
+	// Validate the whole archive before touching the destination so a bad
+	// payload never leaves partial results behind.
+	if err := verifyBatchArchive(payload); err != nil {
+		return 0, "", nil, err
+	}
+
+	archive, err := decompressArchive(bytes.NewReader(payload.Archive), payload.Compression)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	defer archive.Close()
+	tr := tar.NewReader(archive)
 
+	entries := append([]task.CopyBatchEntry(nil), payload.Entries...)
 	var totalBytes int64
+	for i := range entries {
+		header, err := tr.Next()
+		if err != nil {
+			return totalBytes, "", nil, fmt.Errorf("reading batch entry %d: %w", i, err)
+		}
+		written, fileHash, err := e.extractBatchEntry(tr, header, entries[i], sync)
+		totalBytes += written
+		if err != nil {
+			return totalBytes, "", nil, err
+		}
+		entries[i].Hash = fileHash
+	}
+
+	return totalBytes, hash, entries, nil
+}
+
+// verifyBatchArchive checks that the archive holds exactly one regular file
+// per entry with the announced size and hash, and nothing after them.
+func verifyBatchArchive(payload *task.CopyBatchPayload) error {
+	archive, err := decompressArchive(bytes.NewReader(payload.Archive), payload.Compression)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	tr := tar.NewReader(archive)
+
 	for i, entry := range payload.Entries {
 		header, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("%w: archive holds %d entries, payload lists %d", ErrInvalidPayload, i, len(payload.Entries))
+		}
 		if err != nil {
-			return totalBytes, "", fmt.Errorf("reading batch entry %d: %w", i, err)
+			return fmt.Errorf("reading batch entry %d: %w", i, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("%w: batch entry %d is not a regular file", ErrInvalidPayload, i)
+		}
+		if header.Size != entry.Size {
+			return fmt.Errorf("%w: batch entry %d (%s) is %d bytes in the archive, expected %d", ErrIntegrity, i, entry.Destination, header.Size, entry.Size)
+		}
+		verifier := newDigest(HashSHA256)
+		n, err := io.Copy(verifier, tr)
+		if err != nil {
+			return fmt.Errorf("reading batch entry %d: %w", i, err)
+		}
+		if n != entry.Size {
+			return fmt.Errorf("%w: batch entry %d (%s) truncated at %d of %d bytes", ErrIntegrity, i, entry.Destination, n, entry.Size)
+		}
+		if sum := verifier.Sum(); !strings.EqualFold(entry.Hash, sum) {
+			return fmt.Errorf("%w: %s sha256 %s, expected %s", ErrIntegrity, entry.Destination, sum, entry.Hash)
 		}
-		if header == nil {
-			return totalBytes, "", fmt.Errorf("missing tar header for entry %d", i)
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		if err == nil {
+			return fmt.Errorf("%w: archive holds more entries than the payload lists", ErrInvalidPayload)
 		}
-		if entry.Size >= 0 && header.Size != entry.Size {
-			// Prefer the metadata from the payload which was derived from the source file.
-			header.Size = entry.Size
+		return fmt.Errorf("reading batch archive trailer: %w", err)
+	}
+	return expectZeroPadding(archive)
+}
+
+// extractBatchEntry writes one archive member through a replacement, so the
+// destination only changes once the member has been written completely.
+func (e *Executor) extractBatchEntry(r io.Reader, header *tar.Header, entry task.CopyBatchEntry, sync *syncer) (int64, string, error) {
+	if err := e.destination().MkdirAll(filepath.Dir(entry.Destination), 0o755); err != nil {
+		return 0, "", err
+	}
+	if _, err := e.preserveOverwritten(entry.Destination); err != nil {
+		return 0, "", err
+	}
+	perm := header.FileInfo().Mode().Perm()
+	if perm == 0 {
+		perm = 0o644
+	}
+	out, err := e.createReplacement(entry.Destination, perm)
+	if err != nil {
+		return 0, "", err
+	}
+	written, hash, err := copyWithBandwidth(out, io.LimitReader(r, header.Size), e.BandwidthLimit, e.HashAlgorithm)
+	if err == nil {
+		err = sync.file(out)
+	}
+	if err == nil && written != entry.Size {
+		err = fmt.Errorf("%w: %s truncated at %d of %d bytes", ErrIntegrity, entry.Destination, written, entry.Size)
+	}
+	if err != nil {
+		out.discard()
+		return written, "", err
+	}
+	if err := out.commit(); err != nil {
+		return written, "", err
+	}
+	return written, hash, sync.dir(entry.Destination)
+}
+
+// tempPath returns an unused name for a temporary file next to path.
+func tempPath(path string) (string, error) {
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"."+hex.EncodeToString(suffix[:])+".tmp"), nil
+}
+
+// expectZeroPadding rejects data hidden after the end-of-archive marker.
+// Block padding written by tar tools is all zeroes and is accepted.
+func expectZeroPadding(r io.Reader) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return fmt.Errorf("%w: trailing data after batch archive", ErrInvalidPayload)
+			}
 		}
-		if err := os.MkdirAll(filepath.Dir(entry.Destination), 0o755); err != nil {
-			return totalBytes, "", err
+		if err == io.EOF {
+			return nil
 		}
-		out, err := os.Create(entry.Destination)
 		if err != nil {
-			return totalBytes, "", err
+			return err
 		}
-		limited := io.LimitReader(tr, header.Size)
-		written, _, copyErr := copyWithBandwidth(out, limited, e.BandwidthLimit)
-		closeErr := out.Close()
-		totalBytes += written
-		if copyErr != nil {
-			return totalBytes, "", copyErr
+	}
+}
+
+// confine rejects paths outside DestinationRoots, including paths whose
+// existing parent directories resolve outside a root through symlinks. It
+// accepts every path when no roots are configured.
+func (e *Executor) confine(path string) error {
+	if len(e.DestinationRoots) == 0 {
+		return nil
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	resolve := resolveExisting
+	if !backend.IsLocal(e.DestinationBackend) {
+		// Only the local filesystem has symlinks to resolve.
+		resolve = func(path string) (string, error) { return path, nil }
+	}
+	resolvedParent, err := resolve(filepath.Dir(absPath))
+	if err != nil {
+		return err
+	}
+	for _, root := range e.DestinationRoots {
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			return err
+		}
+		if !withinRoot(absRoot, absPath) {
+			continue
+		}
+		resolvedRoot, err := resolve(absRoot)
+		if err != nil {
+			return err
+		}
+		if resolvedParent == resolvedRoot || withinRoot(resolvedRoot, resolvedParent) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is outside the destination roots", ErrInvalidPayload, path)
+}
+
+// withinRoot reports whether path lies strictly below root. Both must be
+// absolute and clean.
+func withinRoot(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+// resolveExisting evaluates symlinks in the longest existing prefix of path
+// and re-appends the components that do not exist yet.
+func resolveExisting(path string) (string, error) {
+	var missing []string
+	current := path
+	for {
+		resolved, err := filepath.EvalSymlinks(current)
+		if err == nil {
+			for i := len(missing) - 1; i >= 0; i-- {
+				resolved = filepath.Join(resolved, missing[i])
+			}
+			return resolved, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
 		}
-		if closeErr != nil {
-			return totalBytes, "", closeErr
+		parent := filepath.Dir(current)
+		if parent == current {
+			return path, nil
 		}
+		missing = append(missing, filepath.Base(current))
+		current = parent
 	}
+}
 
-	return totalBytes, hex.EncodeToString(hashBytes[:]), nil
+// decompressArchive wraps r with the reader for the archive's compression
+// codec.
+func decompressArchive(r io.Reader, codec string) (io.ReadCloser, error) {
+	switch codec {
+	case task.CompressionNone:
+		return io.NopCloser(r), nil
+	case task.CompressionGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("opening gzip batch archive: %w", err)
+		}
+		return zr, nil
+	case task.CompressionFlate:
+		return flate.NewReader(r), nil
+	default:
+		return nil, fmt.Errorf("unsupported batch archive compression %q", codec)
+	}
 }
 
-func copyWithBandwidth(dst io.Writer, src io.Reader, limit int64) (int64, string, error) {
+// copyLazyBatch streams every entry of a batch without an archive straight
+// from its source. The returned batch hash covers the concatenated file
+// contents in entry order.
+func (e *Executor) copyLazyBatch(payload *task.CopyBatchPayload, sync *syncer) (int64, string, []task.CopyBatchEntry, error) {
+	entries := append([]task.CopyBatchEntry(nil), payload.Entries...)
+	batchDigest := newDigest(e.HashAlgorithm)
+	var totalBytes int64
+	for i := range entries {
+		written, fileHash, err := e.copyBatchEntry(entries[i], batchDigest, sync)
+		totalBytes += written
+		if err != nil {
+			return totalBytes, "", nil, err
+		}
+		entries[i].Hash = fileHash
+	}
+	return totalBytes, batchDigest.Sum(), entries, nil
+}
+
+func (e *Executor) copyBatchEntry(entry task.CopyBatchEntry, batchDigest digest, sync *syncer) (int64, string, error) {
+	in, err := e.source().Open(entry.Source)
+	if err != nil {
+		return 0, "", err
+	}
+	defer in.Close()
+	if err := e.destination().MkdirAll(filepath.Dir(entry.Destination), 0o755); err != nil {
+		return 0, "", err
+	}
+	if _, err := e.preserveOverwritten(entry.Destination); err != nil {
+		return 0, "", err
+	}
+	out, err := e.createReplacement(entry.Destination, 0)
+	if err != nil {
+		return 0, "", err
+	}
+	var dst io.Writer = out
+	if batchDigest.enabled() {
+		dst = io.MultiWriter(out, batchDigest)
+	}
+	written, hash, err := copyWithBandwidth(dst, in, e.BandwidthLimit, e.HashAlgorithm)
+	if err == nil {
+		err = sync.file(out)
+	}
+	if err != nil {
+		out.discard()
+		return written, "", err
+	}
+	if err := out.commit(); err != nil {
+		return written, "", err
+	}
+	return written, hash, sync.dir(entry.Destination)
+}
+
+func copyWithBandwidth(dst io.Writer, src io.Reader, limit int64, alg HashAlgorithm) (int64, string, error) {
+	hasher := newDigest(alg)
 	if limit <= 0 {
-		hasher := sha256.New()
-		mw := io.MultiWriter(dst, hasher)
-		written, err := io.Copy(mw, src)
+		if !hasher.enabled() {
+			// Without a hasher in the way io.Copy can use copy_file_range
+			// or sendfile between files.
+			written, err := io.Copy(dst, src)
+			return written, "", err
+		}
+		written, err := io.Copy(io.MultiWriter(dst, hasher), src)
 		if err != nil {
 			return written, "", err
 		}
-		return written, hex.EncodeToString(hasher.Sum(nil)), nil
+		return written, hasher.Sum(), nil
 	}
 
 	bufSize := 32 * 1024
@@ -192,7 +824,6 @@ func copyWithBandwidth(dst io.Writer, src io.Reader, limit int64) (int64, string
 
 	buf := make([]byte, bufSize)
 	start := time.Now()
-	hasher := sha256.New()
 	var written int64
 	for {
 		n, readErr := src.Read(buf)
@@ -218,14 +849,22 @@ func copyWithBandwidth(dst io.Writer, src io.Reader, limit int64) (int64, string
 		}
 		if readErr != nil {
 			if readErr == io.EOF {
-				return written, hex.EncodeToString(hasher.Sum(nil)), nil
+				return written, hasher.Sum(), nil
 			}
 			return written, "", readErr
 		}
 	}
 }
 
-func deletePath(path string) error {
-	// os.RemoveAll succeeds even if the path does not exist.
-	return os.RemoveAll(path)
+// SyncFilesystems completes DurabilitySyncFS by flushing the filesystems
+// holding DestinationRoots once every task has been executed. It is a no-op
+// for other modes, or when files were synced as they were written, and
+// returns the time spent flushing.
+func (e *Executor) SyncFilesystems() (time.Duration, error) {
+	if e.Durability != DurabilitySyncFS || !e.flushesFilesystems() {
+		return 0, nil
+	}
+	start := time.Now()
+	err := syncFilesystems(e.DestinationRoots)
+	return time.Since(start), err
 }
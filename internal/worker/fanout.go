@@ -0,0 +1,373 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+// FanoutResult is the outcome of a task for one of its destinations.
+type FanoutResult struct {
+	// Task is the task narrowed to this destination.
+	Task task.Task
+	// Report describes the work done, or the attempts made when Err is set.
+	Report *TaskReport
+	Err    error
+}
+
+// FansOut reports whether t writes more than one destination.
+func FansOut(t task.Task) bool {
+	switch t.Action {
+	case task.ActionCopy:
+		return len(t.Fanout) > 0
+	case task.ActionCopyBatch:
+		return t.Batch != nil && len(t.Batch.Entries) > 0 && len(t.Batch.Entries[0].Fanout) > 0
+	}
+	return false
+}
+
+// RunFanout executes t for Dst and every Fanout destination and returns one
+// result per destination, in order. Each source file is read once and
+// written to all destinations together; a destination that fails does not
+// stop the others and is retried on its own according to the action's
+// RetryPolicy. Tasks that do not fan out are run by RunTask.
+func (e *Executor) RunFanout(t task.Task) []FanoutResult {
+	if !FansOut(t) {
+		res, err := e.RunTask(t)
+		return []FanoutResult{{Task: t, Report: res, Err: err}}
+	}
+	defer t.Batch.Release()
+	replicas, err := splitFanout(t)
+	if err == nil && (e.Repository != nil || e.Encryption != nil || e.Compression != nil) {
+		err = errors.New("fan-out copies need plain directory destinations")
+	}
+	if err != nil {
+		return []FanoutResult{{Task: t, Report: &TaskReport{Action: t.Action, Source: t.Src, Destination: t.Dst, Attempts: 1}, Err: err}}
+	}
+
+	policy := e.retryPolicyFor(t.Action)
+	start := time.Now()
+	results := make([]FanoutResult, len(replicas))
+	retries := make([][]RetryAttempt, len(replicas))
+	pending := make([]int, len(replicas))
+	for i := range pending {
+		pending[i] = i
+	}
+	for attempt := 1; len(pending) > 0; attempt++ {
+		reports, errs := e.runFanoutOnce(replicas, pending)
+		var next []int
+		var delay time.Duration
+		for j, i := range pending {
+			if errs[j] == nil {
+				reports[j].Attempts = attempt
+				reports[j].Retries = retries[i]
+				reports[j].Explanation = replicas[i].ExplanationFor(0)
+				results[i] = FanoutResult{Task: replicas[i], Report: reports[j]}
+				continue
+			}
+			class, errno := ClassifyError(errs[j])
+			if attempt >= policy.attempts() || !policy.Retryable(class) {
+				results[i] = FanoutResult{Task: replicas[i], Err: errs[j], Report: &TaskReport{
+					Action:      t.Action,
+					Source:      replicas[i].Src,
+					Destination: replicas[i].Dst,
+					StartedAt:   start,
+					Duration:    time.Since(start),
+					Attempts:    attempt,
+					Retries:     retries[i],
+					Explanation: replicas[i].ExplanationFor(0),
+				}}
+				continue
+			}
+			backoff := policy.Backoff(attempt)
+			retries[i] = append(retries[i], RetryAttempt{Attempt: attempt, Error: errs[j].Error(), Class: class, Errno: errno, Backoff: backoff})
+			if e.Verbose {
+				log.Printf("retrying %s %s after %s (attempt %d/%d): %v", t.Action, replicas[i].Dst, backoff, attempt+1, policy.attempts(), errs[j])
+			}
+			delay = max(delay, backoff)
+			next = append(next, i)
+		}
+		pending = next
+		if len(pending) > 0 {
+			e.wait(delay)
+		}
+	}
+	return results
+}
+
+// splitFanout narrows t to each of its destinations. For batches the n-th
+// replica holds the n-th destination of every entry.
+func splitFanout(t task.Task) ([]task.Task, error) {
+	if t.Action == task.ActionCopy {
+		dsts := t.Destinations()
+		replicas := make([]task.Task, len(dsts))
+		for i, dst := range dsts {
+			replicas[i] = task.Task{Action: t.Action, Src: t.Src, Dst: dst, Size: t.Size, ModTime: t.ModTime, Explanations: explanationAt(t.ExplanationFor(i))}
+		}
+		return replicas, nil
+	}
+	entries := t.Batch.Entries
+	width := 1 + len(entries[0].Fanout)
+	replicas := make([]task.Task, width)
+	for i := range replicas {
+		payload := &task.CopyBatchPayload{
+			Entries:     make([]task.CopyBatchEntry, len(entries)),
+			Archive:     t.Batch.Archive,
+			Compression: t.Batch.Compression,
+			ArchiveHash: t.Batch.ArchiveHash,
+		}
+		for j, entry := range entries {
+			if len(entry.Fanout) != width-1 {
+				return nil, fmt.Errorf("%w: batch entry %d has %d fan-out destinations, expected %d", ErrInvalidPayload, j, len(entry.Fanout), width-1)
+			}
+			dst := entry.Destination
+			if i > 0 {
+				dst = entry.Fanout[i-1]
+			}
+			payload.Entries[j] = task.CopyBatchEntry{Source: entry.Source, Destination: dst, Size: entry.Size, ModTime: entry.ModTime, Explanations: explanationAt(entry.ExplanationFor(i)), Hash: entry.Hash}
+		}
+		replicas[i] = task.Task{Action: t.Action, Src: t.Src, Dst: payload.Entries[0].Destination, Batch: payload}
+	}
+	return replicas, nil
+}
+
+// explanationAt narrows the explanations of a fanned-out copy to the one of
+// a single destination.
+func explanationAt(e *task.Explanation) []task.Explanation {
+	if e == nil {
+		return nil
+	}
+	return []task.Explanation{*e}
+}
+
+// runFanoutOnce makes one attempt at the replicas listed in pending and
+// returns a report or an error for each of them.
+func (e *Executor) runFanoutOnce(replicas []task.Task, pending []int) ([]*TaskReport, []error) {
+	tasks := make([]task.Task, len(pending))
+	for j, i := range pending {
+		tasks[j] = replicas[i]
+	}
+	if tasks[0].Action == task.ActionCopy {
+		return e.copyFanout(tasks)
+	}
+	if tasks[0].Batch.Lazy() {
+		return e.copyLazyBatchFanout(tasks)
+	}
+	// Embedded archives are already in memory, so extracting them once per
+	// destination reads nothing twice.
+	reports := make([]*TaskReport, len(tasks))
+	errs := make([]error, len(tasks))
+	for j, t := range tasks {
+		reports[j], errs[j] = e.runOnce(t)
+	}
+	return reports, errs
+}
+
+// copyFanout copies the common source of tasks to the destination of each.
+func (e *Executor) copyFanout(tasks []task.Task) ([]*TaskReport, []error) {
+	start := time.Now()
+	targets := make([]*fanoutTarget, len(tasks))
+	for j, t := range tasks {
+		if e.Verbose {
+			log.Printf("copy %s -> %s", t.Src, t.Dst)
+		}
+		targets[j] = &fanoutTarget{path: t.Dst, sync: e.newSyncer()}
+	}
+	written, hash := e.writeFanout(tasks[0].Src, targets, digest{})
+	reports := make([]*TaskReport, len(tasks))
+	errs := make([]error, len(tasks))
+	for j, target := range targets {
+		if target.err != nil {
+			errs[j] = target.err
+			continue
+		}
+		reports[j] = &TaskReport{
+			Action:        task.ActionCopy,
+			Source:        tasks[j].Src,
+			Destination:   target.path,
+			Bytes:         written,
+			Hash:          hash,
+			HashAlgorithm: e.HashAlgorithm.orDefault(),
+			StartedAt:     start,
+			Duration:      time.Since(start),
+			SyncDuration:  target.sync.elapsed,
+			BackupPath:    target.backup,
+		}
+	}
+	return reports, errs
+}
+
+// copyLazyBatchFanout streams every entry of the lazy batches in tasks, which
+// list the same sources, from its source to its destination in each batch.
+// A batch stops at its first failing entry while the others continue.
+func (e *Executor) copyLazyBatchFanout(tasks []task.Task) ([]*TaskReport, []error) {
+	start := time.Now()
+	errs := make([]error, len(tasks))
+	syncs := make([]*syncer, len(tasks))
+	entries := make([][]task.CopyBatchEntry, len(tasks))
+	totals := make([]int64, len(tasks))
+	for j, t := range tasks {
+		if e.Verbose {
+			log.Printf("copy batch (%d files) -> %s", len(t.Batch.Entries), t.Dst)
+		}
+		syncs[j] = e.newSyncer()
+		entries[j] = append([]task.CopyBatchEntry(nil), t.Batch.Entries...)
+		for _, entry := range entries[j] {
+			if entry.Size < 0 {
+				errs[j] = fmt.Errorf("%w: batch entry %s has negative size", ErrInvalidPayload, entry.Destination)
+				break
+			}
+			if err := e.confine(entry.Destination); err != nil {
+				errs[j] = err
+				break
+			}
+		}
+	}
+	batchDigest := newDigest(e.HashAlgorithm)
+	for i, entry := range entries[0] {
+		var targets []*fanoutTarget
+		var owners []int
+		for j := range tasks {
+			if errs[j] == nil {
+				targets = append(targets, &fanoutTarget{path: entries[j][i].Destination, sync: syncs[j]})
+				owners = append(owners, j)
+			}
+		}
+		if len(targets) == 0 {
+			break
+		}
+		written, hash := e.writeFanout(entry.Source, targets, batchDigest)
+		for k, j := range owners {
+			totals[j] += written
+			if targets[k].err != nil {
+				errs[j] = targets[k].err
+				continue
+			}
+			entries[j][i].Hash = hash
+		}
+	}
+
+	reports := make([]*TaskReport, len(tasks))
+	hash := batchDigest.Sum()
+	for j := range tasks {
+		if errs[j] != nil {
+			continue
+		}
+		reports[j] = &TaskReport{
+			Action:        task.ActionCopyBatch,
+			Source:        entries[j][0].Source,
+			Destination:   fmt.Sprintf("%s (batch of %d files)", entries[j][0].Destination, len(entries[j])),
+			Bytes:         totals[j],
+			Hash:          hash,
+			HashAlgorithm: e.HashAlgorithm.orDefault(),
+			StartedAt:     start,
+			Duration:      time.Since(start),
+			SyncDuration:  syncs[j].elapsed,
+			BatchEntries:  entries[j],
+		}
+	}
+	return reports, errs
+}
+
+// fanoutTarget is one destination written by writeFanout. err records why
+// the destination could not be written.
+type fanoutTarget struct {
+	path   string
+	sync   *syncer
+	out    *replacement
+	backup string
+	err    error
+}
+
+// writeFanout reads src once and writes it to every target, feeding extra,
+// when set, with the same content. Targets that fail are marked and left
+// behind while the others are completed. It returns the bytes read and the
+// digest of the content.
+func (e *Executor) writeFanout(src string, targets []*fanoutTarget, extra digest) (int64, string) {
+	in, err := e.source().Open(src)
+	if err != nil {
+		for _, target := range targets {
+			target.err = err
+		}
+		return 0, ""
+	}
+	defer in.Close()
+
+	var live []*fanoutTarget
+	for _, target := range targets {
+		if target.err = e.openFanoutTarget(target); target.err == nil {
+			live = append(live, target)
+		}
+	}
+	if len(live) == 0 {
+		return 0, ""
+	}
+	var w io.Writer = fanoutWriter(live)
+	if extra.enabled() {
+		w = io.MultiWriter(w, extra)
+	}
+	written, hash, err := copyWithBandwidth(w, in, e.BandwidthLimit, e.HashAlgorithm)
+	for _, target := range live {
+		if target.err == nil {
+			target.err = err
+		}
+		if target.err == nil {
+			target.err = target.sync.file(target.out)
+		}
+		if target.err != nil {
+			target.out.discard()
+			continue
+		}
+		target.err = target.out.commit()
+		if target.err == nil {
+			target.err = target.sync.dir(target.path)
+		}
+	}
+	return written, hash
+}
+
+func (e *Executor) openFanoutTarget(target *fanoutTarget) error {
+	if err := e.confine(target.path); err != nil {
+		return err
+	}
+	backup, err := e.preserveOverwritten(target.path)
+	if err != nil {
+		return err
+	}
+	target.backup = backup
+	destination := e.destination()
+	if err := destination.MkdirAll(filepath.Dir(target.path), 0o755); err != nil {
+		return err
+	}
+	target.out, err = e.createReplacement(target.path, 0)
+	return err
+}
+
+// fanoutWriter writes to every target that has not failed yet. A failing
+// target is marked and skipped; the write only fails once every target has.
+type fanoutWriter []*fanoutTarget
+
+func (w fanoutWriter) Write(p []byte) (int, error) {
+	var err error
+	live := false
+	for _, target := range w {
+		if target.err != nil {
+			err = target.err
+			continue
+		}
+		if _, target.err = target.out.Write(p); target.err != nil {
+			err = target.err
+			continue
+		}
+		live = true
+	}
+	if !live {
+		return 0, err
+	}
+	return len(p), nil
+}
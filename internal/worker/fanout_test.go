@@ -0,0 +1,140 @@
+package worker
+
+import (
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/backend"
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+// countingBackend counts the files opened for reading and created, and fails
+// the first Create of the paths in failOnce with EIO.
+type countingBackend struct {
+	backend.Backend
+	mu       sync.Mutex
+	opens    map[string]int
+	creates  map[string]int
+	failOnce map[string]bool
+}
+
+func newCountingBackend(b backend.Backend) *countingBackend {
+	return &countingBackend{Backend: b, opens: map[string]int{}, creates: map[string]int{}, failOnce: map[string]bool{}}
+}
+
+func (c *countingBackend) Open(path string) (fs.File, error) {
+	c.mu.Lock()
+	c.opens[path]++
+	c.mu.Unlock()
+	return c.Backend.Open(path)
+}
+
+func (c *countingBackend) Create(path string) (backend.Writer, error) {
+	c.mu.Lock()
+	c.creates[path]++
+	fail := c.failOnce[path]
+	delete(c.failOnce, path)
+	c.mu.Unlock()
+	if fail {
+		return nil, &fs.PathError{Op: "create", Path: path, Err: syscall.EIO}
+	}
+	return c.Backend.Create(path)
+}
+
+func TestPoolFansOutToEveryDestination(t *testing.T) {
+	src := newCountingBackend(backend.NewMemory())
+	dst := newCountingBackend(backend.NewMemory())
+	sep := string(filepath.Separator)
+	srcRoot := filepath.Join(sep, "src")
+	a, b, c := filepath.Join(sep, "a"), filepath.Join(sep, "b"), filepath.Join(sep, "c")
+	mtime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for rel, data := range map[string]string{"x.txt": "ex", "y.txt": "why"} {
+		if err := src.Backend.(*backend.Memory).WriteFile(filepath.Join(srcRoot, rel), []byte(data), 0o644, mtime); err != nil {
+			t.Fatalf("failed to seed %s: %v", rel, err)
+		}
+	}
+	// A transient failure at one destination is retried on its own.
+	dst.failOnce[filepath.Join(b, "y.txt")] = true
+
+	pool := New(1, false, 0)
+	pool.SourceBackend = src
+	pool.DestinationBackend = dst
+	// c is not a destination root, so writes to it are refused.
+	pool.DestinationRoots = []string{a, b}
+	pool.Destinations = []string{a, b, c}
+	pool.Retry = RetryPolicy{MaxAttempts: 2}
+	pool.executor.sleep = func(time.Duration) {}
+	report, err := pool.Run(taskChannel(
+		task.Task{Action: task.ActionCopy, Src: filepath.Join(srcRoot, "x.txt"), Dst: filepath.Join(a, "x.txt"), Fanout: []string{filepath.Join(b, "x.txt"), filepath.Join(c, "x.txt")}},
+		task.Task{Action: task.ActionCopyBatch, Batch: &task.CopyBatchPayload{Entries: []task.CopyBatchEntry{
+			{Source: filepath.Join(srcRoot, "y.txt"), Destination: filepath.Join(a, "y.txt"), Fanout: []string{filepath.Join(b, "y.txt")}, Size: 3},
+		}}},
+		task.Task{Action: task.ActionCopyBatch, Batch: sealed(&task.CopyBatchPayload{
+			Entries: []task.CopyBatchEntry{{Destination: filepath.Join(a, "z.txt"), Fanout: []string{filepath.Join(b, "z.txt")}, Size: 3, Hash: sha256Hex("zed")}},
+			Archive: buildArchive(t, []archiveMember{{name: "file-0", content: "zed"}}),
+		})},
+	))
+	if err == nil {
+		t.Fatalf("expected the refused destination to fail the run")
+	}
+
+	for _, root := range []string{a, b} {
+		for name, want := range map[string]string{"x.txt": "ex", "y.txt": "why", "z.txt": "zed"} {
+			f, err := dst.Open(filepath.Join(root, name))
+			if err != nil {
+				t.Fatalf("expected %s in %s: %v", name, root, err)
+			}
+			data, err := io.ReadAll(f)
+			f.Close()
+			if err != nil || string(data) != want {
+				t.Fatalf("%s in %s holds %q, %v; want %q", name, root, data, err, want)
+			}
+		}
+	}
+	if n := src.opens[filepath.Join(srcRoot, "x.txt")]; n != 1 {
+		t.Fatalf("expected x.txt to be read once for all destinations, got %d reads", n)
+	}
+	if n := src.opens[filepath.Join(srcRoot, "y.txt")]; n != 2 {
+		t.Fatalf("expected y.txt to be read once plus once for the retry, got %d reads", n)
+	}
+	if n := dst.creates[filepath.Join(a, "y.txt")]; n != 1 {
+		t.Fatalf("expected the retry to leave the successful destination alone, got %d writes", n)
+	}
+
+	failures := report.Failures()
+	if len(failures) != 1 || failures[0].Destination != filepath.Join(c, "x.txt") || failures[0].Class != ErrorClassInvalid {
+		t.Fatalf("unexpected failures: %+v", failures)
+	}
+	statuses := report.Destinations()
+	want := []DestinationStatus{
+		{Root: a, Copied: 3, Bytes: 8},
+		{Root: b, Copied: 3, Bytes: 8},
+		{Root: c, Failed: 1},
+	}
+	if len(statuses) != len(want) {
+		t.Fatalf("unexpected destination statuses: %+v", statuses)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Fatalf("destination %d: got %+v want %+v", i, statuses[i], want[i])
+		}
+	}
+	if statuses[2].OK() || !statuses[0].OK() {
+		t.Fatalf("expected only %s to be reported as failed", c)
+	}
+}
+
+func TestSplitFanoutRejectsUnevenBatches(t *testing.T) {
+	_, err := splitFanout(task.Task{Action: task.ActionCopyBatch, Batch: &task.CopyBatchPayload{Entries: []task.CopyBatchEntry{
+		{Source: "s1", Destination: "a/1", Fanout: []string{"b/1"}},
+		{Source: "s2", Destination: "a/2"},
+	}}})
+	if err == nil {
+		t.Fatalf("expected a batch whose entries fan out differently to be rejected")
+	}
+}
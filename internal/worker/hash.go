@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strings"
+)
+
+// HashAlgorithm names the digest computed over copied content.
+type HashAlgorithm string
+
+const (
+	// HashSHA256 is the default digest.
+	HashSHA256 HashAlgorithm = "sha256"
+	// HashSHA512 is a slower but stronger alternative to SHA-256.
+	HashSHA512 HashAlgorithm = "sha512"
+	// HashMD5 exists only to produce manifests for legacy tooling.
+	HashMD5 HashAlgorithm = "md5"
+	// HashCRC32C is a cheap checksum using the Castagnoli polynomial.
+	HashCRC32C HashAlgorithm = "crc32c"
+	// HashNone disables hashing so copies are bound only by I/O.
+	HashNone HashAlgorithm = "none"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ParseHashAlgorithm validates a hash algorithm name. The empty string
+// selects HashSHA256.
+func ParseHashAlgorithm(s string) (HashAlgorithm, error) {
+	switch alg := HashAlgorithm(strings.ToLower(strings.TrimSpace(s))); alg {
+	case "":
+		return HashSHA256, nil
+	case HashSHA256, HashSHA512, HashMD5, HashCRC32C, HashNone:
+		return alg, nil
+	default:
+		return "", fmt.Errorf("unknown hash algorithm %q", s)
+	}
+}
+
+// orDefault maps the zero value, used by reports written before the
+// algorithm was recorded, to HashSHA256.
+func (a HashAlgorithm) orDefault() HashAlgorithm {
+	if a == "" {
+		return HashSHA256
+	}
+	return a
+}
+
+// digest accumulates a hash of the configured algorithm. For HashNone it
+// discards its input and Sum returns the empty string.
+type digest struct {
+	h hash.Hash
+}
+
+func newDigest(alg HashAlgorithm) digest {
+	switch alg.orDefault() {
+	case HashSHA512:
+		return digest{h: sha512.New()}
+	case HashMD5:
+		return digest{h: md5.New()}
+	case HashCRC32C:
+		return digest{h: crc32.New(crc32cTable)}
+	case HashNone:
+		return digest{}
+	default:
+		return digest{h: sha256.New()}
+	}
+}
+
+// enabled reports whether the digest computes anything.
+func (d digest) enabled() bool {
+	return d.h != nil
+}
+
+func (d digest) Write(p []byte) (int, error) {
+	if d.h == nil {
+		return len(p), nil
+	}
+	return d.h.Write(p)
+}
+
+// Sum returns the hex encoded digest.
+func (d digest) Sum() string {
+	if d.h == nil {
+		return ""
+	}
+	return hex.EncodeToString(d.h.Sum(nil))
+}
+
+// hashLabel formats a hash together with its algorithm for human readable
+// reports.
+func hashLabel(alg HashAlgorithm, sum string) string {
+	alg = alg.orDefault()
+	if alg == HashNone {
+		return "hash=none"
+	}
+	return string(alg) + "=" + sum
+}
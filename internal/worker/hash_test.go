@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+func TestRunTaskHashAlgorithms(t *testing.T) {
+	content := []byte("hash me please")
+	sha256Sum := sha256.Sum256(content)
+	sha512Sum := sha512.Sum512(content)
+	md5Sum := md5.Sum(content)
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	crc.Write(content)
+
+	cases := []struct {
+		alg  HashAlgorithm
+		want string
+	}{
+		{"", hex.EncodeToString(sha256Sum[:])},
+		{HashSHA256, hex.EncodeToString(sha256Sum[:])},
+		{HashSHA512, hex.EncodeToString(sha512Sum[:])},
+		{HashMD5, hex.EncodeToString(md5Sum[:])},
+		{HashCRC32C, hex.EncodeToString(crc.Sum(nil))},
+		{HashNone, ""},
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.txt")
+	if err := os.WriteFile(src, content, 0o644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	for _, tc := range cases {
+		// Exercise both the unthrottled and the throttled copy paths.
+		for _, bandwidth := range []int64{0, 1 << 20} {
+			exec := NewExecutor(false, bandwidth)
+			exec.HashAlgorithm = tc.alg
+			dst := filepath.Join(dir, "dst", string(tc.alg.orDefault())+".txt")
+			res, err := exec.RunTask(task.Task{Action: task.ActionCopy, Src: src, Dst: dst})
+			if err != nil {
+				t.Fatalf("%q: RunTask returned error: %v", tc.alg, err)
+			}
+			if res.Hash != tc.want {
+				t.Fatalf("%q (bandwidth %d): got hash %q want %q", tc.alg, bandwidth, res.Hash, tc.want)
+			}
+			if res.HashAlgorithm != tc.alg.orDefault() {
+				t.Fatalf("%q: recorded algorithm %q", tc.alg, res.HashAlgorithm)
+			}
+			data, err := os.ReadFile(dst)
+			if err != nil || string(data) != string(content) {
+				t.Fatalf("%q: unexpected destination contents %q (%v)", tc.alg, data, err)
+			}
+		}
+	}
+}
+
+func TestRunTaskBatchHashAlgorithm(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	src := filepath.Join(srcDir, "a.txt")
+	if err := os.WriteFile(src, []byte("alpha"), 0o644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	exec := NewExecutor(false, 0)
+	exec.HashAlgorithm = HashMD5
+	payload := &task.CopyBatchPayload{Entries: []task.CopyBatchEntry{{Source: src, Destination: filepath.Join(dstDir, "a.txt"), Size: 5}}}
+	res, err := exec.RunTask(task.Task{Action: task.ActionCopyBatch, Batch: payload})
+	if err != nil {
+		t.Fatalf("RunTask returned error: %v", err)
+	}
+	sum := md5.Sum([]byte("alpha"))
+	if got := res.BatchEntries[0].Hash; got != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected entry hash %q", got)
+	}
+	if res.HashAlgorithm != HashMD5 {
+		t.Fatalf("unexpected algorithm %q", res.HashAlgorithm)
+	}
+}
+
+func TestParseHashAlgorithm(t *testing.T) {
+	for in, want := range map[string]HashAlgorithm{"": HashSHA256, "SHA512": HashSHA512, " crc32c ": HashCRC32C, "none": HashNone} {
+		got, err := ParseHashAlgorithm(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseHashAlgorithm(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseHashAlgorithm("sha1"); err == nil {
+		t.Fatal("expected error for unsupported algorithm")
+	}
+}
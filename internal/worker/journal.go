@@ -0,0 +1,319 @@
+package worker
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/backend"
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+const journalVersion = 1
+
+// Journal is an append-only checkpoint log of completed tasks. Each line is a
+// JSON record holding the TaskReport and a fingerprint of the sources that
+// were read, so a resumed run can skip work whose inputs have not changed.
+type Journal struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+
+	// done maps a destination path to the journaled work that produced it.
+	done  map[string]journalEntry
+	prior []TaskReport
+
+	// source and destination are the backends of the pool the journal is
+	// attached to; nil selects the local filesystem.
+	source, destination backend.Backend
+}
+
+type journalRecord struct {
+	Version int             `json:"v"`
+	Report  TaskReport      `json:"report"`
+	Sources []JournalSource `json:"sources,omitempty"`
+}
+
+// JournalSource is the size and modification time of a source file before
+// a task read it.
+type JournalSource struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+type journalEntry struct {
+	action task.Action
+	source JournalSource
+}
+
+// OpenJournal opens the journal at path. When resume is true existing
+// records are loaded so Completed can report journaled work and new records
+// are appended; otherwise any previous journal is discarded.
+func OpenJournal(path string, resume bool) (*Journal, error) {
+	j := &Journal{path: path, done: map[string]journalEntry{}}
+	if dir := filepath.Dir(path); dir != "" && dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if resume {
+		if err := j.load(); err != nil {
+			return nil, err
+		}
+	} else {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	j.file = f
+	return j, nil
+}
+
+func (j *Journal) load() error {
+	f, err := os.Open(j.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	lineNo := 0
+	var validEnd, offset int64
+	var pendingErr error
+	for scanner.Scan() {
+		lineNo++
+		offset += int64(len(scanner.Bytes())) + 1
+		if pendingErr != nil {
+			// Only the final record may be torn by an interrupted write.
+			return pendingErr
+		}
+		var rec journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			pendingErr = fmt.Errorf("journal %s line %d: %w", j.path, lineNo, err)
+			continue
+		}
+		if rec.Version != journalVersion {
+			return fmt.Errorf("journal %s line %d: unsupported version %d", j.path, lineNo, rec.Version)
+		}
+		j.index(rec)
+		validEnd = offset
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	j.prior = latestReports(j.prior)
+	if pendingErr != nil {
+		// Drop the torn record so new records start on a fresh line.
+		return os.Truncate(j.path, validEnd)
+	}
+	return nil
+}
+
+func (j *Journal) index(rec journalRecord) {
+	j.prior = append(j.prior, rec.Report)
+	sources := make(map[string]JournalSource, len(rec.Sources))
+	for _, src := range rec.Sources {
+		sources[src.Path] = src
+	}
+	switch rec.Report.Action {
+	case task.ActionCopy, task.ActionDelete, task.ActionLink:
+		j.done[rec.Report.Destination] = journalEntry{action: rec.Report.Action, source: sources[rec.Report.Source]}
+	case task.ActionCopyBatch:
+		for _, entry := range rec.Report.BatchEntries {
+			j.done[entry.Destination] = journalEntry{action: task.ActionCopy, source: sources[entry.Source]}
+		}
+	}
+}
+
+// Path returns the location of the journal file.
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Completed reports whether t was fully performed by a previous run, for
+// every destination it fans out to, and its sources are unchanged since then.
+// Deletes and links are also checked against the destination, which may have
+// changed after the run was interrupted.
+func (j *Journal) Completed(t task.Task) bool {
+	if j == nil || len(j.done) == 0 {
+		return false
+	}
+	switch t.Action {
+	case task.ActionDelete:
+		entry, ok := j.done[t.Dst]
+		return ok && entry.action == t.Action && j.absent(t.Dst)
+	case task.ActionLink:
+		entry, ok := j.done[t.Dst]
+		return ok && entry.action == t.Action && linked(t.Src, t.Dst)
+	case task.ActionCopy:
+		for _, dst := range t.Destinations() {
+			if !j.copyCompleted(t.Src, dst) {
+				return false
+			}
+		}
+		return true
+	case task.ActionCopyBatch:
+		if t.Batch == nil || len(t.Batch.Entries) == 0 {
+			return false
+		}
+		for _, entry := range t.Batch.Entries {
+			for _, dst := range append([]string{entry.Destination}, entry.Fanout...) {
+				if !j.copyCompleted(entry.Source, dst) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (j *Journal) copyCompleted(src, dst string) bool {
+	entry, ok := j.done[dst]
+	if !ok || entry.action != task.ActionCopy || entry.source.Path != src {
+		return false
+	}
+	info, err := backend.OrLocal(j.source).Stat(src)
+	if err != nil {
+		return false
+	}
+	return info.Size() == entry.source.Size && info.ModTime().Equal(entry.source.ModTime)
+}
+
+// absent reports whether nothing exists at the destination path, not even a
+// dangling symlink.
+func (j *Journal) absent(path string) bool {
+	var err error
+	if backend.IsLocal(j.destination) {
+		_, err = os.Lstat(path)
+	} else {
+		_, err = j.destination.Stat(path)
+	}
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// linked reports whether dst is a hard link to src. Links are only made on
+// the local filesystem.
+func linked(src, dst string) bool {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return false
+	}
+	dstInfo, err := os.Lstat(dst)
+	return err == nil && os.SameFile(srcInfo, dstInfo)
+}
+
+// PriorReports returns the task reports loaded from a previous run.
+func (j *Journal) PriorReports() []TaskReport {
+	if j == nil {
+		return nil
+	}
+	res := make([]TaskReport, len(j.prior))
+	for i, tr := range j.prior {
+		res[i] = cloneTaskReport(tr)
+	}
+	return res
+}
+
+// Fingerprint returns the state of the sources t reads. It is taken before
+// t runs and passed to Append, so a source that changes while it is being
+// copied is copied again on resume.
+func (j *Journal) Fingerprint(t task.Task) []JournalSource {
+	if j == nil {
+		return nil
+	}
+	var sources []JournalSource
+	switch t.Action {
+	case task.ActionCopy:
+		sources = j.appendSource(sources, t.Src)
+	case task.ActionCopyBatch:
+		if t.Batch == nil {
+			break
+		}
+		for _, entry := range t.Batch.Entries {
+			sources = j.appendSource(sources, entry.Source)
+		}
+	}
+	return sources
+}
+
+// Append durably records a completed task together with the fingerprint
+// Fingerprint took of its sources.
+func (j *Journal) Append(res *TaskReport, sources []JournalSource) error {
+	if j == nil || res == nil {
+		return nil
+	}
+	rec := journalRecord{Version: journalVersion, Report: cloneTaskReport(*res), Sources: sources}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return fmt.Errorf("journal %s is closed", j.path)
+	}
+	if _, err := j.file.Write(line); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+func (j *Journal) appendSource(sources []JournalSource, path string) []JournalSource {
+	if path == "" {
+		return sources
+	}
+	info, err := backend.OrLocal(j.source).Stat(path)
+	if err != nil {
+		// Without a fingerprint the entry will simply be redone on resume.
+		return sources
+	}
+	return append(sources, JournalSource{Path: path, Size: info.Size(), ModTime: info.ModTime()})
+}
+
+// Close flushes and closes the journal file.
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// Remove closes and deletes the journal. It is called once a run completes
+// without failures so a later --resume starts from scratch.
+func (j *Journal) Remove() error {
+	if j == nil {
+		return nil
+	}
+	if err := j.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(j.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
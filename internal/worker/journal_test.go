@@ -0,0 +1,305 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/backend"
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+func TestPoolResumesFromJournal(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	journalPath := filepath.Join(t.TempDir(), "state", "journal.jsonl")
+
+	names := []string{"a.txt", "b.txt", "c.txt"}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(name), 0o644); err != nil {
+			t.Fatalf("failed to write source: %v", err)
+		}
+	}
+	copyTask := func(name string) task.Task {
+		return task.Task{Action: task.ActionCopy, Src: filepath.Join(srcDir, name), Dst: filepath.Join(dstDir, name)}
+	}
+
+	// The first run only gets through a and b before being "interrupted".
+	journal, err := OpenJournal(journalPath, false)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+	pool := New(1, false, 0)
+	pool.Journal = journal
+	if _, err := pool.Run(taskChannel(copyTask("a.txt"), copyTask("b.txt"))); err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+	if err := journal.Close(); err != nil {
+		t.Fatalf("closing journal failed: %v", err)
+	}
+
+	// b changes before the rerun and must be copied again.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(srcDir, "b.txt"), later, later); err != nil {
+		t.Fatalf("failed to touch source: %v", err)
+	}
+
+	journal, err = OpenJournal(journalPath, true)
+	if err != nil {
+		t.Fatalf("reopening journal failed: %v", err)
+	}
+	defer journal.Close()
+	pool = New(1, false, 0)
+	pool.Journal = journal
+	report, err := pool.Run(taskChannel(copyTask("a.txt"), copyTask("b.txt"), copyTask("c.txt")))
+	if err != nil {
+		t.Fatalf("resumed run failed: %v", err)
+	}
+	if report.ResumedCount() != 1 {
+		t.Fatalf("expected 1 resumed task, got %d", report.ResumedCount())
+	}
+	copies := report.Copies()
+	if len(copies) != len(names) {
+		t.Fatalf("expected merged report with %d copies, got %d", len(names), len(copies))
+	}
+	for i, name := range names {
+		if copies[i].Destination != filepath.Join(dstDir, name) {
+			t.Fatalf("unexpected copy %d: %s", i, copies[i].Destination)
+		}
+	}
+}
+
+func TestOpenJournalIgnoresTornFinalRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	if err := os.WriteFile(path, []byte(`{"v":1,"report":{"Action":1,"Destination":"/dst/old"}}`+"\n"+`{"v":1,"rep`), 0o644); err != nil {
+		t.Fatalf("failed to seed journal: %v", err)
+	}
+	journal, err := OpenJournal(path, true)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+	if !journal.Completed(task.Task{Action: task.ActionDelete, Dst: "/dst/old"}) {
+		t.Fatal("expected journaled delete to be completed")
+	}
+	if err := journal.Append(&TaskReport{Action: task.ActionDelete, Destination: "/dst/new"}, nil); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	journal.Close()
+
+	reopened, err := OpenJournal(path, true)
+	if err != nil {
+		t.Fatalf("reopening journal failed: %v", err)
+	}
+	defer reopened.Close()
+	if len(reopened.PriorReports()) != 2 {
+		t.Fatalf("expected the torn record to be replaced, got %d records", len(reopened.PriorReports()))
+	}
+}
+
+func TestJournalCountsRedoneWorkOnce(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	journalPath := filepath.Join(t.TempDir(), "journal.jsonl")
+	src := filepath.Join(srcDir, "a.txt")
+	copyTask := task.Task{Action: task.ActionCopy, Src: src, Dst: filepath.Join(dstDir, "a.txt")}
+
+	// Every run copies a again because it changed in between, and each run
+	// is resumed by the next one.
+	for i, content := range []string{"one", "two", "three"} {
+		if err := os.WriteFile(src, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write source: %v", err)
+		}
+		later := time.Now().Add(time.Duration(i+1) * time.Hour)
+		if err := os.Chtimes(src, later, later); err != nil {
+			t.Fatalf("failed to touch source: %v", err)
+		}
+		journal, err := OpenJournal(journalPath, i > 0)
+		if err != nil {
+			t.Fatalf("OpenJournal failed: %v", err)
+		}
+		pool := New(1, false, 0)
+		pool.Journal = journal
+		report, err := pool.Run(taskChannel(copyTask))
+		journal.Close()
+		if err != nil {
+			t.Fatalf("run %d failed: %v", i, err)
+		}
+		if copies := report.Copies(); len(copies) != 1 || copies[0].Bytes != int64(len(content)) {
+			t.Fatalf("run %d: expected a single copy of %d bytes, got %+v", i, len(content), copies)
+		}
+	}
+
+	journal, err := OpenJournal(journalPath, true)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+	defer journal.Close()
+	if prior := journal.PriorReports(); len(prior) != 1 || prior[0].Bytes != int64(len("three")) {
+		t.Fatalf("expected only the latest copy to be kept, got %+v", prior)
+	}
+	// A final resume finds nothing to redo and reports the copy once.
+	pool := New(1, false, 0)
+	pool.Journal = journal
+	report, err := pool.Run(taskChannel(copyTask))
+	if err != nil {
+		t.Fatalf("final run failed: %v", err)
+	}
+	if report.ResumedCount() != 1 || len(report.Copies()) != 1 {
+		t.Fatalf("expected one resumed copy, got %d resumed and %d copies", report.ResumedCount(), len(report.Copies()))
+	}
+}
+
+func TestLatestReportsTrimsRedoneBatchEntries(t *testing.T) {
+	batch := TaskReport{Action: task.ActionCopyBatch, Destination: "/dst/a (batch of 2 files)", Bytes: 5, BatchEntries: []task.CopyBatchEntry{
+		{Source: "/src/a", Destination: "/dst/a", Size: 2},
+		{Source: "/src/b", Destination: "/dst/b", Size: 3},
+	}}
+	redo := TaskReport{Action: task.ActionCopy, Source: "/src/b", Destination: "/dst/b", Bytes: 4}
+	got := latestReports([]TaskReport{batch, redo})
+	if len(got) != 2 || got[0].Bytes != 2 || len(got[0].BatchEntries) != 1 || got[0].BatchEntries[0].Destination != "/dst/a" {
+		t.Fatalf("unexpected reports: %+v", got)
+	}
+}
+
+func TestJournalFingerprintsSourcesBeforeCopying(t *testing.T) {
+	srcDir := t.TempDir()
+	journalPath := filepath.Join(t.TempDir(), "journal.jsonl")
+	src := filepath.Join(srcDir, "a.txt")
+	if err := os.WriteFile(src, []byte("before"), 0o644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	copyTask := task.Task{Action: task.ActionCopy, Src: src, Dst: filepath.Join(t.TempDir(), "a.txt")}
+
+	journal, err := OpenJournal(journalPath, false)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+	sources := journal.Fingerprint(copyTask)
+	// The source is rewritten while it is being copied.
+	if err := os.WriteFile(src, []byte("while copying"), 0o644); err != nil {
+		t.Fatalf("failed to rewrite source: %v", err)
+	}
+	if err := journal.Append(&TaskReport{Action: task.ActionCopy, Source: src, Destination: copyTask.Dst}, sources); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	journal.Close()
+
+	journal, err = OpenJournal(journalPath, true)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+	defer journal.Close()
+	if journal.Completed(copyTask) {
+		t.Fatal("expected a source changed during the copy to be copied again")
+	}
+}
+
+func TestJournalChecksDeletesAndLinksAgainstDestination(t *testing.T) {
+	dir := t.TempDir()
+	journalPath := filepath.Join(t.TempDir(), "journal.jsonl")
+	ref := filepath.Join(dir, "ref.txt")
+	if err := os.WriteFile(ref, []byte("ref"), 0o644); err != nil {
+		t.Fatalf("failed to write reference: %v", err)
+	}
+	deleteTask := task.Task{Action: task.ActionDelete, Dst: filepath.Join(dir, "gone.txt")}
+	linkTask := task.Task{Action: task.ActionLink, Src: ref, Dst: filepath.Join(dir, "link.txt")}
+
+	journal, err := OpenJournal(journalPath, false)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+	pool := New(1, false, 0)
+	pool.Journal = journal
+	if _, err := pool.Run(taskChannel(deleteTask, linkTask)); err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+	journal.Close()
+
+	journal, err = OpenJournal(journalPath, true)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+	defer journal.Close()
+	if !journal.Completed(deleteTask) || !journal.Completed(linkTask) {
+		t.Fatal("expected the untouched delete and link to be completed")
+	}
+
+	// Both destinations change after the run was interrupted.
+	if err := os.WriteFile(deleteTask.Dst, []byte("back"), 0o644); err != nil {
+		t.Fatalf("failed to recreate deleted file: %v", err)
+	}
+	if err := os.Remove(linkTask.Dst); err != nil {
+		t.Fatalf("failed to remove link: %v", err)
+	}
+	if err := os.WriteFile(linkTask.Dst, []byte("ref"), 0o644); err != nil {
+		t.Fatalf("failed to replace link: %v", err)
+	}
+	if journal.Completed(deleteTask) {
+		t.Fatal("expected a recreated file to be deleted again")
+	}
+	if journal.Completed(linkTask) {
+		t.Fatal("expected a replaced link to be linked again")
+	}
+}
+
+func TestJournalFingerprintsThroughSourceBackend(t *testing.T) {
+	src := backend.NewMemory()
+	mtime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := src.WriteFile("/src/a.txt", []byte("alpha"), 0o644, mtime); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	dstDir := t.TempDir()
+	journalPath := filepath.Join(t.TempDir(), "journal.jsonl")
+	copyTask := task.Task{Action: task.ActionCopy, Src: "/src/a.txt", Dst: filepath.Join(dstDir, "a.txt")}
+
+	journal, err := OpenJournal(journalPath, false)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+	pool := New(1, false, 0)
+	pool.SourceBackend = src
+	pool.Journal = journal
+	if _, err := pool.Run(taskChannel(copyTask)); err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+	journal.Close()
+
+	journal, err = OpenJournal(journalPath, true)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+	defer journal.Close()
+	pool = New(1, false, 0)
+	pool.SourceBackend = src
+	pool.Journal = journal
+	report, err := pool.Run(taskChannel(copyTask))
+	if err != nil {
+		t.Fatalf("resumed run failed: %v", err)
+	}
+	if report.ResumedCount() != 1 {
+		t.Fatalf("expected the copy from the source backend to be resumed, got %d resumed", report.ResumedCount())
+	}
+}
+
+func TestResumedBytesDoNotCountTowardsSpeed(t *testing.T) {
+	report := newReport()
+	report.add(&TaskReport{Action: task.ActionCopy, Destination: "/dst/new", Bytes: 1 << 20})
+	report.mergePrior([]TaskReport{{Action: task.ActionCopy, Destination: "/dst/old", Bytes: 1 << 30}})
+	report.CompletedAt = report.StartedAt.Add(time.Second)
+	if report.TotalBytes() != 1<<20+1<<30 {
+		t.Fatalf("expected prior bytes in the total, got %d", report.TotalBytes())
+	}
+	if got := report.AverageSpeedBytes(); got != 1<<20 {
+		t.Fatalf("expected the speed of this run only, got %g", got)
+	}
+}
+
+func taskChannel(tasks ...task.Task) <-chan task.Task {
+	ch := make(chan task.Task, len(tasks))
+	for _, t := range tasks {
+		ch <- t
+	}
+	close(ch)
+	return ch
+}
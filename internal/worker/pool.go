@@ -1,8 +1,12 @@
 package worker
 
 import (
+	"fmt"
 	"sync"
+	"sync/atomic"
 
+	"github.com/syncopasoft/syncopa-core/internal/backend"
+	"github.com/syncopasoft/syncopa-core/internal/repo"
 	"github.com/syncopasoft/syncopa-core/internal/task"
 )
 
@@ -13,10 +17,58 @@ type Pool struct {
 	// BandwidthLimit limits the number of bytes per second used when copying files.
 	// A value <= 0 disables throttling.
 	BandwidthLimit int64
+	// MaxErrors aborts the run once this many tasks have failed. Remaining
+	// tasks are drained without being executed. A value <= 0 keeps going
+	// regardless of failures.
+	MaxErrors int
+	// Retry is the default retry policy for every action.
+	Retry RetryPolicy
+	// ActionRetry overrides Retry for individual actions.
+	ActionRetry map[task.Action]RetryPolicy
+	// Journal, when set, receives every completed task. Tasks the journal
+	// already lists as completed are skipped and the reports from the
+	// previous run are merged into the new one.
+	Journal *Journal
+	// DestinationRoots confines writes and deletes; see Executor.
+	DestinationRoots []string
+	// Destinations names the destination roots of a fan-out run. When there
+	// is more than one, the Report breaks its totals down per root.
+	Destinations []string
+	// HashAlgorithm selects the digest recorded for copies; see Executor.
+	HashAlgorithm HashAlgorithm
+	// Durability selects when written data is flushed; see Executor.
+	Durability Durability
+	// Backup and Trash preserve overwritten and deleted destination
+	// entries; see Executor.
+	Backup *BackupTree
+	Trash  *BackupTree
+	// Versions keeps revisions of replaced files; see Executor.
+	Versions *VersionStore
+	// Repository receives the run's files instead of the filesystem; see
+	// Executor.
+	Repository *repo.Run
+	// Encryption stores copies encrypted; see Executor.
+	Encryption *Encryptor
+	// Compression stores copies compressed; see Executor.
+	Compression *Compressor
+	// SourceBackend and DestinationBackend hold the source and destination
+	// trees; see Executor.
+	SourceBackend      backend.Backend
+	DestinationBackend backend.Backend
 
 	executor *Executor
 }
 
+// taskOutcome carries either a successful report or a failure from a worker to
+// the collector goroutine.
+type taskOutcome struct {
+	report  *TaskReport
+	failure *TaskFailure
+	err     error
+	// sources is the journal fingerprint taken before the task ran.
+	sources []JournalSource
+}
+
 // New creates a new worker pool.
 func New(workers int, verbose bool, bandwidthLimit int64) *Pool {
 	if workers <= 0 {
@@ -30,62 +82,120 @@ func New(workers int, verbose bool, bandwidthLimit int64) *Pool {
 	}
 }
 
-// Run starts the worker pool and processes tasks from the channel.
+// Run starts the worker pool and processes tasks from the channel. Failed
+// tasks are recorded in the returned Report; the error summarises them and
+// wraps the first failure.
 func (p *Pool) Run(tasks <-chan task.Task) (*Report, error) {
 	report := newReport()
-	results := make(chan *TaskReport, p.Workers)
+	report.SetDestinations(p.Destinations)
+	outcomes := make(chan taskOutcome, p.Workers)
+	var aborted atomic.Bool
+	var failed, skipped, resumed atomic.Int64
+	var firstErr, journalErr error
 	var collector sync.WaitGroup
 	collector.Add(1)
 	go func() {
 		defer collector.Done()
-		for res := range results {
-			report.add(res)
+		for out := range outcomes {
+			if out.failure != nil {
+				report.addFailure(*out.failure)
+				if firstErr == nil {
+					firstErr = out.err
+				}
+				continue
+			}
+			report.add(out.report)
+			if err := p.Journal.Append(out.report, out.sources); err != nil && journalErr == nil {
+				journalErr = err
+			}
 		}
 	}()
 
-	errs := make(chan error, p.Workers)
 	var wg sync.WaitGroup
 
 	// Ensure any runtime adjustments to the public fields are reflected in the executor.
 	p.executor.Verbose = p.Verbose
 	p.executor.BandwidthLimit = p.BandwidthLimit
+	p.executor.Retry = p.Retry
+	p.executor.ActionRetry = p.ActionRetry
+	p.executor.DestinationRoots = p.DestinationRoots
+	p.executor.HashAlgorithm = p.HashAlgorithm
+	p.executor.Durability = p.Durability
+	p.executor.Backup = p.Backup
+	p.executor.Trash = p.Trash
+	p.executor.Versions = p.Versions
+	p.executor.Repository = p.Repository
+	p.executor.Encryption = p.Encryption
+	p.executor.Compression = p.Compression
+	p.executor.SourceBackend = p.SourceBackend
+	p.executor.DestinationBackend = p.DestinationBackend
+	if p.Journal != nil {
+		p.Journal.source, p.Journal.destination = p.SourceBackend, p.DestinationBackend
+	}
 
 	for i := 0; i < p.Workers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			for t := range tasks {
-				res, err := p.executor.RunTask(t)
-				if err != nil {
-					errs <- err
+				if aborted.Load() {
+					// Keep draining so the producer is never blocked.
+					t.Batch.Release()
+					skipped.Add(1)
+					continue
+				}
+				if p.Journal.Completed(t) {
+					t.Batch.Release()
+					resumed.Add(1)
 					continue
 				}
-				if res != nil {
-					results <- res
+				sources := p.Journal.Fingerprint(t)
+				// Each destination of a fan-out task is recorded, and
+				// counts against MaxErrors, on its own.
+				for _, out := range p.executor.RunFanout(t) {
+					if out.Err != nil {
+						attempts := 1
+						if out.Report != nil {
+							attempts = out.Report.Attempts
+						}
+						failure := NewTaskFailure(out.Task, out.Err, attempts)
+						if n := failed.Add(1); p.MaxErrors > 0 && n >= int64(p.MaxErrors) {
+							aborted.Store(true)
+						}
+						outcomes <- taskOutcome{failure: &failure, err: out.Err}
+						continue
+					}
+					if out.Report != nil {
+						outcomes <- taskOutcome{report: out.Report, sources: sources}
+					}
 				}
 			}
 		}()
 	}
 
 	wg.Wait()
-	close(results)
+	close(outcomes)
 	collector.Wait()
-	close(errs)
-
-	var firstErr error
-	for err := range errs {
-		if err != nil && firstErr == nil {
-			firstErr = err
-		}
+	finalSync, syncErr := p.executor.SyncFilesystems()
+	report.finalSync = finalSync
+	report.skipped = int(skipped.Load())
+	report.resumed = int(resumed.Load())
+	if p.Journal != nil {
+		report.mergePrior(p.Journal.PriorReports())
 	}
 	report.Finalize()
 
+	if journalErr != nil {
+		return report, fmt.Errorf("writing journal %s: %w", p.Journal.Path(), journalErr)
+	}
+	if syncErr != nil {
+		return report, fmt.Errorf("flushing filesystems: %w", syncErr)
+	}
+	if aborted.Load() {
+		return report, fmt.Errorf("%w: %d failed, %d skipped (limit %d): %v", ErrTooManyFailures, len(report.failures), report.skipped, p.MaxErrors, firstErr)
+	}
 	if firstErr != nil {
-		return report, firstErr
+		return report, fmt.Errorf("%d tasks failed: %w", len(report.failures), firstErr)
 	}
 	return report, nil
 }
-
-func (p *Pool) handleTask(t task.Task) (*TaskReport, error) {
-	return p.executor.RunTask(t)
-}
@@ -3,8 +3,11 @@ package worker
 import (
 	"archive/tar"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
 	"crypto/sha256"
 	"encoding/hex"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -12,7 +15,7 @@ import (
 	"github.com/syncopasoft/syncopa-core/internal/task"
 )
 
-func TestHandleTaskCopyBatch(t *testing.T) {
+func TestRunCopyBatch(t *testing.T) {
 	dir := t.TempDir()
 
 	files := []struct {
@@ -43,19 +46,15 @@ func TestHandleTaskCopyBatch(t *testing.T) {
 			Source:      filepath.Join("/src", file.name),
 			Destination: filepath.Join(dir, file.name),
 			Size:        int64(len(file.content)),
+			Hash:        sha256Hex(file.content),
 		}
 	}
 	if err := tw.Close(); err != nil {
 		t.Fatalf("failed to close tar writer: %v", err)
 	}
 
-	payload := &task.CopyBatchPayload{Entries: entries, Archive: buf.Bytes()}
-	pool := New(1, false, 0)
-
-	report, err := pool.handleTask(task.Task{Action: task.ActionCopyBatch, Batch: payload})
-	if err != nil {
-		t.Fatalf("handleTask returned error: %v", err)
-	}
+	payload := sealed(&task.CopyBatchPayload{Entries: entries, Archive: buf.Bytes()})
+	report := runBatch(t, New(1, false, 0), payload)
 	if report.Action != task.ActionCopyBatch {
 		t.Fatalf("unexpected action: got %v want %v", report.Action, task.ActionCopyBatch)
 	}
@@ -96,4 +95,121 @@ func TestHandleTaskCopyBatch(t *testing.T) {
 			t.Fatalf("unexpected file contents for %s: got %q want %q", entry.Destination, string(data), expected)
 		}
 	}
+
+	for _, entry := range report.BatchEntries {
+		data, err := os.ReadFile(entry.Destination)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", entry.Destination, err)
+		}
+		fileHash := sha256.Sum256(data)
+		if entry.Hash != hex.EncodeToString(fileHash[:]) {
+			t.Fatalf("unexpected hash for %s: got %s want %s", entry.Destination, entry.Hash, hex.EncodeToString(fileHash[:]))
+		}
+	}
+}
+
+func TestRunLazyCopyBatch(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	contents := map[string]string{"first.txt": "alpha", filepath.Join("nested", "second.txt"): "bravo"}
+	var entries []task.CopyBatchEntry
+	for name, data := range contents {
+		src := filepath.Join(srcDir, filepath.Base(name))
+		if err := os.WriteFile(src, []byte(data), 0o644); err != nil {
+			t.Fatalf("failed to write source: %v", err)
+		}
+		entries = append(entries, task.CopyBatchEntry{Source: src, Destination: filepath.Join(dstDir, name), Size: int64(len(data))})
+	}
+
+	report := runBatch(t, New(1, false, 0), &task.CopyBatchPayload{Entries: entries})
+	if report.Bytes != 10 {
+		t.Fatalf("unexpected bytes: got %d want 10", report.Bytes)
+	}
+	for name, want := range contents {
+		got, err := os.ReadFile(filepath.Join(dstDir, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		if string(got) != want {
+			t.Fatalf("unexpected contents for %s: got %q want %q", name, got, want)
+		}
+	}
+	for _, entry := range report.BatchEntries {
+		fileHash := sha256.Sum256([]byte(contents[mustRel(t, dstDir, entry.Destination)]))
+		if entry.Hash != hex.EncodeToString(fileHash[:]) {
+			t.Fatalf("unexpected hash for %s: got %s", entry.Destination, entry.Hash)
+		}
+	}
+}
+
+// runBatch runs payload through pool and returns the report of the batch.
+func runBatch(t *testing.T, pool *Pool, payload *task.CopyBatchPayload) TaskReport {
+	t.Helper()
+	report, err := pool.Run(taskChannel(task.Task{Action: task.ActionCopyBatch, Batch: payload}))
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	copies := report.Copies()
+	if len(copies) != 1 {
+		t.Fatalf("expected one batch report, got %d", len(copies))
+	}
+	return copies[0]
+}
+
+func mustRel(t *testing.T, base, path string) string {
+	t.Helper()
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		t.Fatalf("failed to derive relative path: %v", err)
+	}
+	return rel
+}
+
+func TestRunCompressedCopyBatch(t *testing.T) {
+	dir := t.TempDir()
+	content := "compressible compressible compressible"
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	if err := tw.WriteHeader(&tar.Header{Name: "file-0", Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatalf("failed to write header: %v", err)
+	}
+	if _, err := tw.Write([]byte(content)); err != nil {
+		t.Fatalf("failed to write contents: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+
+	for _, codec := range []string{task.CompressionGzip, task.CompressionFlate} {
+		var compressed bytes.Buffer
+		var w io.WriteCloser
+		if codec == task.CompressionGzip {
+			w = gzip.NewWriter(&compressed)
+		} else {
+			w, _ = flate.NewWriter(&compressed, flate.DefaultCompression)
+		}
+		if _, err := w.Write(archive.Bytes()); err != nil {
+			t.Fatalf("failed to compress archive: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("failed to close compressor: %v", err)
+		}
+
+		dst := filepath.Join(dir, codec+".txt")
+		payload := sealed(&task.CopyBatchPayload{
+			Entries:     []task.CopyBatchEntry{{Source: "/src/a.txt", Destination: dst, Size: int64(len(content)), Hash: sha256Hex(content)}},
+			Archive:     compressed.Bytes(),
+			Compression: codec,
+		})
+		runBatch(t, New(1, false, 0), payload)
+		data, err := os.ReadFile(dst)
+		if err != nil {
+			t.Fatalf("%s: failed to read destination: %v", codec, err)
+		}
+		if string(data) != content {
+			t.Fatalf("%s: unexpected contents %q", codec, data)
+		}
+	}
 }
@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+func TestPoolRunRecordsFailures(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	good := filepath.Join(srcDir, "good.txt")
+	if err := os.WriteFile(good, []byte("ok"), 0o644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	tasks := make(chan task.Task, 2)
+	tasks <- task.Task{Action: task.ActionCopy, Src: filepath.Join(srcDir, "missing.txt"), Dst: filepath.Join(dstDir, "missing.txt")}
+	tasks <- task.Task{Action: task.ActionCopy, Src: good, Dst: filepath.Join(dstDir, "good.txt")}
+	close(tasks)
+
+	pool := New(1, false, 0)
+	report, err := pool.Run(tasks)
+	if err == nil {
+		t.Fatal("expected run error when a task fails")
+	}
+	if errors.Is(err, ErrTooManyFailures) {
+		t.Fatalf("run should not abort without a MaxErrors limit: %v", err)
+	}
+	if report.CopyCount() != 1 {
+		t.Fatalf("expected the remaining copy to succeed, got %d copies", report.CopyCount())
+	}
+
+	failures := report.Failures()
+	if len(failures) != 1 {
+		t.Fatalf("expected 1 failure, got %d", len(failures))
+	}
+	failure := failures[0]
+	if failure.Class != ErrorClassNotFound {
+		t.Fatalf("unexpected error class: %s", failure.Class)
+	}
+	if failure.Errno != int(syscall.ENOENT) {
+		t.Fatalf("unexpected errno: got %d want %d", failure.Errno, int(syscall.ENOENT))
+	}
+	if failure.Attempts != 1 {
+		t.Fatalf("unexpected attempts: %d", failure.Attempts)
+	}
+	if !strings.Contains(report.ShortSummary(), "Tasks failed: 1") {
+		t.Fatalf("summary does not mention the failure:\n%s", report.ShortSummary())
+	}
+}
+
+func TestPoolRunAbortsAfterMaxErrors(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	const total = 5
+	tasks := make(chan task.Task, total)
+	for i := 0; i < total; i++ {
+		name := filepath.Join("missing", string(rune('a'+i)))
+		tasks <- task.Task{Action: task.ActionCopy, Src: filepath.Join(srcDir, name), Dst: filepath.Join(dstDir, name)}
+	}
+	close(tasks)
+
+	pool := New(1, false, 0)
+	pool.MaxErrors = 2
+	report, err := pool.Run(tasks)
+	if !errors.Is(err, ErrTooManyFailures) {
+		t.Fatalf("expected ErrTooManyFailures, got %v", err)
+	}
+	if report.FailureCount() != pool.MaxErrors {
+		t.Fatalf("expected %d failures, got %d", pool.MaxErrors, report.FailureCount())
+	}
+	if report.SkippedCount() != total-pool.MaxErrors {
+		t.Fatalf("expected %d skipped tasks, got %d", total-pool.MaxErrors, report.SkippedCount())
+	}
+}
@@ -3,6 +3,8 @@ package worker
 import (
 	"fmt"
 	"math"
+	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -12,14 +14,31 @@ import (
 
 // TaskReport captures the outcome of processing a single task.
 type TaskReport struct {
-	Action       task.Action
-	Source       string
-	Destination  string
-	Bytes        int64
-	Hash         string
-	StartedAt    time.Time
-	Duration     time.Duration
+	Action      task.Action
+	Source      string
+	Destination string
+	Bytes       int64
+	Hash        string
+	// HashAlgorithm names the digest in Hash and in BatchEntries. Reports
+	// recorded before it existed leave it empty, which means HashSHA256.
+	HashAlgorithm HashAlgorithm
+	StartedAt     time.Time
+	Duration      time.Duration
+	// SyncDuration is the part of Duration spent waiting for fsync.
+	SyncDuration time.Duration
+	// BackupPath is where the previous destination content was moved to by
+	// a backup or trash directory. Batches leave it empty; their entries
+	// keep the same relative layout inside the run's backup tree.
+	BackupPath   string
 	BatchEntries []task.CopyBatchEntry
+	// Explanation is why the scanner planned the task, when it said. Batch
+	// entries carry their own.
+	Explanation *task.Explanation `json:",omitempty"`
+	// Attempts is the number of times the task was executed, including the
+	// successful one.
+	Attempts int
+	// Retries describes the failed attempts that preceded the final outcome.
+	Retries []RetryAttempt
 }
 
 // CompletedAt returns when the task finished.
@@ -36,18 +55,52 @@ type Report struct {
 	CompletedAt time.Time
 
 	totalBytes int64
+	// priorBytes are the bytes of totalBytes copied by an interrupted run
+	// this one resumed.
+	priorBytes int64
 	copies     []TaskReport
 	deletes    []TaskReport
+	links      []TaskReport
+	failures   []TaskFailure
+	skipped    int
+	resumed    int
+	// finalSync is the time spent flushing filesystems after all tasks ran.
+	finalSync time.Duration
+	// destinations are the destination roots of a fan-out run.
+	destinations []string
 }
 
 // ReportSnapshot captures a serializable representation of a Report so it can
 // be persisted and reconstructed later.
 type ReportSnapshot struct {
-	StartedAt   time.Time    `json:"started_at"`
-	CompletedAt time.Time    `json:"completed_at"`
-	TotalBytes  int64        `json:"total_bytes"`
-	Copies      []TaskReport `json:"copies"`
-	Deletes     []TaskReport `json:"deletes"`
+	StartedAt   time.Time     `json:"started_at"`
+	CompletedAt time.Time     `json:"completed_at"`
+	TotalBytes  int64         `json:"total_bytes"`
+	Copies      []TaskReport  `json:"copies"`
+	Deletes     []TaskReport  `json:"deletes"`
+	Links       []TaskReport  `json:"links,omitempty"`
+	Failures    []TaskFailure `json:"failures,omitempty"`
+	Skipped     int           `json:"skipped,omitempty"`
+	Resumed     int           `json:"resumed,omitempty"`
+	PriorBytes  int64         `json:"prior_bytes,omitempty"`
+	FinalSync   time.Duration `json:"final_sync,omitempty"`
+	// Destinations are the destination roots of a fan-out run.
+	Destinations []string `json:"destinations,omitempty"`
+}
+
+// DestinationStatus summarises a fan-out run for one destination root.
+type DestinationStatus struct {
+	Root    string
+	Copied  int
+	Linked  int
+	Deleted int
+	Failed  int
+	Bytes   int64
+}
+
+// OK reports whether every task for the destination succeeded.
+func (s DestinationStatus) OK() bool {
+	return s.Failed == 0
 }
 
 func newReport() *Report {
@@ -70,7 +123,90 @@ func (r *Report) add(res *TaskReport) {
 		r.copies = append(r.copies, cloneTaskReport(*res))
 	case task.ActionDelete:
 		r.deletes = append(r.deletes, cloneTaskReport(*res))
+	case task.ActionLink:
+		r.links = append(r.links, cloneTaskReport(*res))
+	}
+}
+
+func (r *Report) addFailure(failure TaskFailure) {
+	r.failures = append(r.failures, cloneTaskFailure(failure))
+}
+
+// mergePrior folds task reports journaled by an interrupted run into the
+// report. Prior entries superseded by work redone in this run are dropped.
+func (r *Report) mergePrior(prior []TaskReport) {
+	if len(prior) == 0 {
+		return
+	}
+	redone := make(map[string]struct{}, len(r.copies)+len(r.deletes))
+	for _, list := range [][]TaskReport{r.copies, r.deletes, r.links} {
+		for i := range list {
+			for _, dst := range reportDestinations(&list[i]) {
+				redone[dst] = struct{}{}
+			}
+		}
+	}
+	for i := range prior {
+		if tr, ok := withoutDestinations(prior[i], redone); ok {
+			r.add(&tr)
+			if tr.Action == task.ActionCopy || tr.Action == task.ActionCopyBatch {
+				r.priorBytes += tr.Bytes
+			}
+		}
+	}
+}
+
+// latestReports keeps the last report for every destination, so work that
+// a resumed run redid is counted once however often the run is resumed.
+func latestReports(reports []TaskReport) []TaskReport {
+	seen := make(map[string]struct{}, len(reports))
+	var kept []TaskReport
+	for i := len(reports) - 1; i >= 0; i-- {
+		if tr, ok := withoutDestinations(reports[i], seen); ok {
+			kept = append(kept, tr)
+		}
+		for _, dst := range reportDestinations(&reports[i]) {
+			seen[dst] = struct{}{}
+		}
+	}
+	slices.Reverse(kept)
+	return kept
+}
+
+// reportDestinations returns the destinations written by tr: one per file
+// for batches.
+func reportDestinations(tr *TaskReport) []string {
+	if tr.Action != task.ActionCopyBatch || len(tr.BatchEntries) == 0 {
+		return []string{tr.Destination}
+	}
+	dsts := make([]string, len(tr.BatchEntries))
+	for i, entry := range tr.BatchEntries {
+		dsts[i] = entry.Destination
+	}
+	return dsts
+}
+
+// withoutDestinations returns tr without the work on the destinations in
+// drop. A batch keeps its other files and their bytes; the boolean reports
+// whether anything is left.
+func withoutDestinations(tr TaskReport, drop map[string]struct{}) (TaskReport, bool) {
+	if tr.Action != task.ActionCopyBatch || len(tr.BatchEntries) == 0 {
+		_, dropped := drop[tr.Destination]
+		return tr, !dropped
+	}
+	var kept []task.CopyBatchEntry
+	for _, entry := range tr.BatchEntries {
+		if _, dropped := drop[entry.Destination]; dropped {
+			tr.Bytes -= entry.Size
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	if len(kept) == 0 {
+		return TaskReport{}, false
 	}
+	tr.BatchEntries = kept
+	return tr, true
 }
 
 func (r *Report) markComplete() {
@@ -83,6 +219,12 @@ func (r *Report) markComplete() {
 	sort.Slice(r.deletes, func(i, j int) bool {
 		return r.deletes[i].Destination < r.deletes[j].Destination
 	})
+	sort.Slice(r.links, func(i, j int) bool {
+		return r.links[i].Destination < r.links[j].Destination
+	})
+	sort.Slice(r.failures, func(i, j int) bool {
+		return r.failures[i].Destination < r.failures[j].Destination
+	})
 }
 
 // Finalize freezes the report, computing derived statistics and marking it as
@@ -98,6 +240,12 @@ func (r *Report) Record(res *TaskReport) {
 	r.add(res)
 }
 
+// RecordFailure inserts a failed task into the aggregate. Like Record it is
+// exported for distributed orchestrators.
+func (r *Report) RecordFailure(failure TaskFailure) {
+	r.addFailure(failure)
+}
+
 // Duration returns the total time spent for the run.
 func (r *Report) Duration() time.Duration {
 	if r.CompletedAt.IsZero() {
@@ -109,13 +257,30 @@ func (r *Report) Duration() time.Duration {
 	return r.CompletedAt.Sub(r.StartedAt)
 }
 
-// AverageSpeedBytes returns the average throughput in bytes per second.
+// SyncDuration returns the total time spent waiting for fsync, including the
+// flush at the end of the run.
+func (r *Report) SyncDuration() time.Duration {
+	total := r.finalSync
+	for _, tr := range r.copies {
+		total += tr.SyncDuration
+	}
+	for _, tr := range r.deletes {
+		total += tr.SyncDuration
+	}
+	for _, tr := range r.links {
+		total += tr.SyncDuration
+	}
+	return total
+}
+
+// AverageSpeedBytes returns the average throughput in bytes per second. Bytes
+// copied by a resumed run are left out, as its time is not part of Duration.
 func (r *Report) AverageSpeedBytes() float64 {
 	dur := r.Duration()
 	if dur <= 0 {
 		return 0
 	}
-	return float64(r.totalBytes) / dur.Seconds()
+	return float64(r.totalBytes-r.priorBytes) / dur.Seconds()
 }
 
 // ShortSummary returns a compact textual report of the run.
@@ -127,19 +292,42 @@ func (r *Report) ShortSummary() string {
 	fmt.Fprintf(&b, "End: %s\n", r.CompletedAt.Format(time.RFC3339))
 	fmt.Fprintf(&b, "Duration: %s\n", r.Duration())
 	fmt.Fprintf(&b, "Files copied: %d\n", r.copiedFileCount())
+	if len(r.links) > 0 {
+		fmt.Fprintf(&b, "Files linked: %d\n", len(r.links))
+	}
 	fmt.Fprintf(&b, "Files deleted: %d\n", len(r.deletes))
+	fmt.Fprintf(&b, "Tasks failed: %d\n", len(r.failures))
+	if retried := r.retriedCount(); retried > 0 {
+		fmt.Fprintf(&b, "Tasks retried: %d\n", retried)
+	}
+	if r.skipped > 0 {
+		fmt.Fprintf(&b, "Tasks skipped: %d\n", r.skipped)
+	}
+	if r.resumed > 0 {
+		fmt.Fprintf(&b, "Tasks resumed from journal: %d\n", r.resumed)
+	}
 	fmt.Fprintf(&b, "Bytes copied: %s\n", formatBytes(r.totalBytes))
 	fmt.Fprintf(&b, "Average speed: %s/s\n", formatBytesPerSecond(r.AverageSpeedBytes()))
+	if synced := r.SyncDuration(); synced > 0 {
+		fmt.Fprintf(&b, "Time in fsync: %s\n", synced)
+	}
+	writeDestinations(&b, r.Destinations())
 
 	if len(r.copies) > 0 {
 		fmt.Fprintln(&b, "\nFiles transferred:")
 		for _, copy := range r.copies {
-			fmt.Fprintf(&b, "- %s (sha256=%s, %s in %s, %s/s)\n",
+			fmt.Fprintf(&b, "- %s (%s, %s in %s, %s/s)\n",
 				copy.Destination,
-				copy.Hash,
+				hashLabel(copy.HashAlgorithm, copy.Hash),
 				formatBytes(copy.Bytes),
 				copy.Duration,
 				formatBytesPerSecond(speedFromCopy(copy)))
+			if copy.Attempts > 1 {
+				fmt.Fprintf(&b, "    retried: succeeded on attempt %d\n", copy.Attempts)
+			}
+			if copy.BackupPath != "" {
+				fmt.Fprintf(&b, "    previous version kept at %s\n", copy.BackupPath)
+			}
 			if len(copy.BatchEntries) > 0 {
 				for _, entry := range copy.BatchEntries {
 					fmt.Fprintf(&b, "    • %s (%s)\n", entry.Destination, formatBytes(entry.Size))
@@ -147,9 +335,31 @@ func (r *Report) ShortSummary() string {
 			}
 		}
 	}
+
+	if len(r.failures) > 0 {
+		fmt.Fprintln(&b, "\nFailed tasks:")
+		for _, failure := range r.failures {
+			fmt.Fprintf(&b, "- %s (%s, attempts=%d): %s\n",
+				failure.Destination,
+				failureClassLabel(failure),
+				failure.Attempts,
+				failure.Error)
+		}
+	}
 	return b.String()
 }
 
+func failureClassLabel(failure TaskFailure) string {
+	class := failure.Class
+	if class == "" {
+		class = ErrorClassOther
+	}
+	if failure.Errno != 0 {
+		return fmt.Sprintf("class=%s, errno=%d", class, failure.Errno)
+	}
+	return fmt.Sprintf("class=%s", class)
+}
+
 func speedFromCopy(copy TaskReport) float64 {
 	if copy.Duration <= 0 {
 		return 0
@@ -157,16 +367,127 @@ func speedFromCopy(copy TaskReport) float64 {
 	return float64(copy.Bytes) / copy.Duration.Seconds()
 }
 
+// SetDestinations records the destination roots of a fan-out run so the
+// report can break its totals down per destination.
+func (r *Report) SetDestinations(roots []string) {
+	r.destinations = append([]string(nil), roots...)
+}
+
+// Destinations returns the status of every destination root of a fan-out
+// run in the order the roots were given. Each task is attributed to the
+// innermost root holding its destination. Runs with a single destination
+// return nil.
+func (r *Report) Destinations() []DestinationStatus {
+	if len(r.destinations) < 2 {
+		return nil
+	}
+	statuses := make([]DestinationStatus, len(r.destinations))
+	roots := make([]string, len(r.destinations))
+	for i, root := range r.destinations {
+		statuses[i].Root = root
+		if abs, err := filepath.Abs(root); err == nil {
+			roots[i] = abs
+		}
+	}
+	find := func(path string) *DestinationStatus {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil
+		}
+		match := -1
+		for i, root := range roots {
+			if root != "" && withinRoot(root, abs) && (match < 0 || len(root) > len(roots[match])) {
+				match = i
+			}
+		}
+		if match < 0 {
+			return nil
+		}
+		return &statuses[match]
+	}
+	for _, tr := range r.copies {
+		if status := find(reportedPath(tr)); status != nil {
+			status.Copied += copyCount(tr)
+			status.Bytes += tr.Bytes
+		}
+	}
+	for _, tr := range r.links {
+		if status := find(tr.Destination); status != nil {
+			status.Linked++
+		}
+	}
+	for _, tr := range r.deletes {
+		if status := find(tr.Destination); status != nil {
+			status.Deleted++
+		}
+	}
+	for _, failure := range r.failures {
+		if status := find(failure.Destination); status != nil {
+			status.Failed++
+		}
+	}
+	return statuses
+}
+
+// reportedPath returns the destination of a copy, or of the first file of a
+// batch.
+func reportedPath(tr TaskReport) string {
+	if len(tr.BatchEntries) > 0 {
+		return tr.BatchEntries[0].Destination
+	}
+	return tr.Destination
+}
+
+func writeDestinations(b *strings.Builder, statuses []DestinationStatus) {
+	if len(statuses) == 0 {
+		return
+	}
+	fmt.Fprintln(b, "\nDestinations:")
+	for _, status := range statuses {
+		state := "ok"
+		if !status.OK() {
+			state = "failed"
+		}
+		fmt.Fprintf(b, "- %s: %s, %d copied (%s), %d deleted, %d failed\n",
+			status.Root, state, status.Copied, formatBytes(status.Bytes), status.Deleted, status.Failed)
+		if status.Linked > 0 {
+			fmt.Fprintf(b, "    linked: %d\n", status.Linked)
+		}
+	}
+}
+
 // CopyCount returns the number of copy operations recorded.
 func (r *Report) CopyCount() int {
 	return len(r.copies)
 }
 
+// LinkCount returns the number of files hard-linked from a reference tree.
+func (r *Report) LinkCount() int {
+	return len(r.links)
+}
+
 // DeleteCount returns the number of delete operations recorded.
 func (r *Report) DeleteCount() int {
 	return len(r.deletes)
 }
 
+// FailureCount returns the number of failed tasks recorded.
+func (r *Report) FailureCount() int {
+	return len(r.failures)
+}
+
+// SkippedCount returns the number of tasks that were not executed because the
+// run was aborted after too many failures.
+func (r *Report) SkippedCount() int {
+	return r.skipped
+}
+
+// ResumedCount returns the number of tasks skipped because a checkpoint
+// journal showed they were completed by an earlier run.
+func (r *Report) ResumedCount() int {
+	return r.resumed
+}
+
 // TotalBytes returns the sum of bytes copied during the run.
 func (r *Report) TotalBytes() int64 {
 	return r.totalBytes
@@ -190,6 +511,44 @@ func (r *Report) Deletes() []TaskReport {
 	return res
 }
 
+// Links returns a snapshot of the recorded link reports.
+func (r *Report) Links() []TaskReport {
+	res := make([]TaskReport, len(r.links))
+	for i, tr := range r.links {
+		res[i] = cloneTaskReport(tr)
+	}
+	return res
+}
+
+// Failures returns a snapshot of the recorded task failures.
+func (r *Report) Failures() []TaskFailure {
+	res := make([]TaskFailure, len(r.failures))
+	for i, f := range r.failures {
+		res[i] = cloneTaskFailure(f)
+	}
+	return res
+}
+
+func (r *Report) retriedCount() int {
+	total := 0
+	for _, c := range r.copies {
+		if c.Attempts > 1 {
+			total++
+		}
+	}
+	for _, d := range r.deletes {
+		if d.Attempts > 1 {
+			total++
+		}
+	}
+	for _, l := range r.links {
+		if l.Attempts > 1 {
+			total++
+		}
+	}
+	return total
+}
+
 func (r *Report) copiedFileCount() int {
 	total := 0
 	for _, c := range r.copies {
@@ -215,9 +574,17 @@ func cloneTaskReport(src TaskReport) TaskReport {
 		copy(entries, src.BatchEntries)
 		dup.BatchEntries = entries
 	}
+	if len(src.Retries) > 0 {
+		dup.Retries = append([]RetryAttempt(nil), src.Retries...)
+	}
 	return dup
 }
 
+// FormatBytes renders value with binary units, as reports do.
+func FormatBytes(value int64) string {
+	return formatBytes(value)
+}
+
 func formatBytes(value int64) string {
 	if value < 0 {
 		return fmt.Sprintf("-%s", formatBytes(-value))
@@ -43,6 +43,23 @@ func (r *Report) Snapshot() ReportSnapshot {
 			snap.Deletes[i] = cloneTaskReport(tr)
 		}
 	}
+	if len(r.links) > 0 {
+		snap.Links = make([]TaskReport, len(r.links))
+		for i, tr := range r.links {
+			snap.Links[i] = cloneTaskReport(tr)
+		}
+	}
+	if len(r.failures) > 0 {
+		snap.Failures = make([]TaskFailure, len(r.failures))
+		for i, f := range r.failures {
+			snap.Failures[i] = cloneTaskFailure(f)
+		}
+	}
+	snap.Skipped = r.skipped
+	snap.Resumed = r.resumed
+	snap.PriorBytes = r.priorBytes
+	snap.FinalSync = r.finalSync
+	snap.Destinations = append([]string(nil), r.destinations...)
 	return snap
 }
 
@@ -66,6 +83,23 @@ func ReportFromSnapshot(snap ReportSnapshot) *Report {
 			report.deletes[i] = cloneTaskReport(tr)
 		}
 	}
+	if len(snap.Links) > 0 {
+		report.links = make([]TaskReport, len(snap.Links))
+		for i, tr := range snap.Links {
+			report.links[i] = cloneTaskReport(tr)
+		}
+	}
+	if len(snap.Failures) > 0 {
+		report.failures = make([]TaskFailure, len(snap.Failures))
+		for i, f := range snap.Failures {
+			report.failures[i] = cloneTaskFailure(f)
+		}
+	}
+	report.skipped = snap.Skipped
+	report.resumed = snap.Resumed
+	report.priorBytes = snap.PriorBytes
+	report.finalSync = snap.FinalSync
+	report.destinations = append([]string(nil), snap.Destinations...)
 	return report
 }
 
@@ -75,10 +109,27 @@ func (r *Report) VerboseReport() string {
 	fmt.Fprintln(&b, "Verbose Report")
 	fmt.Fprintln(&b, strings.Repeat("=", len("Verbose Report")))
 	fmt.Fprintf(&b, "Total files copied: %d\n", r.copiedFileCount())
+	if len(r.links) > 0 {
+		fmt.Fprintf(&b, "Total files linked: %d\n", len(r.links))
+	}
 	fmt.Fprintf(&b, "Total files deleted: %d\n", len(r.deletes))
+	fmt.Fprintf(&b, "Total tasks failed: %d\n", len(r.failures))
+	if r.skipped > 0 {
+		fmt.Fprintf(&b, "Total tasks skipped: %d\n", r.skipped)
+	}
+	if r.resumed > 0 {
+		fmt.Fprintf(&b, "Total tasks resumed from journal: %d\n", r.resumed)
+	}
 	fmt.Fprintf(&b, "Total bytes copied: %s\n", formatBytes(r.totalBytes))
 	fmt.Fprintf(&b, "Overall duration: %s\n", r.Duration())
 	fmt.Fprintf(&b, "Overall average speed: %s/s\n", formatBytesPerSecond(r.AverageSpeedBytes()))
+	if synced := r.SyncDuration(); synced > 0 {
+		fmt.Fprintf(&b, "Overall time in fsync: %s\n", synced)
+		if r.finalSync > 0 {
+			fmt.Fprintf(&b, "Final filesystem flush: %s\n", r.finalSync)
+		}
+	}
+	writeDestinations(&b, r.Destinations())
 
 	if len(r.copies) > 0 {
 		fmt.Fprintln(&b, "\nDetailed copies:")
@@ -87,9 +138,22 @@ func (r *Report) VerboseReport() string {
 			if copy.Source != "" {
 				fmt.Fprintf(&b, "  Source: %s\n", copy.Source)
 			}
-			fmt.Fprintf(&b, "  Hash: %s\n", copy.Hash)
+			if copy.Action == task.ActionCopyBatch {
+				fmt.Fprintf(&b, "  Archive hash (%s): %s\n", copy.HashAlgorithm.orDefault(), copy.Hash)
+			} else {
+				fmt.Fprintf(&b, "  Hash (%s): %s\n", copy.HashAlgorithm.orDefault(), copy.Hash)
+			}
 			fmt.Fprintf(&b, "  Size: %s\n", formatBytes(copy.Bytes))
+			if copy.Explanation != nil {
+				fmt.Fprintf(&b, "  Reason: %s\n", copy.Explanation)
+			}
 			fmt.Fprintf(&b, "  Duration: %s\n", copy.Duration)
+			if copy.SyncDuration > 0 {
+				fmt.Fprintf(&b, "  Fsync: %s\n", copy.SyncDuration)
+			}
+			if copy.BackupPath != "" {
+				fmt.Fprintf(&b, "  Previous version: %s\n", copy.BackupPath)
+			}
 			fmt.Fprintf(&b, "  Speed: %s/s\n", formatBytesPerSecond(speedFromCopy(copy)))
 			if !copy.StartedAt.IsZero() {
 				fmt.Fprintf(&b, "  Started: %s\n", copy.StartedAt.Format(time.RFC3339))
@@ -97,26 +161,99 @@ func (r *Report) VerboseReport() string {
 			if completed := copy.CompletedAt(); !completed.IsZero() {
 				fmt.Fprintf(&b, "  Completed: %s\n", completed.Format(time.RFC3339))
 			}
+			writeRetryDetails(&b, copy)
 			if len(copy.BatchEntries) > 0 {
 				fmt.Fprintln(&b, "  Files in batch:")
 				for _, entry := range copy.BatchEntries {
-					fmt.Fprintf(&b, "    - %s (source=%s, size=%s)\n", entry.Destination, entry.Source, formatBytes(entry.Size))
+					fmt.Fprintf(&b, "    - %s (source=%s, size=%s, %s%s)\n", entry.Destination, entry.Source, formatBytes(entry.Size), hashLabel(copy.HashAlgorithm, entry.Hash), reasonLabel(entry.ExplanationFor(0)))
 				}
 			}
 		}
 	}
 
+	if len(r.links) > 0 {
+		fmt.Fprintln(&b, "\nLinks:")
+		for _, link := range r.links {
+			fmt.Fprintf(&b, "- %s (source=%s, duration=%s%s)\n", link.Destination, link.Source, link.Duration, reasonLabel(link.Explanation))
+			writeRetryDetails(&b, link)
+		}
+	}
+
 	if len(r.deletes) > 0 {
 		fmt.Fprintln(&b, "\nDeletes:")
 		for _, del := range r.deletes {
-			fmt.Fprintf(&b, "- %s (duration=%s)\n", del.Destination, del.Duration)
+			details := []string{fmt.Sprintf("duration=%s", del.Duration)}
+			if del.SyncDuration > 0 {
+				details = append(details, fmt.Sprintf("fsync=%s", del.SyncDuration))
+			}
+			if del.BackupPath != "" {
+				details = append(details, fmt.Sprintf("trashed to %s", del.BackupPath))
+			}
+			if del.Explanation != nil {
+				details = append(details, fmt.Sprintf("reason=%s", del.Explanation.Reason))
+			}
+			fmt.Fprintf(&b, "- %s (%s)\n", del.Destination, strings.Join(details, ", "))
+			writeRetryDetails(&b, del)
+		}
+	}
+
+	if len(r.failures) > 0 {
+		fmt.Fprintln(&b, "\nFailures:")
+		for _, failure := range r.failures {
+			fmt.Fprintf(&b, "\nDestination: %s\n", failure.Destination)
+			fmt.Fprintf(&b, "  Action: %s\n", actionLabel(failure.Action))
+			if failure.Source != "" {
+				fmt.Fprintf(&b, "  Source: %s\n", failure.Source)
+			}
+			fmt.Fprintf(&b, "  Error: %s\n", failure.Error)
+			fmt.Fprintf(&b, "  Class: %s\n", failure.Class)
+			if failure.Errno != 0 {
+				fmt.Fprintf(&b, "  Errno: %d\n", failure.Errno)
+			}
+			fmt.Fprintf(&b, "  Attempts: %d\n", failure.Attempts)
+			if !failure.FailedAt.IsZero() {
+				fmt.Fprintf(&b, "  Failed: %s\n", failure.FailedAt.Format(time.RFC3339))
+			}
+			if len(failure.BatchEntries) > 0 {
+				fmt.Fprintln(&b, "  Files in batch:")
+				for _, entry := range failure.BatchEntries {
+					fmt.Fprintf(&b, "    - %s (source=%s, size=%s)\n", entry.Destination, entry.Source, formatBytes(entry.Size))
+				}
+			}
 		}
 	}
 	return b.String()
 }
 
+// reasonLabel formats the reason of a list entry, if it has one.
+func reasonLabel(e *task.Explanation) string {
+	if e == nil {
+		return ""
+	}
+	return ", reason=" + string(e.Reason)
+}
+
+// explanationReason returns the reason column of a CSV row.
+func explanationReason(e *task.Explanation) string {
+	if e == nil {
+		return ""
+	}
+	return string(e.Reason)
+}
+
+func writeRetryDetails(b *strings.Builder, tr TaskReport) {
+	if tr.Attempts <= 1 {
+		return
+	}
+	fmt.Fprintf(b, "  Attempts: %d\n", tr.Attempts)
+	for _, retry := range tr.Retries {
+		fmt.Fprintf(b, "    - attempt %d failed (class=%s), retried after %s: %s\n", retry.Attempt, retry.Class, retry.Backoff, retry.Error)
+	}
+}
+
 // WriteCSV serialises the report details into CSV format. The output contains
-// summary rows followed by a detailed breakdown of every recorded task. The
+// summary rows followed by a detailed breakdown of every recorded task, with
+// batched copies expanded into one row per file. The
 // function is deterministic so the resulting file can be diffed or processed
 // by spreadsheet tools.
 func (r *Report) WriteCSV(w io.Writer) error {
@@ -133,8 +270,14 @@ func (r *Report) WriteCSV(w io.Writer) error {
 		{"summary", "start", formatTimestamp(r.StartedAt)},
 		{"summary", "end", formatTimestamp(r.CompletedAt)},
 		{"summary", "duration_seconds", formatFloat(r.Duration().Seconds(), 3)},
+		{"summary", "fsync_seconds", formatFloat(r.SyncDuration().Seconds(), 3)},
 		{"summary", "copied_files", strconv.Itoa(r.copiedFileCount())},
+		{"summary", "linked_files", strconv.Itoa(len(r.links))},
 		{"summary", "deleted_files", strconv.Itoa(len(r.deletes))},
+		{"summary", "failed_tasks", strconv.Itoa(len(r.failures))},
+		{"summary", "skipped_tasks", strconv.Itoa(r.skipped)},
+		{"summary", "retried_tasks", strconv.Itoa(r.retriedCount())},
+		{"summary", "resumed_tasks", strconv.Itoa(r.resumed)},
 		{"summary", "bytes_copied", strconv.FormatInt(r.totalBytes, 10)},
 		{"summary", "average_bytes_per_second", formatFloat(r.AverageSpeedBytes(), 2)},
 	}
@@ -148,22 +291,53 @@ func (r *Report) WriteCSV(w io.Writer) error {
 		return err
 	}
 
-	header := []string{"action", "source", "destination", "bytes", "hash", "duration_seconds", "started_at", "completed_at", "speed_bytes_per_sec"}
+	header := []string{"action", "source", "destination", "bytes", "hash", "hash_algorithm", "duration_seconds", "fsync_seconds", "started_at", "completed_at", "speed_bytes_per_sec", "attempts", "reason"}
 	if err := writer.Write(header); err != nil {
 		return err
 	}
 
 	for _, copy := range r.copies {
+		if copy.Action == task.ActionCopyBatch && len(copy.BatchEntries) > 0 {
+			if err := writeBatchCSV(writer, copy); err != nil {
+				return err
+			}
+			continue
+		}
 		record := []string{
 			actionLabel(copy.Action),
 			copy.Source,
 			copy.Destination,
 			strconv.FormatInt(copy.Bytes, 10),
 			copy.Hash,
+			string(copy.HashAlgorithm.orDefault()),
 			formatFloat(copy.Duration.Seconds(), 3),
+			formatFloat(copy.SyncDuration.Seconds(), 3),
 			formatTimestamp(copy.StartedAt),
 			formatTimestamp(copy.CompletedAt()),
 			formatFloat(speedFromCopy(copy), 2),
+			formatAttempts(copy.Attempts),
+			explanationReason(copy.Explanation),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	for _, link := range r.links {
+		record := []string{
+			actionLabel(task.ActionLink),
+			link.Source,
+			link.Destination,
+			"",
+			"",
+			"",
+			formatFloat(link.Duration.Seconds(), 3),
+			formatFloat(link.SyncDuration.Seconds(), 3),
+			formatTimestamp(link.StartedAt),
+			formatTimestamp(link.CompletedAt()),
+			"",
+			formatAttempts(link.Attempts),
+			explanationReason(link.Explanation),
 		}
 		if err := writer.Write(record); err != nil {
 			return err
@@ -177,20 +351,78 @@ func (r *Report) WriteCSV(w io.Writer) error {
 			del.Destination,
 			"",
 			"",
+			"",
 			formatFloat(del.Duration.Seconds(), 3),
+			formatFloat(del.SyncDuration.Seconds(), 3),
 			formatTimestamp(del.StartedAt),
 			formatTimestamp(del.CompletedAt()),
 			"",
+			formatAttempts(del.Attempts),
+			explanationReason(del.Explanation),
 		}
 		if err := writer.Write(record); err != nil {
 			return err
 		}
 	}
 
+	if len(r.failures) > 0 {
+		if err := writer.Write(nil); err != nil {
+			return err
+		}
+		failureHeader := []string{"failure", "action", "source", "destination", "error_class", "errno", "attempts", "failed_at", "error"}
+		if err := writer.Write(failureHeader); err != nil {
+			return err
+		}
+		for _, failure := range r.failures {
+			record := []string{
+				"failure",
+				actionLabel(failure.Action),
+				failure.Source,
+				failure.Destination,
+				string(failure.Class),
+				strconv.Itoa(failure.Errno),
+				strconv.Itoa(failure.Attempts),
+				formatTimestamp(failure.FailedAt),
+				failure.Error,
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+
 	writer.Flush()
 	return writer.Error()
 }
 
+// writeBatchCSV expands a batched copy into one row per file so every file
+// can be audited by its own hash. The rows share the batch's timing; no
+// per-file speed is reported because files in a batch are not timed
+// individually.
+func writeBatchCSV(writer *csv.Writer, batch TaskReport) error {
+	for _, entry := range batch.BatchEntries {
+		record := []string{
+			actionLabel(batch.Action),
+			entry.Source,
+			entry.Destination,
+			strconv.FormatInt(entry.Size, 10),
+			entry.Hash,
+			string(batch.HashAlgorithm.orDefault()),
+			formatFloat(batch.Duration.Seconds(), 3),
+			formatFloat(batch.SyncDuration.Seconds(), 3),
+			formatTimestamp(batch.StartedAt),
+			formatTimestamp(batch.CompletedAt()),
+			"",
+			formatAttempts(batch.Attempts),
+			explanationReason(entry.ExplanationFor(0)),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // WritePDF produces a standalone PDF summary of the report including a brief
 // description of the run and simple ASCII bar charts for the largest
 // transfers. The generated PDF is intentionally lightweight and avoids third
@@ -235,6 +467,21 @@ func (r *Report) WritePDF(w io.Writer) error {
 	return err
 }
 
+// hashAlgorithms lists the distinct digests used by the recorded copies.
+func (r *Report) hashAlgorithms() []string {
+	seen := map[HashAlgorithm]bool{}
+	var algs []string
+	for _, c := range r.copies {
+		alg := c.HashAlgorithm.orDefault()
+		if !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, string(alg))
+		}
+	}
+	sort.Strings(algs)
+	return algs
+}
+
 func (r *Report) pdfLines() []string {
 	const title = "Migration Report"
 
@@ -246,10 +493,18 @@ func (r *Report) pdfLines() []string {
 		fmt.Sprintf("Duration: %s", r.Duration()),
 		"",
 		fmt.Sprintf("Files copied: %d", r.copiedFileCount()),
+		fmt.Sprintf("Files linked: %d", len(r.links)),
 		fmt.Sprintf("Files deleted: %d", len(r.deletes)),
+		fmt.Sprintf("Tasks failed: %d", len(r.failures)),
 		fmt.Sprintf("Bytes copied: %s", formatBytes(r.totalBytes)),
 		fmt.Sprintf("Average speed: %s/s", formatBytesPerSecond(r.AverageSpeedBytes())),
 	}
+	if synced := r.SyncDuration(); synced > 0 {
+		lines = append(lines, fmt.Sprintf("Time in fsync: %s", synced))
+	}
+	if algs := r.hashAlgorithms(); len(algs) > 0 {
+		lines = append(lines, fmt.Sprintf("Hash algorithm: %s", strings.Join(algs, ", ")))
+	}
 
 	copies := append([]TaskReport(nil), r.copies...)
 	sort.Slice(copies, func(i, j int) bool {
@@ -291,6 +546,21 @@ func (r *Report) pdfLines() []string {
 		}
 	}
 
+	if len(r.failures) > 0 {
+		lines = append(lines, "", "Failed Tasks", "------------")
+		limit := len(r.failures)
+		if limit > 5 {
+			limit = 5
+		}
+		for i := 0; i < limit; i++ {
+			failure := r.failures[i]
+			lines = append(lines, fmt.Sprintf("- %s [%s]", truncateText(relPath(failure.Destination), 50), failureClassLabel(failure)))
+		}
+		if len(r.failures) > limit {
+			lines = append(lines, fmt.Sprintf("- ... %d more", len(r.failures)-limit))
+		}
+	}
+
 	return lines
 }
 
@@ -309,11 +579,20 @@ func actionLabel(action task.Action) string {
 		return "copy_batch"
 	case task.ActionDelete:
 		return "delete"
+	case task.ActionLink:
+		return "link"
 	default:
 		return fmt.Sprintf("action_%d", action)
 	}
 }
 
+func formatAttempts(attempts int) string {
+	if attempts <= 0 {
+		attempts = 1
+	}
+	return strconv.Itoa(attempts)
+}
+
 func formatFloat(v float64, precision int) string {
 	if math.IsNaN(v) || math.IsInf(v, 0) {
 		return "0"
@@ -22,13 +22,15 @@ func TestReportWriteCSV(t *testing.T) {
 
 	report := &Report{StartedAt: base}
 	report.add(&TaskReport{
-		Action:      task.ActionCopy,
-		Source:      "/src/a.txt",
-		Destination: "/dst/a.txt",
-		Bytes:       2048,
-		Hash:        "abc123",
-		StartedAt:   copyStart,
-		Duration:    copyDuration,
+		Action:       task.ActionCopy,
+		Source:       "/src/a.txt",
+		Destination:  "/dst/a.txt",
+		Bytes:        2048,
+		Hash:         "abc123",
+		StartedAt:    copyStart,
+		Duration:     copyDuration,
+		SyncDuration: 250 * time.Millisecond,
+		Explanation:  &task.Explanation{Reason: task.ReasonSize},
 	})
 	report.add(&TaskReport{
 		Action:      task.ActionDelete,
@@ -55,13 +57,19 @@ func TestReportWriteCSV(t *testing.T) {
 		{"summary", "start", base.Format(time.RFC3339)},
 		{"summary", "end", report.CompletedAt.Format(time.RFC3339)},
 		{"summary", "duration_seconds", "3.000"},
+		{"summary", "fsync_seconds", "0.250"},
 		{"summary", "copied_files", "1"},
+		{"summary", "linked_files", "0"},
 		{"summary", "deleted_files", "1"},
+		{"summary", "failed_tasks", "0"},
+		{"summary", "skipped_tasks", "0"},
+		{"summary", "retried_tasks", "0"},
+		{"summary", "resumed_tasks", "0"},
 		{"summary", "bytes_copied", "2048"},
 		{"summary", "average_bytes_per_second", "682.67"},
-		{"action", "source", "destination", "bytes", "hash", "duration_seconds", "started_at", "completed_at", "speed_bytes_per_sec"},
-		{"copy", "/src/a.txt", "/dst/a.txt", "2048", "abc123", "2.000", copyStart.Format(time.RFC3339), copyStart.Add(copyDuration).Format(time.RFC3339), "1024.00"},
-		{"delete", "", "/dst/old.txt", "", "", "1.500", deleteStart.Format(time.RFC3339), deleteStart.Add(deleteDuration).Format(time.RFC3339), ""},
+		{"action", "source", "destination", "bytes", "hash", "hash_algorithm", "duration_seconds", "fsync_seconds", "started_at", "completed_at", "speed_bytes_per_sec", "attempts", "reason"},
+		{"copy", "/src/a.txt", "/dst/a.txt", "2048", "abc123", "sha256", "2.000", "0.250", copyStart.Format(time.RFC3339), copyStart.Add(copyDuration).Format(time.RFC3339), "1024.00", "1", "size"},
+		{"delete", "", "/dst/old.txt", "", "", "", "1.500", "0.000", deleteStart.Format(time.RFC3339), deleteStart.Add(deleteDuration).Format(time.RFC3339), "", "1", ""},
 	}
 
 	if len(records) != len(wantRecords) {
@@ -74,6 +82,55 @@ func TestReportWriteCSV(t *testing.T) {
 	}
 }
 
+func TestReportWriteCSVExpandsBatches(t *testing.T) {
+	start := time.Date(2024, 5, 20, 15, 4, 5, 0, time.UTC)
+	report := &Report{StartedAt: start}
+	report.add(&TaskReport{
+		Action:        task.ActionCopyBatch,
+		Source:        "/src/a.txt",
+		Destination:   "/dst/a.txt (batch of 2 files)",
+		Bytes:         30,
+		Hash:          "archive",
+		HashAlgorithm: HashCRC32C,
+		StartedAt:     start,
+		Duration:      time.Second,
+		BatchEntries: []task.CopyBatchEntry{
+			{Source: "/src/a.txt", Destination: "/dst/a.txt", Size: 10, Hash: "hash-a", Explanations: []task.Explanation{{Reason: task.ReasonNew}}},
+			{Source: "/src/b.txt", Destination: "/dst/b.txt", Size: 20, Hash: "hash-b"},
+		},
+	})
+	report.CompletedAt = start.Add(time.Second)
+	report.markComplete()
+
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV returned error: %v", err)
+	}
+	reader := csv.NewReader(bytes.NewReader(buf.Bytes()))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to parse csv output: %v", err)
+	}
+
+	completed := start.Add(time.Second).Format(time.RFC3339)
+	wantRows := [][]string{
+		{"copy_batch", "/src/a.txt", "/dst/a.txt", "10", "hash-a", "crc32c", "1.000", "0.000", start.Format(time.RFC3339), completed, "", "1", "new"},
+		{"copy_batch", "/src/b.txt", "/dst/b.txt", "20", "hash-b", "crc32c", "1.000", "0.000", start.Format(time.RFC3339), completed, "", "1", ""},
+	}
+	rows := records[len(records)-len(wantRows):]
+	for i := range wantRows {
+		if strings.Join(rows[i], "|") != strings.Join(wantRows[i], "|") {
+			t.Fatalf("row %d mismatch:\n got %v\nwant %v", i, rows[i], wantRows[i])
+		}
+	}
+
+	verbose := report.VerboseReport()
+	if !strings.Contains(verbose, "/dst/b.txt (source=/src/b.txt, size=20 B, crc32c=hash-b)") {
+		t.Fatalf("verbose report missing per-file hash:\n%s", verbose)
+	}
+}
+
 func TestReportWritePDF(t *testing.T) {
 	base := time.Date(2024, 2, 1, 9, 30, 0, 0, time.UTC)
 	report := &Report{StartedAt: base}
@@ -0,0 +1,144 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/repo"
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+// runRepository executes t against a deduplicating repository instead of a
+// plain directory. Destinations are paths below the repository root, as
+// planned by a scan whose Destination lists the same run.
+func (e *Executor) runRepository(t task.Task) (*TaskReport, error) {
+	run := e.Repository
+	switch t.Action {
+	case task.ActionCopy:
+		if e.Verbose {
+			log.Printf("store %s -> %s", t.Src, t.Dst)
+		}
+		start := time.Now()
+		bytes, hash, err := e.putFile(run, t.Src, t.Dst)
+		if err != nil {
+			return nil, err
+		}
+		return &TaskReport{
+			Action:        t.Action,
+			Source:        t.Src,
+			Destination:   t.Dst,
+			Bytes:         bytes,
+			Hash:          hash,
+			HashAlgorithm: e.HashAlgorithm.orDefault(),
+			StartedAt:     start,
+			Duration:      time.Since(start),
+		}, nil
+	case task.ActionCopyBatch:
+		if t.Batch == nil {
+			return nil, fmt.Errorf("copy batch task missing payload")
+		}
+		if !t.Batch.Lazy() {
+			return nil, fmt.Errorf("repository destinations do not accept embedded batch archives")
+		}
+		if e.Verbose {
+			log.Printf("store batch (%d files)", len(t.Batch.Entries))
+		}
+		start := time.Now()
+		entries := make([]task.CopyBatchEntry, len(t.Batch.Entries))
+		var total int64
+		for i, entry := range t.Batch.Entries {
+			bytes, hash, err := e.putFile(run, entry.Source, entry.Destination)
+			if err != nil {
+				return nil, err
+			}
+			entry.Size = bytes
+			entry.Hash = hash
+			entries[i] = entry
+			total += bytes
+		}
+		destination := fmt.Sprintf("batch of %d files", len(entries))
+		source := ""
+		if len(entries) > 0 {
+			source = entries[0].Source
+			destination = fmt.Sprintf("%s (batch of %d files)", entries[0].Destination, len(entries))
+		}
+		return &TaskReport{
+			Action:        t.Action,
+			Source:        source,
+			Destination:   destination,
+			Bytes:         total,
+			HashAlgorithm: e.HashAlgorithm.orDefault(),
+			StartedAt:     start,
+			Duration:      time.Since(start),
+			BatchEntries:  entries,
+		}, nil
+	case task.ActionDelete:
+		if e.Verbose {
+			log.Printf("unreference %s", t.Dst)
+		}
+		start := time.Now()
+		rel, err := filepath.Rel(run.Root(), t.Dst)
+		if err != nil {
+			return nil, err
+		}
+		if err := run.Remove(rel); err != nil {
+			return nil, err
+		}
+		return &TaskReport{
+			Action:      t.Action,
+			Destination: t.Dst,
+			StartedAt:   start,
+			Duration:    time.Since(start),
+		}, nil
+	default:
+		return nil, fmt.Errorf("action %s is not supported by repository destinations", t.Action)
+	}
+}
+
+// putFile streams src into run as dst, applying the bandwidth limit and
+// hashing the content on the way.
+func (e *Executor) putFile(run *repo.Run, src, dst string) (int64, string, error) {
+	rel, err := filepath.Rel(run.Root(), dst)
+	if err != nil {
+		return 0, "", err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, "", err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return 0, "", err
+	}
+
+	pr, pw := io.Pipe()
+	type copyResult struct {
+		hash string
+		err  error
+	}
+	done := make(chan copyResult, 1)
+	go func() {
+		_, hash, err := copyWithBandwidth(pw, in, e.BandwidthLimit, e.HashAlgorithm)
+		pw.CloseWithError(err)
+		done <- copyResult{hash: hash, err: err}
+	}()
+	stored, err := run.Put(rel, pr, info)
+	// Unblock the copy if Put gave up early.
+	pr.CloseWithError(err)
+	res := <-done
+	if err != nil {
+		return stored, "", err
+	}
+	if res.err != nil {
+		return stored, "", res.err
+	}
+	if stored != info.Size() {
+		return stored, "", fmt.Errorf("%w: %s changed size while being stored (%d of %d bytes)", ErrIntegrity, src, stored, info.Size())
+	}
+	return stored, res.hash, nil
+}
@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/repo"
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+func TestPoolWritesIntoRepository(t *testing.T) {
+	srcDir := t.TempDir()
+	root := filepath.Join(t.TempDir(), "repo")
+	for rel, data := range map[string]string{"a.txt": "alpha", filepath.Join("dir", "b.txt"): "beta"} {
+		path := filepath.Join(srcDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create parent: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+	r, err := repo.OpenOrInit(root)
+	if err != nil {
+		t.Fatalf("OpenOrInit returned error: %v", err)
+	}
+	run, err := r.NewRun(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("NewRun returned error: %v", err)
+	}
+
+	pool := New(2, false, 0)
+	pool.Repository = run
+	report, err := pool.Run(taskChannel(
+		task.Task{Action: task.ActionCopy, Src: filepath.Join(srcDir, "a.txt"), Dst: filepath.Join(root, "a.txt")},
+		task.Task{Action: task.ActionCopyBatch, Batch: &task.CopyBatchPayload{Entries: []task.CopyBatchEntry{
+			{Source: filepath.Join(srcDir, "dir", "b.txt"), Destination: filepath.Join(root, "dir", "b.txt"), Size: 4},
+		}}},
+	))
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if report.copiedFileCount() != 2 || report.TotalBytes() != int64(len("alpha")+len("beta")) {
+		t.Fatalf("unexpected report: %d files, %d bytes", report.copiedFileCount(), report.TotalBytes())
+	}
+	if _, err := os.Stat(filepath.Join(root, "a.txt")); !os.IsNotExist(err) {
+		t.Fatalf("repository copies must not create plain files, got %v", err)
+	}
+	if err := run.Commit(); err != nil {
+		t.Fatalf("Commit returned error: %v", err)
+	}
+
+	out := t.TempDir()
+	if n, err := r.Restore("latest", "", out); err != nil || n != 2 {
+		t.Fatalf("Restore returned %d, %v", n, err)
+	}
+	assertContents(t, filepath.Join(out, "dir", "b.txt"), "beta")
+}
@@ -0,0 +1,139 @@
+package worker
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+// DefaultRetryableClasses lists the error classes that are retried when a
+// RetryPolicy does not specify its own set. They cover transient NFS and
+// storage conditions that commonly clear up on their own.
+var DefaultRetryableClasses = []ErrorClass{
+	ErrorClassIO,
+	ErrorClassStale,
+	ErrorClassTimeout,
+	ErrorClassBusy,
+	ErrorClassNoSpace,
+}
+
+// RetryPolicy controls how often a failed task is attempted again and how
+// long the executor waits between attempts. The zero value disables retries.
+type RetryPolicy struct {
+	// MaxAttempts caps the total number of attempts including the first one.
+	// A value <= 1 disables retries.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts. A value <= 0 means no cap.
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after every attempt. Values < 1 are treated
+	// as 1.
+	Multiplier float64
+	// Jitter randomly shortens each delay by up to this fraction (0-1) so
+	// workers that failed together do not retry in lockstep.
+	Jitter float64
+	// RetryableClasses overrides DefaultRetryableClasses when non-nil.
+	RetryableClasses []ErrorClass
+}
+
+// RetryAttempt records a failed attempt that was followed by a retry.
+type RetryAttempt struct {
+	Attempt int
+	Error   string
+	Class   ErrorClass
+	Errno   int
+	Backoff time.Duration
+}
+
+// DefaultRetryPolicy returns the policy used by the CLI when retries are
+// enabled without further tuning.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// Retryable reports whether errors of the given class should be retried.
+func (p RetryPolicy) Retryable(class ErrorClass) bool {
+	classes := p.RetryableClasses
+	if classes == nil {
+		classes = DefaultRetryableClasses
+	}
+	for _, c := range classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns the delay to wait after the given failed attempt (1-based).
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	return p.backoff(attempt, rand.Float64)
+}
+
+func (p RetryPolicy) backoff(attempt int, random func() float64) time.Duration {
+	if p.InitialBackoff <= 0 || attempt < 1 {
+		return 0
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	jitter := p.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+	if jitter > 0 {
+		delay -= delay * jitter * random()
+	}
+	return time.Duration(delay)
+}
+
+// ParseRetryOverrides parses a comma separated list of action=retries pairs,
+// for example "copy=5,delete=0", into per-action attempt limits. The value is
+// the number of retries after the first attempt.
+func ParseRetryOverrides(s string) (map[task.Action]int, error) {
+	overrides := map[task.Action]int{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("retry override %q: expected action=retries", part)
+		}
+		action, err := task.ParseAction(name)
+		if err != nil {
+			return nil, fmt.Errorf("retry override %q: %w", part, err)
+		}
+		retries, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || retries < 0 {
+			return nil, fmt.Errorf("retry override %q: invalid retry count", part)
+		}
+		overrides[action] = retries
+	}
+	return overrides, nil
+}
@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     300 * time.Millisecond,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+	noJitter := func() float64 { return 0 }
+	fullJitter := func() float64 { return 1 }
+
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	for i, expected := range want {
+		if got := policy.backoff(i+1, noJitter); got != expected {
+			t.Fatalf("attempt %d: got %s want %s", i+1, got, expected)
+		}
+	}
+	if got := policy.backoff(2, fullJitter); got != 100*time.Millisecond {
+		t.Fatalf("expected jitter to halve the delay, got %s", got)
+	}
+}
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	policy := DefaultRetryPolicy()
+	for _, class := range []ErrorClass{ErrorClassIO, ErrorClassStale, ErrorClassTimeout, ErrorClassNoSpace} {
+		if !policy.Retryable(class) {
+			t.Fatalf("expected %s to be retryable", class)
+		}
+	}
+	for _, class := range []ErrorClass{ErrorClassPermission, ErrorClassNotFound, ErrorClassOther} {
+		if policy.Retryable(class) {
+			t.Fatalf("expected %s to be fatal", class)
+		}
+	}
+}
+
+func TestExecutorRetriesTransientFailure(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	src := filepath.Join(srcDir, "late.txt")
+	dst := filepath.Join(dstDir, "late.txt")
+
+	exec := NewExecutor(false, 0)
+	exec.ActionRetry = map[task.Action]RetryPolicy{
+		task.ActionCopy: {
+			MaxAttempts:      3,
+			InitialBackoff:   time.Second,
+			RetryableClasses: []ErrorClass{ErrorClassNotFound},
+		},
+	}
+	var waits []time.Duration
+	exec.sleep = func(d time.Duration) {
+		waits = append(waits, d)
+		if len(waits) > 1 {
+			return
+		}
+		// The source appears while the executor is backing off.
+		if err := os.WriteFile(src, []byte("eventually"), 0o644); err != nil {
+			t.Fatalf("failed to write source: %v", err)
+		}
+	}
+
+	report, err := exec.RunTask(task.Task{Action: task.ActionCopy, Src: src, Dst: dst})
+	if err != nil {
+		t.Fatalf("RunTask returned error: %v", err)
+	}
+	if report.Attempts != 2 {
+		t.Fatalf("unexpected attempts: got %d want 2", report.Attempts)
+	}
+	if len(report.Retries) != 1 || report.Retries[0].Class != ErrorClassNotFound {
+		t.Fatalf("unexpected retries: %+v", report.Retries)
+	}
+	if len(waits) != 1 || waits[0] != time.Second {
+		t.Fatalf("unexpected backoff waits: %v", waits)
+	}
+
+	// A source that never appears exhausts the attempts.
+	if _, err := exec.RunTask(task.Task{Action: task.ActionCopy, Src: filepath.Join(srcDir, "never"), Dst: dst}); err == nil {
+		t.Fatal("expected copy of a missing source to fail after exhausting retries")
+	}
+	if len(waits) != 3 {
+		t.Fatalf("expected two more backoff waits, got %v", waits)
+	}
+}
+
+func TestParseRetryOverrides(t *testing.T) {
+	overrides, err := ParseRetryOverrides("copy=5, delete=0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if overrides[task.ActionCopy] != 5 || overrides[task.ActionDelete] != 0 || len(overrides) != 2 {
+		t.Fatalf("unexpected overrides: %v", overrides)
+	}
+	if _, err := ParseRetryOverrides("move=1"); err == nil {
+		t.Fatal("expected error for unknown action")
+	}
+}
@@ -0,0 +1,41 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// NextSnapshot returns the directory for a snapshot of root taken at now and
+// the newest earlier snapshot, or an empty string when there is none.
+// Snapshots are subdirectories of root named after the UTC time they were
+// taken, using the same stamps as backup trees. Other entries in root are
+// ignored.
+func NextSnapshot(root string, now time.Time) (string, string, error) {
+	stamp := now.UTC().Format(backupStampLayout)
+	entries, err := os.ReadDir(root)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", "", err
+	}
+	previous := ""
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || name >= stamp || name <= previous {
+			continue
+		}
+		if _, err := time.Parse(backupStampLayout, name); err == nil {
+			previous = name
+		}
+	}
+	dir := filepath.Join(root, stamp)
+	if _, err := os.Lstat(dir); err == nil {
+		return "", "", fmt.Errorf("snapshot %s already exists", dir)
+	}
+	if previous != "" {
+		previous = filepath.Join(root, previous)
+	}
+	return dir, previous, nil
+}
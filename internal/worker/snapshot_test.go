@@ -0,0 +1,142 @@
+package worker
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+func TestRunTaskLinksReferenceFile(t *testing.T) {
+	refDir := t.TempDir()
+	dstDir := t.TempDir()
+	ref := filepath.Join(refDir, "a.txt")
+	if err := os.WriteFile(ref, []byte("unchanged"), 0o644); err != nil {
+		t.Fatalf("failed to write reference: %v", err)
+	}
+	dst := filepath.Join(dstDir, "nested", "a.txt")
+
+	exec := NewExecutor(false, 0)
+	exec.DestinationRoots = []string{dstDir}
+	res, err := exec.RunTask(task.Task{Action: task.ActionLink, Src: ref, Dst: dst})
+	if err != nil {
+		t.Fatalf("RunTask returned error: %v", err)
+	}
+	if res.Action != task.ActionLink || res.Bytes != 0 {
+		t.Fatalf("unexpected report: %+v", res)
+	}
+	refInfo, err := os.Stat(ref)
+	if err != nil {
+		t.Fatalf("failed to stat reference: %v", err)
+	}
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		t.Fatalf("failed to stat link: %v", err)
+	}
+	if !os.SameFile(refInfo, dstInfo) {
+		t.Fatalf("expected %s to be a hard link to %s", dst, ref)
+	}
+
+	report := NewReport()
+	report.Record(res)
+	if report.LinkCount() != 1 || report.CopyCount() != 0 {
+		t.Fatalf("expected one link and no copies, got %d and %d", report.LinkCount(), report.CopyCount())
+	}
+}
+
+func TestLinkedReferenceSurvivesLaterUpdate(t *testing.T) {
+	// Zero-copy applies without a bandwidth limit; the limit forces the
+	// buffered copy.
+	for _, limit := range []int64{0, 1 << 20} {
+		refDir := t.TempDir()
+		srcDir := t.TempDir()
+		dstDir := t.TempDir()
+		ref := filepath.Join(refDir, "a.txt")
+		src := filepath.Join(srcDir, "a.txt")
+		dst := filepath.Join(dstDir, "a.txt")
+		if err := os.WriteFile(ref, []byte("reference"), 0o644); err != nil {
+			t.Fatalf("failed to write reference: %v", err)
+		}
+		if err := os.WriteFile(src, []byte("updated content"), 0o644); err != nil {
+			t.Fatalf("failed to write source: %v", err)
+		}
+
+		exec := NewExecutor(false, limit)
+		exec.DestinationRoots = []string{dstDir}
+		if _, err := exec.RunTask(task.Task{Action: task.ActionLink, Src: ref, Dst: dst}); err != nil {
+			t.Fatalf("link run returned error: %v", err)
+		}
+		if _, err := exec.RunTask(task.Task{Action: task.ActionCopy, Src: src, Dst: dst}); err != nil {
+			t.Fatalf("update run returned error: %v", err)
+		}
+
+		if data, err := os.ReadFile(ref); err != nil || string(data) != "reference" {
+			t.Fatalf("limit %d: reference changed to %q (%v)", limit, data, err)
+		}
+		if data, err := os.ReadFile(dst); err != nil || string(data) != "updated content" {
+			t.Fatalf("limit %d: destination holds %q (%v)", limit, data, err)
+		}
+		entries, err := os.ReadDir(dstDir)
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("limit %d: expected only the destination file, got %v (%v)", limit, entries, err)
+		}
+	}
+}
+
+func TestNextSnapshot(t *testing.T) {
+	root := t.TempDir()
+	now := time.Date(2024, 6, 3, 9, 30, 0, 0, time.UTC)
+
+	dir, previous, err := NextSnapshot(filepath.Join(root, "missing"), now)
+	if err != nil || previous != "" || filepath.Base(dir) != "20240603T093000Z" {
+		t.Fatalf("first snapshot: got %q, %q, %v", dir, previous, err)
+	}
+
+	for _, name := range []string{"20240601T000000Z", "20240602T000000Z", "20240604T000000Z", "latest"} {
+		if err := os.MkdirAll(filepath.Join(root, name), 0o755); err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+	}
+	dir, previous, err = NextSnapshot(root, now)
+	if err != nil {
+		t.Fatalf("NextSnapshot returned error: %v", err)
+	}
+	if dir != filepath.Join(root, "20240603T093000Z") || previous != filepath.Join(root, "20240602T000000Z") {
+		t.Fatalf("unexpected snapshots %q and %q", dir, previous)
+	}
+
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatalf("failed to create %s: %v", dir, err)
+	}
+	if _, _, err := NextSnapshot(root, now); err == nil {
+		t.Fatal("expected an existing snapshot to be rejected")
+	}
+}
+
+func TestLinkUnsupportedOnlyCoversMissingLinkSupport(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{syscall.EXDEV, true},
+		{syscall.EMLINK, true},
+		{syscall.ENOTSUP, true},
+		{errors.ErrUnsupported, true},
+		{syscall.EPERM, false},
+		{syscall.EACCES, false},
+		{nil, false},
+	}
+	for _, tc := range cases {
+		var err error
+		if tc.err != nil {
+			err = &os.LinkError{Op: "link", Old: "a", New: "b", Err: tc.err}
+		}
+		if got := linkUnsupported(err); got != tc.want {
+			t.Fatalf("linkUnsupported(%v) = %v, want %v", err, got, tc.want)
+		}
+	}
+}
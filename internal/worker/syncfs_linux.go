@@ -0,0 +1,7 @@
+//go:build linux && !amd64 && !386
+
+package worker
+
+import "syscall"
+
+const sysSyncFS = syscall.SYS_SYNCFS
@@ -0,0 +1,4 @@
+package worker
+
+// The frozen syscall tables of amd64 predate syncfs(2).
+const sysSyncFS = 306
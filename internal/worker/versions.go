@@ -0,0 +1,301 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VersionStore keeps previous revisions of destination files that a run
+// overwrites or deletes. Each revision is stored as
+// Dir/<path relative to its destination root>/<run stamp>, so all revisions
+// of a file live in one directory. Like BackupTree, a store never replaces a
+// revision: a path saved twice under one stamp gets <run stamp>.1, .2, ...
+type VersionStore struct {
+	// Dir is the root of the store.
+	Dir string
+	// Stamp names the revisions saved by this run.
+	Stamp string
+	// Roots are the destination roots paths are made relative to.
+	Roots []string
+	// KeepVersions is the number of revisions retained per path. A value
+	// <= 0 keeps every revision.
+	KeepVersions int
+	// MaxAge removes revisions older than this. A value <= 0 keeps
+	// revisions regardless of age.
+	MaxAge time.Duration
+
+	now time.Time
+}
+
+// Version describes one stored revision.
+type Version struct {
+	// Path is the file's path relative to its destination root.
+	Path string
+	// Stamp identifies the run that replaced the revision. It carries a
+	// .N suffix for the later revisions of a path saved under one stamp.
+	Stamp string
+	// SavedAt is the time encoded in Stamp.
+	SavedAt time.Time
+	// Size and ModTime describe the stored content.
+	Size    int64
+	ModTime time.Time
+	// Location is where the revision is stored.
+	Location string
+
+	// seq orders revisions saved under the same stamp.
+	seq int
+}
+
+// parseRevisionName parses the name of a stored revision: a run stamp,
+// optionally followed by .N.
+func parseRevisionName(name string) (time.Time, int, bool) {
+	stamp, suffix, found := strings.Cut(name, ".")
+	seq := 0
+	if found {
+		n, err := strconv.Atoi(suffix)
+		if err != nil || n <= 0 || strconv.Itoa(n) != suffix {
+			return time.Time{}, 0, false
+		}
+		seq = n
+	}
+	savedAt, err := time.Parse(backupStampLayout, stamp)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	return savedAt, seq, true
+}
+
+// newerRevision reports whether a was saved after b.
+func newerRevision(a, b Version) bool {
+	if !a.SavedAt.Equal(b.SavedAt) {
+		return a.SavedAt.After(b.SavedAt)
+	}
+	return a.seq > b.seq
+}
+
+// NewVersionStore returns a VersionStore whose revisions are stamped with
+// now.
+func NewVersionStore(dir string, roots []string, now time.Time) *VersionStore {
+	return &VersionStore{Dir: dir, Stamp: now.UTC().Format(backupStampLayout), Roots: roots, now: now}
+}
+
+// ParseVersionTime parses a point in time given either as RFC 3339 or as a
+// run stamp such as 20240601T120000Z.
+func ParseVersionTime(s string) (time.Time, error) {
+	if t, err := time.Parse(backupStampLayout, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: expected RFC 3339 or %s", s, backupStampLayout)
+	}
+	return t, nil
+}
+
+// Save moves path into the store and applies the retention rules to the
+// path's revisions. A directory is stored file by file and then removed.
+// It returns the stored location of path, or an empty string when path does
+// not exist.
+func (s *VersionStore) Save(path string) (string, error) {
+	if s == nil {
+		return "", nil
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	if !info.IsDir() {
+		return s.saveFile(path)
+	}
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil || d.IsDir() {
+			return walkErr
+		}
+		_, err := s.saveFile(p)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	rel, err := relativeToRoots(s.Roots, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.Dir, rel), os.RemoveAll(path)
+}
+
+func (s *VersionStore) saveFile(path string) (string, error) {
+	rel, err := relativeToRoots(s.Roots, path)
+	if err != nil {
+		return "", err
+	}
+	target, err := unusedPath(filepath.Join(s.Dir, rel, s.Stamp))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return "", err
+	}
+	if err := moveEntry(path, target); err != nil {
+		return "", fmt.Errorf("saving version of %s: %w", path, err)
+	}
+	if _, err := s.prunePath(rel); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// List returns the stored revisions of rel, or of every path when rel is
+// empty, ordered by path and then newest first.
+func (s *VersionStore) List(rel string) ([]Version, error) {
+	root := s.Dir
+	if rel != "" {
+		root = filepath.Join(s.Dir, filepath.Clean(rel))
+	}
+	var versions []Version
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if errors.Is(walkErr, fs.ErrNotExist) && p == root {
+				return filepath.SkipDir
+			}
+			return walkErr
+		}
+		savedAt, seq, ok := parseRevisionName(d.Name())
+		if !ok || p == root {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(s.Dir, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		versions = append(versions, Version{
+			Path:     relPath,
+			Stamp:    d.Name(),
+			SavedAt:  savedAt,
+			Size:     info.Size(),
+			ModTime:  info.ModTime(),
+			Location: p,
+			seq:      seq,
+		})
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].Path != versions[j].Path {
+			return versions[i].Path < versions[j].Path
+		}
+		return newerRevision(versions[i], versions[j])
+	})
+	return versions, nil
+}
+
+// Find returns the newest revision of rel saved at or before at. A zero at
+// selects the newest revision.
+func (s *VersionStore) Find(rel string, at time.Time) (Version, error) {
+	versions, err := s.List(rel)
+	if err != nil {
+		return Version{}, err
+	}
+	rel = filepath.Clean(rel)
+	for _, v := range versions {
+		if v.Path != rel {
+			continue
+		}
+		if at.IsZero() || !v.SavedAt.After(at) {
+			return v, nil
+		}
+	}
+	return Version{}, fmt.Errorf("no version of %s in %s", rel, s.Dir)
+}
+
+// Restore copies revision v to dst. The current content of dst, if any, is
+// saved as a revision first so the restore can itself be undone.
+func (s *VersionStore) Restore(v Version, dst string) error {
+	if _, err := s.Save(dst); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return copyTree(v.Location, dst)
+}
+
+// Prune applies the retention rules to every path in the store and returns
+// the removed revisions.
+func (s *VersionStore) Prune() ([]string, error) {
+	versions, err := s.List("")
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	seen := map[string]bool{}
+	for _, v := range versions {
+		if seen[v.Path] {
+			continue
+		}
+		seen[v.Path] = true
+		pruned, err := s.prunePath(v.Path)
+		removed = append(removed, pruned...)
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// prunePath removes the revisions of rel that exceed KeepVersions or are
+// older than MaxAge. The newest revision is never removed for its age so a
+// deleted file stays recoverable.
+func (s *VersionStore) prunePath(rel string) ([]string, error) {
+	if s.KeepVersions <= 0 && s.MaxAge <= 0 {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(filepath.Join(s.Dir, rel))
+	if err != nil {
+		return nil, err
+	}
+	var revisions []Version
+	for _, entry := range entries {
+		if savedAt, seq, ok := parseRevisionName(entry.Name()); ok {
+			revisions = append(revisions, Version{Stamp: entry.Name(), SavedAt: savedAt, seq: seq})
+		}
+	}
+	sort.Slice(revisions, func(i, j int) bool { return newerRevision(revisions[i], revisions[j]) })
+	now := s.now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	var removed []string
+	for i, v := range revisions {
+		tooMany := s.KeepVersions > 0 && i >= s.KeepVersions
+		tooOld := s.MaxAge > 0 && i > 0 && now.Sub(v.SavedAt) > s.MaxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		location := filepath.Join(s.Dir, rel, v.Stamp)
+		if err := os.RemoveAll(location); err != nil {
+			return removed, err
+		}
+		removed = append(removed, location)
+	}
+	return removed, nil
+}
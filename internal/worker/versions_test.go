@@ -0,0 +1,172 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syncopasoft/syncopa-core/internal/task"
+)
+
+func TestRunTaskKeepsVersions(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	storeDir := t.TempDir()
+	src := filepath.Join(srcDir, "a.txt")
+	dst := filepath.Join(dstDir, "docs", "a.txt")
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		t.Fatalf("failed to create parent: %v", err)
+	}
+	if err := os.WriteFile(dst, []byte("v1"), 0o644); err != nil {
+		t.Fatalf("failed to write destination: %v", err)
+	}
+
+	runs := []struct {
+		at       time.Time
+		contents string
+	}{
+		{time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), "v2"},
+		{time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC), "v3"},
+	}
+	for _, run := range runs {
+		if err := os.WriteFile(src, []byte(run.contents), 0o644); err != nil {
+			t.Fatalf("failed to write source: %v", err)
+		}
+		exec := NewExecutor(false, 0)
+		exec.Versions = NewVersionStore(storeDir, []string{dstDir}, run.at)
+		res, err := exec.RunTask(task.Task{Action: task.ActionCopy, Src: src, Dst: dst})
+		if err != nil {
+			t.Fatalf("copy returned error: %v", err)
+		}
+		want := filepath.Join(storeDir, "docs", "a.txt", run.at.Format(backupStampLayout))
+		if res.BackupPath != want {
+			t.Fatalf("unexpected version path %q, want %q", res.BackupPath, want)
+		}
+	}
+
+	deletedAt := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)
+	exec := NewExecutor(false, 0)
+	exec.Versions = NewVersionStore(storeDir, []string{dstDir}, deletedAt)
+	if _, err := exec.RunTask(task.Task{Action: task.ActionDelete, Dst: filepath.Dir(dst)}); err != nil {
+		t.Fatalf("delete returned error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(dst)); !os.IsNotExist(err) {
+		t.Fatalf("expected the directory to be removed, got %v", err)
+	}
+
+	store := NewVersionStore(storeDir, []string{dstDir}, deletedAt)
+	versions, err := store.List("")
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if len(versions) != 3 || versions[0].Stamp != "20240603T000000Z" || versions[2].Stamp != "20240601T000000Z" {
+		t.Fatalf("unexpected versions: %+v", versions)
+	}
+	assertContents(t, versions[0].Location, "v3")
+
+	v, err := store.Find(filepath.Join("docs", "a.txt"), time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Find returned error: %v", err)
+	}
+	if v.Stamp != "20240602T000000Z" {
+		t.Fatalf("unexpected version %s", v.Stamp)
+	}
+	if err := store.Restore(v, dst); err != nil {
+		t.Fatalf("Restore returned error: %v", err)
+	}
+	assertContents(t, dst, "v2")
+	if _, err := store.Find("docs/a.txt", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Fatal("expected no version before the first run")
+	}
+}
+
+func TestVersionStoreRetention(t *testing.T) {
+	storeDir := t.TempDir()
+	rel := filepath.Join("dir", "f.txt")
+	stamps := []string{"20240101T000000Z", "20240501T000000Z", "20240601T000000Z", "20240602T000000Z"}
+	for _, stamp := range stamps {
+		path := filepath.Join(storeDir, rel, stamp)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create parent: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(stamp), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+	now := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+
+	store := NewVersionStore(storeDir, nil, now)
+	store.KeepVersions = 3
+	removed, err := store.Prune()
+	if err != nil {
+		t.Fatalf("Prune returned error: %v", err)
+	}
+	if len(removed) != 1 || filepath.Base(removed[0]) != "20240101T000000Z" {
+		t.Fatalf("count retention removed %v", removed)
+	}
+
+	// Age retention never drops the newest revision of a path.
+	store = NewVersionStore(storeDir, nil, now.AddDate(1, 0, 0))
+	store.MaxAge = 30 * 24 * time.Hour
+	if _, err := store.Prune(); err != nil {
+		t.Fatalf("Prune returned error: %v", err)
+	}
+	versions, err := store.List(rel)
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if len(versions) != 1 || versions[0].Stamp != "20240602T000000Z" {
+		t.Fatalf("age retention kept %+v", versions)
+	}
+}
+
+func TestVersionStoreKeepsRevisionsSavedUnderOneStamp(t *testing.T) {
+	dstDir := t.TempDir()
+	storeDir := t.TempDir()
+	dst := filepath.Join(dstDir, "a.txt")
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// Two runs started in the same second share a stamp.
+	for _, contents := range []string{"first", "second"} {
+		if err := os.WriteFile(dst, []byte(contents), 0o644); err != nil {
+			t.Fatalf("failed to write destination: %v", err)
+		}
+		if _, err := NewVersionStore(storeDir, []string{dstDir}, now).Save(dst); err != nil {
+			t.Fatalf("Save returned error: %v", err)
+		}
+	}
+
+	store := NewVersionStore(storeDir, []string{dstDir}, now)
+	versions, err := store.List("a.txt")
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if len(versions) != 2 || versions[0].Stamp != "20240601T120000Z.1" || versions[1].Stamp != "20240601T120000Z" {
+		t.Fatalf("unexpected versions: %+v", versions)
+	}
+	assertContents(t, versions[0].Location, "second")
+	assertContents(t, versions[1].Location, "first")
+
+	store.KeepVersions = 1
+	removed, err := store.Prune()
+	if err != nil {
+		t.Fatalf("Prune returned error: %v", err)
+	}
+	if len(removed) != 1 || filepath.Base(removed[0]) != "20240601T120000Z" {
+		t.Fatalf("retention removed %v", removed)
+	}
+}
+
+func TestParseVersionTime(t *testing.T) {
+	want := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, in := range []string{"20240601T120000Z", "2024-06-01T12:00:00Z"} {
+		got, err := ParseVersionTime(in)
+		if err != nil || !got.Equal(want) {
+			t.Fatalf("ParseVersionTime(%q) = %s, %v", in, got, err)
+		}
+	}
+	if _, err := ParseVersionTime("yesterday"); err == nil {
+		t.Fatal("expected an invalid time to be rejected")
+	}
+}
@@ -3,14 +3,12 @@
 package worker
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"io"
 	"os"
 	"syscall"
 )
 
-func tryZeroCopy(srcPath, dstPath string) (int64, string, bool, error) {
+func tryZeroCopy(srcPath, dstPath string, alg HashAlgorithm, sync *syncer) (int64, string, bool, error) {
 	src, err := os.Open(srcPath)
 	if err != nil {
 		return 0, "", false, err
@@ -63,19 +61,21 @@ func tryZeroCopy(srcPath, dstPath string) (int64, string, bool, error) {
 		}
 	}
 
-	if err := dst.Sync(); err != nil {
+	if err := sync.file(dst); err != nil {
 		return written, "", true, err
 	}
 	if err := os.Chmod(dstPath, info.Mode().Perm()); err != nil {
 		return written, "", true, err
 	}
+	hasher := newDigest(alg)
+	if !hasher.enabled() {
+		return written, "", true, nil
+	}
 	if _, err := src.Seek(0, io.SeekStart); err != nil {
 		return written, "", true, err
 	}
-	hasher := sha256.New()
 	if _, err := io.Copy(hasher, src); err != nil {
 		return written, "", true, err
 	}
-	hash := hex.EncodeToString(hasher.Sum(nil))
-	return written, hash, true, nil
+	return written, hasher.Sum(), true, nil
 }
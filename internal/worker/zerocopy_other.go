@@ -2,6 +2,6 @@
 
 package worker
 
-func tryZeroCopy(srcPath, dstPath string) (int64, string, bool, error) {
+func tryZeroCopy(srcPath, dstPath string, alg HashAlgorithm, sync *syncer) (int64, string, bool, error) {
 	return 0, "", false, nil
 }